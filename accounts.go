@@ -0,0 +1,350 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// RoleUser sees and manages only the links it created
+	RoleUser = "user"
+	// RoleAdmin sees and manages every link
+	RoleAdmin = "admin"
+
+	// minJWTSecretLength keeps short, guessable secrets out of JWT_SECRET
+	minJWTSecretLength = 32
+	// tokenIssuer is the iss claim of every token the service signs
+	tokenIssuer = "trimurl"
+
+	minUsernameLength = 3
+	maxUsernameLength = 32
+	minPasswordLength = 8
+)
+
+var (
+	// ErrUsernameTaken is returned when registering a username that already exists
+	ErrUsernameTaken = errors.New("username already taken")
+	// ErrInvalidCredentials is returned when a login's username or password is wrong
+	ErrInvalidCredentials = errors.New("invalid username or password")
+	// ErrInvalidToken is returned for tokens that are malformed, forged or expired
+	ErrInvalidToken = errors.New("invalid or expired token")
+)
+
+// AccountsConfig configures user accounts
+type AccountsConfig struct {
+	Secret    string        // signs tokens; accounts are disabled when empty
+	TokenTTL  time.Duration // how long an issued token is valid
+	UsersPath string        // JSON file users are kept in; empty keeps them in memory only
+	Admins    []string      // usernames given the admin role
+}
+
+// DefaultAccountsConfig returns the default accounts configuration, with accounts disabled
+func DefaultAccountsConfig() AccountsConfig {
+	return AccountsConfig{TokenTTL: 24 * time.Hour}
+}
+
+// User is a registered account. Roles are not stored: they follow
+// AccountsConfig.Admins each time a token is issued.
+type User struct {
+	ID           string    `json:"id"`
+	Username     string    `json:"username"`
+	PasswordHash string    `json:"password_hash"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// Principal is the signed-in user a request acts for
+type Principal struct {
+	UserID   string
+	Username string
+	Role     string
+}
+
+// principalKey is the context key for the request's principal
+type principalKey struct{}
+
+// withPrincipal returns a copy of ctx acting for principal
+func withPrincipal(ctx context.Context, principal *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// PrincipalFromContext returns the signed-in user of ctx, or nil when the
+// request is not acting for a user: accounts are disabled or it used an API key
+func PrincipalFromContext(ctx context.Context) *Principal {
+	principal, _ := ctx.Value(principalKey{}).(*Principal)
+	return principal
+}
+
+// ownerOf returns the user ID links created under ctx belong to, "" when none
+func ownerOf(ctx context.Context) string {
+	if principal := PrincipalFromContext(ctx); principal != nil {
+		return principal.UserID
+	}
+	return ""
+}
+
+// canAccess reports whether the caller in ctx may see and manage shortURL.
+// Callers without a principal and admins reach every link; users only their own.
+func canAccess(ctx context.Context, shortURL *ShortURL) bool {
+	principal := PrincipalFromContext(ctx)
+	return principal == nil || principal.Role == RoleAdmin || shortURL.OwnerID == principal.UserID
+}
+
+// tokenClaims are the claims of an issued token; the subject is the user ID
+type tokenClaims struct {
+	Username string `json:"username"`
+	Role     string `json:"role"`
+	jwt.RegisteredClaims
+}
+
+// Accounts registers users, checks their passwords and issues and verifies
+// the HS256 tokens that identify them
+type Accounts struct {
+	secret    []byte
+	tokenTTL  time.Duration
+	usersPath string
+	admins    map[string]bool
+	clock     Clock
+	// dummyHash is compared against on unknown usernames so logins take as
+	// long whether or not the user exists
+	dummyHash []byte
+
+	mu    sync.RWMutex
+	users map[string]*User // keyed by username
+}
+
+// NewAccounts creates the accounts subsystem, loading any users saved at
+// config.UsersPath. A missing file is not an error.
+func NewAccounts(config AccountsConfig, clock Clock) (*Accounts, error) {
+	if len(config.Secret) < minJWTSecretLength {
+		return nil, fmt.Errorf("token secret must be at least %d characters", minJWTSecretLength)
+	}
+	if config.TokenTTL <= 0 {
+		config.TokenTTL = DefaultAccountsConfig().TokenTTL
+	}
+	dummyHash, err := bcrypt.GenerateFromPassword([]byte("not a real password"), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, fmt.Errorf("failed to prepare password checks: %v", err)
+	}
+
+	a := &Accounts{
+		secret:    []byte(config.Secret),
+		tokenTTL:  config.TokenTTL,
+		usersPath: config.UsersPath,
+		admins:    make(map[string]bool, len(config.Admins)),
+		clock:     clock,
+		dummyHash: dummyHash,
+		users:     make(map[string]*User),
+	}
+	for _, username := range config.Admins {
+		a.admins[strings.ToLower(username)] = true
+	}
+	if err := a.load(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// load reads the users file, if there is one
+func (a *Accounts) load() error {
+	if a.usersPath == "" {
+		return nil
+	}
+	data, err := os.ReadFile(a.usersPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read users file: %v", err)
+	}
+	var users []*User
+	if err := json.Unmarshal(data, &users); err != nil {
+		return fmt.Errorf("invalid users file %s: %v", a.usersPath, err)
+	}
+	for _, user := range users {
+		a.users[user.Username] = user
+	}
+	return nil
+}
+
+// save replaces the users file with the current users, writing a temporary
+// file first so a crash never leaves it half written. Callers must hold a.mu.
+func (a *Accounts) save() error {
+	if a.usersPath == "" {
+		return nil
+	}
+	users := make([]*User, 0, len(a.users))
+	for _, user := range a.users {
+		users = append(users, user)
+	}
+	data, err := json.MarshalIndent(users, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode users: %v", err)
+	}
+
+	file, err := os.CreateTemp(filepath.Dir(a.usersPath), ".users-*.json")
+	if err != nil {
+		return fmt.Errorf("failed to write users file: %v", err)
+	}
+	defer os.Remove(file.Name())
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to write users file: %v", err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("failed to write users file: %v", err)
+	}
+	if err := os.Rename(file.Name(), a.usersPath); err != nil {
+		return fmt.Errorf("failed to replace users file: %v", err)
+	}
+	return nil
+}
+
+// Register creates a user. Usernames are case-insensitive and stored in
+// lower case. Invalid fields return a *ValidationError naming each one.
+func (a *Accounts) Register(username, password string) (*User, error) {
+	username = strings.ToLower(strings.TrimSpace(username))
+
+	var validation ValidationError
+	if err := validateUsername(username); err != nil {
+		validation.Add("username", err.Error())
+	}
+	switch {
+	case len(password) < minPasswordLength:
+		validation.Add("password", fmt.Sprintf("password must be at least %d characters", minPasswordLength))
+	case len(password) > maxPasswordBytes:
+		validation.Add("password", fmt.Sprintf("password must be at most %d bytes", maxPasswordBytes))
+	}
+	if len(validation.Errors) > 0 {
+		return nil, &validation
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash password")
+	}
+	id, err := newUserID()
+	if err != nil {
+		return nil, err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, exists := a.users[username]; exists {
+		return nil, ErrUsernameTaken
+	}
+	user := &User{ID: id, Username: username, PasswordHash: string(hash), CreatedAt: a.clock.Now()}
+	a.users[username] = user
+	if err := a.save(); err != nil {
+		delete(a.users, username)
+		return nil, err
+	}
+	return user, nil
+}
+
+// Login checks a username and password and issues a token for the user,
+// returning ErrInvalidCredentials without saying which of the two was wrong
+func (a *Accounts) Login(username, password string) (*TokenResponse, error) {
+	username = strings.ToLower(strings.TrimSpace(username))
+
+	a.mu.RLock()
+	user, exists := a.users[username]
+	a.mu.RUnlock()
+
+	hash := a.dummyHash
+	if exists {
+		hash = []byte(user.PasswordHash)
+	}
+	if err := bcrypt.CompareHashAndPassword(hash, []byte(password)); err != nil || !exists {
+		return nil, ErrInvalidCredentials
+	}
+	return a.issueToken(user)
+}
+
+// role returns the role a user's token carries
+func (a *Accounts) role(user *User) string {
+	if a.admins[user.Username] {
+		return RoleAdmin
+	}
+	return RoleUser
+}
+
+// issueToken signs a token identifying user that is valid for the token TTL
+func (a *Accounts) issueToken(user *User) (*TokenResponse, error) {
+	now := a.clock.Now()
+	expiresAt := now.Add(a.tokenTTL)
+	role := a.role(user)
+	claims := tokenClaims{
+		Username: user.Username,
+		Role:     role,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    tokenIssuer,
+			Subject:   user.ID,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+		},
+	}
+	signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(a.secret)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign token: %v", err)
+	}
+	return &TokenResponse{
+		Token:     signed,
+		TokenType: "Bearer",
+		ExpiresAt: expiresAt.UTC().Format(time.RFC3339),
+		Username:  user.Username,
+		Role:      role,
+	}, nil
+}
+
+// VerifyToken returns the principal a token was issued to, or ErrInvalidToken
+// if it is malformed, was not signed with the secret or has expired
+func (a *Accounts) VerifyToken(token string) (*Principal, error) {
+	var claims tokenClaims
+	_, err := jwt.ParseWithClaims(token, &claims, func(*jwt.Token) (interface{}, error) {
+		return a.secret, nil
+	},
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}),
+		jwt.WithIssuer(tokenIssuer),
+		jwt.WithExpirationRequired(),
+		jwt.WithTimeFunc(a.clock.Now),
+	)
+	if err != nil || claims.Subject == "" {
+		return nil, ErrInvalidToken
+	}
+	return &Principal{UserID: claims.Subject, Username: claims.Username, Role: claims.Role}, nil
+}
+
+// validateUsername checks a lower-cased username's length and characters
+func validateUsername(username string) error {
+	if len(username) < minUsernameLength || len(username) > maxUsernameLength {
+		return fmt.Errorf("username must be %d to %d characters", minUsernameLength, maxUsernameLength)
+	}
+	for _, r := range username {
+		if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '_' || r == '.' || r == '-') {
+			return fmt.Errorf("username may only contain letters, digits, '_', '.' and '-'")
+		}
+	}
+	return nil
+}
+
+// newUserID returns a random 128-bit user ID in hex
+func newUserID() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", fmt.Errorf("failed to generate user ID: %v", err)
+	}
+	return hex.EncodeToString(b[:]), nil
+}
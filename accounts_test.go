@@ -0,0 +1,182 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testJWTSecret = "test-secret-0123456789abcdef0123456789"
+
+func newTestAccounts(t *testing.T, clock Clock, config AccountsConfig) *Accounts {
+	t.Helper()
+	config.Secret = testJWTSecret
+	accounts, err := NewAccounts(config, clock)
+	if err != nil {
+		t.Fatalf("NewAccounts: %v", err)
+	}
+	return accounts
+}
+
+func TestAccountsRegisterAndLogin(t *testing.T) {
+	clock := NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	usersPath := filepath.Join(t.TempDir(), "users.json")
+	accounts := newTestAccounts(t, clock, AccountsConfig{TokenTTL: time.Hour, UsersPath: usersPath, Admins: []string{"Root"}})
+
+	var validationErr *ValidationError
+	if _, err := accounts.Register("a b", "short"); !errors.As(err, &validationErr) || len(validationErr.Errors) != 2 {
+		t.Errorf("Register with a bad username and password = %v, want both fields reported", err)
+	}
+	user, err := accounts.Register("Alice", "correct horse")
+	if err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	if user.Username != "alice" {
+		t.Errorf("username = %q, want it lower-cased", user.Username)
+	}
+	if _, err := accounts.Register("ALICE", "another password"); !errors.Is(err, ErrUsernameTaken) {
+		t.Errorf("Register of a taken username = %v, want ErrUsernameTaken", err)
+	}
+
+	for _, password := range []string{"wrong password", ""} {
+		if _, err := accounts.Login("alice", password); !errors.Is(err, ErrInvalidCredentials) {
+			t.Errorf("Login with %q = %v, want ErrInvalidCredentials", password, err)
+		}
+	}
+	if _, err := accounts.Login("nobody", "correct horse"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("Login of an unknown user = %v, want ErrInvalidCredentials", err)
+	}
+
+	token, err := accounts.Login("alice", "correct horse")
+	if err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	principal, err := accounts.VerifyToken(token.Token)
+	if err != nil || principal.UserID != user.ID || principal.Role != RoleUser {
+		t.Errorf("VerifyToken = %+v, %v; want alice as a user", principal, err)
+	}
+	if _, err := accounts.VerifyToken(token.Token + "x"); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("VerifyToken of a tampered token = %v, want ErrInvalidToken", err)
+	}
+	other := newTestAccounts(t, clock, AccountsConfig{})
+	other.secret = []byte("another-secret-0123456789abcdef012345")
+	if _, err := other.VerifyToken(token.Token); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("VerifyToken with another secret = %v, want ErrInvalidToken", err)
+	}
+	clock.Advance(time.Hour + time.Second)
+	if _, err := accounts.VerifyToken(token.Token); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("VerifyToken after the TTL = %v, want ErrInvalidToken", err)
+	}
+
+	// Users survive a restart, and roles follow the configured admins
+	if _, err := accounts.Register("root", "admin password"); err != nil {
+		t.Fatalf("Register root: %v", err)
+	}
+	reloaded := newTestAccounts(t, clock, AccountsConfig{UsersPath: usersPath, Admins: []string{"root"}})
+	if _, err := reloaded.Login("alice", "correct horse"); err != nil {
+		t.Errorf("Login after reloading the users file: %v", err)
+	}
+	if token, err := reloaded.Login("root", "admin password"); err != nil || token.Role != RoleAdmin {
+		t.Errorf("Login of an admin = %+v, %v; want the admin role", token, err)
+	}
+}
+
+func TestLinksAreScopedToTheirOwner(t *testing.T) {
+	h := newTestHandler(t)
+	h.APIKeys = []string{testAPIKey}
+	h.Accounts = newTestAccounts(t, RealClock(), AccountsConfig{Admins: []string{"root"}})
+	router := NewRouter(h, newTestLogger(t))
+
+	do := func(method, path, token, body string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+	login := func(username string) string {
+		t.Helper()
+		credentials := `{"username": "` + username + `", "password": "password-` + username + `"}`
+		if rec := do(http.MethodPost, "/auth/register", "", credentials); rec.Code != http.StatusCreated {
+			t.Fatalf("register %s = %d: %s", username, rec.Code, rec.Body.String())
+		}
+		rec := do(http.MethodPost, "/auth/login", "", credentials)
+		var token TokenResponse
+		if rec.Code != http.StatusOK || json.Unmarshal(rec.Body.Bytes(), &token) != nil {
+			t.Fatalf("login %s = %d: %s", username, rec.Code, rec.Body.String())
+		}
+		return token.Token
+	}
+	alice, bob, root := login("alice"), login("bob"), login("root")
+
+	if rec := do(http.MethodPost, "/shorturls", alice, `{"url": "example.com", "shortcode": "alices"}`); rec.Code != http.StatusCreated {
+		t.Fatalf("create = %d: %s", rec.Code, rec.Body.String())
+	}
+	// Deduplication must not hand alice's link to bob
+	do(http.MethodPost, "/shorturls", alice, `{"url": "example.org", "deduplicate": true}`)
+	rec := do(http.MethodPost, "/shorturls", bob, `{"url": "example.org", "deduplicate": true}`)
+	var created CreateShortURLResponse
+	json.Unmarshal(rec.Body.Bytes(), &created)
+	if rec.Code != http.StatusCreated {
+		t.Errorf("bob's deduplicated create = %d, want a new link of his own", rec.Code)
+	}
+
+	tests := []struct {
+		name, method, path, token, body string
+		want                            int
+	}{
+		{"anonymous stats", http.MethodGet, "/shorturls/alices", "", "", http.StatusUnauthorized},
+		{"forged token", http.MethodGet, "/shorturls/alices", alice + "x", "", http.StatusUnauthorized},
+		{"owner stats", http.MethodGet, "/shorturls/alices", alice, "", http.StatusOK},
+		{"other user's stats", http.MethodGet, "/shorturls/alices", bob, "", http.StatusNotFound},
+		{"other user's update", http.MethodPatch, "/shorturls/alices", bob, `{"url": "evil.example"}`, http.StatusNotFound},
+		{"other user's delete", http.MethodDelete, "/shorturls/alices", bob, "", http.StatusNotFound},
+		{"other user's click export", http.MethodGet, "/shorturls/alices/clicks.csv", bob, "", http.StatusNotFound},
+		{"admin stats", http.MethodGet, "/shorturls/alices", root, "", http.StatusOK},
+		{"API key stats", http.MethodGet, "/shorturls/alices", testAPIKey, "", http.StatusOK},
+		{"owner update", http.MethodPatch, "/shorturls/alices", alice, `{"url": "example.net"}`, http.StatusOK},
+		{"redirect stays public", http.MethodGet, "/alices", "", "", http.StatusFound},
+		{"owner delete", http.MethodDelete, "/shorturls/alices", alice, "", http.StatusNoContent},
+		{"deleted stats", http.MethodGet, "/shorturls/alices", alice, "", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		if rec := do(tt.method, tt.path, tt.token, tt.body); rec.Code != tt.want {
+			t.Errorf("%s: %s %s = %d, want %d: %s", tt.name, tt.method, tt.path, rec.Code, tt.want, rec.Body.String())
+		}
+	}
+
+	listed := func(token string) []string {
+		t.Helper()
+		var list ShortURLList
+		json.Unmarshal(do(http.MethodGet, "/shorturls", token, "").Body.Bytes(), &list)
+		codes := make([]string, len(list.ShortURLs))
+		for i, summary := range list.ShortURLs {
+			codes[i] = summary.ShortCode
+		}
+		return codes
+	}
+	if codes := listed(bob); len(codes) != 1 || codes[0] != created.ShortCode {
+		t.Errorf("bob lists %v, want only his link %s", codes, created.ShortCode)
+	}
+	if codes := listed(root); len(codes) != 2 {
+		t.Errorf("admin lists %v, want both remaining links", codes)
+	}
+}
+
+func TestAuthRoutesNeedAccounts(t *testing.T) {
+	h := newTestHandler(t)
+	router := NewRouter(h, newTestLogger(t))
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/auth/login", strings.NewReader(`{"username": "alice", "password": "password"}`)))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("login without accounts = %d, want 404", rec.Code)
+	}
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// AdminTokenHeader carries the admin token on /admin requests
+const AdminTokenHeader = "X-Admin-Token"
+
+// authorizeAdmin checks the admin token, writing an error response and
+// returning false when the request may not proceed
+func (h *URLHandler) authorizeAdmin(w http.ResponseWriter, r *http.Request) bool {
+	if h.AdminToken == "" {
+		h.logger.LogContext(r.Context(), BackendStack, WarnLevel, HandlerPackage, fmt.Sprintf("%s %s - Admin endpoints are disabled", r.Method, r.URL.Path))
+		h.sendErrorResponse(w, r, "Admin endpoints are disabled", http.StatusNotFound)
+		return false
+	}
+
+	token := r.Header.Get(AdminTokenHeader)
+	if subtle.ConstantTimeCompare([]byte(token), []byte(h.AdminToken)) != 1 {
+		h.logger.LogContext(r.Context(), BackendStack, WarnLevel, HandlerPackage, fmt.Sprintf("%s %s - Invalid admin token", r.Method, r.URL.Path))
+		h.sendErrorResponse(w, r, "Invalid or missing admin token", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+// SetEnabled handles PATCH /admin/shorturls/:shortcode with {"enabled": bool}
+func (h *URLHandler) SetEnabled(w http.ResponseWriter, r *http.Request) {
+	shortCode := strings.TrimPrefix(r.URL.Path, "/admin/shorturls/")
+	h.logger.LogContext(r.Context(), BackendStack, InfoLevel, HandlerPackage, fmt.Sprintf("PATCH /admin/shorturls/%s - Setting enabled flag", shortCode))
+
+	if !h.authorizeAdmin(w, r) {
+		return
+	}
+	if shortCode == "" {
+		h.sendErrorResponse(w, r, "Shortcode is required", http.StatusBadRequest)
+		return
+	}
+
+	var req SetEnabledRequest
+	r.Body = http.MaxBytesReader(w, r.Body, h.MaxBodyBytes)
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.logger.LogContext(r.Context(), BackendStack, ErrorLevel, HandlerPackage, fmt.Sprintf("Invalid JSON: %v", err))
+		h.sendBodyReadError(w, r, err, "Invalid JSON")
+		return
+	}
+	if req.Enabled == nil {
+		h.sendErrorResponse(w, r, "enabled is required", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.urlService.SetEnabled(shortCode, *req.Enabled); err != nil {
+		h.logger.LogContext(r.Context(), BackendStack, ErrorLevel, HandlerPackage, fmt.Sprintf("Failed to set enabled flag for %s: %v", shortCode, err))
+		if errors.Is(err, ErrShortCodeNotFound) {
+			h.sendErrorResponse(w, r, err.Error(), http.StatusNotFound)
+			return
+		}
+		h.sendErrorResponse(w, r, "Failed to update short URL", http.StatusInternalServerError)
+		return
+	}
+
+	h.logger.LogContext(r.Context(), BackendStack, InfoLevel, HandlerPackage, fmt.Sprintf("Shortcode %s enabled=%t", shortCode, *req.Enabled))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ExportURLs handles GET /admin/export, streaming every entry as a JSON array.
+// It uses the request context rather than RequestTimeout since large stores take a while.
+func (h *URLHandler) ExportURLs(w http.ResponseWriter, r *http.Request) {
+	h.logger.LogContext(r.Context(), BackendStack, InfoLevel, HandlerPackage, "GET /admin/export - Exporting short URLs")
+
+	if !h.authorizeAdmin(w, r) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	// The status is already sent, so a failure can only truncate the array
+	encoder := json.NewEncoder(w)
+	count := 0
+	w.Write([]byte("["))
+	err := h.urlService.ExportShortURLs(r.Context(), func(shortURL *ShortURL) error {
+		if count > 0 {
+			if _, err := w.Write([]byte(",")); err != nil {
+				return err
+			}
+		}
+		count++
+		return encoder.Encode(shortURL)
+	})
+	if err != nil {
+		h.logger.LogContext(r.Context(), BackendStack, ErrorLevel, HandlerPackage, fmt.Sprintf("Export aborted after %d entries: %v", count, err))
+		return
+	}
+	w.Write([]byte("]\n"))
+
+	h.logger.LogContext(r.Context(), BackendStack, InfoLevel, HandlerPackage, fmt.Sprintf("Exported %d short URLs", count))
+}
+
+// ImportURLs handles POST /admin/import, decoding a JSON array of entries one
+// at a time and skipping shortcodes that already exist
+func (h *URLHandler) ImportURLs(w http.ResponseWriter, r *http.Request) {
+	h.logger.LogContext(r.Context(), BackendStack, InfoLevel, HandlerPackage, "POST /admin/import - Importing short URLs")
+
+	if !h.authorizeAdmin(w, r) {
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, h.MaxImportBytes)
+	decoder := json.NewDecoder(r.Body)
+
+	if token, err := decoder.Token(); err != nil || token != json.Delim('[') {
+		h.logger.LogContext(r.Context(), BackendStack, ErrorLevel, HandlerPackage, "Import body is not a JSON array")
+		h.sendBodyReadError(w, r, err, "Request body must be a JSON array of short URLs")
+		return
+	}
+
+	var result ImportResponse
+	for decoder.More() {
+		var shortURL ShortURL
+		if err := decoder.Decode(&shortURL); err != nil {
+			h.logger.LogContext(r.Context(), BackendStack, ErrorLevel, HandlerPackage, fmt.Sprintf("Invalid import entry after %d imported: %v", result.Imported, err))
+			h.sendBodyReadError(w, r, err, fmt.Sprintf("Invalid JSON after %d entries were imported", result.Imported))
+			return
+		}
+
+		imported, err := h.urlService.ImportShortURL(r.Context(), &shortURL)
+		if err != nil {
+			h.logger.LogContext(r.Context(), BackendStack, ErrorLevel, HandlerPackage, fmt.Sprintf("Import failed after %d imported: %v", result.Imported, err))
+			h.sendErrorResponse(w, r, fmt.Sprintf("%v (%d entries were imported)", err, result.Imported), http.StatusBadRequest)
+			return
+		}
+		if imported {
+			result.Imported++
+		} else {
+			result.Skipped++
+		}
+	}
+
+	h.logger.LogContext(r.Context(), BackendStack, InfoLevel, HandlerPackage, fmt.Sprintf("Import finished: %d imported, %d skipped", result.Imported, result.Skipped))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(result)
+}
+
+// levelLogger is a logger whose minimum level can be changed while it runs
+type levelLogger interface {
+	MinLevel() Level
+	SetMinLevel(level Level) error
+}
+
+var _ levelLogger = (*Logger)(nil)
+
+// LogLevel handles GET and PUT /admin/log-level, reporting or changing the
+// least severe level that is logged, with {"level": "info"} as the body
+func (h *URLHandler) LogLevel(w http.ResponseWriter, r *http.Request) {
+	if !h.authorizeAdmin(w, r) {
+		return
+	}
+	logger, ok := h.logger.(levelLogger)
+	if !ok {
+		h.sendErrorResponse(w, r, "Remote logging is disabled", http.StatusNotFound)
+		return
+	}
+
+	if r.Method == http.MethodPut {
+		var req LogLevelSetting
+		r.Body = http.MaxBytesReader(w, r.Body, h.MaxBodyBytes)
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			h.logger.LogContext(r.Context(), BackendStack, ErrorLevel, HandlerPackage, fmt.Sprintf("Invalid JSON: %v", err))
+			h.sendBodyReadError(w, r, err, "Invalid JSON")
+			return
+		}
+		level, err := ParseLevel(string(req.Level))
+		if err != nil {
+			h.sendErrorResponse(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
+		previous := logger.MinLevel()
+		logger.SetMinLevel(level)
+		h.logger.LogContext(r.Context(), BackendStack, WarnLevel, HandlerPackage, fmt.Sprintf("PUT /admin/log-level - Minimum log level changed from %s to %s", previous, level))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(LogLevelSetting{Level: logger.MinLevel()})
+}
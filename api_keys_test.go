@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const testAPIKey = "test-key-0123456789"
+
+func TestAPIKeysProtectManagementRoutes(t *testing.T) {
+	h := newTestHandler(t)
+	h.APIKeys = []string{"other-key-0123456789", testAPIKey}
+	router := NewRouter(h, newTestLogger(t))
+	if _, err := h.urlService.CreateShortURL(context.Background(), CreateShortURLRequest{URL: "example.com", ShortCode: "keyed"}); err != nil {
+		t.Fatalf("CreateShortURL: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		header string
+		value  string
+		want   int
+	}{
+		{"create without a key", http.MethodPost, "/shorturls", "", "", http.StatusUnauthorized},
+		{"stats without a key", http.MethodGet, "/shorturls/keyed", "", "", http.StatusUnauthorized},
+		{"stats with a wrong key", http.MethodGet, "/shorturls/keyed", APIKeyHeader, "wrong-key-0123456789", http.StatusUnauthorized},
+		{"stats with the header", http.MethodGet, "/shorturls/keyed", APIKeyHeader, testAPIKey, http.StatusOK},
+		{"stats with a bearer token", http.MethodGet, "/shorturls/keyed", "Authorization", "Bearer " + testAPIKey, http.StatusOK},
+		{"redirect stays public", http.MethodGet, "/keyed", "", "", http.StatusFound},
+		{"health stays public", http.MethodGet, "/health", "", "", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(`{"url": "example.com"}`))
+			if tt.header != "" {
+				req.Header.Set(tt.header, tt.value)
+			}
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("%s %s = %d, want %d", tt.method, tt.path, rec.Code, tt.want)
+			}
+			if tt.want == http.StatusUnauthorized && rec.Header().Get("WWW-Authenticate") == "" {
+				t.Error("401 response has no WWW-Authenticate header")
+			}
+		})
+	}
+}
+
+func TestClientSendsAPIKey(t *testing.T) {
+	h := newTestHandler(t)
+	h.APIKeys = []string{testAPIKey}
+	srv := httptest.NewServer(NewRouter(h, newTestLogger(t)))
+	t.Cleanup(srv.Close)
+
+	c := NewClient(srv.URL)
+	var apiErr *APIError
+	if _, err := c.Create(CreateShortURLRequest{URL: "example.com"}); !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("Create without a key = %v, want a 401 APIError", err)
+	}
+
+	c.APIKey = testAPIKey
+	created, err := c.Create(CreateShortURLRequest{URL: "example.com"})
+	if err != nil {
+		t.Fatalf("Create with a key: %v", err)
+	}
+	if _, err := c.Stats(created.ShortCode); err != nil {
+		t.Errorf("Stats with a key: %v", err)
+	}
+}
+
+func TestParseAPIKeys(t *testing.T) {
+	keys, err := ParseAPIKeys(" " + testAPIKey + " ,, other-key-0123456789")
+	if err != nil || len(keys) != 2 || keys[0] != testAPIKey {
+		t.Errorf("ParseAPIKeys = %v, %v; want both keys trimmed", keys, err)
+	}
+	if _, err := ParseAPIKeys("short"); err == nil {
+		t.Error("ParseAPIKeys accepted a key shorter than the minimum")
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// RequireCredentials guards the /shorturls API. A bearer token issued by
+// h.Accounts makes the request act for that user, who then reaches only their
+// own links unless an admin; one of h.APIKeys reaches every link. Anything
+// else is rejected with 401 once accounts or API keys are enabled, and let
+// through while neither is.
+func (h *URLHandler) RequireCredentials(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if h.Accounts == nil && len(h.APIKeys) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		credential := credentialFromRequest(r)
+		if h.validAPIKey(credential) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if h.Accounts != nil && credential != "" {
+			if principal, err := h.Accounts.VerifyToken(credential); err == nil {
+				next.ServeHTTP(w, r.WithContext(withPrincipal(r.Context(), principal)))
+				return
+			}
+		}
+
+		h.logger.LogContext(r.Context(), BackendStack, WarnLevel, HandlerPackage, fmt.Sprintf("%s %s - Missing or invalid credentials", r.Method, r.URL.Path))
+		w.Header().Set("WWW-Authenticate", `Bearer realm="trimurl"`)
+		h.sendErrorResponse(w, r, "Invalid or missing API key or token", http.StatusUnauthorized)
+	})
+}
+
+// decodeCredentials reads a CredentialsRequest body, writing an error
+// response and returning false when there is none. Accounts being disabled
+// is reported as 404, like the admin endpoints.
+func (h *URLHandler) decodeCredentials(w http.ResponseWriter, r *http.Request) (CredentialsRequest, bool) {
+	var req CredentialsRequest
+	if h.Accounts == nil {
+		h.logger.LogContext(r.Context(), BackendStack, WarnLevel, HandlerPackage, fmt.Sprintf("%s %s - Accounts are disabled", r.Method, r.URL.Path))
+		h.sendErrorResponse(w, r, "Accounts are disabled", http.StatusNotFound)
+		return req, false
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, h.MaxBodyBytes)
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.logger.LogContext(r.Context(), BackendStack, ErrorLevel, HandlerPackage, fmt.Sprintf("Invalid JSON: %v", err))
+		h.sendBodyReadError(w, r, err, "Invalid JSON")
+		return req, false
+	}
+	return req, true
+}
+
+// Register handles POST /auth/register
+func (h *URLHandler) Register(w http.ResponseWriter, r *http.Request) {
+	h.logger.LogContext(r.Context(), BackendStack, InfoLevel, HandlerPackage, "POST /auth/register - Registering user")
+
+	req, ok := h.decodeCredentials(w, r)
+	if !ok {
+		return
+	}
+
+	user, err := h.Accounts.Register(req.Username, req.Password)
+	if err != nil {
+		h.logger.LogContext(r.Context(), BackendStack, ErrorLevel, HandlerPackage, fmt.Sprintf("Registration failed: %v", err))
+		var validationErr *ValidationError
+		switch {
+		case errors.As(err, &validationErr):
+			h.sendErrorDetails(w, r, err.Error(), http.StatusBadRequest, validationErr.Errors)
+		case errors.Is(err, ErrUsernameTaken):
+			h.sendErrorResponse(w, r, err.Error(), http.StatusConflict)
+		default:
+			h.sendErrorResponse(w, r, "Failed to register user", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	h.logger.LogContext(r.Context(), BackendStack, InfoLevel, HandlerPackage, fmt.Sprintf("Registered user %s", user.Username))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(UserResponse{ID: user.ID, Username: user.Username, CreatedAt: user.CreatedAt})
+}
+
+// Login handles POST /auth/login, answering with a bearer token for the /shorturls API
+func (h *URLHandler) Login(w http.ResponseWriter, r *http.Request) {
+	h.logger.LogContext(r.Context(), BackendStack, InfoLevel, HandlerPackage, "POST /auth/login - Logging in")
+
+	req, ok := h.decodeCredentials(w, r)
+	if !ok {
+		return
+	}
+
+	token, err := h.Accounts.Login(req.Username, req.Password)
+	if err != nil {
+		h.logger.LogContext(r.Context(), BackendStack, WarnLevel, HandlerPackage, fmt.Sprintf("Login failed: %v", err))
+		if errors.Is(err, ErrInvalidCredentials) {
+			h.sendErrorResponse(w, r, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		h.sendErrorResponse(w, r, "Failed to log in", http.StatusInternalServerError)
+		return
+	}
+
+	h.logger.LogContext(r.Context(), BackendStack, InfoLevel, HandlerPackage, fmt.Sprintf("Issued token for %s (%s)", token.Username, token.Role))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(token)
+}
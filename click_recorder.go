@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"time"
+)
+
+// ErrClickQueueFull is returned when a click arrives while its worker's queue is full
+var ErrClickQueueFull = errors.New("click queue full")
+
+// ClickRecorderConfig controls the asynchronous click pipeline
+type ClickRecorderConfig struct {
+	Workers       int           // goroutines writing clicks
+	BufferSize    int           // clicks queued per worker before new ones are dropped
+	MaxBatchSize  int           // clicks a worker writes in one pass
+	FlushInterval time.Duration // longest a queued click waits for its batch to fill
+}
+
+// DefaultClickRecorderConfig returns the config used by main unless overridden
+func DefaultClickRecorderConfig() ClickRecorderConfig {
+	return ClickRecorderConfig{
+		Workers:       4,
+		BufferSize:    4096,
+		MaxBatchSize:  256,
+		FlushInterval: 100 * time.Millisecond,
+	}
+}
+
+// queuedClick is a click waiting to be written
+type queuedClick struct {
+	shortCode string
+	click     Click
+}
+
+// ClickRecorder takes clicks off the redirect path. Each shortcode is
+// assigned to one worker, which writes its queued clicks in batches through
+// URLService.RecordClicks, so a link's clicks keep their order and a burst on
+// one link costs one store write per batch rather than per click.
+type ClickRecorder struct {
+	service       *URLService
+	logger        LoggerInterface
+	queues        []chan queuedClick
+	maxBatchSize  int
+	flushInterval time.Duration
+
+	closeMu sync.RWMutex
+	closed  bool
+	done    sync.WaitGroup
+}
+
+// NewClickRecorder starts config.Workers workers recording into service;
+// zero fields take their defaults
+func NewClickRecorder(service *URLService, logger LoggerInterface, config ClickRecorderConfig) *ClickRecorder {
+	defaults := DefaultClickRecorderConfig()
+	if config.Workers <= 0 {
+		config.Workers = defaults.Workers
+	}
+	if config.BufferSize <= 0 {
+		config.BufferSize = defaults.BufferSize
+	}
+	if config.MaxBatchSize <= 0 {
+		config.MaxBatchSize = defaults.MaxBatchSize
+	}
+	if config.FlushInterval <= 0 {
+		config.FlushInterval = defaults.FlushInterval
+	}
+
+	r := &ClickRecorder{
+		service:       service,
+		logger:        logger,
+		queues:        make([]chan queuedClick, config.Workers),
+		maxBatchSize:  config.MaxBatchSize,
+		flushInterval: config.FlushInterval,
+	}
+	for i := range r.queues {
+		r.queues[i] = make(chan queuedClick, config.BufferSize)
+		r.done.Add(1)
+		go r.run(r.queues[i])
+	}
+	return r
+}
+
+// Record stamps a click with the current time and queues it without
+// blocking. It returns ErrClickQueueFull when the click had to be dropped.
+func (r *ClickRecorder) Record(shortCode string, click Click) error {
+	r.closeMu.RLock()
+	defer r.closeMu.RUnlock()
+	if r.closed {
+		return fmt.Errorf("click recorder is closed")
+	}
+
+	shortCode = r.service.normalizeCode(shortCode)
+	click.Timestamp = r.service.clock.Now()
+	hash := fnv.New32a()
+	hash.Write([]byte(shortCode))
+	select {
+	case r.queues[hash.Sum32()%uint32(len(r.queues))] <- queuedClick{shortCode: shortCode, click: click}:
+		return nil
+	default:
+		return ErrClickQueueFull
+	}
+}
+
+// Close stops accepting clicks and waits until the queued ones are written
+// or ctx is done
+func (r *ClickRecorder) Close(ctx context.Context) error {
+	r.closeMu.Lock()
+	if !r.closed {
+		r.closed = true
+		for _, queue := range r.queues {
+			close(queue)
+		}
+	}
+	r.closeMu.Unlock()
+
+	drained := make(chan struct{})
+	go func() {
+		r.done.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("clicks still queued: %v", ctx.Err())
+	}
+}
+
+// run writes one worker's clicks until its queue is closed and empty
+func (r *ClickRecorder) run(queue chan queuedClick) {
+	defer r.done.Done()
+	ticker := time.NewTicker(r.flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]queuedClick, 0, r.maxBatchSize)
+	for {
+		select {
+		case queued, ok := <-queue:
+			if !ok {
+				r.flush(batch)
+				return
+			}
+			batch = append(batch, queued)
+			if len(batch) >= r.maxBatchSize {
+				r.flush(batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			r.flush(batch)
+			batch = batch[:0]
+		}
+	}
+}
+
+// flush writes a batch with one RecordClicks call per shortcode, counting
+// the clicks that could not be written as dropped
+func (r *ClickRecorder) flush(batch []queuedClick) {
+	if len(batch) == 0 {
+		return
+	}
+	var order []string
+	byCode := make(map[string][]Click)
+	for _, queued := range batch {
+		if _, seen := byCode[queued.shortCode]; !seen {
+			order = append(order, queued.shortCode)
+		}
+		byCode[queued.shortCode] = append(byCode[queued.shortCode], queued.click)
+	}
+
+	for _, shortCode := range order {
+		clicks := byCode[shortCode]
+		err := r.service.RecordClicks(context.Background(), shortCode, clicks)
+		switch {
+		case err == nil:
+		case errors.Is(err, ErrShortCodeNotFound), errors.Is(err, ErrClickLimitReached):
+			// The link was deleted or used up its budget while the clicks were queued
+			r.logger.Log(BackendStack, WarnLevel, ServicePackage, fmt.Sprintf("Queued clicks for %s not recorded: %v", shortCode, err))
+		default:
+			clicksDroppedTotal.Add(float64(len(clicks)))
+			r.logger.Log(BackendStack, ErrorLevel, ServicePackage, fmt.Sprintf("Failed to record %d queued clicks for %s: %v", len(clicks), shortCode, err))
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestClickRecorderBatchesClicksInOrder(t *testing.T) {
+	clock := NewFakeClock(time.Date(2024, 1, 20, 10, 0, 0, 0, time.UTC))
+	s := newTestService(t, URLServiceConfig{Clock: clock})
+	ctx := context.Background()
+	for _, code := range []string{"first", "second"} {
+		if _, err := s.CreateShortURL(ctx, CreateShortURLRequest{URL: "example.com", ShortCode: code}); err != nil {
+			t.Fatalf("CreateShortURL: %v", err)
+		}
+	}
+
+	recorder := NewClickRecorder(s, newTestLogger(t), ClickRecorderConfig{Workers: 2, MaxBatchSize: 8, FlushInterval: time.Millisecond})
+	for i := 0; i < 20; i++ {
+		clock.Advance(time.Second)
+		code := []string{"first", "second"}[i%2]
+		if err := recorder.Record(code, Click{Source: fmt.Sprint(i)}); err != nil {
+			t.Fatalf("Record: %v", err)
+		}
+	}
+	if err := recorder.Close(ctx); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if err := recorder.Record("first", Click{}); err == nil {
+		t.Error("Record after Close succeeded")
+	}
+
+	clicks, err := s.ClickHistory(ctx, "first")
+	if err != nil || len(clicks) != 10 {
+		t.Fatalf("first has %d clicks, %v; want 10", len(clicks), err)
+	}
+	for i, click := range clicks {
+		// Clicks keep the time they were queued, not the time they were written
+		if want := fmt.Sprint(2 * i); click.Source != want || !click.Timestamp.Equal(time.Date(2024, 1, 20, 10, 0, 2*i+1, 0, time.UTC)) {
+			t.Errorf("click %d = %s at %s, want %s at 10:00:%02d", i, click.Source, click.Timestamp.Format(time.TimeOnly), want, 2*i+1)
+		}
+	}
+}
+
+func TestRedirectQueuesClicksUnlessLimited(t *testing.T) {
+	h := newTestHandler(t)
+	ctx := context.Background()
+	if _, err := h.urlService.CreateShortURL(ctx, CreateShortURLRequest{URL: "example.com", ShortCode: "queued"}); err != nil {
+		t.Fatalf("CreateShortURL: %v", err)
+	}
+	if _, err := h.urlService.CreateShortURL(ctx, CreateShortURLRequest{URL: "example.com", ShortCode: "budget", MaxClicks: 1}); err != nil {
+		t.Fatalf("CreateShortURL: %v", err)
+	}
+	// A long flush interval keeps queued clicks unwritten until Close
+	h.Clicks = NewClickRecorder(h.urlService, h.logger, ClickRecorderConfig{FlushInterval: time.Hour})
+
+	redirect := func(code string) int {
+		rec := httptest.NewRecorder()
+		h.RedirectURL(rec, httptest.NewRequest(http.MethodGet, "/"+code, nil))
+		return rec.Code
+	}
+	for i := 0; i < 3; i++ {
+		if code := redirect("queued"); code != http.StatusFound {
+			t.Fatalf("redirect = %d, want 302", code)
+		}
+	}
+	if stats, _ := h.urlService.GetStats(ctx, "queued"); stats.TotalClicks != 0 {
+		t.Errorf("%d clicks written before the batch was flushed", stats.TotalClicks)
+	}
+
+	// The click budget is enforced synchronously, so the second visit is refused
+	if first, second := redirect("budget"), redirect("budget"); first != http.StatusFound || second != http.StatusGone {
+		t.Errorf("limited link redirects = %d, %d; want 302 then 410", first, second)
+	}
+
+	if err := h.Clicks.Close(ctx); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if stats, _ := h.urlService.GetStats(ctx, "queued"); stats.TotalClicks != 3 {
+		t.Errorf("after Close, %d clicks recorded, want 3", stats.TotalClicks)
+	}
+}
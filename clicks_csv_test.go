@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestExportClicksCSV(t *testing.T) {
+	h := newTestHandler(t)
+	ctx := context.Background()
+	if _, err := h.urlService.CreateShortURL(ctx, CreateShortURLRequest{URL: "example.com", ShortCode: "sheet"}); err != nil {
+		t.Fatalf("CreateShortURL: %v", err)
+	}
+	for _, click := range []Click{
+		{Source: "direct", Location: "unknown", UserAgent: "curl/8.0"},
+		{Source: "=HYPERLINK(\"x\")", Location: "unknown", UserAgent: "Mozilla/5.0, \"quoted\""},
+	} {
+		if err := h.urlService.RecordClick(ctx, "sheet", click); err != nil {
+			t.Fatalf("RecordClick: %v", err)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	h.ShortURLResource(rec, httptest.NewRequest(http.MethodGet, "/shorturls/sheet/clicks.csv", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rec.Code)
+	}
+	if got := rec.Header().Get("Content-Type"); got != "text/csv; charset=utf-8" {
+		t.Errorf("Content-Type = %q, want text/csv", got)
+	}
+	if got := rec.Header().Get("Content-Disposition"); got != `attachment; filename="sheet-clicks.csv"` {
+		t.Errorf("Content-Disposition = %q", got)
+	}
+
+	rows, err := csv.NewReader(rec.Body).ReadAll()
+	if err != nil {
+		t.Fatalf("parse CSV: %v", err)
+	}
+	if len(rows) != 3 || rows[0][0] != "timestamp" || rows[0][3] != "user_agent" {
+		t.Fatalf("rows = %q, want a header and 2 clicks", rows)
+	}
+	if rows[1][1] != "direct" || rows[2][3] != "Mozilla/5.0, \"quoted\"" {
+		t.Errorf("rows = %q, want the recorded sources and user agents", rows[1:])
+	}
+	if rows[2][1] != "'=HYPERLINK(\"x\")" {
+		t.Errorf("formula source = %q, want it escaped with a leading quote", rows[2][1])
+	}
+}
+
+func TestExportClicksCSVUnknownCode(t *testing.T) {
+	h := newTestHandler(t)
+
+	rec := httptest.NewRecorder()
+	h.ShortURLResource(rec, httptest.NewRequest(http.MethodGet, "/shorturls/missing/clicks.csv", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want 404", rec.Code)
+	}
+}
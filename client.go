@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Client is a typed client for the URL shortener API. It lives alongside
+// the server so it shares the request and response models.
+type Client struct {
+	baseURL    string
+	HTTPClient *http.Client
+	APIKey     string // sent with /shorturls requests when set
+}
+
+// APIError is a non-2xx response from the API
+type APIError struct {
+	StatusCode int
+	Response   ErrorResponse
+}
+
+func (e *APIError) Error() string {
+	if e.Response.Message != "" {
+		return fmt.Sprintf("api error %d: %s", e.StatusCode, e.Response.Message)
+	}
+	return fmt.Sprintf("api error %d", e.StatusCode)
+}
+
+// NewClient creates a client for the service at baseURL, e.g. http://localhost:3000
+func NewClient(baseURL string) *Client {
+	return &Client{
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		HTTPClient: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Create creates a short URL
+func (c *Client) Create(req CreateShortURLRequest) (*CreateShortURLResponse, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	httpReq, err := c.newAPIRequest(http.MethodPost, "/shorturls", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	resp, err := c.HTTPClient.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var created CreateShortURLResponse
+	if err := decodeResponse(resp, &created); err != nil {
+		return nil, err
+	}
+	return &created, nil
+}
+
+// Stats retrieves statistics for a shortcode
+func (c *Client) Stats(code string) (*ShortURLStats, error) {
+	req, err := c.newAPIRequest(http.MethodGet, "/shorturls/"+url.PathEscape(code), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var stats ShortURLStats
+	if err := decodeResponse(resp, &stats); err != nil {
+		return nil, err
+	}
+	return &stats, nil
+}
+
+// Resolve returns the destination a shortcode redirects to without following
+// the redirect. Note that resolving counts as a click.
+func (c *Client) Resolve(code string) (string, error) {
+	httpClient := *c.HTTPClient
+	httpClient.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+
+	resp, err := httpClient.Get(c.baseURL + "/" + url.PathEscape(code))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 300 || resp.StatusCode >= 400 {
+		return "", decodeResponse(resp, nil)
+	}
+	location := resp.Header.Get("Location")
+	if location == "" {
+		return "", fmt.Errorf("redirect for %s has no Location header", code)
+	}
+	return location, nil
+}
+
+// newAPIRequest builds a request for an API path, carrying the API key if one is set
+func (c *Client) newAPIRequest(method, path string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequest(method, c.baseURL+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if c.APIKey != "" {
+		req.Header.Set(APIKeyHeader, c.APIKey)
+	}
+	return req, nil
+}
+
+// decodeResponse decodes a 2xx JSON body into out, or returns an *APIError
+// built from the ErrorResponse body otherwise
+func decodeResponse(resp *http.Response, out interface{}) error {
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		apiErr := &APIError{StatusCode: resp.StatusCode}
+		body, _ := io.ReadAll(resp.Body)
+		if json.Unmarshal(body, &apiErr.Response) != nil {
+			apiErr.Response.Message = strings.TrimSpace(string(body))
+		}
+		return apiErr
+	}
+
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("decoding response: %v", err)
+	}
+	return nil
+}
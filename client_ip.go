@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// clientIP returns the address of the client that made the request.
+// Forwarded headers are honoured only when the immediate peer is one of the
+// trusted proxies, since anyone else can forge them. X-Forwarded-For is then
+// walked from the right, skipping trusted proxies, to the first untrusted hop;
+// X-Real-IP is used if that yields nothing. Otherwise the peer address is
+// used. Ports and IPv6 brackets are stripped; "" means no valid address.
+func clientIP(r *http.Request, trustedProxies []*net.IPNet) string {
+	peer := parseIP(r.RemoteAddr)
+	if peer == "" || !isTrustedProxy(peer, trustedProxies) {
+		return peer
+	}
+
+	if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+		hops := strings.Split(forwarded, ",")
+		leftmost := ""
+		for i := len(hops) - 1; i >= 0; i-- {
+			ip := parseIP(hops[i])
+			if ip == "" {
+				continue
+			}
+			if !isTrustedProxy(ip, trustedProxies) {
+				return ip
+			}
+			leftmost = ip
+		}
+		// Every hop is a trusted proxy; the left-most is the closest to the client
+		if leftmost != "" {
+			return leftmost
+		}
+	}
+
+	if ip := parseIP(r.Header.Get("X-Real-IP")); ip != "" {
+		return ip
+	}
+
+	return peer
+}
+
+// isTrustedProxy reports whether ip falls in one of the trusted networks
+func isTrustedProxy(ip string, trustedProxies []*net.IPNet) bool {
+	parsed := net.ParseIP(ip)
+	for _, network := range trustedProxies {
+		if network.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseTrustedProxies parses a comma-separated list of CIDRs or single IP
+// addresses, e.g. "10.0.0.0/8, 192.168.1.10, fd00::/8"
+func ParseTrustedProxies(list string) ([]*net.IPNet, error) {
+	return parseNetworks(list, "trusted proxy")
+}
+
+// parseNetworks parses a comma-separated list of CIDRs or single IP
+// addresses, naming entries as what in errors
+func parseNetworks(list, what string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid %s %q", what, entry)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s %q: %v", what, entry, err)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// parseIP extracts an IP address from "ip", "ip:port", "[ipv6]" or
+// "[ipv6]:port", returning it in canonical form or "" if it is not valid
+func parseIP(value string) string {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return ""
+	}
+
+	if host, _, err := net.SplitHostPort(value); err == nil {
+		value = host
+	}
+	value = strings.TrimSuffix(strings.TrimPrefix(value, "["), "]")
+
+	// Drop an IPv6 zone such as fe80::1%eth0
+	if i := strings.IndexByte(value, '%'); i >= 0 {
+		value = value[:i]
+	}
+
+	ip := net.ParseIP(value)
+	if ip == nil {
+		return ""
+	}
+	return ip.String()
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	trusted, err := ParseTrustedProxies("10.0.0.0/8, fd00::/8")
+	if err != nil {
+		t.Fatalf("ParseTrustedProxies: %v", err)
+	}
+
+	tests := []struct {
+		name         string
+		remoteAddr   string
+		forwardedFor string
+		realIP       string
+		want         string
+	}{
+		{"remote addr only", "203.0.113.7:5123", "", "", "203.0.113.7"},
+		{"remote addr IPv6", "[2001:db8::1]:443", "", "", "2001:db8::1"},
+		{"untrusted peer ignores forwarded for", "203.0.113.7:5123", "198.51.100.2", "", "203.0.113.7"},
+		{"untrusted peer ignores real ip", "203.0.113.7:5123", "", "198.51.100.9", "203.0.113.7"},
+		{"forwarded for single", "10.0.0.1:80", "198.51.100.2", "", "198.51.100.2"},
+		{"forwarded for skips trusted hops", "10.0.0.1:80", "198.51.100.2, 10.0.0.5", "", "198.51.100.2"},
+		{"forwarded for ignores spoofed left hops", "10.0.0.1:80", "1.2.3.4, 198.51.100.2, 10.0.0.5", "", "198.51.100.2"},
+		{"forwarded for all trusted", "10.0.0.1:80", "10.1.1.1, 10.0.0.5", "", "10.1.1.1"},
+		{"forwarded for with port", "10.0.0.1:80", "198.51.100.2:8080", "", "198.51.100.2"},
+		{"forwarded for IPv6 brackets", "10.0.0.1:80", "[2001:db8::2]:8080", "", "2001:db8::2"},
+		{"forwarded for bare IPv6", "[fd00::1]:80", "2001:db8::3", "", "2001:db8::3"},
+		{"forwarded for skips garbage", "10.0.0.1:80", "198.51.100.4, unknown", "", "198.51.100.4"},
+		{"forwarded for beats real ip", "10.0.0.1:80", "198.51.100.2", "198.51.100.9", "198.51.100.2"},
+		{"real ip", "10.0.0.1:80", "", "198.51.100.9", "198.51.100.9"},
+		{"real ip IPv6", "10.0.0.1:80", "", "[2001:db8::9]", "2001:db8::9"},
+		{"invalid headers fall back", "10.0.0.1:80", "garbage", "also-garbage", "10.0.0.1"},
+		{"IPv6 zone dropped", "[fe80::1%eth0]:80", "", "", "fe80::1"},
+		{"nothing valid", "pipe", "", "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/abc", nil)
+			r.RemoteAddr = tt.remoteAddr
+			if tt.forwardedFor != "" {
+				r.Header.Set("X-Forwarded-For", tt.forwardedFor)
+			}
+			if tt.realIP != "" {
+				r.Header.Set("X-Real-IP", tt.realIP)
+			}
+
+			if got := clientIP(r, trusted); got != tt.want {
+				t.Errorf("clientIP = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClientIPTrustsNothingByDefault(t *testing.T) {
+	r := httptest.NewRequest("GET", "/abc", nil)
+	r.RemoteAddr = "10.0.0.1:80"
+	r.Header.Set("X-Forwarded-For", "198.51.100.2")
+	r.Header.Set("X-Real-IP", "198.51.100.9")
+
+	if got := clientIP(r, nil); got != "10.0.0.1" {
+		t.Errorf("clientIP = %q, want the peer address 10.0.0.1", got)
+	}
+}
+
+func TestParseTrustedProxies(t *testing.T) {
+	networks, err := ParseTrustedProxies("10.0.0.0/8, 192.168.1.10 ,2001:db8::1")
+	if err != nil {
+		t.Fatalf("ParseTrustedProxies: %v", err)
+	}
+	if len(networks) != 3 {
+		t.Fatalf("got %d networks, want 3", len(networks))
+	}
+	if !isTrustedProxy("192.168.1.10", networks) || isTrustedProxy("192.168.1.11", networks) {
+		t.Error("single IPv4 entry should trust exactly that address")
+	}
+	if !isTrustedProxy("2001:db8::1", networks) {
+		t.Error("single IPv6 entry should be trusted")
+	}
+
+	if _, err := ParseTrustedProxies("10.0.0.0/33"); err == nil {
+		t.Error("ParseTrustedProxies accepted an invalid CIDR")
+	}
+	if _, err := ParseTrustedProxies("proxy.local"); err == nil {
+		t.Error("ParseTrustedProxies accepted a hostname")
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// newTestClient serves the API routes from a test server and returns a client for it
+func newTestClient(t *testing.T) *Client {
+	t.Helper()
+
+	h := newTestHandler(t)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/shorturls/", h.ShortURLResource)
+	mux.HandleFunc("/shorturls", h.CreateShortURL)
+	mux.HandleFunc("/", h.RedirectURL)
+
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return NewClient(srv.URL)
+}
+
+func TestClientCreateStatsResolve(t *testing.T) {
+	c := newTestClient(t)
+
+	created, err := c.Create(CreateShortURLRequest{URL: "example.com/page", ShortCode: "client"})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if !strings.HasSuffix(created.ShortLink, "/client") {
+		t.Errorf("ShortLink = %q, want it to end in /client", created.ShortLink)
+	}
+
+	destination, err := c.Resolve("client")
+	if err != nil {
+		t.Fatalf("Resolve: %v", err)
+	}
+	if destination != "https://example.com/page" {
+		t.Errorf("Resolve = %q, want https://example.com/page", destination)
+	}
+
+	stats, err := c.Stats("client")
+	if err != nil {
+		t.Fatalf("Stats: %v", err)
+	}
+	if stats.TotalClicks != 1 {
+		t.Errorf("TotalClicks = %d, want 1 after Resolve", stats.TotalClicks)
+	}
+}
+
+func TestClientDecodesErrorResponses(t *testing.T) {
+	c := newTestClient(t)
+
+	_, err := c.Stats("missing")
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("Stats(missing) error = %v, want *APIError", err)
+	}
+	if apiErr.StatusCode != http.StatusNotFound || apiErr.Response.Message == "" {
+		t.Errorf("APIError = %+v, want 404 with a message", apiErr)
+	}
+
+	if _, err := c.Resolve("missing"); !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusNotFound {
+		t.Errorf("Resolve(missing) error = %v, want 404 APIError", err)
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// ShortCodeGenerator proposes shortcodes for links created without one. The
+// URL service normalizes and signs each candidate, skips reserved words and
+// taken codes, and asks again up to maxGenerateAttempts times, so a
+// generator need not check the store itself. It must be safe for concurrent
+// use.
+type ShortCodeGenerator interface {
+	Generate() (string, error)
+}
+
+// RandomCodeGenerator draws codes of Length characters uniformly from
+// Alphabet with crypto/rand. It is the default generator.
+type RandomCodeGenerator struct {
+	Alphabet string
+	Length   int
+}
+
+var _ ShortCodeGenerator = RandomCodeGenerator{}
+
+// Generate returns a new random code
+func (g RandomCodeGenerator) Generate() (string, error) {
+	alphabetSize := big.NewInt(int64(len(g.Alphabet)))
+	code := make([]byte, g.Length)
+	for i := range code {
+		n, err := rand.Int(rand.Reader, alphabetSize)
+		if err != nil {
+			return "", err
+		}
+		code[i] = g.Alphabet[n.Int64()]
+	}
+	return string(code), nil
+}
+
+// shortCodeSequence names the store counter CounterCodeGenerator draws from
+const shortCodeSequence = "shortcode"
+
+// CounterCodeGenerator writes the next value of a counter kept in the store
+// in the digits of Alphabet. Codes are as short as the number of links
+// allows and, since the store hands each value out once, never collide with
+// each other, even across instances sharing the store. They are sequential
+// and so easy to enumerate; sign them with SHORTCODE_SECRET if that matters.
+type CounterCodeGenerator struct {
+	Sequence  SequenceStore
+	Alphabet  string
+	MinLength int // shorter codes are padded with the alphabet's first character
+}
+
+var _ ShortCodeGenerator = CounterCodeGenerator{}
+
+// Generate returns the code for the next counter value
+func (g CounterCodeGenerator) Generate() (string, error) {
+	value, err := g.Sequence.NextSequence(shortCodeSequence)
+	if err != nil {
+		return "", fmt.Errorf("failed to advance the shortcode counter: %v", err)
+	}
+	return encodeCounter(value, g.Alphabet, g.MinLength), nil
+}
+
+// encodeCounter writes value in base len(alphabet), left-padded to minLength
+func encodeCounter(value uint64, alphabet string, minLength int) string {
+	base := uint64(len(alphabet))
+	var digits []byte
+	for value > 0 || len(digits) < minLength {
+		digits = append(digits, alphabet[value%base])
+		value /= base
+	}
+	for i, j := 0, len(digits)-1; i < j; i, j = i+1, j-1 {
+		digits[i], digits[j] = digits[j], digits[i]
+	}
+	return string(digits)
+}
+
+// generateShortCode generates a unique shortcode with the configured generator.
+// Callers must hold s.mutex so the code stays unique until it is stored.
+func (s *URLService) generateShortCode() (string, error) {
+	for attempt := 0; attempt < maxGenerateAttempts; attempt++ {
+		candidate, err := s.generator.Generate()
+		if err != nil {
+			return "", fmt.Errorf("failed to generate shortcode: %v", err)
+		}
+
+		shortCode := s.normalizeCode(candidate)
+		if s.signingKey != nil {
+			shortCode = s.signCode(shortCode)
+		}
+		if isReservedShortCode(shortCode) || s.settings.Load().reservedCodes[strings.ToLower(shortCode)] {
+			continue
+		}
+		if err := s.validateShortCode(shortCode); err != nil {
+			return "", fmt.Errorf("generated shortcode is invalid: %v", err)
+		}
+		exists, err := s.shortCodeExists(shortCode)
+		if err != nil {
+			return "", err
+		}
+		if !exists {
+			return shortCode, nil
+		}
+	}
+
+	return "", fmt.Errorf("failed to generate unique shortcode after %d attempts", maxGenerateAttempts)
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// sequenceGenerator proposes the given codes in order
+type sequenceGenerator struct {
+	mu    sync.Mutex
+	codes []string
+}
+
+func (g *sequenceGenerator) Generate() (string, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if len(g.codes) == 0 {
+		return "", errors.New("out of codes")
+	}
+	code := g.codes[0]
+	g.codes = g.codes[1:]
+	return code, nil
+}
+
+func TestRandomCodeGeneratorUsesAlphabet(t *testing.T) {
+	g := RandomCodeGenerator{Alphabet: Base58Alphabet, Length: 12}
+	for i := 0; i < 100; i++ {
+		code, err := g.Generate()
+		if err != nil {
+			t.Fatalf("Generate: %v", err)
+		}
+		if len(code) != 12 {
+			t.Fatalf("Generate() = %q, want 12 characters", code)
+		}
+		for _, char := range code {
+			if !strings.ContainsRune(Base58Alphabet, char) {
+				t.Fatalf("Generate() = %q, which has %q outside base58", code, char)
+			}
+		}
+	}
+	if strings.ContainsAny(Base58Alphabet, "0OIl") {
+		t.Error("Base58Alphabet contains an ambiguous character")
+	}
+}
+
+func TestCustomGeneratorSkipsTakenAndReservedCodes(t *testing.T) {
+	generator := &sequenceGenerator{codes: []string{"taken1", "health", "Brand", "fresh1", "bad code"}}
+	s := newTestService(t, URLServiceConfig{Generator: generator, ReservedCodes: []string{"brand"}})
+	ctx := context.Background()
+	if _, err := s.CreateShortURL(ctx, CreateShortURLRequest{URL: "example.com", ShortCode: "taken1"}); err != nil {
+		t.Fatalf("CreateShortURL: %v", err)
+	}
+
+	created, err := s.CreateShortURL(ctx, CreateShortURLRequest{URL: "example.com/other"})
+	if err != nil {
+		t.Fatalf("CreateShortURL: %v", err)
+	}
+	if created.ShortCode != "fresh1" {
+		t.Errorf("generated %q, want the first free, unreserved candidate fresh1", created.ShortCode)
+	}
+
+	if _, err := s.CreateShortURL(ctx, CreateShortURLRequest{URL: "example.com/third"}); err == nil || !strings.Contains(err.Error(), "invalid") {
+		t.Errorf("CreateShortURL with an invalid candidate = %v, want invalid shortcode error", err)
+	}
+	if _, err := s.CreateShortURL(ctx, CreateShortURLRequest{URL: "example.com/fourth"}); err == nil || !strings.Contains(err.Error(), "out of codes") {
+		t.Errorf("CreateShortURL with a failing generator = %v, want its error", err)
+	}
+}
+
+func TestEncodeCounter(t *testing.T) {
+	tests := []struct {
+		value uint64
+		want  string
+	}{
+		{1, "0001"},
+		{61, "000Z"},
+		{62, "0010"},
+		{62 * 62 * 62 * 62, "10000"},
+	}
+	for _, tt := range tests {
+		if got := encodeCounter(tt.value, Base62Alphabet, 4); got != tt.want {
+			t.Errorf("encodeCounter(%d) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestSequentialCodes(t *testing.T) {
+	store := NewMemoryStore()
+	s, err := NewURLServiceWithConfig(newTestLogger(t), store, URLServiceConfig{SequentialCodes: true, CodeAlphabet: Base62Alphabet})
+	if err != nil {
+		t.Fatalf("NewURLServiceWithConfig: %v", err)
+	}
+	ctx := context.Background()
+	// A custom code can take the counter's next value; it is skipped
+	if _, err := s.CreateShortURL(ctx, CreateShortURLRequest{URL: "example.com/custom", ShortCode: "0002"}); err != nil {
+		t.Fatalf("CreateShortURL: %v", err)
+	}
+
+	var codes []string
+	for i := 0; i < 3; i++ {
+		created, err := s.CreateShortURL(ctx, CreateShortURLRequest{URL: fmt.Sprintf("example.com/%d", i)})
+		if err != nil {
+			t.Fatalf("CreateShortURL: %v", err)
+		}
+		codes = append(codes, created.ShortCode)
+	}
+	if got := strings.Join(codes, " "); got != "0001 0003 0004" {
+		t.Errorf("generated %s, want 0001 0003 0004", got)
+	}
+
+	// A second instance sharing the store continues the same counter
+	other, err := NewURLServiceWithConfig(newTestLogger(t), store, URLServiceConfig{SequentialCodes: true, CodeAlphabet: Base62Alphabet})
+	if err != nil {
+		t.Fatalf("NewURLServiceWithConfig: %v", err)
+	}
+	if created, err := other.CreateShortURL(ctx, CreateShortURLRequest{URL: "example.com/other"}); err != nil || created.ShortCode != "0005" {
+		t.Errorf("second instance generated %v, %v, want 0005", created, err)
+	}
+}
+
+func TestSequentialCodesNeedCounters(t *testing.T) {
+	store := struct{ Store }{NewMemoryStore()}
+	if _, err := NewURLServiceWithConfig(newTestLogger(t), store, URLServiceConfig{SequentialCodes: true}); err == nil {
+		t.Error("NewURLServiceWithConfig accepted sequential codes on a store without counters")
+	}
+}
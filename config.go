@@ -0,0 +1,532 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultPort is the port the server listens on unless PORT or -port says otherwise
+const DefaultPort = "3000"
+
+// DefaultLogEndpoint is where log entries are sent unless LOG_ENDPOINT or -log-endpoint says otherwise
+const DefaultLogEndpoint = "http://20.244.56.144/evaluation-service/logs"
+
+// DefaultShutdownTimeout is how long in-flight requests may take to finish on
+// shutdown unless SHUTDOWN_TIMEOUT says otherwise
+const DefaultShutdownTimeout = 15 * time.Second
+
+// Storage backends selectable with STORE or -store
+const (
+	StoreMemory   = "memory"
+	StoreSQLite   = "sqlite"
+	StorePostgres = "postgres"
+	StoreRedis    = "redis"
+)
+
+// StorageConfig selects the store and where it keeps its data
+type StorageConfig struct {
+	Backend    string // one of the Store* names; inferred from the settings below when empty
+	SQLitePath string
+	Postgres   PostgresConfig
+	Redis      RedisConfig
+}
+
+// Config holds every setting main needs to start the service
+type Config struct {
+	Port             string
+	LogEndpoint      string
+	LogAuthToken     string
+	DisableRemoteLog bool   // discard log entries instead of sending them
+	LogFallback      string // "stderr", "stdout" or a file path for entries the log server did not take
+	LogTee           bool   // write every entry to LogFallback as well as the log server
+	LogLevel         Level  // least severe level logged; changeable at runtime through /admin/log-level
+	AdminToken       string
+	APIKeys          []string
+	Accounts         AccountsConfig // an empty Secret disables accounts
+	TrustedProxies   []*net.IPNet
+	GeoIPPath        string // MaxMind City or Country database clicks are located with; empty disables
+	ExpiredPagePath  string // html/template file shown to browsers for expired links; empty shows the not-found page
+	NotFoundPagePath string // html/template file shown to browsers for unknown shortcodes; empty uses the built-in page
+	Homepage         string // linked from the HTML pages when set
+	PreviewLinks     bool   // show every link's preview page before redirecting
+	ClickPolicy      ClickPolicy
+	Clicks           ClickRecorderConfig // Workers 0 records clicks synchronously on the redirect
+	FetchMetadata    bool                // read new destinations' titles, descriptions and favicons
+	Metadata         MetadataConfig
+	RateLimit        RateLimitConfig // zero rates disable limiting
+	CORS             CORSConfig
+	ShutdownTimeout  time.Duration
+	Tracing          TracingConfig // an empty Endpoint disables tracing
+	Service          URLServiceConfig
+	Storage          StorageConfig
+	Cleanup          CleanupConfig  // an Interval of 0 disables cleanup
+	Snapshot         SnapshotConfig // an empty Path disables snapshots
+	File             string         // YAML config file the settings were read from, if any
+}
+
+// DefaultConfig returns the config used when no environment variables or flags are set
+func DefaultConfig() Config {
+	return Config{
+		Port:            DefaultPort,
+		LogEndpoint:     DefaultLogEndpoint,
+		LogFallback:     "stderr",
+		LogLevel:        DebugLevel,
+		ClickPolicy:     ClickPolicyBestEffort,
+		Clicks:          DefaultClickRecorderConfig(),
+		Metadata:        DefaultMetadataConfig(),
+		CORS:            DefaultCORSConfig(),
+		ShutdownTimeout: DefaultShutdownTimeout,
+		Accounts:        DefaultAccountsConfig(),
+		Tracing:         DefaultTracingConfig(),
+		Service:         DefaultURLServiceConfig(),
+		Storage: StorageConfig{
+			Postgres: DefaultPostgresConfig(),
+			Redis:    DefaultRedisConfig(),
+		},
+		Cleanup:  DefaultCleanupConfig(),
+		Snapshot: DefaultSnapshotConfig(),
+	}
+}
+
+// LoadConfig builds the configuration from, in increasing precedence, the
+// defaults, the YAML config file named by CONFIG_FILE or -config, environment
+// variables, and the command-line flags in args. Each file key is the name
+// of the environment variable it stands in for, in any case.
+func LoadConfig(args []string) (Config, error) {
+	defaults := DefaultConfig()
+	var flagged Config
+	var configPath string
+	flags := flag.NewFlagSet("trimurl", flag.ContinueOnError)
+	flags.StringVar(&configPath, "config", "", "YAML config file (CONFIG_FILE)")
+	flags.StringVar(&flagged.Port, "port", defaults.Port, "port to listen on (PORT)")
+	flags.StringVar(&flagged.Service.BaseURL, "base-url", defaults.Service.BaseURL, "scheme and host short links are served from (BASE_URL)")
+	flags.StringVar(&flagged.LogEndpoint, "log-endpoint", defaults.LogEndpoint, "URL log entries are sent to (LOG_ENDPOINT)")
+	flags.IntVar(&flagged.Service.DefaultValidity, "default-validity", defaults.Service.DefaultValidity, "minutes a link lives when the request gives no validity (DEFAULT_VALIDITY_MINUTES)")
+	flags.StringVar(&flagged.Storage.Backend, "store", "", "storage backend: memory, sqlite, postgres or redis (STORE)")
+	if err := flags.Parse(args); err != nil {
+		return defaults, err
+	}
+	if flags.NArg() > 0 {
+		return defaults, fmt.Errorf("unexpected arguments: %s", strings.Join(flags.Args(), " "))
+	}
+	given := make(map[string]bool)
+	flags.Visit(func(f *flag.Flag) { given[f.Name] = true })
+
+	if !given["config"] {
+		configPath = os.Getenv("CONFIG_FILE")
+	}
+	source, err := newSettingSource(configPath)
+	if err != nil {
+		return defaults, err
+	}
+	config := defaults
+	config.File = configPath
+	if err := config.load(source); err != nil {
+		return config, err
+	}
+	if err := source.checkUnknown(); err != nil {
+		return config, err
+	}
+
+	if given["port"] {
+		config.Port = flagged.Port
+	}
+	if given["base-url"] {
+		config.Service.BaseURL = flagged.Service.BaseURL
+	}
+	if given["log-endpoint"] {
+		config.LogEndpoint = flagged.LogEndpoint
+	}
+	if given["default-validity"] {
+		config.Service.DefaultValidity = flagged.Service.DefaultValidity
+	}
+	if given["store"] {
+		config.Storage.Backend = flagged.Storage.Backend
+	}
+
+	if err := config.validate(); err != nil {
+		return config, err
+	}
+	return config, nil
+}
+
+// load overrides defaults with every setting source has a value for
+func (c *Config) load(source *settingSource) error {
+	var err error
+	if value := source.get("PORT"); value != "" {
+		c.Port = value
+	}
+	if value := source.get("LOG_ENDPOINT"); value != "" {
+		c.LogEndpoint = value
+	}
+	c.LogAuthToken = source.get("LOG_AUTH_TOKEN")
+	c.DisableRemoteLog, _ = strconv.ParseBool(source.get("DISABLE_REMOTE_LOG"))
+	if value := source.get("LOG_FALLBACK"); value != "" {
+		c.LogFallback = value
+	}
+	if value := source.get("LOG_TEE"); value != "" {
+		if c.LogTee, err = strconv.ParseBool(value); err != nil {
+			return fmt.Errorf("LOG_TEE must be true or false")
+		}
+	}
+	if value := source.get("LOG_LEVEL"); value != "" {
+		if c.LogLevel, err = ParseLevel(value); err != nil {
+			return fmt.Errorf("LOG_LEVEL: %v", err)
+		}
+	}
+	c.AdminToken = source.get("ADMIN_TOKEN")
+	if c.APIKeys, err = ParseAPIKeys(source.get("API_KEYS")); err != nil {
+		return fmt.Errorf("API_KEYS: %v", err)
+	}
+	if err := c.Accounts.load(source); err != nil {
+		return err
+	}
+	if err := c.Tracing.load(source); err != nil {
+		return err
+	}
+
+	c.GeoIPPath = source.get("GEOIP_DB_PATH")
+	c.ExpiredPagePath = source.get("EXPIRED_PAGE_TEMPLATE")
+	c.NotFoundPagePath = source.get("NOT_FOUND_PAGE_TEMPLATE")
+	if value := source.get("HOMEPAGE_URL"); value != "" {
+		if c.Homepage, err = ParseHomepage(value); err != nil {
+			return fmt.Errorf("HOMEPAGE_URL %v", err)
+		}
+	}
+	c.TrustedProxies, err = ParseTrustedProxies(source.get("TRUSTED_PROXIES"))
+	if err != nil {
+		return fmt.Errorf("TRUSTED_PROXIES: %v", err)
+	}
+	for name, target := range map[string]*Rate{
+		"RATE_LIMIT_CREATE":   &c.RateLimit.Create,
+		"RATE_LIMIT_REDIRECT": &c.RateLimit.Redirect,
+	} {
+		if *target, err = ParseRate(source.get(name)); err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+	}
+	if value := source.get("FETCH_METADATA"); value != "" {
+		if c.FetchMetadata, err = strconv.ParseBool(value); err != nil {
+			return fmt.Errorf("FETCH_METADATA must be true or false")
+		}
+	}
+	if value := source.get("PREVIEW_LINKS"); value != "" {
+		if c.PreviewLinks, err = strconv.ParseBool(value); err != nil {
+			return fmt.Errorf("PREVIEW_LINKS must be true or false")
+		}
+	}
+	if value := source.get("RATE_LIMIT_PER_KEY"); value != "" {
+		if c.RateLimit.PerKey, err = strconv.ParseBool(value); err != nil {
+			return fmt.Errorf("RATE_LIMIT_PER_KEY must be true or false")
+		}
+	}
+	for name, target := range map[string]*int{
+		"CLICK_WORKERS":    &c.Clicks.Workers,
+		"CLICK_QUEUE_SIZE": &c.Clicks.BufferSize,
+	} {
+		if value := source.get(name); value != "" {
+			if *target, err = strconv.Atoi(value); err != nil || *target < 0 {
+				return fmt.Errorf("%s must be a non-negative integer", name)
+			}
+		}
+	}
+	if value := source.get("CLICK_POLICY"); value != "" {
+		c.ClickPolicy, err = ParseClickPolicy(value)
+		if err != nil {
+			return fmt.Errorf("CLICK_POLICY: %v", err)
+		}
+	}
+
+	// CORS is off unless CORS_ALLOWED_ORIGINS lists origins (comma-separated, or "*")
+	if origins := source.get("CORS_ALLOWED_ORIGINS"); origins != "" {
+		for _, origin := range strings.Split(origins, ",") {
+			if origin = strings.TrimSpace(origin); origin != "" {
+				c.CORS.AllowedOrigins = append(c.CORS.AllowedOrigins, origin)
+			}
+		}
+	}
+
+	if c.Service, err = loadURLServiceConfig(c.Service, source); err != nil {
+		return err
+	}
+	if err := c.Storage.load(source); err != nil {
+		return err
+	}
+
+	c.Snapshot.Path = source.get("SNAPSHOT_PATH")
+	for _, setting := range []struct {
+		name         string
+		target       *time.Duration
+		zeroDisables bool
+	}{
+		{"SHUTDOWN_TIMEOUT", &c.ShutdownTimeout, false},
+		{"METADATA_TIMEOUT", &c.Metadata.Timeout, false},
+		{"SNAPSHOT_INTERVAL", &c.Snapshot.Interval, false},
+		{"CLEANUP_INTERVAL", &c.Cleanup.Interval, true},
+		{"EXPIRED_RETENTION", &c.Cleanup.Retention, true},
+	} {
+		value := source.get(setting.name)
+		if value == "" {
+			continue
+		}
+		duration, err := time.ParseDuration(value)
+		switch {
+		case setting.zeroDisables && (err != nil || duration < 0):
+			return fmt.Errorf("%s must be a non-negative duration such as 10m", setting.name)
+		case !setting.zeroDisables && (err != nil || duration <= 0):
+			return fmt.Errorf("%s must be a positive duration such as 1m", setting.name)
+		}
+		*setting.target = duration
+	}
+	if source.get("REDIS_RETENTION") == "" {
+		// Expired links keep their stats as long as in the other stores
+		c.Storage.Redis.Retention = c.Cleanup.Retention
+	}
+	return nil
+}
+
+// load reads the account settings; they are ignored without JWT_SECRET
+func (c *AccountsConfig) load(source *settingSource) error {
+	c.Secret = source.get("JWT_SECRET")
+	c.UsersPath = source.get("USERS_PATH")
+	for _, username := range strings.Split(source.get("ACCOUNT_ADMINS"), ",") {
+		if username = strings.TrimSpace(username); username != "" {
+			c.Admins = append(c.Admins, username)
+		}
+	}
+	if value := source.get("TOKEN_TTL"); value != "" {
+		ttl, err := time.ParseDuration(value)
+		if err != nil || ttl <= 0 {
+			return fmt.Errorf("TOKEN_TTL must be a positive duration such as 24h")
+		}
+		c.TokenTTL = ttl
+	}
+	if c.Secret != "" && len(c.Secret) < minJWTSecretLength {
+		return fmt.Errorf("JWT_SECRET must be at least %d characters", minJWTSecretLength)
+	}
+	return nil
+}
+
+// load reads the tracing settings, using the standard OpenTelemetry variable
+// names where there is one
+func (c *TracingConfig) load(source *settingSource) error {
+	c.Endpoint = source.get("OTEL_EXPORTER_OTLP_ENDPOINT")
+	if value := source.get("OTEL_SERVICE_NAME"); value != "" {
+		c.ServiceName = value
+	}
+	if value := source.get("TRACE_SAMPLE_RATIO"); value != "" {
+		ratio, err := strconv.ParseFloat(value, 64)
+		if err != nil || ratio < 0 || ratio > 1 {
+			return fmt.Errorf("TRACE_SAMPLE_RATIO must be a number from 0 to 1")
+		}
+		c.SampleRatio = ratio
+	}
+	return nil
+}
+
+// load reads the store selection and its connection settings
+func (c *StorageConfig) load(source *settingSource) error {
+	c.Backend = source.get("STORE")
+	c.SQLitePath = source.get("SQLITE_PATH")
+	c.Postgres.DSN = source.get("POSTGRES_DSN")
+	c.Redis.URL = source.get("REDIS_URL")
+
+	if value := source.get("POSTGRES_MAX_CONNS"); value != "" {
+		maxConns, err := strconv.Atoi(value)
+		if err != nil || maxConns <= 0 {
+			return fmt.Errorf("POSTGRES_MAX_CONNS must be a positive integer")
+		}
+		c.Postgres.MaxConns = maxConns
+	}
+	if value := source.get("REDIS_RETENTION"); value != "" {
+		retention, err := time.ParseDuration(value)
+		if err != nil || retention < 0 {
+			return fmt.Errorf("REDIS_RETENTION must be a non-negative duration such as 24h")
+		}
+		c.Redis.Retention = retention
+	}
+	return nil
+}
+
+// validate checks the settings flags may have changed and settles the store
+// backend, inferring it from the connection setting when none was named
+func (c *Config) validate() error {
+	if port, err := strconv.Atoi(c.Port); err != nil || port < 1 || port > 65535 {
+		return fmt.Errorf("port must be a number between 1 and 65535, got %q", c.Port)
+	}
+	if c.Service.DefaultValidity <= 0 {
+		return fmt.Errorf("default validity must be a positive number of minutes")
+	}
+
+	locations := map[string]struct{ value, variable string }{
+		StoreSQLite:   {c.Storage.SQLitePath, "SQLITE_PATH"},
+		StorePostgres: {c.Storage.Postgres.DSN, "POSTGRES_DSN"},
+		StoreRedis:    {c.Storage.Redis.URL, "REDIS_URL"},
+	}
+	if c.Storage.Backend == "" {
+		c.Storage.Backend = StoreMemory
+		selected := 0
+		for backend, location := range locations {
+			if location.value != "" {
+				c.Storage.Backend = backend
+				selected++
+			}
+		}
+		if selected > 1 {
+			return fmt.Errorf("set only one of POSTGRES_DSN, REDIS_URL and SQLITE_PATH, or choose one with STORE")
+		}
+	}
+	switch c.Storage.Backend {
+	case StoreMemory:
+	case StoreSQLite, StorePostgres, StoreRedis:
+		if location := locations[c.Storage.Backend]; location.value == "" {
+			return fmt.Errorf("the %s store needs %s", c.Storage.Backend, location.variable)
+		}
+	default:
+		return fmt.Errorf("store must be %s, %s, %s or %s, got %q", StoreMemory, StoreSQLite, StorePostgres, StoreRedis, c.Storage.Backend)
+	}
+
+	if c.Snapshot.Path != "" && c.Storage.Backend != StoreMemory {
+		return fmt.Errorf("SNAPSHOT_PATH only applies to the in-memory store")
+	}
+	if c.Snapshot.Path != "" && c.Service.SequentialCodes {
+		// The counter is not snapshotted, so it would restart among restored codes
+		return fmt.Errorf("SHORTCODE_GENERATOR=counter needs a database store, not SNAPSHOT_PATH")
+	}
+	return nil
+}
+
+// loadURLServiceConfig reads URL service settings from source, keeping the
+// values in config for unset ones
+func loadURLServiceConfig(config URLServiceConfig, source *settingSource) (URLServiceConfig, error) {
+	if value := source.get("SHORTCODE_LENGTH"); value != "" {
+		length, err := strconv.Atoi(value)
+		if err != nil || length <= 0 {
+			return config, fmt.Errorf("SHORTCODE_LENGTH must be a positive integer")
+		}
+		config.CodeLength = length
+	}
+
+	if config.Destinations != nil {
+		policy := *config.Destinations
+		var err error
+		if policy.Allow, err = ParseDestinationNetworks(source.get("ALLOWED_DESTINATION_CIDRS")); err != nil {
+			return config, fmt.Errorf("ALLOWED_DESTINATION_CIDRS: %v", err)
+		}
+		if policy.Deny, err = ParseDestinationNetworks(source.get("BLOCKED_DESTINATION_CIDRS")); err != nil {
+			return config, fmt.Errorf("BLOCKED_DESTINATION_CIDRS: %v", err)
+		}
+		config.Destinations = &policy
+	}
+
+	switch generator := source.get("SHORTCODE_GENERATOR"); generator {
+	case "", "random":
+		config.SequentialCodes = false
+	case "counter":
+		config.SequentialCodes = true
+	default:
+		return config, fmt.Errorf("SHORTCODE_GENERATOR must be random or counter, got %q", generator)
+	}
+
+	switch alphabet := source.get("SHORTCODE_ALPHABET"); alphabet {
+	case "":
+		// Counter codes are meant to be short, so they default to the denser alphabet
+		config.CodeAlphabet = HexAlphabet
+		if config.SequentialCodes {
+			config.CodeAlphabet = Base62Alphabet
+		}
+	case "hex":
+		config.CodeAlphabet = HexAlphabet
+	case "base62":
+		config.CodeAlphabet = Base62Alphabet
+	case "base58":
+		config.CodeAlphabet = Base58Alphabet
+	default:
+		config.CodeAlphabet = alphabet
+	}
+
+	config.SigningSecret = source.get("SHORTCODE_SECRET")
+
+	if value := source.get("RESERVED_SHORTCODES"); value != "" {
+		config.ReservedCodes = strings.Split(value, ",")
+	}
+
+	if value := source.get("BASE_URL"); value != "" {
+		config.BaseURL = value
+	}
+
+	if value := source.get("REDIRECT_STATUS"); value != "" {
+		status, err := strconv.Atoi(value)
+		if err != nil {
+			return config, fmt.Errorf("REDIRECT_STATUS must be 301, 302, 307 or 308")
+		}
+		config.RedirectStatus = status
+	}
+
+	if value := source.get("MAX_URLS"); value != "" {
+		maxURLs, err := strconv.Atoi(value)
+		if err != nil || maxURLs < 0 {
+			return config, fmt.Errorf("MAX_URLS must be a non-negative integer")
+		}
+		config.MaxURLs = maxURLs
+	}
+
+	if value := source.get("MAX_CLICK_HISTORY"); value != "" {
+		maxClicks, err := strconv.Atoi(value)
+		if err != nil {
+			return config, fmt.Errorf("MAX_CLICK_HISTORY must be an integer")
+		}
+		// 0 keeps every click here, unlike in URLServiceConfig where it selects the default
+		if maxClicks <= 0 {
+			maxClicks = -1
+		}
+		config.MaxClickHistory = maxClicks
+	}
+
+	if value := source.get("TRASH_RETENTION"); value != "" {
+		retention, err := time.ParseDuration(value)
+		if err != nil || retention < 0 {
+			return config, fmt.Errorf("TRASH_RETENTION must be a non-negative duration such as 720h")
+		}
+		// 0 deletes at once here, unlike in URLServiceConfig where it selects the default
+		if retention == 0 {
+			retention = -1
+		}
+		config.TrashRetention = retention
+	}
+
+	for name, target := range map[string]*int{
+		"DEFAULT_VALIDITY_MINUTES": &config.DefaultValidity,
+		"MAX_VALIDITY_MINUTES":     &config.MaxValidity,
+	} {
+		if value := source.get(name); value != "" {
+			minutes, err := strconv.Atoi(value)
+			if err != nil || minutes <= 0 {
+				return config, fmt.Errorf("%s must be a positive integer", name)
+			}
+			*target = minutes
+		}
+	}
+
+	for name, target := range map[string]*bool{
+		"CASE_INSENSITIVE_CODES": &config.CaseInsensitive,
+		"DEDUPLICATE_URLS":       &config.Deduplicate,
+		"NO_PERMANENT_LINKS":     &config.NoPermanent,
+		"FORWARD_QUERY":          &config.ForwardQuery,
+	} {
+		if value := source.get(name); value != "" {
+			enabled, err := strconv.ParseBool(value)
+			if err != nil {
+				return config, fmt.Errorf("%s must be true or false", name)
+			}
+			*target = enabled
+		}
+	}
+
+	return config, nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// settingSource looks settings up by environment variable name, preferring
+// the environment over the config file, and remembers which names were asked
+// for so misspelt file keys can be reported
+type settingSource struct {
+	path string            // config file, empty when none is used
+	file map[string]string // file values keyed by upper-cased variable name
+	used map[string]bool
+}
+
+// newSettingSource reads the YAML config file at path; an empty path uses the
+// environment alone
+func newSettingSource(path string) (*settingSource, error) {
+	source := &settingSource{path: path, file: make(map[string]string), used: make(map[string]bool)}
+	if path == "" {
+		return source, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %v", err)
+	}
+	var raw map[string]interface{}
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %v", path, err)
+	}
+	for key, value := range raw {
+		name := strings.ToUpper(key)
+		switch value := value.(type) {
+		case nil:
+			source.file[name] = ""
+		case []interface{}:
+			// Lists stand in for the comma-separated variables such as CORS_ALLOWED_ORIGINS
+			items := make([]string, len(value))
+			for i, item := range value {
+				items[i] = fmt.Sprint(item)
+			}
+			source.file[name] = strings.Join(items, ",")
+		case map[string]interface{}:
+			return nil, fmt.Errorf("invalid config file %s: %s must be a value or a list, not a mapping", path, key)
+		default:
+			source.file[name] = fmt.Sprint(value)
+		}
+	}
+	return source, nil
+}
+
+// get returns the environment variable name if it is set, and the config file's value otherwise
+func (s *settingSource) get(name string) string {
+	s.used[name] = true
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return s.file[name]
+}
+
+// checkUnknown fails for config file keys that no setting asked for
+func (s *settingSource) checkUnknown() error {
+	var unknown []string
+	for name := range s.file {
+		if !s.used[name] {
+			unknown = append(unknown, strings.ToLower(name))
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return fmt.Errorf("unknown settings in config file %s: %s", s.path, strings.Join(unknown, ", "))
+	}
+	return nil
+}
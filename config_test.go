@@ -0,0 +1,180 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadConfigDefaults(t *testing.T) {
+	config, err := LoadConfig(nil)
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	if config.Port != DefaultPort || config.LogEndpoint != DefaultLogEndpoint || config.Storage.Backend != StoreMemory {
+		t.Errorf("defaults = port %s, log endpoint %s, store %s", config.Port, config.LogEndpoint, config.Storage.Backend)
+	}
+	if config.Service.DefaultValidity != defaultValidityMinutes {
+		t.Errorf("default validity = %d, want %d", config.Service.DefaultValidity, defaultValidityMinutes)
+	}
+	if retention := DefaultCleanupConfig().Retention; config.Storage.Redis.Retention != retention {
+		t.Errorf("Redis retention = %s, want the cleanup retention %s", config.Storage.Redis.Retention, retention)
+	}
+}
+
+func TestReloadConfigAppliesLimitsAndDestinations(t *testing.T) {
+	h := newTestHandler(t)
+	router := NewRouter(h, newTestLogger(t))
+	create := func(url string) int {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/shorturls", strings.NewReader(`{"url": "`+url+`"}`)))
+		return rec.Code
+	}
+
+	for i := 0; i < 2; i++ {
+		if code := create("http://203.0.113.7/"); code != http.StatusCreated {
+			t.Fatalf("create %d before the reload = %d, want 201", i+1, code)
+		}
+	}
+
+	t.Setenv("RATE_LIMIT_CREATE", "2/m")
+	t.Setenv("BLOCKED_DESTINATION_CIDRS", "203.0.113.0/24")
+	reloadConfig(nil, h.urlService, h, h.logger)
+
+	if code := create("http://203.0.113.7/"); code != http.StatusBadRequest {
+		t.Errorf("create to a newly blocked network = %d, want 400", code)
+	}
+	if code := create("example.com"); code != http.StatusCreated {
+		t.Errorf("create within the new rate = %d, want 201", code)
+	}
+	// Reloading the same rate keeps the buckets as they are
+	reloadConfig(nil, h.urlService, h, h.logger)
+	if code := create("example.com"); code != http.StatusTooManyRequests {
+		t.Errorf("create past the new rate = %d, want 429", code)
+	}
+}
+
+func TestLoadConfigRedisRetention(t *testing.T) {
+	t.Setenv("EXPIRED_RETENTION", "72h")
+	config, err := LoadConfig(nil)
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	if config.Storage.Redis.Retention != 72*time.Hour {
+		t.Errorf("Redis retention = %s, want EXPIRED_RETENTION's 72h", config.Storage.Redis.Retention)
+	}
+
+	t.Setenv("REDIS_RETENTION", "0")
+	if config, err = LoadConfig(nil); err != nil || config.Storage.Redis.Retention != 0 {
+		t.Errorf("REDIS_RETENTION=0 gave %s, %v, want entries dropped on expiry", config.Storage.Redis.Retention, err)
+	}
+}
+
+func TestLoadConfigFlagsOverrideEnv(t *testing.T) {
+	t.Setenv("PORT", "4000")
+	t.Setenv("BASE_URL", "https://env.example")
+	t.Setenv("LOG_ENDPOINT", "http://logs.example/env")
+	t.Setenv("DEFAULT_VALIDITY_MINUTES", "60")
+
+	config, err := LoadConfig([]string{"-port", "5000", "-default-validity", "15"})
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	if config.Port != "5000" || config.Service.DefaultValidity != 15 {
+		t.Errorf("flags gave port %s and validity %d, want 5000 and 15", config.Port, config.Service.DefaultValidity)
+	}
+	if config.Service.BaseURL != "https://env.example" || config.LogEndpoint != "http://logs.example/env" {
+		t.Errorf("unflagged settings = %s and %s, want the environment values", config.Service.BaseURL, config.LogEndpoint)
+	}
+}
+
+func TestLoadConfigStoreSelection(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		args    []string
+		want    string
+		wantErr bool
+	}{
+		{name: "inferred", env: map[string]string{"SQLITE_PATH": "links.db"}, want: StoreSQLite},
+		{name: "named", env: map[string]string{"SQLITE_PATH": "links.db", "REDIS_URL": "redis://localhost"}, args: []string{"-store", "redis"}, want: StoreRedis},
+		{name: "ambiguous", env: map[string]string{"SQLITE_PATH": "links.db", "REDIS_URL": "redis://localhost"}, wantErr: true},
+		{name: "missing location", env: map[string]string{"STORE": "postgres"}, wantErr: true},
+		{name: "unknown", args: []string{"-store", "cassandra"}, wantErr: true},
+		{name: "snapshot outside memory", env: map[string]string{"SQLITE_PATH": "links.db", "SNAPSHOT_PATH": "links.json"}, wantErr: true},
+		{name: "bad port", args: []string{"-port", "http"}, wantErr: true},
+		{name: "stray argument", args: []string{"serve"}, wantErr: true},
+		{name: "short token secret", env: map[string]string{"JWT_SECRET": "short"}, wantErr: true},
+		{name: "unknown log level", env: map[string]string{"LOG_LEVEL": "verbose"}, wantErr: true},
+		{name: "counter codes with snapshots", env: map[string]string{"SHORTCODE_GENERATOR": "counter", "SNAPSHOT_PATH": "links.json"}, wantErr: true},
+		{name: "unknown code generator", env: map[string]string{"SHORTCODE_GENERATOR": "uuid"}, wantErr: true},
+		{name: "negative trash retention", env: map[string]string{"TRASH_RETENTION": "-1h"}, wantErr: true},
+		{name: "relative homepage", env: map[string]string{"HOMEPAGE_URL": "/home"}, wantErr: true},
+		{name: "invalid preview mode", env: map[string]string{"PREVIEW_LINKS": "sometimes"}, wantErr: true},
+		{name: "invalid metadata timeout", env: map[string]string{"METADATA_TIMEOUT": "0s"}, wantErr: true},
+		{name: "invalid blocked destinations", env: map[string]string{"BLOCKED_DESTINATION_CIDRS": "10.0.0.0/33"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+			config, err := LoadConfig(tt.args)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("LoadConfig accepted the configuration, store %s", config.Storage.Backend)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadConfig: %v", err)
+			}
+			if config.Storage.Backend != tt.want {
+				t.Errorf("store = %s, want %s", config.Storage.Backend, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "trimurl.yaml")
+	contents := `
+port: 4000
+default_validity_minutes: 90
+redirect_status: 301
+cors_allowed_origins:
+  - https://a.example
+  - https://b.example
+`
+	if err := os.WriteFile(path, []byte(contents), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CONFIG_FILE", path)
+	t.Setenv("REDIRECT_STATUS", "307")
+
+	config, err := LoadConfig([]string{"-port", "5000"})
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	if config.Port != "5000" || config.Service.DefaultValidity != 90 || config.Service.RedirectStatus != 307 {
+		t.Errorf("port %s, validity %d, redirect %d; want the flag, file and environment values 5000, 90 and 307",
+			config.Port, config.Service.DefaultValidity, config.Service.RedirectStatus)
+	}
+	if got := strings.Join(config.CORS.AllowedOrigins, " "); got != "https://a.example https://b.example" {
+		t.Errorf("origins = %s, want both listed origins", got)
+	}
+
+	if err := os.WriteFile(path, []byte("defualt_validity_minutes: 90\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadConfig(nil); err == nil || !strings.Contains(err.Error(), "defualt_validity_minutes") {
+		t.Errorf("LoadConfig with a misspelt key = %v, want an error naming it", err)
+	}
+	if _, err := LoadConfig([]string{"-config", filepath.Join(t.TempDir(), "missing.yaml")}); err == nil {
+		t.Error("LoadConfig accepted a missing config file")
+	}
+}
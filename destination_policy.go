@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"syscall"
+	"time"
+)
+
+// ErrBlockedDestination is returned when a destination resolves to an address the policy blocks
+var ErrBlockedDestination = errors.New("destination address is not allowed")
+
+// destinationLookupTimeout bounds the DNS lookup of a new destination
+const destinationLookupTimeout = 3 * time.Second
+
+// blockedDestinationRanges are never allowed unless listed in Allow: the
+// unspecified, loopback, private (RFC 1918 and IPv6 unique local),
+// carrier-grade NAT and link-local ranges. Together they cover the cloud
+// metadata services at 169.254.169.254, fd00:ec2::254 and 100.100.100.200.
+var blockedDestinationRanges = mustParseNetworks("0.0.0.0/8, 127.0.0.0/8, 10.0.0.0/8, 172.16.0.0/12, 192.168.0.0/16, 100.64.0.0/10, 169.254.0.0/16, ::/128, ::1/128, fc00::/7, fe80::/10")
+
+// Resolver looks up the addresses of a host; *net.Resolver is one
+type Resolver interface {
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+// DestinationPolicy decides which addresses links may point at, so the
+// service cannot be used to reach its own internal network. Destinations
+// are checked when a link is created or given a new URL, and the metadata
+// fetcher checks every address it connects to, which also catches DNS
+// records changed after the link was made.
+type DestinationPolicy struct {
+	Allow    []*net.IPNet // always allowed, even inside a blocked range
+	Deny     []*net.IPNet // blocked in addition to the built-in ranges
+	Resolver Resolver     // nil means net.DefaultResolver
+}
+
+// mustParseNetworks parses a list of CIDRs known to be valid
+func mustParseNetworks(list string) []*net.IPNet {
+	networks, err := parseNetworks(list, "network")
+	if err != nil {
+		panic(err)
+	}
+	return networks
+}
+
+// ParseDestinationNetworks parses a comma-separated list of CIDRs or single
+// IP addresses for DestinationPolicy.Allow or Deny
+func ParseDestinationNetworks(list string) ([]*net.IPNet, error) {
+	return parseNetworks(list, "network")
+}
+
+// Allowed reports whether links may point at ip
+func (p *DestinationPolicy) Allowed(ip net.IP) bool {
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	if containsIP(p.Allow, ip) {
+		return true
+	}
+	return !containsIP(p.Deny, ip) && !containsIP(blockedDestinationRanges, ip)
+}
+
+// containsIP reports whether any of networks contains ip
+func containsIP(networks []*net.IPNet, ip net.IP) bool {
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckURL resolves the host of an absolute URL and returns an error
+// wrapping ErrBlockedDestination if any of its addresses is blocked. Hosts
+// that do not resolve are let through, since nothing can be reached through
+// them; the fetcher checks again when it connects.
+func (p *DestinationPolicy) CheckURL(ctx context.Context, rawURL string) error {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	host := parsed.Hostname()
+	if ip := net.ParseIP(host); ip != nil {
+		return p.check(ip)
+	}
+
+	resolver := p.Resolver
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	ctx, cancel := context.WithTimeout(ctx, destinationLookupTimeout)
+	defer cancel()
+	addrs, err := resolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil
+	}
+	for _, addr := range addrs {
+		if err := p.check(addr.IP); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// check returns an error wrapping ErrBlockedDestination for a blocked address
+func (p *DestinationPolicy) check(ip net.IP) error {
+	if !p.Allowed(ip) {
+		return fmt.Errorf("%w: %s is a private or internal address", ErrBlockedDestination, ip)
+	}
+	return nil
+}
+
+// checkDestination applies the service's destination policy, if any, to a
+// normalized URL, logging what it blocks
+func (s *URLService) checkDestination(ctx context.Context, originalURL string) error {
+	destinations := s.settings.Load().destinations
+	if destinations == nil {
+		return nil
+	}
+	err := destinations.CheckURL(ctx, originalURL)
+	if err != nil {
+		s.logger.LogContext(ctx, BackendStack, WarnLevel, DomainPackage, fmt.Sprintf("Destination %s refused: %v", originalURL, err))
+	}
+	return err
+}
+
+// controlDestination is a net.Dialer Control function applying the
+// service's current destination policy, if any
+func (s *URLService) controlDestination(network, address string, conn syscall.RawConn) error {
+	if destinations := s.settings.Load().destinations; destinations != nil {
+		return destinations.control(network, address, conn)
+	}
+	return nil
+}
+
+// control is a net.Dialer Control function refusing blocked addresses at
+// connect time, after DNS has been resolved
+func (p *DestinationPolicy) control(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return fmt.Errorf("%w: cannot parse %s", ErrBlockedDestination, host)
+	}
+	return p.check(ip)
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeResolver answers lookups from a map; unknown hosts do not resolve
+type fakeResolver map[string]string
+
+func (r fakeResolver) LookupIPAddr(_ context.Context, host string) ([]net.IPAddr, error) {
+	address, ok := r[host]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	return []net.IPAddr{{IP: net.ParseIP(address)}}, nil
+}
+
+func TestDestinationPolicyAllowed(t *testing.T) {
+	policy := &DestinationPolicy{
+		Allow: mustParseNetworks("10.20.0.0/16"),
+		Deny:  mustParseNetworks("203.0.113.0/24"),
+	}
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"93.184.216.34", true},
+		{"2606:4700::1111", true},
+		{"127.0.0.1", false},
+		{"0.0.0.0", false},
+		{"10.1.2.3", false},
+		{"172.16.5.4", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false},
+		{"100.100.100.200", false},
+		{"::1", false},
+		{"::ffff:127.0.0.1", false},
+		{"fd00:ec2::254", false},
+		{"fe80::1", false},
+		{"10.20.30.40", true},
+		{"203.0.113.9", false},
+	}
+	for _, tt := range tests {
+		if got := policy.Allowed(net.ParseIP(tt.ip)); got != tt.want {
+			t.Errorf("Allowed(%s) = %v, want %v", tt.ip, got, tt.want)
+		}
+	}
+}
+
+func TestCreateRejectsInternalDestinations(t *testing.T) {
+	s := newTestService(t, URLServiceConfig{Destinations: &DestinationPolicy{Resolver: fakeResolver{
+		"intranet.example": "10.0.0.5",
+		"public.example":   "93.184.216.34",
+	}}})
+	ctx := context.Background()
+
+	for _, destination := range []string{"http://127.0.0.1:8080/admin", "http://[::1]/", "http://169.254.169.254/latest/meta-data/", "https://intranet.example/wiki"} {
+		_, err := s.CreateShortURL(ctx, CreateShortURLRequest{URL: destination})
+		var validationErr *ValidationError
+		if !errors.As(err, &validationErr) || !strings.Contains(err.Error(), "not allowed") {
+			t.Errorf("CreateShortURL(%s) = %v, want a validation error", destination, err)
+		}
+	}
+	for _, destination := range []string{"https://public.example/", "https://unresolvable.example/"} {
+		if _, err := s.CreateShortURL(ctx, CreateShortURLRequest{URL: destination}); err != nil {
+			t.Errorf("CreateShortURL(%s) = %v, want it created", destination, err)
+		}
+	}
+
+	if _, err := s.CreateShortURL(ctx, CreateShortURLRequest{URL: "public.example", ShortCode: "moved"}); err != nil {
+		t.Fatalf("CreateShortURL: %v", err)
+	}
+	if _, err := s.UpdateShortURL(ctx, "moved", UpdateShortURLRequest{URL: "http://intranet.example/"}); err == nil || !strings.Contains(err.Error(), "not allowed") {
+		t.Errorf("UpdateShortURL to an internal host = %v, want a validation error", err)
+	}
+}
+
+func TestMetadataFetcherRefusesBlockedAddresses(t *testing.T) {
+	var fetched atomic.Bool
+	site := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetched.Store(true)
+	}))
+	defer site.Close()
+	port := site.Listener.Addr().(*net.TCPAddr).Port
+
+	// localhost looks public when the link is made but is dialled as loopback,
+	// as with a DNS record changed afterwards
+	s := newTestService(t, URLServiceConfig{Destinations: &DestinationPolicy{Resolver: fakeResolver{"localhost": "93.184.216.34"}}})
+	ctx := context.Background()
+	destination := fmt.Sprintf("http://localhost:%d/", port)
+	if _, err := s.CreateShortURL(ctx, CreateShortURLRequest{URL: destination, ShortCode: "rebound"}); err != nil {
+		t.Fatalf("CreateShortURL: %v", err)
+	}
+
+	fetcher := NewMetadataFetcher(s, s.logger, MetadataConfig{Timeout: time.Second})
+	if err := fetcher.Queue("rebound"); err != nil {
+		t.Fatalf("Queue: %v", err)
+	}
+	closeCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	if err := fetcher.Close(closeCtx); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	preview, err := s.GetPreview(ctx, "rebound")
+	if err != nil || !strings.Contains(preview.Error, "not allowed") {
+		t.Errorf("GetPreview = %+v, %v, want the blocked fetch recorded", preview, err)
+	}
+	if fetched.Load() {
+		t.Error("the fetcher connected to a loopback address")
+	}
+}
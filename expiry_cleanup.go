@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// CleanupConfig controls the background removal of expired links
+type CleanupConfig struct {
+	Interval  time.Duration // time between sweeps
+	Retention time.Duration // how long expired links keep their stats before removal
+}
+
+// DefaultCleanupConfig returns the config used by main unless overridden
+func DefaultCleanupConfig() CleanupConfig {
+	return CleanupConfig{
+		Interval:  10 * time.Minute,
+		Retention: 24 * time.Hour,
+	}
+}
+
+// PurgeExpired removes every link that expired more than retention ago, or
+// has been in the trash longer than the trash retention, along with its
+// index entry and password attempt state, and returns how many were removed
+func (s *URLService) PurgeExpired(ctx context.Context, retention time.Duration) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	shortURLs, err := s.store.List()
+	if err != nil {
+		return 0, fmt.Errorf("failed to list short URLs: %v", err)
+	}
+
+	now := s.clock.Now()
+	cutoff := now.Add(-retention)
+	purged := 0
+	for _, shortURL := range shortURLs {
+		trashExpired := !shortURL.DeletedAt.IsZero() && !now.Before(s.restorableUntil(shortURL))
+		if !shortURL.ExpiresAt.Before(cutoff) && !trashExpired {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return purged, err
+		}
+		if err := s.store.Delete(shortURL.ShortCode); err != nil {
+			return purged, fmt.Errorf("failed to remove %s: %v", shortURL.ShortCode, err)
+		}
+		s.attemptsMu.Lock()
+		delete(s.passwordAttempts, shortURL.ShortCode)
+		s.attemptsMu.Unlock()
+		purged++
+	}
+	urlsPurgedTotal.Add(float64(purged))
+	return purged, nil
+}
+
+// StartCleanup runs PurgeExpired every config.Interval in a goroutine. The
+// returned function stops it and waits for a sweep in progress to finish.
+func (s *URLService) StartCleanup(config CleanupConfig) (stop func()) {
+	if config.Interval <= 0 {
+		config.Interval = DefaultCleanupConfig().Interval
+	}
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(config.Interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				start := time.Now()
+				purged, err := s.PurgeExpired(context.Background(), config.Retention)
+				if err != nil {
+					s.logger.Log(BackendStack, ErrorLevel, CronJobPackage, fmt.Sprintf("Expiry cleanup failed after removing %d links: %v", purged, err))
+					continue
+				}
+				level := DebugLevel
+				if purged > 0 {
+					level = InfoLevel
+				}
+				s.logger.Log(BackendStack, level, CronJobPackage, fmt.Sprintf("Expiry cleanup removed %d links in %s", purged, time.Since(start)))
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(done)
+			<-stopped
+		})
+	}
+}
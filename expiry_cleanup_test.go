@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestPurgeExpired(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	s := newTestService(t, URLServiceConfig{Clock: clock})
+	ctx := context.Background()
+
+	for code, validity := range map[string]int{"brief": 10, "lasting": 600} {
+		if _, err := s.CreateShortURL(ctx, CreateShortURLRequest{URL: "example.com/" + code, ShortCode: code, Validity: validity}); err != nil {
+			t.Fatalf("CreateShortURL(%s): %v", code, err)
+		}
+	}
+
+	// Expired, but still inside the retention window
+	clock.Advance(30 * time.Minute)
+	if purged, err := s.PurgeExpired(ctx, time.Hour); err != nil || purged != 0 {
+		t.Fatalf("PurgeExpired within retention = %d, %v, want 0", purged, err)
+	}
+	if _, err := s.GetStats(ctx, "brief"); err != nil {
+		t.Errorf("stats within retention: %v", err)
+	}
+
+	clock.Advance(time.Hour)
+	if purged, err := s.PurgeExpired(ctx, time.Hour); err != nil || purged != 1 {
+		t.Fatalf("PurgeExpired after retention = %d, %v, want 1", purged, err)
+	}
+	if _, err := s.GetStats(ctx, "brief"); !errors.Is(err, ErrShortCodeNotFound) {
+		t.Errorf("stats after purge = %v, want ErrShortCodeNotFound", err)
+	}
+	if found, err := s.FindByOriginalURL(ctx, "example.com/brief"); err != nil || len(found) != 0 {
+		t.Errorf("FindByOriginalURL after purge = %v, %v, want nothing", found, err)
+	}
+	if found, _ := s.store.ListByOriginalURL("https://example.com/brief"); len(found) != 0 {
+		t.Error("purged link is still in the destination index")
+	}
+	if _, err := s.GetStats(ctx, "lasting"); err != nil {
+		t.Errorf("unexpired link was purged: %v", err)
+	}
+}
+
+func TestStartCleanupStops(t *testing.T) {
+	s := newTestService(t, URLServiceConfig{})
+	if _, err := s.CreateShortURL(context.Background(), CreateShortURLRequest{URL: "example.com", ShortCode: "swept", ExpiresIn: "1ms"}); err != nil {
+		t.Fatalf("CreateShortURL: %v", err)
+	}
+
+	stop := s.StartCleanup(CleanupConfig{Interval: 5 * time.Millisecond})
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if exists, _ := s.store.Exists("swept"); !exists {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("cleanup worker did not remove the expired link")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	stop()
+	stop()
+}
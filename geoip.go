@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/oschwald/geoip2-golang"
+)
+
+// unknownLocation is the Location of clicks whose address could not be placed
+const unknownLocation = "unknown"
+
+// GeoLocation is where a client address is, as far as the database knows
+type GeoLocation struct {
+	Country string // ISO 3166-1 alpha-2 code, e.g. "DE"
+	City    string // English city name, e.g. "Berlin"
+}
+
+// String formats the location as stored on a click: "Berlin, DE", "DE", or
+// "unknown" when nothing is known
+func (l GeoLocation) String() string {
+	switch {
+	case l.Country == "":
+		return unknownLocation
+	case l.City == "":
+		return l.Country
+	default:
+		return l.City + ", " + l.Country
+	}
+}
+
+// GeoLocator resolves client IP addresses to locations. It must return the
+// zero GeoLocation for addresses it cannot place.
+type GeoLocator interface {
+	Locate(ip string) GeoLocation
+}
+
+// GeoIPReader looks addresses up in a MaxMind GeoLite2 or GeoIP2 City or
+// Country database. It is safe for concurrent use.
+type GeoIPReader struct {
+	db          *geoip2.Reader
+	countryOnly bool // the database has no cities
+}
+
+var _ GeoLocator = (*GeoIPReader)(nil)
+
+// OpenGeoIP opens the .mmdb database at path, which must be a City or Country edition
+func OpenGeoIP(path string) (*GeoIPReader, error) {
+	db, err := geoip2.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open GeoIP database: %v", err)
+	}
+	databaseType := db.Metadata().DatabaseType
+	if !strings.Contains(databaseType, "City") && !strings.Contains(databaseType, "Country") {
+		db.Close()
+		return nil, fmt.Errorf("GeoIP database %s is a %s database, want a City or Country one", path, databaseType)
+	}
+	return &GeoIPReader{db: db, countryOnly: !strings.Contains(databaseType, "City")}, nil
+}
+
+// Locate returns the country and city of ip. Private, reserved and unlisted
+// addresses, and lookup failures, give the zero GeoLocation.
+func (g *GeoIPReader) Locate(ip string) GeoLocation {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return GeoLocation{}
+	}
+	if g.countryOnly {
+		record, err := g.db.Country(parsed)
+		if err != nil {
+			return GeoLocation{}
+		}
+		return GeoLocation{Country: record.Country.IsoCode}
+	}
+	record, err := g.db.City(parsed)
+	if err != nil {
+		return GeoLocation{}
+	}
+	return GeoLocation{Country: record.Country.IsoCode, City: record.City.Names["en"]}
+}
+
+// Close releases the database
+func (g *GeoIPReader) Close() error {
+	return g.db.Close()
+}
+
+// locateClick returns the location of the client at ip, using h.Geo when it is set
+func (h *URLHandler) locateClick(ip string) GeoLocation {
+	if h.Geo == nil || ip == "" {
+		return GeoLocation{}
+	}
+	return h.Geo.Locate(ip)
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/maxmind/mmdbwriter"
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+)
+
+// writeTestGeoIP writes a City database placing 81.2.69.0/24 in London and
+// 2.125.160.0/24 in the United Kingdom without a city
+func writeTestGeoIP(t *testing.T) string {
+	t.Helper()
+	writer, err := mmdbwriter.New(mmdbwriter.Options{DatabaseType: "GeoLite2-City", RecordSize: 24})
+	if err != nil {
+		t.Fatal(err)
+	}
+	records := map[string]mmdbtype.Map{
+		"81.2.69.0/24": {
+			"country": mmdbtype.Map{"iso_code": mmdbtype.String("GB")},
+			"city":    mmdbtype.Map{"names": mmdbtype.Map{"en": mmdbtype.String("London")}},
+		},
+		"2.125.160.0/24": {
+			"country": mmdbtype.Map{"iso_code": mmdbtype.String("GB")},
+		},
+	}
+	for cidr, record := range records {
+		_, network, _ := net.ParseCIDR(cidr)
+		if err := writer.Insert(network, record); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), "GeoLite2-City.mmdb")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if _, err := writer.WriteTo(file); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestGeoIPLocatesClicks(t *testing.T) {
+	geoIP, err := OpenGeoIP(writeTestGeoIP(t))
+	if err != nil {
+		t.Fatalf("OpenGeoIP: %v", err)
+	}
+	t.Cleanup(func() { geoIP.Close() })
+
+	h := newTestHandler(t)
+	h.Geo = geoIP
+	h.TrustedProxies, _ = ParseTrustedProxies("10.0.0.0/8")
+	ctx := context.Background()
+	if _, err := h.urlService.CreateShortURL(ctx, CreateShortURLRequest{URL: "example.com", ShortCode: "located"}); err != nil {
+		t.Fatalf("CreateShortURL: %v", err)
+	}
+
+	tests := []struct {
+		remoteAddr, forwardedFor, want string
+	}{
+		{"81.2.69.160:1000", "", "London, GB"},
+		{"10.0.0.1:1000", "2.125.160.216", "GB"},
+		{"192.0.2.1:1000", "", "unknown"},
+		{"127.0.0.1:1000", "", "unknown"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/located", nil)
+		req.RemoteAddr = tt.remoteAddr
+		if tt.forwardedFor != "" {
+			req.Header.Set("X-Forwarded-For", tt.forwardedFor)
+		}
+		rec := httptest.NewRecorder()
+		h.RedirectURL(rec, req)
+		if rec.Code != http.StatusFound {
+			t.Fatalf("redirect = %d, want 302", rec.Code)
+		}
+	}
+
+	clicks, err := h.urlService.ClickHistory(ctx, "located")
+	if err != nil {
+		t.Fatalf("ClickHistory: %v", err)
+	}
+	if len(clicks) != len(tests) {
+		t.Fatalf("recorded %d clicks, want %d", len(clicks), len(tests))
+	}
+	for i, tt := range tests {
+		if clicks[i].Location != tt.want {
+			t.Errorf("click from %s (forwarded for %q) located at %q, want %q", tt.remoteAddr, tt.forwardedFor, clicks[i].Location, tt.want)
+		}
+	}
+	if clicks[0].Country != "GB" {
+		t.Errorf("click country = %q, want GB", clicks[0].Country)
+	}
+}
+
+func TestOpenGeoIPRejectsOtherFiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "not.mmdb")
+	os.WriteFile(path, []byte("not a database"), 0o644)
+	if _, err := OpenGeoIP(path); err == nil {
+		t.Error("OpenGeoIP accepted a file that is not a MaxMind database")
+	}
+}
@@ -1,94 +1,326 @@
 package main
 
 import (
+	"context"
+	"crypto/sha256"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"html/template"
 	"io"
+	"net"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"logging-middleware/version"
+)
+
+// DefaultMaxBodyBytes is the default cap on JSON request bodies
+const DefaultMaxBodyBytes int64 = 1 << 20
+
+// DefaultRequestTimeout is the default deadline for service calls made by a handler
+const DefaultRequestTimeout = 10 * time.Second
+
+// healthPingTimeout bounds the logging server probe in the health check
+const healthPingTimeout = 2 * time.Second
+
+// DefaultRedirectMaxAge is the default upper bound on how long clients may cache a redirect
+const DefaultRedirectMaxAge = 5 * time.Minute
+
+// DefaultMaxBulkStatsCodes is the default cap on codes in one POST /shorturls/stats request
+const DefaultMaxBulkStatsCodes = 100
+
+// DefaultMaxBulkCreate is the default cap on items in one POST /shorturls/bulk request
+const DefaultMaxBulkCreate = 1000
+
+// Page sizes for GET /shorturls
+const (
+	DefaultListLimit = 50
+	MaxListLimit     = 500
+)
+
+// DefaultMaxImportBytes is the default cap on POST /admin/import bodies
+const DefaultMaxImportBytes int64 = 64 << 20
+
+// ClickPolicy decides what a redirect does when its click cannot be recorded
+type ClickPolicy string
+
+const (
+	// ClickPolicyBestEffort redirects anyway; the lost click is only logged and counted
+	ClickPolicyBestEffort ClickPolicy = "best-effort"
+	// ClickPolicyStrict fails the redirect with 500 so no visit goes uncounted
+	ClickPolicyStrict ClickPolicy = "strict"
 )
 
+// ParseClickPolicy parses a CLICK_POLICY value
+func ParseClickPolicy(value string) (ClickPolicy, error) {
+	switch policy := ClickPolicy(value); policy {
+	case ClickPolicyBestEffort, ClickPolicyStrict:
+		return policy, nil
+	}
+	return "", fmt.Errorf("click policy must be %q or %q", ClickPolicyBestEffort, ClickPolicyStrict)
+}
+
 // URLHandler handles HTTP requests for URL shortening
 type URLHandler struct {
-	urlService *URLService
-	logger     *Logger
+	urlService     *URLService
+	logger         LoggerInterface
+	MaxBodyBytes   int64
+	MaxImportBytes int64
+	MaxBulkStats   int // codes allowed in one bulk stats request
+	MaxBulkCreate  int // items allowed in one bulk create request
+	RequestTimeout time.Duration
+	RedirectMaxAge time.Duration // cap on redirect caching; links expiring sooner are cached less
+	AdminToken     string        // admin endpoints are disabled when empty
+	APIKeys        []string      // /shorturls routes require one, or an account's token, when any are set
+	Accounts       *Accounts     // user accounts and per-user links; nil disables them
+
+	// limits are the rate limits on creates and redirects, swapped by
+	// SetRateLimits; nil until it is first called, which disables them
+	limits atomic.Pointer[rateLimits]
+
+	TrustedProxies []*net.IPNet       // peers whose forwarded headers are believed; none by default
+	Geo            GeoLocator         // places clicks by client IP; nil records them as "unknown"
+	Idempotency    *IdempotencyCache  // replays creates by Idempotency-Key; nil disables
+	ClickPolicy    ClickPolicy        // what to do when a click cannot be recorded
+	Clicks         *ClickRecorder     // queues clicks off the redirect path; nil records them synchronously
+	ExpiredPage    *template.Template // shown to browsers for expired links; nil shows NotFoundPage
+	NotFoundPage   *template.Template // shown to browsers for unknown shortcodes
+	Homepage       string             // linked from the HTML pages when set
+	PreviewLinks   bool               // show the preview page unless a redirect asks for ?preview=0
+	Metadata       *MetadataFetcher   // fetches new destinations' titles and favicons; nil disables
 }
 
 // NewURLHandler creates a new URL handler
-func NewURLHandler(urlService *URLService, logger *Logger) *URLHandler {
+func NewURLHandler(urlService *URLService, logger LoggerInterface) *URLHandler {
 	return &URLHandler{
-		urlService: urlService,
-		logger:     logger,
+		urlService:     urlService,
+		logger:         logger,
+		MaxBodyBytes:   DefaultMaxBodyBytes,
+		MaxImportBytes: DefaultMaxImportBytes,
+		MaxBulkStats:   DefaultMaxBulkStatsCodes,
+		MaxBulkCreate:  DefaultMaxBulkCreate,
+		RequestTimeout: DefaultRequestTimeout,
+		RedirectMaxAge: DefaultRedirectMaxAge,
+		Idempotency:    NewIdempotencyCache(DefaultIdempotencyConfig()),
+		ClickPolicy:    ClickPolicyBestEffort,
+		NotFoundPage:   notFoundPageTemplate,
+	}
+}
+
+// statsPath returns the path of the stats resource for a shortcode, used as
+// the Location of a created link
+func statsPath(shortCode string) string {
+	return "/shorturls/" + url.PathEscape(shortCode)
+}
+
+// requestContext derives the context for service calls from the request,
+// bounded by RequestTimeout when it is positive
+func (h *URLHandler) requestContext(r *http.Request) (context.Context, context.CancelFunc) {
+	if h.RequestTimeout > 0 {
+		return context.WithTimeout(r.Context(), h.RequestTimeout)
 	}
+	return context.WithCancel(r.Context())
 }
 
 // CreateShortURL handles POST /shorturls
 func (h *URLHandler) CreateShortURL(w http.ResponseWriter, r *http.Request) {
-	h.logger.Log(BackendStack, InfoLevel, HandlerPackage, "POST /shorturls - Creating short URL")
+	timer := prometheus.NewTimer(createLatency)
+	defer timer.ObserveDuration()
 
-	if r.Method != "POST" {
-		h.logger.Log(BackendStack, ErrorLevel, HandlerPackage, "Invalid method for /shorturls")
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
+	h.logger.LogContext(r.Context(), BackendStack, InfoLevel, HandlerPackage, "POST /shorturls - Creating short URL")
 
-	// Read the raw body for debugging
+	// Read the raw body
+	r.Body = http.MaxBytesReader(w, r.Body, h.MaxBodyBytes)
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
-		h.logger.Log(BackendStack, ErrorLevel, HandlerPackage, fmt.Sprintf("Failed to read body: %v", err))
-		h.sendErrorResponse(w, "Failed to read request body", http.StatusBadRequest)
+		h.logger.LogContext(r.Context(), BackendStack, ErrorLevel, HandlerPackage, fmt.Sprintf("Failed to read body: %v", err))
+		h.sendBodyReadError(w, r, err, "Failed to read request body")
 		return
 	}
 
-	h.logger.Log(BackendStack, DebugLevel, HandlerPackage, fmt.Sprintf("Received body: %s", string(body)))
-
 	var req CreateShortURLRequest
 	if err := json.Unmarshal(body, &req); err != nil {
-		h.logger.Log(BackendStack, ErrorLevel, HandlerPackage, fmt.Sprintf("Invalid JSON: %v", err))
-		h.sendErrorResponse(w, "Invalid JSON", http.StatusBadRequest)
+		h.logger.LogContext(r.Context(), BackendStack, ErrorLevel, HandlerPackage, fmt.Sprintf("Invalid JSON: %v", err))
+		h.sendErrorResponse(w, r, "Invalid JSON", http.StatusBadRequest)
 		return
 	}
 
-	// Validate required fields
-	if req.URL == "" {
-		h.logger.Log(BackendStack, ErrorLevel, HandlerPackage, "Missing URL field")
-		h.sendErrorResponse(w, "URL is required", http.StatusBadRequest)
+	// ?dryRun=true previews the response without storing the link
+	if dryRun := r.URL.Query().Get("dryRun"); dryRun != "" {
+		enabled, err := strconv.ParseBool(dryRun)
+		if err != nil {
+			h.logger.LogContext(r.Context(), BackendStack, ErrorLevel, HandlerPackage, fmt.Sprintf("Invalid dryRun parameter: %s", dryRun))
+			h.sendErrorResponse(w, r, "dryRun must be true or false", http.StatusBadRequest)
+			return
+		}
+		req.DryRun = req.DryRun || enabled
+	}
+
+	// ?format=code answers with just the shortcode as plain text, for scripts
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "json" && format != "code" {
+		h.logger.LogContext(r.Context(), BackendStack, ErrorLevel, HandlerPackage, fmt.Sprintf("Invalid format parameter: %s", format))
+		h.sendErrorResponse(w, r, "format must be json or code", http.StatusBadRequest)
 		return
 	}
 
-	h.logger.Log(BackendStack, DebugLevel, HandlerPackage, fmt.Sprintf("Processing URL: %s", req.URL))
+	// Log the parsed body with the password redacted
+	logged := req
+	if logged.Password != "" {
+		logged.Password = "[REDACTED]"
+	}
+	loggedBody, _ := json.Marshal(logged)
+	h.logger.LogContext(r.Context(), BackendStack, DebugLevel, HandlerPackage, fmt.Sprintf("Received body: %s", string(loggedBody)))
+
+	h.logger.LogContext(r.Context(), BackendStack, DebugLevel, HandlerPackage, fmt.Sprintf("Processing URL: %s", req.URL))
+
+	// A retry with the same Idempotency-Key gets the first response back.
+	// Keys are scoped to the user so one cannot replay another's response.
+	idempotencyKey := r.Header.Get(IdempotencyKeyHeader)
+	if idempotencyKey != "" && h.Idempotency != nil {
+		if len(idempotencyKey) > maxIdempotencyKeyLength {
+			h.sendErrorResponse(w, r, fmt.Sprintf("%s must be at most %d characters", IdempotencyKeyHeader, maxIdempotencyKeyLength), http.StatusBadRequest)
+			return
+		}
+		if owner := ownerOf(r.Context()); owner != "" {
+			idempotencyKey = owner + ":" + idempotencyKey
+		}
+		replay, status, err := h.Idempotency.Begin(idempotencyKey, requestFingerprint(req))
+		if err != nil {
+			h.logger.LogContext(r.Context(), BackendStack, WarnLevel, HandlerPackage, fmt.Sprintf("Idempotency key rejected: %v", err))
+			h.sendErrorResponse(w, r, err.Error(), http.StatusConflict)
+			return
+		}
+		if replay != nil {
+			h.logger.LogContext(r.Context(), BackendStack, InfoLevel, HandlerPackage, fmt.Sprintf("Replaying response for idempotency key: %s", replay.ShortLink))
+			w.Header().Set("Idempotent-Replayed", "true")
+			if status == http.StatusCreated {
+				w.Header().Set("Location", statsPath(replay.ShortCode))
+			}
+			writeCreateResponse(w, replay, status, format)
+			return
+		}
+	}
 
 	// Create short URL
-	resp, err := h.urlService.CreateShortURL(req)
+	ctx, cancel := h.requestContext(r)
+	defer cancel()
+
+	resp, err := h.urlService.CreateShortURL(ctx, req)
 	if err != nil {
-		h.logger.Log(BackendStack, ErrorLevel, HandlerPackage, fmt.Sprintf("Failed to create short URL: %v", err))
-		h.sendErrorResponse(w, err.Error(), http.StatusBadRequest)
+		if idempotencyKey != "" && h.Idempotency != nil {
+			h.Idempotency.Release(idempotencyKey)
+		}
+		h.logger.LogContext(r.Context(), BackendStack, ErrorLevel, HandlerPackage, fmt.Sprintf("Failed to create short URL: %v", err))
+		var validationErr *ValidationError
+		switch {
+		case isContextError(err):
+			h.sendErrorResponse(w, r, "Request timed out", http.StatusServiceUnavailable)
+		case errors.As(err, &validationErr):
+			h.sendErrorDetails(w, r, err.Error(), http.StatusBadRequest, validationErr.Errors)
+		default:
+			h.sendErrorResponse(w, r, err.Error(), http.StatusBadRequest)
+		}
 		return
 	}
 
-	h.logger.Log(BackendStack, InfoLevel, HandlerPackage, fmt.Sprintf("Short URL created successfully: %s", resp.ShortLink))
+	status := http.StatusCreated
+	if resp.DryRun {
+		h.logger.LogContext(r.Context(), BackendStack, InfoLevel, HandlerPackage, fmt.Sprintf("Dry run previewed: %s", resp.ShortLink))
+		status = http.StatusOK
+	} else {
+		h.logger.LogContext(r.Context(), BackendStack, InfoLevel, HandlerPackage, fmt.Sprintf("Short URL created successfully: %s", resp.ShortLink))
+		w.Header().Set("Location", statsPath(resp.ShortCode))
+		h.queueMetadata(r, resp.ShortCode)
+	}
+	if idempotencyKey != "" && h.Idempotency != nil {
+		h.Idempotency.Complete(idempotencyKey, resp, status)
+	}
+
+	writeCreateResponse(w, resp, status, format)
+}
+
+// writeCreateResponse writes a create response as JSON, or as the bare
+// shortcode in text/plain when format is "code"
+func writeCreateResponse(w http.ResponseWriter, resp *CreateShortURLResponse, status int, format string) {
+	if format == "code" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(status)
+		fmt.Fprintln(w, resp.ShortCode)
+		return
+	}
 
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
+	w.WriteHeader(status)
 	json.NewEncoder(w).Encode(resp)
 }
 
-// RedirectURL handles GET /:shortcode (redirect)
+// RedirectURL handles GET /:shortcode (redirect), and shows the preview page
+// instead for GET /:shortcode+ and ?preview=1
 func (h *URLHandler) RedirectURL(w http.ResponseWriter, r *http.Request) {
-	shortCode := strings.TrimPrefix(r.URL.Path, "/")
-	h.logger.Log(BackendStack, InfoLevel, HandlerPackage, fmt.Sprintf("GET /%s - Redirecting", shortCode))
+	shortCode, plusSuffix := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/"), "+")
+	h.logger.LogContext(r.Context(), BackendStack, InfoLevel, HandlerPackage, fmt.Sprintf("GET /%s - Redirecting", shortCode))
 
 	// Skip API endpoints
-	if shortCode == "shorturls" || shortCode == "health" {
+	if isReservedShortCode(shortCode) {
 		return
 	}
 
+	ctx, cancel := h.requestContext(r)
+	defer cancel()
+
 	// Get original URL
-	originalURL, err := h.urlService.GetOriginalURL(shortCode)
+	shortURL, err := h.urlService.ResolveShortURL(ctx, shortCode)
 	if err != nil {
-		h.logger.Log(BackendStack, ErrorLevel, HandlerPackage, fmt.Sprintf("Redirect failed for %s: %v", shortCode, err))
-		h.sendErrorResponse(w, "Short URL not found or expired", http.StatusNotFound)
+		h.logger.LogContext(r.Context(), BackendStack, ErrorLevel, HandlerPackage, fmt.Sprintf("Redirect failed for %s: %v", shortCode, err))
+		switch {
+		case isContextError(err):
+			h.sendErrorResponse(w, r, "Request timed out", http.StatusServiceUnavailable)
+		case errors.Is(err, ErrShortCodeNotActive):
+			h.sendErrorResponse(w, r, "This short URL is not active yet", http.StatusNotFound)
+		case errors.Is(err, ErrShortCodeDisabled):
+			h.sendErrorResponse(w, r, "This short URL has been disabled", http.StatusForbidden)
+		case errors.Is(err, ErrClickLimitReached):
+			h.sendErrorResponse(w, r, "This short URL has reached its click limit", http.StatusGone)
+		default:
+			h.sendNotFound(w, r, shortCode, errors.Is(err, ErrShortCodeExpired))
+		}
+		return
+	}
+
+	// Protected links require a password via ?pw= or the password form
+	password := r.URL.Query().Get("pw")
+	if r.Method == http.MethodPost {
+		password = r.PostFormValue("password")
+	}
+	if _, err := h.urlService.CheckPassword(shortCode, password); err != nil {
+		h.logger.LogContext(r.Context(), BackendStack, WarnLevel, HandlerPackage, fmt.Sprintf("Password check failed for %s: %v", shortCode, err))
+		switch {
+		case errors.Is(err, ErrPasswordRequired):
+			renderPasswordForm(w, shortCode, "", http.StatusUnauthorized)
+		case errors.Is(err, ErrInvalidPassword):
+			renderPasswordForm(w, shortCode, "Incorrect password", http.StatusUnauthorized)
+		case errors.Is(err, ErrTooManyPasswordAttempts):
+			w.Header().Set("Retry-After", strconv.Itoa(int(passwordLockout.Seconds())))
+			renderPasswordForm(w, shortCode, "Too many attempts, try again later", http.StatusTooManyRequests)
+		default:
+			h.sendNotFound(w, r, shortCode, errors.Is(err, ErrShortCodeExpired))
+		}
+		return
+	}
+
+	// Previews come after the password check so a protected link's
+	// destination stays hidden, and are not counted as clicks
+	if r.Method != http.MethodPost && h.previewRequested(r, plusSuffix) {
+		h.logger.LogContext(r.Context(), BackendStack, InfoLevel, HandlerPackage, fmt.Sprintf("Showing preview for %s", shortCode))
+		h.renderPreview(w, r, shortURL)
 		return
 	}
 
@@ -97,16 +329,394 @@ func (h *URLHandler) RedirectURL(w http.ResponseWriter, r *http.Request) {
 	if source == "" {
 		source = "direct"
 	}
-	location := "unknown" // In a real app, you'd use IP geolocation
+	ip := clientIP(r, h.TrustedProxies)
+	location := h.locateClick(ip)
+	agent := ParseUserAgent(r.UserAgent())
+
+	click := Click{
+		Source:    source,
+		Location:  location.String(),
+		Country:   location.Country,
+		UserAgent: r.UserAgent(),
+		Browser:   agent.Browser,
+		OS:        agent.OS,
+		Device:    agent.Device,
+		IP:        ip,
+	}
+	// Click-limited links and the strict policy need the click written before
+	// the redirect, so only other clicks are queued
+	if h.Clicks != nil && h.ClickPolicy != ClickPolicyStrict && shortURL.MaxClicks == 0 {
+		if err := h.Clicks.Record(shortCode, click); err != nil {
+			clicksDroppedTotal.Inc()
+			h.logger.LogContext(r.Context(), BackendStack, WarnLevel, HandlerPackage, fmt.Sprintf("Failed to queue click for %s: %v", shortCode, err))
+		}
+	} else if err := h.urlService.RecordClick(ctx, shortCode, click); err != nil {
+		// A concurrent click may have used the last of the budget since the lookup
+		if errors.Is(err, ErrClickLimitReached) {
+			h.logger.LogContext(r.Context(), BackendStack, WarnLevel, HandlerPackage, fmt.Sprintf("Click limit reached for %s", shortCode))
+			h.sendErrorResponse(w, r, "This short URL has reached its click limit", http.StatusGone)
+			return
+		}
+		clicksDroppedTotal.Inc()
+		if h.ClickPolicy == ClickPolicyStrict {
+			h.logger.LogContext(r.Context(), BackendStack, ErrorLevel, HandlerPackage, fmt.Sprintf("Failed to record click, not redirecting: %v", err))
+			if isContextError(err) {
+				h.sendErrorResponse(w, r, "Request timed out", http.StatusServiceUnavailable)
+				return
+			}
+			h.sendErrorResponse(w, r, "Failed to record click", http.StatusInternalServerError)
+			return
+		}
+		h.logger.LogContext(r.Context(), BackendStack, WarnLevel, HandlerPackage, fmt.Sprintf("Failed to record click: %v", err))
+	}
+
+	originalURL := h.urlService.RedirectTarget(shortURL, r.URL.Query())
+	h.logger.LogContext(r.Context(), BackendStack, InfoLevel, HandlerPackage, fmt.Sprintf("Redirecting %s -> %s", shortCode, originalURL))
+
+	// Redirect to original URL with the link's status (302 unless configured otherwise)
+	redirectsTotal.Inc()
+	w.Header().Set("Cache-Control", h.redirectCacheControl(shortURL, h.urlService.clock.Now()))
+	http.Redirect(w, r, originalURL, h.urlService.RedirectStatus(shortURL))
+}
+
+// redirectCacheControl returns the Cache-Control value for a redirect: cached
+// for at most RedirectMaxAge and never past the link's expiry. Protected and
+// click-limited links are not cached so every visit reaches the server.
+func (h *URLHandler) redirectCacheControl(shortURL *ShortURL, now time.Time) string {
+	if shortURL.PasswordHash != "" || shortURL.MaxClicks > 0 {
+		return "no-store"
+	}
+
+	maxAge := shortURL.ExpiresAt.Sub(now)
+	if maxAge > h.RedirectMaxAge {
+		maxAge = h.RedirectMaxAge
+	}
+	if maxAge < time.Second {
+		return "no-store"
+	}
+	return fmt.Sprintf("max-age=%d", int(maxAge.Seconds()))
+}
+
+// ShortURLResource handles everything under /shorturls/, picking the
+// methods for the path and then dispatching on the request method
+func (h *URLHandler) ShortURLResource(w http.ResponseWriter, r *http.Request) {
+	var methods Methods
+	switch path := r.URL.Path; {
+	case path == "/shorturls/check":
+		methods = Methods{http.MethodGet: h.CheckShortCode}
+	case path == "/shorturls/reverse":
+		methods = Methods{http.MethodGet: h.ReverseLookup}
+	case path == "/shorturls/stats":
+		methods = Methods{http.MethodPost: h.GetBulkStats}
+	case path == "/shorturls/bulk":
+		methods = Methods{http.MethodPost: h.CreateShortURLsBulk}
+	case path == "/shorturls/trash":
+		methods = Methods{http.MethodGet: h.ListTrash}
+	case strings.HasSuffix(path, "/restore"):
+		methods = Methods{http.MethodPost: h.RestoreShortURL}
+	case strings.HasSuffix(path, "/qr"):
+		methods = Methods{http.MethodGet: h.GetQRCode}
+	case strings.HasSuffix(path, "/preview"):
+		methods = Methods{http.MethodGet: h.GetPreview}
+	case strings.HasSuffix(path, "/clicks.csv"):
+		methods = Methods{http.MethodGet: h.ExportClicksCSV}
+	case strings.HasSuffix(path, "/stats/timeseries"):
+		methods = Methods{http.MethodGet: h.GetTimeSeries}
+	default:
+		methods = Methods{http.MethodGet: h.GetStats, http.MethodPatch: h.UpdateShortURL, http.MethodDelete: h.DeleteShortURL}
+	}
+	methods.ServeHTTP(w, r)
+}
+
+// CreateShortURLsBulk handles POST /shorturls/bulk. The response is 200
+// whenever the batch was processed; each item reports its own success or error.
+func (h *URLHandler) CreateShortURLsBulk(w http.ResponseWriter, r *http.Request) {
+	h.logger.LogContext(r.Context(), BackendStack, InfoLevel, HandlerPackage, "POST /shorturls/bulk - Creating short URLs")
+
+	var reqs []CreateShortURLRequest
+	r.Body = http.MaxBytesReader(w, r.Body, h.MaxBodyBytes)
+	if err := json.NewDecoder(r.Body).Decode(&reqs); err != nil {
+		h.logger.LogContext(r.Context(), BackendStack, ErrorLevel, HandlerPackage, fmt.Sprintf("Invalid JSON: %v", err))
+		h.sendBodyReadError(w, r, err, "Request body must be a JSON array of create requests")
+		return
+	}
+
+	if len(reqs) == 0 {
+		h.sendErrorResponse(w, r, "At least one create request is required", http.StatusBadRequest)
+		return
+	}
+	if h.MaxBulkCreate > 0 && len(reqs) > h.MaxBulkCreate {
+		h.logger.LogContext(r.Context(), BackendStack, ErrorLevel, HandlerPackage, fmt.Sprintf("Bulk create request for %d links exceeds the limit of %d", len(reqs), h.MaxBulkCreate))
+		h.sendErrorResponse(w, r, fmt.Sprintf("At most %d links may be created at once, got %d", h.MaxBulkCreate, len(reqs)), http.StatusBadRequest)
+		return
+	}
+	// Each link costs a create token, as if sent on its own
+	if !h.allowRequest(w, r, h.createLimiter(), true, len(reqs)) {
+		return
+	}
+
+	ctx, cancel := h.requestContext(r)
+	defer cancel()
+
+	response := BulkCreateResponse{Results: h.urlService.CreateShortURLs(ctx, reqs)}
+	for _, result := range response.Results {
+		if result.Created != nil {
+			response.Created++
+			if !result.Created.DryRun {
+				h.queueMetadata(r, result.Created.ShortCode)
+			}
+		} else {
+			response.Failed++
+		}
+	}
+
+	h.logger.LogContext(r.Context(), BackendStack, InfoLevel, HandlerPackage, fmt.Sprintf("Bulk create finished: %d created, %d failed", response.Created, response.Failed))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
+
+// ListShortURLs handles GET /shorturls
+func (h *URLHandler) ListShortURLs(w http.ResponseWriter, r *http.Request) {
+	h.logger.LogContext(r.Context(), BackendStack, InfoLevel, HandlerPackage, "GET /shorturls - Listing short URLs")
+
+	options, err := parseListOptions(r)
+	if err != nil {
+		h.logger.LogContext(r.Context(), BackendStack, ErrorLevel, HandlerPackage, fmt.Sprintf("Invalid list options: %v", err))
+		h.sendErrorResponse(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	h.sendShortURLList(w, r, options)
+}
+
+// sendShortURLList writes the page of links options selects
+func (h *URLHandler) sendShortURLList(w http.ResponseWriter, r *http.Request, options ListOptions) {
+	ctx, cancel := h.requestContext(r)
+	defer cancel()
+
+	summaries, total, err := h.urlService.ListShortURLs(ctx, options)
+	if err != nil {
+		h.logger.LogContext(r.Context(), BackendStack, ErrorLevel, HandlerPackage, fmt.Sprintf("Failed to list short URLs: %v", err))
+		if isContextError(err) {
+			h.sendErrorResponse(w, r, "Request timed out", http.StatusServiceUnavailable)
+			return
+		}
+		h.sendErrorResponse(w, r, "Failed to list short URLs", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(ShortURLList{
+		ShortURLs: summaries,
+		Total:     total,
+		Offset:    options.Offset,
+		Limit:     options.Limit,
+	})
+}
+
+// CheckShortCode handles GET /shorturls/check?code=xyz
+func (h *URLHandler) CheckShortCode(w http.ResponseWriter, r *http.Request) {
+	code := r.URL.Query().Get("code")
+	h.logger.LogContext(r.Context(), BackendStack, InfoLevel, HandlerPackage, fmt.Sprintf("GET /shorturls/check - Checking availability of %q", code))
+
+	if code == "" {
+		h.logger.LogContext(r.Context(), BackendStack, ErrorLevel, HandlerPackage, "Missing code in availability check")
+		h.sendErrorResponse(w, r, "code query parameter is required", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := h.requestContext(r)
+	defer cancel()
+
+	availability, err := h.urlService.CheckAvailability(ctx, code)
+	if err != nil {
+		h.logger.LogContext(r.Context(), BackendStack, ErrorLevel, HandlerPackage, fmt.Sprintf("Availability check failed for %s: %v", code, err))
+		if isContextError(err) {
+			h.sendErrorResponse(w, r, "Request timed out", http.StatusServiceUnavailable)
+			return
+		}
+		h.sendErrorResponse(w, r, "Failed to check shortcode", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(availability)
+}
+
+// ReverseLookup handles GET /shorturls/reverse?url=..., listing the links
+// whose destination normalizes to the given URL
+func (h *URLHandler) ReverseLookup(w http.ResponseWriter, r *http.Request) {
+	rawURL := r.URL.Query().Get("url")
+	h.logger.LogContext(r.Context(), BackendStack, InfoLevel, HandlerPackage, fmt.Sprintf("GET /shorturls/reverse - Looking up links to %q", rawURL))
+
+	if rawURL == "" {
+		h.logger.LogContext(r.Context(), BackendStack, ErrorLevel, HandlerPackage, "Missing url in reverse lookup")
+		h.sendErrorResponse(w, r, "url query parameter is required", http.StatusBadRequest)
+		return
+	}
+
+	normalized, err := h.urlService.normalizeURL(rawURL)
+	if err != nil {
+		h.logger.LogContext(r.Context(), BackendStack, ErrorLevel, HandlerPackage, fmt.Sprintf("Invalid url in reverse lookup: %v", err))
+		h.sendErrorResponse(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := h.requestContext(r)
+	defer cancel()
+
+	shortURLs, err := h.urlService.FindByOriginalURL(ctx, normalized)
+	if err != nil {
+		h.logger.LogContext(r.Context(), BackendStack, ErrorLevel, HandlerPackage, fmt.Sprintf("Reverse lookup failed for %s: %v", normalized, err))
+		if isContextError(err) {
+			h.sendErrorResponse(w, r, "Request timed out", http.StatusServiceUnavailable)
+			return
+		}
+		h.sendErrorResponse(w, r, "Failed to look up short URLs", http.StatusInternalServerError)
+		return
+	}
+
+	resp := ReverseLookupResponse{URL: normalized, ShortURLs: make([]ShortURLSummary, len(shortURLs))}
+	for i, shortURL := range shortURLs {
+		resp.ShortURLs[i] = h.urlService.summarize(shortURL)
+	}
 
-	if err := h.urlService.RecordClick(shortCode, source, location); err != nil {
-		h.logger.Log(BackendStack, WarnLevel, HandlerPackage, fmt.Sprintf("Failed to record click: %v", err))
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// UpdateShortURL handles PATCH /shorturls/:shortcode. Extending the expiry
+// with validity is open to anyone, as renewals always were; changing the
+// destination or setting the expiry with expiresIn, which can shorten it,
+// takes the admin token unless a signed-in user is editing their own link.
+func (h *URLHandler) UpdateShortURL(w http.ResponseWriter, r *http.Request) {
+	shortCode := strings.TrimPrefix(r.URL.Path, "/shorturls/")
+
+	h.logger.LogContext(r.Context(), BackendStack, InfoLevel, HandlerPackage, fmt.Sprintf("PATCH /shorturls/%s - Updating short URL", shortCode))
+
+	if shortCode == "" {
+		h.logger.LogContext(r.Context(), BackendStack, ErrorLevel, HandlerPackage, "Missing shortcode in update request")
+		h.sendErrorResponse(w, r, "Shortcode is required", http.StatusBadRequest)
+		return
+	}
+
+	var req UpdateShortURLRequest
+	r.Body = http.MaxBytesReader(w, r.Body, h.MaxBodyBytes)
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.logger.LogContext(r.Context(), BackendStack, ErrorLevel, HandlerPackage, fmt.Sprintf("Invalid JSON: %v", err))
+		h.sendBodyReadError(w, r, err, "Invalid JSON")
+		return
+	}
+
+	if PrincipalFromContext(r.Context()) == nil && !h.authorizeAdmin(w, r) {
+		return
+	}
+
+	ctx, cancel := h.requestContext(r)
+	defer cancel()
+
+	resp, err := h.urlService.UpdateShortURL(ctx, shortCode, req)
+	if err != nil {
+		h.logger.LogContext(r.Context(), BackendStack, ErrorLevel, HandlerPackage, fmt.Sprintf("Failed to update %s: %v", shortCode, err))
+		var validationErr *ValidationError
+		switch {
+		case isContextError(err):
+			h.sendErrorResponse(w, r, "Request timed out", http.StatusServiceUnavailable)
+		case errors.As(err, &validationErr):
+			h.sendErrorDetails(w, r, err.Error(), http.StatusBadRequest, validationErr.Errors)
+		case errors.Is(err, ErrShortCodeNotFound):
+			h.sendErrorResponse(w, r, err.Error(), http.StatusNotFound)
+		case errors.Is(err, ErrShortCodeExpired):
+			h.sendErrorResponse(w, r, "Short URL has already expired and cannot be updated", http.StatusGone)
+		default:
+			h.sendErrorResponse(w, r, err.Error(), http.StatusBadRequest)
+		}
+		return
+	}
+	if req.URL != "" {
+		h.queueMetadata(r, shortCode)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// DeleteShortURL handles DELETE /shorturls/:shortcode. Signed-in users may
+// delete their own links; other callers need the admin token.
+func (h *URLHandler) DeleteShortURL(w http.ResponseWriter, r *http.Request) {
+	shortCode := strings.TrimPrefix(r.URL.Path, "/shorturls/")
+
+	h.logger.LogContext(r.Context(), BackendStack, InfoLevel, HandlerPackage, fmt.Sprintf("DELETE /shorturls/%s - Deleting short URL", shortCode))
+
+	if shortCode == "" {
+		h.logger.LogContext(r.Context(), BackendStack, ErrorLevel, HandlerPackage, "Missing shortcode in delete request")
+		h.sendErrorResponse(w, r, "Shortcode is required", http.StatusBadRequest)
+		return
 	}
+	if PrincipalFromContext(r.Context()) == nil && !h.authorizeAdmin(w, r) {
+		return
+	}
+
+	ctx, cancel := h.requestContext(r)
+	defer cancel()
+
+	if err := h.urlService.DeleteShortURL(ctx, shortCode); err != nil {
+		h.logger.LogContext(r.Context(), BackendStack, ErrorLevel, HandlerPackage, fmt.Sprintf("Failed to delete %s: %v", shortCode, err))
+		switch {
+		case isContextError(err):
+			h.sendErrorResponse(w, r, "Request timed out", http.StatusServiceUnavailable)
+		case errors.Is(err, ErrShortCodeNotFound):
+			h.sendErrorResponse(w, r, err.Error(), http.StatusNotFound)
+		default:
+			h.sendErrorResponse(w, r, "Failed to delete short URL", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
 
-	h.logger.Log(BackendStack, InfoLevel, HandlerPackage, fmt.Sprintf("Redirecting %s -> %s", shortCode, originalURL))
+// GetQRCode handles GET /shorturls/:shortcode/qr
+func (h *URLHandler) GetQRCode(w http.ResponseWriter, r *http.Request) {
+	shortCode := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/shorturls/"), "/qr")
+
+	h.logger.LogContext(r.Context(), BackendStack, InfoLevel, HandlerPackage, fmt.Sprintf("GET /shorturls/%s/qr - Generating QR code", shortCode))
+
+	size := defaultQRSize
+	if sizeParam := r.URL.Query().Get("size"); sizeParam != "" {
+		parsed, err := strconv.Atoi(sizeParam)
+		if err != nil {
+			h.logger.LogContext(r.Context(), BackendStack, ErrorLevel, HandlerPackage, fmt.Sprintf("Invalid QR size: %s", sizeParam))
+			h.sendErrorResponse(w, r, "size must be an integer", http.StatusBadRequest)
+			return
+		}
+		size = parsed
+	}
+
+	if _, err := h.urlService.GetOriginalURL(r.Context(), shortCode); err != nil {
+		h.logger.LogContext(r.Context(), BackendStack, ErrorLevel, HandlerPackage, fmt.Sprintf("QR code lookup failed for %s: %v", shortCode, err))
+		h.sendErrorResponse(w, r, "Short URL not found or expired", http.StatusNotFound)
+		return
+	}
+
+	png, err := generateQRCode(h.urlService.ShortLink(shortCode), size)
+	if err != nil {
+		h.logger.LogContext(r.Context(), BackendStack, ErrorLevel, HandlerPackage, fmt.Sprintf("Failed to generate QR code for %s: %v", shortCode, err))
+		h.sendErrorResponse(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
 
-	// Redirect to original URL
-	http.Redirect(w, r, originalURL, http.StatusMovedPermanently)
+	w.Header().Set("Content-Type", "image/png")
+	w.WriteHeader(http.StatusOK)
+	w.Write(png)
 }
 
 // GetStats handles GET /shorturls/:shortcode
@@ -114,50 +724,277 @@ func (h *URLHandler) GetStats(w http.ResponseWriter, r *http.Request) {
 	path := r.URL.Path
 	shortCode := strings.TrimPrefix(path, "/shorturls/")
 
-	h.logger.Log(BackendStack, InfoLevel, HandlerPackage, fmt.Sprintf("GET /shorturls/%s - Getting stats", shortCode))
+	h.logger.LogContext(r.Context(), BackendStack, InfoLevel, HandlerPackage, fmt.Sprintf("GET /shorturls/%s - Getting stats", shortCode))
 
 	if shortCode == "" {
-		h.logger.Log(BackendStack, ErrorLevel, HandlerPackage, "Missing shortcode in stats request")
-		h.sendErrorResponse(w, "Shortcode is required", http.StatusBadRequest)
+		h.logger.LogContext(r.Context(), BackendStack, ErrorLevel, HandlerPackage, "Missing shortcode in stats request")
+		h.sendErrorResponse(w, r, "Shortcode is required", http.StatusBadRequest)
+		return
+	}
+
+	filter, err := parseStatsFilter(r)
+	if err != nil {
+		h.logger.LogContext(r.Context(), BackendStack, ErrorLevel, HandlerPackage, fmt.Sprintf("Invalid stats filter for %s: %v", shortCode, err))
+		h.sendErrorResponse(w, r, err.Error(), http.StatusBadRequest)
 		return
 	}
 
+	ctx, cancel := h.requestContext(r)
+	defer cancel()
+
 	// Get statistics
-	stats, err := h.urlService.GetStats(shortCode)
+	stats, err := h.urlService.GetStatsFiltered(ctx, shortCode, filter)
 	if err != nil {
-		h.logger.Log(BackendStack, ErrorLevel, HandlerPackage, fmt.Sprintf("Failed to get stats for %s: %v", shortCode, err))
-		h.sendErrorResponse(w, err.Error(), http.StatusNotFound)
+		h.logger.LogContext(r.Context(), BackendStack, ErrorLevel, HandlerPackage, fmt.Sprintf("Failed to get stats for %s: %v", shortCode, err))
+		switch {
+		case isContextError(err):
+			h.sendErrorResponse(w, r, "Request timed out", http.StatusServiceUnavailable)
+		case errors.Is(err, ErrShortCodeNotFound):
+			// Expired links still have stats; only unknown codes are 404
+			h.sendErrorResponse(w, r, err.Error(), http.StatusNotFound)
+		default:
+			h.sendErrorResponse(w, r, "Failed to get statistics", http.StatusInternalServerError)
+		}
 		return
 	}
 
-	h.logger.Log(BackendStack, InfoLevel, HandlerPackage, fmt.Sprintf("Stats retrieved for %s: %d clicks", shortCode, stats.TotalClicks))
+	h.logger.LogContext(r.Context(), BackendStack, InfoLevel, HandlerPackage, fmt.Sprintf("Stats retrieved for %s: %d clicks", shortCode, stats.TotalClicks))
+
+	// The ETag covers the whole encoded body, so any new click, renewal,
+	// flag change or different filter yields a new tag
+	body, err := json.Marshal(stats)
+	if err != nil {
+		h.sendErrorResponse(w, r, "Failed to get statistics", http.StatusInternalServerError)
+		return
+	}
+	body = append(body, '\n')
+	etag := fmt.Sprintf(`"%x"`, sha256.Sum256(body))
+
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "no-store")
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		h.logger.LogContext(r.Context(), BackendStack, DebugLevel, HandlerPackage, fmt.Sprintf("Stats for %s not modified", shortCode))
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(stats)
+	w.Write(body)
 }
 
-// HealthCheck handles GET /health
-func (h *URLHandler) HealthCheck(w http.ResponseWriter, r *http.Request) {
-	h.logger.Log(BackendStack, DebugLevel, HandlerPackage, "GET /health - Health check")
+// GetBulkStats handles POST /shorturls/stats with a JSON array of shortcodes,
+// returning each code's stats, or an error marker for unknown codes
+func (h *URLHandler) GetBulkStats(w http.ResponseWriter, r *http.Request) {
+	h.logger.LogContext(r.Context(), BackendStack, InfoLevel, HandlerPackage, "POST /shorturls/stats - Getting bulk stats")
+
+	var codes []string
+	r.Body = http.MaxBytesReader(w, r.Body, h.MaxBodyBytes)
+	if err := json.NewDecoder(r.Body).Decode(&codes); err != nil {
+		h.logger.LogContext(r.Context(), BackendStack, ErrorLevel, HandlerPackage, fmt.Sprintf("Invalid JSON: %v", err))
+		h.sendBodyReadError(w, r, err, "Request body must be a JSON array of shortcodes")
+		return
+	}
+
+	if h.MaxBulkStats > 0 && len(codes) > h.MaxBulkStats {
+		h.logger.LogContext(r.Context(), BackendStack, ErrorLevel, HandlerPackage, fmt.Sprintf("Bulk stats request for %d codes exceeds the limit of %d", len(codes), h.MaxBulkStats))
+		h.sendErrorResponse(w, r, fmt.Sprintf("At most %d shortcodes may be requested at once, got %d", h.MaxBulkStats, len(codes)), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := h.requestContext(r)
+	defer cancel()
+
+	stats, err := h.urlService.GetStatsBulk(ctx, codes)
+	if err != nil {
+		h.logger.LogContext(r.Context(), BackendStack, ErrorLevel, HandlerPackage, fmt.Sprintf("Failed to get bulk stats: %v", err))
+		if isContextError(err) {
+			h.sendErrorResponse(w, r, "Request timed out", http.StatusServiceUnavailable)
+			return
+		}
+		h.sendErrorResponse(w, r, "Failed to get statistics", http.StatusInternalServerError)
+		return
+	}
+
+	results := make(map[string]BulkStatsResult, len(codes))
+	for _, code := range codes {
+		if found, ok := stats[code]; ok {
+			results[code] = BulkStatsResult{Stats: found}
+		} else {
+			results[code] = BulkStatsResult{Error: ErrShortCodeNotFound.Error()}
+		}
+	}
+
+	h.logger.LogContext(r.Context(), BackendStack, InfoLevel, HandlerPackage, fmt.Sprintf("Bulk stats retrieved for %d of %d codes", len(stats), len(results)))
 
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(map[string]string{
-		"status":  "healthy",
-		"message": "URL Shortener service is running",
-		"time":    time.Now().Format(time.RFC3339),
+	json.NewEncoder(w).Encode(results)
+}
+
+// etagMatches reports whether an If-None-Match header lists etag or is "*".
+// Weak tags (W/"...") match their strong form, as RFC 9110 requires for
+// If-None-Match.
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == etag || candidate == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// parseStatsFilter reads from/to (RFC3339) and offset/limit query parameters
+func parseStatsFilter(r *http.Request) (StatsFilter, error) {
+	var filter StatsFilter
+	query := r.URL.Query()
+
+	if from := query.Get("from"); from != "" {
+		parsed, err := time.Parse(time.RFC3339, from)
+		if err != nil {
+			return filter, fmt.Errorf("from must be an RFC3339 timestamp")
+		}
+		filter.From = parsed
+	}
+
+	if to := query.Get("to"); to != "" {
+		parsed, err := time.Parse(time.RFC3339, to)
+		if err != nil {
+			return filter, fmt.Errorf("to must be an RFC3339 timestamp")
+		}
+		filter.To = parsed
+	}
+
+	if offset := query.Get("offset"); offset != "" {
+		parsed, err := strconv.Atoi(offset)
+		if err != nil || parsed < 0 {
+			return filter, fmt.Errorf("offset must be a non-negative integer")
+		}
+		filter.Offset = parsed
+	}
+
+	if limit := query.Get("limit"); limit != "" {
+		parsed, err := strconv.Atoi(limit)
+		if err != nil || parsed < 0 {
+			return filter, fmt.Errorf("limit must be a non-negative integer")
+		}
+		filter.Limit = parsed
+	}
+
+	return filter, nil
+}
+
+// parseListOptions reads sort and offset/limit query parameters. limit
+// defaults to DefaultListLimit and may not exceed MaxListLimit.
+func parseListOptions(r *http.Request) (ListOptions, error) {
+	options := ListOptions{Sort: SortByCreatedAt, Limit: DefaultListLimit}
+	query := r.URL.Query()
+
+	if sortBy := query.Get("sort"); sortBy != "" {
+		if sortBy != SortByCreatedAt && sortBy != SortByClickCount {
+			return options, fmt.Errorf("sort must be %s or %s", SortByCreatedAt, SortByClickCount)
+		}
+		options.Sort = sortBy
+	}
+
+	if offset := query.Get("offset"); offset != "" {
+		parsed, err := strconv.Atoi(offset)
+		if err != nil || parsed < 0 {
+			return options, fmt.Errorf("offset must be a non-negative integer")
+		}
+		options.Offset = parsed
+	}
+
+	if limit := query.Get("limit"); limit != "" {
+		parsed, err := strconv.Atoi(limit)
+		if err != nil || parsed < 1 || parsed > MaxListLimit {
+			return options, fmt.Errorf("limit must be between 1 and %d", MaxListLimit)
+		}
+		options.Limit = parsed
+	}
+
+	return options, nil
+}
+
+// Version handles GET /version
+func (h *URLHandler) Version(w http.ResponseWriter, r *http.Request) {
+	h.logger.LogContext(r.Context(), BackendStack, DebugLevel, HandlerPackage, "GET /version - Reporting build info")
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(VersionResponse{
+		Version:   version.Version,
+		Commit:    version.Commit,
+		BuildTime: version.BuildTime,
 	})
 }
 
+// HealthCheck handles GET /health
+func (h *URLHandler) HealthCheck(w http.ResponseWriter, r *http.Request) {
+	// Probe the logging server with the health check's own log line
+	pingErr := h.logger.Ping("GET /health - Health check", healthPingTimeout)
+
+	urlCount, err := h.urlService.URLCount()
+	resp := HealthResponse{
+		Status:          "healthy",
+		Message:         "URL Shortener service is running",
+		Time:            time.Now().Format(time.RFC3339),
+		URLCount:        urlCount,
+		StoreHealthy:    err == nil,
+		LoggerReachable: pingErr == nil,
+	}
+
+	statusCode := http.StatusOK
+	if !resp.StoreHealthy {
+		h.logger.LogContext(r.Context(), BackendStack, ErrorLevel, HandlerPackage, fmt.Sprintf("Health check degraded: store error: %v", err))
+		resp.Status = "degraded"
+		resp.Message = "Storage backend is unavailable"
+		statusCode = http.StatusServiceUnavailable
+	} else if !resp.LoggerReachable {
+		h.logger.LogContext(r.Context(), BackendStack, WarnLevel, HandlerPackage, fmt.Sprintf("Health check degraded: logging server unreachable: %v", pingErr))
+		resp.Status = "degraded"
+		resp.Message = "Logging server is unreachable"
+		statusCode = http.StatusServiceUnavailable
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// sendBodyReadError sends 413 when the body limit was hit and 400 with message otherwise
+func (h *URLHandler) sendBodyReadError(w http.ResponseWriter, r *http.Request, err error, message string) {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		h.sendErrorResponse(w, r, fmt.Sprintf("Request body exceeds %d bytes", maxBytesErr.Limit), http.StatusRequestEntityTooLarge)
+		return
+	}
+	h.sendErrorResponse(w, r, message, http.StatusBadRequest)
+}
+
+// isContextError reports whether err comes from a cancelled or timed-out request context
+func isContextError(err error) bool {
+	return errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled)
+}
+
 // sendErrorResponse sends a JSON error response
-func (h *URLHandler) sendErrorResponse(w http.ResponseWriter, message string, statusCode int) {
+func (h *URLHandler) sendErrorResponse(w http.ResponseWriter, r *http.Request, message string, statusCode int) {
+	h.sendErrorDetails(w, r, message, statusCode, nil)
+}
+
+// sendErrorDetails sends a JSON error response listing per-field problems
+func (h *URLHandler) sendErrorDetails(w http.ResponseWriter, r *http.Request, message string, statusCode int, details []FieldError) {
 	errorResp := ErrorResponse{
-		Error:   http.StatusText(statusCode),
-		Message: message,
+		Error:     http.StatusText(statusCode),
+		Message:   message,
+		RequestID: RequestIDFromContext(r.Context()),
+		Details:   details,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
 	w.WriteHeader(statusCode)
 	json.NewEncoder(w).Encode(errorResp)
 }
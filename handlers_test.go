@@ -0,0 +1,652 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// newTestHandler returns a handler wired to a fresh service with default config
+func newTestHandler(t *testing.T) *URLHandler {
+	t.Helper()
+
+	s := newTestService(t, URLServiceConfig{})
+	return NewURLHandler(s, s.logger)
+}
+
+func TestRequestIDInErrorResponse(t *testing.T) {
+	h := newTestHandler(t)
+	handler := LoggingMiddleware(h.logger, BackendStack, RoutePackage)(http.HandlerFunc(h.ShortURLResource))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/shorturls/missing", nil))
+
+	requestID := rec.Header().Get(RequestIDHeader)
+	if requestID == "" {
+		t.Fatalf("response has no %s header", RequestIDHeader)
+	}
+
+	var resp ErrorResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("decoding error response: %v", err)
+	}
+	if resp.RequestID != requestID {
+		t.Errorf("requestId = %q, want header value %q", resp.RequestID, requestID)
+	}
+}
+
+func TestExportImportRoundTrip(t *testing.T) {
+	source := newTestHandler(t)
+	source.AdminToken = "secret"
+	if _, err := source.urlService.CreateShortURL(context.Background(), CreateShortURLRequest{URL: "example.com", ShortCode: "moved"}); err != nil {
+		t.Fatalf("CreateShortURL: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/admin/export", nil)
+	req.Header.Set(AdminTokenHeader, "secret")
+	rec := httptest.NewRecorder()
+	source.ExportURLs(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("export status = %d, want 200", rec.Code)
+	}
+	var exported []json.RawMessage
+	if err := json.NewDecoder(rec.Body).Decode(&exported); err != nil {
+		t.Fatalf("export is not a JSON array: %v", err)
+	}
+
+	target := newTestHandler(t)
+	target.AdminToken = "secret"
+	if _, err := target.urlService.CreateShortURL(context.Background(), CreateShortURLRequest{URL: "example.org", ShortCode: "other"}); err != nil {
+		t.Fatalf("CreateShortURL: %v", err)
+	}
+
+	// The same entry twice: the second copy of "moved" is skipped
+	body, _ := json.Marshal(append(exported, exported...))
+	req = httptest.NewRequest(http.MethodPost, "/admin/import", bytes.NewReader(body))
+	req.Header.Set(AdminTokenHeader, "secret")
+	rec = httptest.NewRecorder()
+	target.ImportURLs(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("import status = %d, want 200: %s", rec.Code, rec.Body.String())
+	}
+
+	var result ImportResponse
+	if err := json.NewDecoder(rec.Body).Decode(&result); err != nil {
+		t.Fatalf("decoding import response: %v", err)
+	}
+	if result.Imported != 1 || result.Skipped != 1 {
+		t.Errorf("import result = %+v, want 1 imported and 1 skipped", result)
+	}
+	if got, err := target.urlService.GetOriginalURL(context.Background(), "moved"); err != nil || got != "https://example.com/" {
+		t.Errorf("GetOriginalURL(moved) = %q, %v; want https://example.com/", got, err)
+	}
+}
+
+func TestAdminEndpointsRequireToken(t *testing.T) {
+	h := newTestHandler(t)
+
+	rec := httptest.NewRecorder()
+	h.ExportURLs(rec, httptest.NewRequest(http.MethodGet, "/admin/export", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("export without ADMIN_TOKEN status = %d, want 404", rec.Code)
+	}
+
+	h.AdminToken = "secret"
+	req := httptest.NewRequest(http.MethodGet, "/admin/export", nil)
+	req.Header.Set(AdminTokenHeader, "wrong")
+	rec = httptest.NewRecorder()
+	h.ExportURLs(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("export with wrong token status = %d, want 401", rec.Code)
+	}
+}
+
+func TestDisableAndReenableLink(t *testing.T) {
+	h := newTestHandler(t)
+	h.AdminToken = "secret"
+	ctx := context.Background()
+	if _, err := h.urlService.CreateShortURL(ctx, CreateShortURLRequest{URL: "example.com", ShortCode: "abuse"}); err != nil {
+		t.Fatalf("CreateShortURL: %v", err)
+	}
+
+	redirect := func() int {
+		rec := httptest.NewRecorder()
+		h.RedirectURL(rec, httptest.NewRequest(http.MethodGet, "/abuse", nil))
+		return rec.Code
+	}
+	setEnabled := func(body string) int {
+		req := httptest.NewRequest(http.MethodPatch, "/admin/shorturls/abuse", strings.NewReader(body))
+		req.Header.Set(AdminTokenHeader, "secret")
+		rec := httptest.NewRecorder()
+		h.SetEnabled(rec, req)
+		return rec.Code
+	}
+
+	if code := redirect(); code != http.StatusFound {
+		t.Fatalf("redirect before disabling = %d, want 302", code)
+	}
+	if code := setEnabled(`{}`); code != http.StatusBadRequest {
+		t.Errorf("PATCH without enabled = %d, want 400", code)
+	}
+	if code := setEnabled(`{"enabled": false}`); code != http.StatusNoContent {
+		t.Fatalf("disable = %d, want 204", code)
+	}
+	if code := redirect(); code != http.StatusForbidden {
+		t.Errorf("redirect while disabled = %d, want 403", code)
+	}
+
+	stats, err := h.urlService.GetStats(ctx, "abuse")
+	if err != nil {
+		t.Fatalf("GetStats on disabled link: %v", err)
+	}
+	if !stats.Disabled || stats.TotalClicks != 1 {
+		t.Errorf("stats = disabled %t, %d clicks; want disabled with 1 click", stats.Disabled, stats.TotalClicks)
+	}
+
+	if code := setEnabled(`{"enabled": true}`); code != http.StatusNoContent {
+		t.Fatalf("re-enable = %d, want 204", code)
+	}
+	if code := redirect(); code != http.StatusFound {
+		t.Errorf("redirect after re-enabling = %d, want 302", code)
+	}
+}
+
+func TestRedirectGoneAfterClickBudget(t *testing.T) {
+	h := newTestHandler(t)
+	if _, err := h.urlService.CreateShortURL(context.Background(), CreateShortURLRequest{URL: "example.com", ShortCode: "once", MaxClicks: 1}); err != nil {
+		t.Fatalf("CreateShortURL: %v", err)
+	}
+
+	for i, want := range []int{http.StatusFound, http.StatusGone} {
+		rec := httptest.NewRecorder()
+		h.RedirectURL(rec, httptest.NewRequest(http.MethodGet, "/once", nil))
+		if rec.Code != want {
+			t.Errorf("visit %d status = %d, want %d", i+1, rec.Code, want)
+		}
+	}
+}
+
+func TestRedirectNotFoundBeforeActivation(t *testing.T) {
+	h := newTestHandler(t)
+	activatesAt := time.Now().Add(time.Hour).Format(time.RFC3339)
+	if _, err := h.urlService.CreateShortURL(context.Background(), CreateShortURLRequest{URL: "example.com", ShortCode: "soon", ActivatesAt: activatesAt}); err != nil {
+		t.Fatalf("CreateShortURL: %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	h.RedirectURL(rec, httptest.NewRequest(http.MethodGet, "/soon", nil))
+	if rec.Code != http.StatusNotFound || !strings.Contains(rec.Body.String(), "not active yet") {
+		t.Errorf("redirect before activation = %d %s, want 404 not active yet", rec.Code, rec.Body.String())
+	}
+}
+
+func TestVersionDefaults(t *testing.T) {
+	h := newTestHandler(t)
+
+	rec := httptest.NewRecorder()
+	h.Version(rec, httptest.NewRequest(http.MethodGet, "/version", nil))
+
+	var resp VersionResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	want := VersionResponse{Version: "dev", Commit: "unknown", BuildTime: "unknown"}
+	if rec.Code != http.StatusOK || resp != want {
+		t.Errorf("GET /version = %d %+v, want 200 %+v", rec.Code, resp, want)
+	}
+}
+
+func TestCreateReturnsLocationAndShortcode(t *testing.T) {
+	h := newTestHandler(t)
+
+	rec := httptest.NewRecorder()
+	h.CreateShortURL(rec, httptest.NewRequest(http.MethodPost, "/shorturls", strings.NewReader(`{"url": "example.com", "shortcode": "located"}`)))
+
+	var resp CreateShortURLResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if rec.Code != http.StatusCreated || resp.ShortCode != "located" || !strings.HasSuffix(resp.ShortLink, "/located") {
+		t.Errorf("create = %d %+v, want 201 with shortcode located", rec.Code, resp)
+	}
+	if got := rec.Header().Get("Location"); got != "/shorturls/located" {
+		t.Errorf("Location = %q, want /shorturls/located", got)
+	}
+}
+
+func TestCreateWithIdempotencyKey(t *testing.T) {
+	h := newTestHandler(t)
+
+	create := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/shorturls", strings.NewReader(body))
+		req.Header.Set(IdempotencyKeyHeader, "retry-1")
+		rec := httptest.NewRecorder()
+		h.CreateShortURL(rec, req)
+		return rec
+	}
+
+	first := create(`{"url": "example.com"}`)
+	retry := create(`{ "url":"example.com" }`)
+	if first.Code != http.StatusCreated || retry.Code != http.StatusCreated {
+		t.Fatalf("statuses = %d, %d; want 201 twice", first.Code, retry.Code)
+	}
+	if retry.Header().Get("Location") != first.Header().Get("Location") {
+		t.Errorf("replayed Location = %q, want %q", retry.Header().Get("Location"), first.Header().Get("Location"))
+	}
+	if first.Body.String() != retry.Body.String() || retry.Header().Get("Idempotent-Replayed") != "true" {
+		t.Errorf("retry = %s (replayed %q), want replay of %s", retry.Body, retry.Header().Get("Idempotent-Replayed"), first.Body)
+	}
+	if count, _ := h.urlService.URLCount(); count != 1 {
+		t.Errorf("stored %d links, want 1", count)
+	}
+
+	if rec := create(`{"url": "other.com"}`); rec.Code != http.StatusConflict {
+		t.Errorf("different body under the same key = %d, want 409", rec.Code)
+	}
+}
+
+func TestClickPolicyOnStoreFailure(t *testing.T) {
+	for _, tt := range []struct {
+		policy ClickPolicy
+		want   int
+	}{
+		{ClickPolicyBestEffort, http.StatusFound},
+		{ClickPolicyStrict, http.StatusInternalServerError},
+	} {
+		t.Run(string(tt.policy), func(t *testing.T) {
+			store := NewMemoryStore()
+			store.Put(&ShortURL{ShortCode: "flaky", OriginalURL: "https://example.com/", ExpiresAt: time.Now().Add(time.Hour)})
+			s, err := NewURLServiceWithConfig(newTestLogger(t), failingStore{store}, URLServiceConfig{})
+			if err != nil {
+				t.Fatalf("NewURLServiceWithConfig: %v", err)
+			}
+			h := NewURLHandler(s, s.logger)
+			h.ClickPolicy = tt.policy
+
+			dropped := testutil.ToFloat64(clicksDroppedTotal)
+			rec := httptest.NewRecorder()
+			h.RedirectURL(rec, httptest.NewRequest(http.MethodGet, "/flaky", nil))
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+			if got := testutil.ToFloat64(clicksDroppedTotal) - dropped; got != 1 {
+				t.Errorf("dropped clicks counter rose by %v, want 1", got)
+			}
+		})
+	}
+
+	if _, err := ParseClickPolicy("sometimes"); err == nil {
+		t.Error("ParseClickPolicy accepted an unknown policy")
+	}
+}
+
+func TestRedirectStatus(t *testing.T) {
+	tests := []struct {
+		name          string
+		serviceStatus int
+		request       CreateShortURLRequest
+		query         string
+		want          int
+	}{
+		{"defaults to 302", 0, CreateShortURLRequest{}, "", http.StatusFound},
+		{"per-link 301", 0, CreateShortURLRequest{RedirectStatus: http.StatusMovedPermanently}, "", http.StatusMovedPermanently},
+		{"service-wide 301", http.StatusMovedPermanently, CreateShortURLRequest{}, "", http.StatusMovedPermanently},
+		{"per-link overrides service", http.StatusMovedPermanently, CreateShortURLRequest{RedirectStatus: http.StatusTemporaryRedirect}, "", http.StatusTemporaryRedirect},
+		{"protected link never permanent", 0, CreateShortURLRequest{RedirectStatus: http.StatusMovedPermanently, Password: "secret"}, "?pw=secret", http.StatusFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestService(t, URLServiceConfig{RedirectStatus: tt.serviceStatus})
+			h := NewURLHandler(s, s.logger)
+
+			tt.request.URL = "example.com"
+			tt.request.ShortCode = "status"
+			if _, err := s.CreateShortURL(context.Background(), tt.request); err != nil {
+				t.Fatalf("CreateShortURL: %v", err)
+			}
+
+			rec := httptest.NewRecorder()
+			h.RedirectURL(rec, httptest.NewRequest(http.MethodGet, "/status"+tt.query, nil))
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestCreateRejectsInvalidRedirectStatus(t *testing.T) {
+	s := newTestService(t, URLServiceConfig{})
+
+	if _, err := s.CreateShortURL(context.Background(), CreateShortURLRequest{URL: "example.com", RedirectStatus: http.StatusOK}); err == nil {
+		t.Error("CreateShortURL with redirect status 200 succeeded, want error")
+	}
+}
+
+func TestCreateReportsEveryInvalidField(t *testing.T) {
+	h := newTestHandler(t)
+
+	rec := httptest.NewRecorder()
+	h.CreateShortURL(rec, httptest.NewRequest(http.MethodPost, "/shorturls", strings.NewReader(`{"shortcode": "ab"}`)))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want 400", rec.Code)
+	}
+
+	var resp ErrorResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("decoding error response: %v", err)
+	}
+
+	fields := make(map[string]bool)
+	for _, detail := range resp.Details {
+		fields[detail.Field] = true
+	}
+	if len(resp.Details) != 2 || !fields["url"] || !fields["shortcode"] {
+		t.Errorf("details = %+v, want errors for url and shortcode", resp.Details)
+	}
+}
+
+func TestRedirectCacheControl(t *testing.T) {
+	h := newTestHandler(t)
+	ctx := context.Background()
+
+	if _, err := h.urlService.CreateShortURL(ctx, CreateShortURLRequest{URL: "example.com", ShortCode: "fresh", Validity: 60}); err != nil {
+		t.Fatalf("CreateShortURL: %v", err)
+	}
+	rec := httptest.NewRecorder()
+	h.RedirectURL(rec, httptest.NewRequest(http.MethodGet, "/fresh", nil))
+	if got, want := rec.Header().Get("Cache-Control"), "max-age=300"; got != want {
+		t.Errorf("fresh link Cache-Control = %q, want %q", got, want)
+	}
+
+	now := time.Now()
+	tests := []struct {
+		name      string
+		expiresIn time.Duration
+		password  string
+		want      string
+	}{
+		{"capped at RedirectMaxAge", time.Hour, "", "max-age=300"},
+		{"near expiry", 42*time.Second + 500*time.Millisecond, "", "max-age=42"},
+		{"under a second left", 500 * time.Millisecond, "", "no-store"},
+		{"already expired", -time.Second, "", "no-store"},
+		{"protected", time.Hour, "hash", "no-store"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			shortURL := &ShortURL{ExpiresAt: now.Add(tt.expiresIn), PasswordHash: tt.password}
+			if got := h.redirectCacheControl(shortURL, now); got != tt.want {
+				t.Errorf("redirectCacheControl = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStatsETag(t *testing.T) {
+	h := newTestHandler(t)
+	ctx := context.Background()
+	if _, err := h.urlService.CreateShortURL(ctx, CreateShortURLRequest{URL: "example.com", ShortCode: "polled"}); err != nil {
+		t.Fatalf("CreateShortURL: %v", err)
+	}
+
+	get := func(ifNoneMatch string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/shorturls/polled", nil)
+		if ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		rec := httptest.NewRecorder()
+		h.GetStats(rec, req)
+		return rec
+	}
+
+	first := get("")
+	etag := first.Header().Get("ETag")
+	if first.Code != http.StatusOK || etag == "" {
+		t.Fatalf("first GET = %d with ETag %q, want 200 with an ETag", first.Code, etag)
+	}
+
+	for _, header := range []string{etag, "W/" + etag, `"other", ` + etag, "*"} {
+		rec := get(header)
+		if rec.Code != http.StatusNotModified || rec.Body.Len() != 0 || rec.Header().Get("ETag") != etag {
+			t.Errorf("If-None-Match %s = %d with %d body bytes, want empty 304 with the same ETag", header, rec.Code, rec.Body.Len())
+		}
+	}
+
+	if err := h.urlService.RecordClick(ctx, "polled", Click{Source: "direct"}); err != nil {
+		t.Fatalf("RecordClick: %v", err)
+	}
+	after := get(etag)
+	if after.Code != http.StatusOK || after.Header().Get("ETag") == etag {
+		t.Errorf("GET after a click = %d with ETag %q, want 200 with a new ETag", after.Code, after.Header().Get("ETag"))
+	}
+}
+
+func TestStatsAndErrorsAreNotCached(t *testing.T) {
+	h := newTestHandler(t)
+	if _, err := h.urlService.CreateShortURL(context.Background(), CreateShortURLRequest{URL: "example.com", ShortCode: "cached"}); err != nil {
+		t.Fatalf("CreateShortURL: %v", err)
+	}
+
+	for _, path := range []string{"/shorturls/cached", "/shorturls/missing"} {
+		rec := httptest.NewRecorder()
+		h.ShortURLResource(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if got := rec.Header().Get("Cache-Control"); got != "no-store" {
+			t.Errorf("GET %s Cache-Control = %q, want no-store", path, got)
+		}
+	}
+}
+
+func TestBulkStats(t *testing.T) {
+	h := newTestHandler(t)
+	ctx := context.Background()
+	for _, code := range []string{"first", "second"} {
+		if _, err := h.urlService.CreateShortURL(ctx, CreateShortURLRequest{URL: "example.com/" + code, ShortCode: code}); err != nil {
+			t.Fatalf("CreateShortURL(%s): %v", code, err)
+		}
+	}
+	if err := h.urlService.RecordClick(ctx, "second", Click{Source: "direct"}); err != nil {
+		t.Fatalf("RecordClick: %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	h.ShortURLResource(rec, httptest.NewRequest(http.MethodPost, "/shorturls/stats", strings.NewReader(`["first", "second", "missing"]`)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("POST /shorturls/stats = %d, want 200: %s", rec.Code, rec.Body.String())
+	}
+
+	var results map[string]BulkStatsResult
+	if err := json.Unmarshal(rec.Body.Bytes(), &results); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if len(results) != 3 {
+		t.Fatalf("got %d results, want 3: %v", len(results), results)
+	}
+	if got := results["second"]; got.Stats == nil || got.Stats.TotalClicks != 1 {
+		t.Errorf("second = %+v, want stats with 1 click", got)
+	}
+	if got := results["first"]; got.Stats == nil || got.Stats.TotalClicks != 0 {
+		t.Errorf("first = %+v, want stats with no clicks", got)
+	}
+	if got := results["missing"]; got.Stats != nil || got.Error == "" {
+		t.Errorf("missing = %+v, want an error marker", got)
+	}
+}
+
+func TestBulkStatsRejectsTooManyCodes(t *testing.T) {
+	h := newTestHandler(t)
+	h.MaxBulkStats = 2
+
+	for body, want := range map[string]int{
+		`["a", "b"]`:      http.StatusOK,
+		`["a", "b", "c"]`: http.StatusBadRequest,
+		`{"codes": []}`:   http.StatusBadRequest,
+	} {
+		rec := httptest.NewRecorder()
+		h.ShortURLResource(rec, httptest.NewRequest(http.MethodPost, "/shorturls/stats", strings.NewReader(body)))
+		if rec.Code != want {
+			t.Errorf("POST /shorturls/stats %s = %d, want %d", body, rec.Code, want)
+		}
+	}
+}
+
+func TestCreateFormatCode(t *testing.T) {
+	h := newTestHandler(t)
+
+	rec := httptest.NewRecorder()
+	h.CreateShortURL(rec, httptest.NewRequest(http.MethodPost, "/shorturls?format=code", strings.NewReader(`{"url": "example.com", "shortcode": "piped"}`)))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("POST ?format=code = %d, want 201: %s", rec.Code, rec.Body.String())
+	}
+	if got := rec.Header().Get("Content-Type"); got != "text/plain; charset=utf-8" {
+		t.Errorf("Content-Type = %q, want text/plain", got)
+	}
+	if got := rec.Body.String(); got != "piped\n" {
+		t.Errorf("body = %q, want the bare shortcode", got)
+	}
+
+	// Errors stay JSON so clients can still read the details
+	rec = httptest.NewRecorder()
+	h.CreateShortURL(rec, httptest.NewRequest(http.MethodPost, "/shorturls?format=code", strings.NewReader(`{"url": ""}`)))
+	if rec.Code != http.StatusBadRequest || rec.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("invalid create with ?format=code = %d %q, want a 400 JSON error", rec.Code, rec.Header().Get("Content-Type"))
+	}
+
+	rec = httptest.NewRecorder()
+	h.CreateShortURL(rec, httptest.NewRequest(http.MethodPost, "/shorturls?format=xml", strings.NewReader(`{"url": "example.com"}`)))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("POST ?format=xml = %d, want 400", rec.Code)
+	}
+}
+
+func TestReverseLookup(t *testing.T) {
+	h := newTestHandler(t)
+	if _, err := h.urlService.CreateShortURL(context.Background(), CreateShortURLRequest{URL: "example.com/target", ShortCode: "target"}); err != nil {
+		t.Fatalf("CreateShortURL: %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	h.ShortURLResource(rec, httptest.NewRequest(http.MethodGet, "/shorturls/reverse?url="+url.QueryEscape("https://example.com/target"), nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET /shorturls/reverse = %d, want 200: %s", rec.Code, rec.Body.String())
+	}
+	var resp ReverseLookupResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if len(resp.ShortURLs) != 1 || resp.ShortURLs[0].ShortCode != "target" {
+		t.Errorf("reverse lookup = %+v, want the target link", resp)
+	}
+
+	for _, query := range []string{"", "?url=" + url.QueryEscape("ftp://example.com")} {
+		rec := httptest.NewRecorder()
+		h.ShortURLResource(rec, httptest.NewRequest(http.MethodGet, "/shorturls/reverse"+query, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("GET /shorturls/reverse%s = %d, want 400", query, rec.Code)
+		}
+	}
+}
+
+func TestUpdateRequiresAdminForDestination(t *testing.T) {
+	h := newTestHandler(t)
+	h.AdminToken = "secret"
+	if _, err := h.urlService.CreateShortURL(context.Background(), CreateShortURLRequest{URL: "example.com", ShortCode: "guarded"}); err != nil {
+		t.Fatalf("CreateShortURL: %v", err)
+	}
+
+	patch := func(body, token string) int {
+		req := httptest.NewRequest(http.MethodPatch, "/shorturls/guarded", strings.NewReader(body))
+		if token != "" {
+			req.Header.Set(AdminTokenHeader, token)
+		}
+		rec := httptest.NewRecorder()
+		h.ShortURLResource(rec, req)
+		return rec.Code
+	}
+
+	if code := patch(`{"validity": 10}`, ""); code != http.StatusUnauthorized {
+		t.Errorf("extending without a token = %d, want 401", code)
+	}
+	if code := patch(`{"validity": 10}`, "secret"); code != http.StatusOK {
+		t.Errorf("extending with the token = %d, want 200", code)
+	}
+	if code := patch(`{"url": "https://elsewhere.example"}`, ""); code != http.StatusUnauthorized {
+		t.Errorf("changing the destination without a token = %d, want 401", code)
+	}
+	if code := patch(`{"expiresIn": "1m"}`, "wrong"); code != http.StatusUnauthorized {
+		t.Errorf("setting the expiry with a wrong token = %d, want 401", code)
+	}
+	if code := patch(`{"url": "https://elsewhere.example"}`, "secret"); code != http.StatusOK {
+		t.Errorf("changing the destination with the token = %d, want 200", code)
+	}
+	if code := patch(`{"url": "javascript:alert(1)"}`, "secret"); code != http.StatusBadRequest {
+		t.Errorf("changing to an invalid destination = %d, want 400", code)
+	}
+}
+
+func TestListShortURLsQuery(t *testing.T) {
+	h := newTestHandler(t)
+	for _, code := range []string{"listed1", "listed2"} {
+		if _, err := h.urlService.CreateShortURL(context.Background(), CreateShortURLRequest{URL: "example.com", ShortCode: code}); err != nil {
+			t.Fatalf("CreateShortURL(%s): %v", code, err)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	h.ListShortURLs(rec, httptest.NewRequest(http.MethodGet, "/shorturls?limit=1&sort=click_count", nil))
+	var list ShortURLList
+	if err := json.NewDecoder(rec.Body).Decode(&list); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if rec.Code != http.StatusOK || len(list.ShortURLs) != 1 || list.Total != 2 || list.Limit != 1 {
+		t.Errorf("GET /shorturls?limit=1 = %d %+v, want one of two links", rec.Code, list)
+	}
+
+	for _, query := range []string{"sort=name", "limit=0", "limit=100000", "offset=-1"} {
+		rec := httptest.NewRecorder()
+		h.ListShortURLs(rec, httptest.NewRequest(http.MethodGet, "/shorturls?"+query, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("GET /shorturls?%s = %d, want 400", query, rec.Code)
+		}
+	}
+}
+
+func TestBulkCreate(t *testing.T) {
+	h := newTestHandler(t)
+	h.MaxBulkCreate = 2
+
+	rec := httptest.NewRecorder()
+	h.ShortURLResource(rec, httptest.NewRequest(http.MethodPost, "/shorturls/bulk", strings.NewReader(`[{"url": "example.com", "shortcode": "bulkone"}, {"url": "ftp://example.com"}]`)))
+	var response BulkCreateResponse
+	if err := json.NewDecoder(rec.Body).Decode(&response); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if rec.Code != http.StatusOK || response.Created != 1 || response.Failed != 1 {
+		t.Fatalf("POST /shorturls/bulk = %d %+v, want one created and one failed", rec.Code, response)
+	}
+	if created := response.Results[0].Created; created == nil || created.ShortCode != "bulkone" {
+		t.Errorf("first result = %+v, want bulkone created", response.Results[0])
+	}
+	if response.Results[1].Error == "" || len(response.Results[1].Details) == 0 {
+		t.Errorf("second result = %+v, want a validation error", response.Results[1])
+	}
+
+	for body, want := range map[string]int{
+		`[]`: http.StatusBadRequest,
+		`[{"url": "a.example"}, {"url": "b.example"}, {"url": "c.example"}]`: http.StatusBadRequest,
+		`{"url": "example.com"}`: http.StatusBadRequest,
+	} {
+		rec := httptest.NewRecorder()
+		h.ShortURLResource(rec, httptest.NewRequest(http.MethodPost, "/shorturls/bulk", strings.NewReader(body)))
+		if rec.Code != want {
+			t.Errorf("POST /shorturls/bulk %s = %d, want %d", body, rec.Code, want)
+		}
+	}
+}
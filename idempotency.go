@@ -0,0 +1,147 @@
+package main
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+)
+
+// IdempotencyKeyHeader lets clients retry POST /shorturls without minting a second link
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// maxIdempotencyKeyLength bounds the keys clients may send
+const maxIdempotencyKeyLength = 255
+
+var (
+	// ErrIdempotencyKeyReused is returned when a key is sent again with a different request
+	ErrIdempotencyKeyReused = errors.New("idempotency key was used with a different request")
+	// ErrIdempotencyKeyInFlight is returned while the first request with a key is still running
+	ErrIdempotencyKeyInFlight = errors.New("a request with this idempotency key is in progress")
+)
+
+// IdempotencyConfig controls how long create responses are kept for replay
+type IdempotencyConfig struct {
+	TTL     time.Duration // how long a response is replayed for its key
+	MaxKeys int           // oldest keys are evicted beyond this many
+}
+
+// DefaultIdempotencyConfig returns the config used by NewURLHandler
+func DefaultIdempotencyConfig() IdempotencyConfig {
+	return IdempotencyConfig{
+		TTL:     24 * time.Hour,
+		MaxKeys: 10000,
+	}
+}
+
+// idempotencyEntry is the outcome recorded for one key
+type idempotencyEntry struct {
+	key         string
+	fingerprint [sha256.Size]byte
+	response    *CreateShortURLResponse // nil while the first request is in flight
+	status      int
+	expiresAt   time.Time
+}
+
+// IdempotencyCache remembers create responses by Idempotency-Key. Entries
+// are queued in expiry order, so expired and excess keys are evicted from
+// the front.
+type IdempotencyCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	maxKeys int
+	entries map[string]*list.Element
+	order   *list.List
+}
+
+// NewIdempotencyCache creates an empty cache
+func NewIdempotencyCache(config IdempotencyConfig) *IdempotencyCache {
+	return &IdempotencyCache{
+		ttl:     config.TTL,
+		maxKeys: config.MaxKeys,
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+	}
+}
+
+// requestFingerprint identifies a decoded create request, so a reused key
+// with a different body can be told apart from a retry regardless of JSON
+// formatting
+func requestFingerprint(req CreateShortURLRequest) [sha256.Size]byte {
+	encoded, _ := json.Marshal(req)
+	return sha256.Sum256(encoded)
+}
+
+// Begin claims key for a request. A completed earlier request with the same
+// fingerprint returns its response for replay; otherwise the key is reserved
+// and the caller must finish with Complete or Release.
+func (c *IdempotencyCache) Begin(key string, fingerprint [sha256.Size]byte) (*CreateShortURLResponse, int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	c.evict(now)
+
+	if element, ok := c.entries[key]; ok {
+		entry := element.Value.(*idempotencyEntry)
+		switch {
+		case entry.fingerprint != fingerprint:
+			return nil, 0, ErrIdempotencyKeyReused
+		case entry.response == nil:
+			return nil, 0, ErrIdempotencyKeyInFlight
+		}
+		replay := *entry.response
+		return &replay, entry.status, nil
+	}
+
+	c.entries[key] = c.order.PushBack(&idempotencyEntry{
+		key:         key,
+		fingerprint: fingerprint,
+		expiresAt:   now.Add(c.ttl),
+	})
+	for c.maxKeys > 0 && c.order.Len() > c.maxKeys {
+		c.remove(c.order.Front())
+	}
+	return nil, 0, nil
+}
+
+// Complete records the response for a key reserved by Begin
+func (c *IdempotencyCache) Complete(key string, response *CreateShortURLResponse, status int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element, ok := c.entries[key]; ok {
+		entry := element.Value.(*idempotencyEntry)
+		entry.response = response
+		entry.status = status
+		entry.expiresAt = time.Now().Add(c.ttl)
+		c.order.MoveToBack(element)
+	}
+}
+
+// Release forgets a key reserved by Begin whose request failed, so it can be retried
+func (c *IdempotencyCache) Release(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element, ok := c.entries[key]; ok {
+		c.remove(element)
+	}
+}
+
+// evict drops expired entries from the front of the queue
+func (c *IdempotencyCache) evict(now time.Time) {
+	for element := c.order.Front(); element != nil; element = c.order.Front() {
+		if now.Before(element.Value.(*idempotencyEntry).expiresAt) {
+			return
+		}
+		c.remove(element)
+	}
+}
+
+func (c *IdempotencyCache) remove(element *list.Element) {
+	c.order.Remove(element)
+	delete(c.entries, element.Value.(*idempotencyEntry).key)
+}
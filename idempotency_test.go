@@ -0,0 +1,58 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestIdempotencyCacheEvictsOldestKeys(t *testing.T) {
+	c := NewIdempotencyCache(IdempotencyConfig{TTL: time.Hour, MaxKeys: 2})
+	fingerprint := requestFingerprint(CreateShortURLRequest{URL: "example.com"})
+
+	for _, key := range []string{"a", "b", "c"} {
+		if _, _, err := c.Begin(key, fingerprint); err != nil {
+			t.Fatalf("Begin(%s): %v", key, err)
+		}
+		c.Complete(key, &CreateShortURLResponse{ShortLink: key}, 201)
+	}
+
+	if replay, _, _ := c.Begin("a", fingerprint); replay != nil {
+		t.Errorf("key a was replayed after exceeding MaxKeys")
+	}
+	if replay, status, _ := c.Begin("c", fingerprint); replay == nil || replay.ShortLink != "c" || status != 201 {
+		t.Errorf("Begin(c) = %+v, %d; want the cached response", replay, status)
+	}
+}
+
+func TestIdempotencyCacheExpiresKeys(t *testing.T) {
+	c := NewIdempotencyCache(IdempotencyConfig{TTL: time.Millisecond})
+	fingerprint := requestFingerprint(CreateShortURLRequest{URL: "example.com"})
+
+	c.Begin("key", fingerprint)
+	c.Complete("key", &CreateShortURLResponse{ShortLink: "first"}, 201)
+	time.Sleep(5 * time.Millisecond)
+
+	if replay, _, err := c.Begin("key", fingerprint); replay != nil || err != nil {
+		t.Errorf("Begin after TTL = %+v, %v; want a fresh reservation", replay, err)
+	}
+}
+
+func TestIdempotencyCacheRejectsConflicts(t *testing.T) {
+	c := NewIdempotencyCache(DefaultIdempotencyConfig())
+	first := requestFingerprint(CreateShortURLRequest{URL: "example.com"})
+
+	c.Begin("key", first)
+	if _, _, err := c.Begin("key", first); !errors.Is(err, ErrIdempotencyKeyInFlight) {
+		t.Errorf("Begin while in flight = %v, want ErrIdempotencyKeyInFlight", err)
+	}
+	c.Complete("key", &CreateShortURLResponse{ShortLink: "first"}, 201)
+	if _, _, err := c.Begin("key", requestFingerprint(CreateShortURLRequest{URL: "other.com"})); !errors.Is(err, ErrIdempotencyKeyReused) {
+		t.Errorf("Begin with another body = %v, want ErrIdempotencyKeyReused", err)
+	}
+
+	c.Release("key")
+	if _, _, err := c.Begin("key", first); err != nil {
+		t.Errorf("Begin after Release = %v, want a fresh reservation", err)
+	}
+}
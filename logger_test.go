@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newCountingServer answers log posts with the given statuses in turn, then
+// 200, counting requests
+func newCountingServer(t *testing.T, statuses ...int) (*httptest.Server, *int32) {
+	t.Helper()
+
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := int(atomic.AddInt32(&requests, 1))
+		if n <= len(statuses) {
+			w.WriteHeader(statuses[n-1])
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &requests
+}
+
+func newRetryTestLogger(t *testing.T, serverURL string) *Logger {
+	t.Helper()
+
+	config := DefaultLoggerConfig()
+	config.Fallback = nil
+	config.RetryBackoff = time.Millisecond
+	logger := NewLoggerWithConfig(serverURL, config)
+	t.Cleanup(logger.Close)
+	return logger
+}
+
+func TestLoggerRetriesServerErrors(t *testing.T) {
+	srv, requests := newCountingServer(t, http.StatusServiceUnavailable, http.StatusBadGateway)
+	logger := newRetryTestLogger(t, srv.URL)
+
+	if err := logger.LogSync(BackendStack, InfoLevel, ServicePackage, "retry me"); err != nil {
+		t.Fatalf("LogSync: %v", err)
+	}
+	if got := atomic.LoadInt32(requests); got != 3 {
+		t.Errorf("server saw %d requests, want 3", got)
+	}
+}
+
+func TestLoggerDoesNotRetryClientErrors(t *testing.T) {
+	srv, requests := newCountingServer(t, http.StatusBadRequest)
+	logger := newRetryTestLogger(t, srv.URL)
+
+	if err := logger.LogSync(BackendStack, InfoLevel, ServicePackage, "rejected"); err == nil {
+		t.Fatal("LogSync succeeded, want the 400 error")
+	}
+	if got := atomic.LoadInt32(requests); got != 1 {
+		t.Errorf("server saw %d requests, want 1", got)
+	}
+}
+
+func TestLoggerRetriesAreCapped(t *testing.T) {
+	srv, requests := newCountingServer(t, 500, 500, 500, 500, 500, 500)
+	logger := newRetryTestLogger(t, srv.URL)
+
+	if err := logger.LogSync(BackendStack, InfoLevel, ServicePackage, "gives up"); err == nil {
+		t.Fatal("LogSync succeeded, want the 500 error")
+	}
+	if got, want := atomic.LoadInt32(requests), int32(1+DefaultLoggerConfig().MaxRetries); got != want {
+		t.Errorf("server saw %d requests, want %d", got, want)
+	}
+}
+
+func TestLoggerFileSink(t *testing.T) {
+	srv, _ := newCountingServer(t, http.StatusServiceUnavailable, http.StatusServiceUnavailable, http.StatusServiceUnavailable, http.StatusServiceUnavailable)
+	path := filepath.Join(t.TempDir(), "trimurl.log")
+	sink, closeSink, err := OpenLogSink(path)
+	if err != nil {
+		t.Fatalf("OpenLogSink: %v", err)
+	}
+	t.Cleanup(func() { closeSink() })
+
+	config := DefaultLoggerConfig()
+	config.Fallback = sink
+	config.Tee = true
+	config.RetryBackoff = time.Millisecond
+	logger := NewLoggerWithConfig(srv.URL, config)
+	t.Cleanup(logger.Close)
+
+	// The first entry exhausts its retries and falls back; the second is
+	// delivered and copied to the file because of Tee
+	logger.LogSync(BackendStack, InfoLevel, ServicePackage, "undelivered")
+	if err := logger.LogSync(BackendStack, InfoLevel, ServicePackage, "delivered"); err != nil {
+		t.Fatalf("LogSync: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var messages []string
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		var entry LogEntry
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("log file line %q is not a JSON entry: %v", line, err)
+		}
+		messages = append(messages, entry.Message)
+	}
+	if strings.Join(messages, ",") != "undelivered,delivered" {
+		t.Errorf("log file has %v, want each entry once", messages)
+	}
+}
+
+func TestLoggerMinLevel(t *testing.T) {
+	srv, requests := newCountingServer(t)
+	config := DefaultLoggerConfig()
+	config.MinLevel = InfoLevel
+	logger := NewLoggerWithConfig(srv.URL, config)
+	t.Cleanup(logger.Close)
+
+	logger.LogSync(BackendStack, DebugLevel, HandlerPackage, "request body")
+	logger.LogSync(BackendStack, InfoLevel, HandlerPackage, "created")
+	if got := atomic.LoadInt32(requests); got != 1 {
+		t.Fatalf("server saw %d entries, want only the info one", got)
+	}
+
+	h := newTestHandler(t)
+	h.logger = logger
+	h.AdminToken = "secret"
+	router := NewRouter(h, logger)
+	do := func(method, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/admin/log-level", strings.NewReader(body))
+		req.Header.Set(AdminTokenHeader, "secret")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := do(http.MethodPut, `{"level": "verbose"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("PUT of an unknown level = %d, want 400", rec.Code)
+	}
+	if rec := do(http.MethodPut, `{"level": "ERROR"}`); rec.Code != http.StatusOK {
+		t.Fatalf("PUT = %d: %s", rec.Code, rec.Body.String())
+	}
+	var setting LogLevelSetting
+	if rec := do(http.MethodGet, ""); json.Unmarshal(rec.Body.Bytes(), &setting) != nil || setting.Level != ErrorLevel {
+		t.Errorf("GET = %d %s, want level error", rec.Code, rec.Body.String())
+	}
+	before := atomic.LoadInt32(requests)
+	logger.LogSync(BackendStack, WarnLevel, HandlerPackage, "slow store")
+	if got := atomic.LoadInt32(requests); got != before {
+		t.Errorf("warn entry was sent after raising the level to error")
+	}
+}
+
+func TestLoggingMiddlewareLogsAccessLine(t *testing.T) {
+	var mu sync.Mutex
+	var entries []LogEntry
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var entry LogEntry
+		json.NewDecoder(r.Body).Decode(&entry)
+		mu.Lock()
+		entries = append(entries, entry)
+		mu.Unlock()
+	}))
+	t.Cleanup(srv.Close)
+	logger := newRetryTestLogger(t, srv.URL)
+
+	handler := LoggingMiddleware(logger, BackendStack, RoutePackage)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("missing"))
+	}))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/abc", nil))
+	logger.Close()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(entries) != 1 {
+		t.Fatalf("logged %d entries, want 1", len(entries))
+	}
+	var access AccessLogEntry
+	if err := json.Unmarshal([]byte(entries[0].Message), &access); err != nil {
+		t.Fatalf("access line %q is not JSON: %v", entries[0].Message, err)
+	}
+	if entries[0].Level != WarnLevel || access.Method != http.MethodGet || access.Path != "/abc" ||
+		access.Status != http.StatusNotFound || access.Bytes != int64(len("missing")) ||
+		access.RequestID != rec.Header().Get(RequestIDHeader) {
+		t.Errorf("access entry = %s %+v, want warn GET /abc 404 with 7 bytes and the request ID", entries[0].Level, access)
+	}
+}
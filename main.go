@@ -1,64 +1,315 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
+	"html/template"
+	"io"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
+
+	"logging-middleware/version"
 )
 
 func main() {
-	// Initialize logger
-	logger := NewLogger("http://20.244.56.144/evaluation-service/logs")
+	config, err := LoadConfig(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
 
-	// Test connection
-	if err := logger.Log(BackendStack, InfoLevel, ServicePackage, "URL Shortener service starting"); err != nil {
-		fmt.Printf("Failed to connect to logging server: %v\n", err)
-		fmt.Println("Continuing without logging...")
+	// OTEL_EXPORTER_OTLP_ENDPOINT exports a span per request, with child spans
+	// for URL service and store calls and for log deliveries
+	stopTracing := func(context.Context) error { return nil }
+	if config.Tracing.Endpoint != "" {
+		stopTracing, err = StartTracing(context.Background(), config.Tracing)
+		if err != nil {
+			log.Fatalf("Failed to start tracing: %v", err)
+		}
+		fmt.Printf("Exporting traces to %s (sampling %g of new traces)\n", config.Tracing.Endpoint, config.Tracing.SampleRatio)
+	}
+
+	// Initialize logger; DISABLE_REMOTE_LOG=true discards entries instead
+	var logger LoggerInterface
+	closeLogSink := func() error { return nil }
+	if config.DisableRemoteLog {
+		fmt.Println("DISABLE_REMOTE_LOG is set; log entries are discarded")
+		logger = NoopLogger{}
 	} else {
-		fmt.Println("Connected to logging server!")
+		if config.LogAuthToken == "" {
+			fmt.Println("Warning: LOG_AUTH_TOKEN is not set; the logging server will reject entries and they will only be written to stderr")
+		}
+		loggerConfig := DefaultLoggerConfig()
+		loggerConfig.AuthToken = config.LogAuthToken
+		loggerConfig.Tee = config.LogTee
+		loggerConfig.MinLevel = config.LogLevel
+		loggerConfig.Fallback, closeLogSink, err = OpenLogSink(config.LogFallback)
+		if err != nil {
+			log.Fatalf("Invalid configuration: LOG_FALLBACK: %v", err)
+		}
+		logger = NewLoggerWithConfig(config.LogEndpoint, loggerConfig)
+		if config.LogLevel != DebugLevel {
+			fmt.Printf("Logging %s entries and above\n", config.LogLevel)
+		}
+
+		// Test connection
+		if err := logger.LogSync(BackendStack, InfoLevel, ServicePackage, fmt.Sprintf("URL Shortener service %s (%s) starting", version.Version, version.Commit)); err != nil {
+			fmt.Printf("Failed to connect to logging server: %v\n", err)
+			fmt.Println("Continuing without logging...")
+		} else {
+			fmt.Println("Connected to logging server!")
+		}
 	}
 
 	// Initialize URL service
-	urlService := NewURLService(logger)
+	store, err := openStore(config.Storage)
+	if err != nil {
+		log.Fatalf("Failed to open store: %v", err)
+	}
+	urlService, err := NewURLServiceWithConfig(logger, store, config.Service)
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
 	logger.Log(BackendStack, InfoLevel, ServicePackage, "URL service initialized")
 
+	// Expired links are removed in the background once their retention ends;
+	// CLEANUP_INTERVAL=0 turns this off
+	stopCleanup := func() {}
+	if config.Cleanup.Interval > 0 {
+		stopCleanup = urlService.StartCleanup(config.Cleanup)
+	}
+
+	// SNAPSHOT_PATH keeps the in-memory store across restarts
+	var snapshotter *Snapshotter
+	if path := config.Snapshot.Path; path != "" {
+		restored, err := LoadSnapshot(context.Background(), urlService, path)
+		if err != nil {
+			log.Fatalf("Failed to load snapshot: %v", err)
+		}
+		fmt.Printf("Restored %d short URLs from %s; saving every %s\n", restored, path, config.Snapshot.Interval)
+		snapshotter, err = StartSnapshotter(urlService, logger, config.Snapshot)
+		if err != nil {
+			log.Fatalf("Failed to start snapshots: %v", err)
+		}
+	}
+
 	// Initialize handlers
 	urlHandler := NewURLHandler(urlService, logger)
+	urlHandler.AdminToken = config.AdminToken
+	if urlHandler.AdminToken == "" {
+		fmt.Println("ADMIN_TOKEN is not set; /admin endpoints are disabled")
+	}
+	urlHandler.APIKeys = config.APIKeys
+	if config.Accounts.Secret != "" {
+		accounts, err := NewAccounts(config.Accounts, RealClock())
+		if err != nil {
+			log.Fatalf("Failed to start accounts: %v", err)
+		}
+		urlHandler.Accounts = accounts
+		if config.Accounts.UsersPath == "" {
+			fmt.Println("USERS_PATH is not set; registered users are lost on restart")
+		}
+	}
+	if len(urlHandler.APIKeys) == 0 && urlHandler.Accounts == nil {
+		fmt.Println("API_KEYS and JWT_SECRET are not set; the /shorturls API is open to anyone who can reach it")
+	}
+	urlHandler.TrustedProxies = config.TrustedProxies
+	var geoIP *GeoIPReader
+	if config.GeoIPPath != "" {
+		geoIP, err = OpenGeoIP(config.GeoIPPath)
+		if err != nil {
+			log.Fatalf("Invalid configuration: GEOIP_DB_PATH: %v", err)
+		}
+		urlHandler.Geo = geoIP
+		fmt.Printf("Locating clicks with GeoIP database %s\n", config.GeoIPPath)
+	}
+	for _, page := range []struct {
+		name, path string
+		target     **template.Template
+	}{
+		{"EXPIRED_PAGE_TEMPLATE", config.ExpiredPagePath, &urlHandler.ExpiredPage},
+		{"NOT_FOUND_PAGE_TEMPLATE", config.NotFoundPagePath, &urlHandler.NotFoundPage},
+	} {
+		if page.path == "" {
+			continue
+		}
+		if *page.target, err = LoadPageTemplate(page.path); err != nil {
+			log.Fatalf("Invalid configuration: %s: %v", page.name, err)
+		}
+	}
+	urlHandler.Homepage = config.Homepage
+	urlHandler.PreviewLinks = config.PreviewLinks
+	urlHandler.ClickPolicy = config.ClickPolicy
+	if config.Clicks.Workers > 0 {
+		urlHandler.Clicks = NewClickRecorder(urlService, logger, config.Clicks)
+	}
+	if config.FetchMetadata {
+		urlHandler.Metadata = NewMetadataFetcher(urlService, logger, config.Metadata)
+	}
+	urlHandler.SetRateLimits(config.RateLimit, RealClock())
 	logger.Log(BackendStack, InfoLevel, HandlerPackage, "URL handlers initialized")
 
-	// Set up routes (order matters - specific routes first)
-	http.Handle("/health", LoggingMiddleware(logger, BackendStack, RoutePackage)(http.HandlerFunc(urlHandler.HealthCheck)))
-	http.Handle("/shorturls/", LoggingMiddleware(logger, BackendStack, RoutePackage)(http.HandlerFunc(urlHandler.GetStats)))
-	http.Handle("/shorturls", LoggingMiddleware(logger, BackendStack, RoutePackage)(http.HandlerFunc(urlHandler.CreateShortURL)))
-	http.Handle("/", LoggingMiddleware(logger, BackendStack, RoutePackage)(http.HandlerFunc(urlHandler.RedirectURL)))
+	shutdownTimeout := config.ShutdownTimeout
+
+	// Set up routes
+	router := NewRouter(urlHandler, logger)
 
 	// Start server
-	port := "3000"
+	port := config.Port
 	logger.Log(BackendStack, InfoLevel, ServicePackage, fmt.Sprintf("Starting server on port %s", port))
 
 	fmt.Printf("URL Shortener Service starting on port %s\n", port)
 	fmt.Printf("API Endpoints:\n")
 	fmt.Printf("POST   http://localhost:%s/shorturls     - Create short URL\n", port)
+	fmt.Printf("GET    http://localhost:%s/shorturls     - List short URLs\n", port)
 	fmt.Printf("GET    http://localhost:%s/shorturls/:id - Get statistics\n", port)
+	fmt.Printf("POST   http://localhost:%s/shorturls/stats - Statistics for several URLs\n", port)
+	fmt.Printf("POST   http://localhost:%s/shorturls/bulk - Create several short URLs\n", port)
+	fmt.Printf("GET    http://localhost:%s/shorturls/reverse?url= - Links to a destination\n", port)
+	fmt.Printf("PATCH  http://localhost:%s/shorturls/:id - Update destination or expiry\n", port)
+	fmt.Printf("DELETE http://localhost:%s/shorturls/:id - Delete a short URL\n", port)
+	fmt.Printf("GET    http://localhost:%s/shorturls/:id/qr - QR code image\n", port)
+	fmt.Printf("GET    http://localhost:%s/shorturls/:id/clicks.csv - Click history as CSV\n", port)
+	fmt.Printf("POST   http://localhost:%s/auth/register - Create an account\n", port)
+	fmt.Printf("POST   http://localhost:%s/auth/login - Get a token for an account\n", port)
 	fmt.Printf("GET    http://localhost:%s/health        - Health check\n", port)
+	fmt.Printf("GET    http://localhost:%s/version       - Build information\n", port)
+	fmt.Printf("GET    http://localhost:%s/metrics       - Prometheus metrics\n", port)
+	fmt.Printf("GET    http://localhost:%s/openapi.json  - OpenAPI specification\n", port)
+	fmt.Printf("GET    http://localhost:%s/admin/export  - Export all URLs (admin)\n", port)
+	fmt.Printf("POST   http://localhost:%s/admin/import  - Import URLs (admin)\n", port)
+	fmt.Printf("PATCH  http://localhost:%s/admin/shorturls/:id - Enable or disable a link (admin)\n", port)
 	fmt.Printf("GET    http://localhost:%s/:shortcode    - Redirect to original URL\n", port)
 	fmt.Printf("\nAll operations are logged to the evaluation server\n")
 
-	// Start server in background
+	srv := &http.Server{
+		Addr:    ":" + port,
+		Handler: CORSMiddleware(config.CORS)(router),
+	}
+
+	// Start server in background. A failure to serve, such as the port being
+	// taken, goes through the same shutdown below so snapshots, the store and
+	// the log queue are still flushed.
+	serveErr := make(chan error, 1)
 	go func() {
 		logger.Log(BackendStack, InfoLevel, ServicePackage, "HTTP server started")
-		log.Fatal(http.ListenAndServe(":"+port, nil))
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			serveErr <- err
+		}
+	}()
+
+	// SIGHUP re-reads the config file and environment and applies the
+	// settings that can change while running
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+	go func() {
+		for range hangups {
+			reloadConfig(os.Args[1:], urlService, urlHandler, logger)
+		}
 	}()
 
 	// Wait for interrupt signal
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
 	fmt.Println("\nPress Ctrl+C to stop the server...")
-	<-c
-
-	logger.Log(BackendStack, InfoLevel, ServicePackage, "Server shutting down")
+	exitCode := 0
+	select {
+	case <-c:
+		logger.Log(BackendStack, InfoLevel, ServicePackage, fmt.Sprintf("Server shutting down, draining requests for up to %s", shutdownTimeout))
+	case err := <-serveErr:
+		logger.Log(BackendStack, FatalLevel, ServicePackage, fmt.Sprintf("HTTP server failed: %v", err))
+		fmt.Printf("Server failed: %v\n", err)
+		exitCode = 1
+	}
 	fmt.Println("\nShutting down URL Shortener Service...")
+
+	// Stop accepting connections and let in-flight requests finish
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		logger.Log(BackendStack, WarnLevel, ServicePackage, fmt.Sprintf("Drain did not finish within %s, closing remaining connections: %v", shutdownTimeout, err))
+		fmt.Printf("Warning: requests still running after %s were cut off\n", shutdownTimeout)
+		srv.Close()
+	}
+	if urlHandler.Clicks != nil {
+		clicksCtx, cancelClicks := context.WithTimeout(context.Background(), shutdownTimeout)
+		if err := urlHandler.Clicks.Close(clicksCtx); err != nil {
+			fmt.Printf("Warning: queued clicks were lost: %v\n", err)
+		}
+		cancelClicks()
+	}
+	if urlHandler.Metadata != nil {
+		metadataCtx, cancelMetadata := context.WithTimeout(context.Background(), shutdownTimeout)
+		if err := urlHandler.Metadata.Close(metadataCtx); err != nil {
+			fmt.Printf("Warning: queued metadata fetches were skipped: %v\n", err)
+		}
+		cancelMetadata()
+	}
+	stopCleanup()
+	if geoIP != nil {
+		geoIP.Close()
+	}
+	if snapshotter != nil {
+		if err := snapshotter.Close(); err != nil {
+			fmt.Printf("Warning: final snapshot failed: %v\n", err)
+		}
+	}
+	if closer, ok := store.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			fmt.Printf("Warning: failed to close store: %v\n", err)
+		}
+	}
+	logger.Close()
+	if err := closeLogSink(); err != nil {
+		fmt.Printf("Warning: failed to close log file: %v\n", err)
+	}
+	tracingCtx, cancelTracing := context.WithTimeout(context.Background(), tracingFlushTimeout)
+	defer cancelTracing()
+	if err := stopTracing(tracingCtx); err != nil {
+		fmt.Printf("Warning: failed to flush traces: %v\n", err)
+	}
+	if exitCode != 0 {
+		os.Exit(exitCode)
+	}
+}
+
+// reloadConfig loads the configuration again from args and the environment
+// and applies its reloadable service settings and rate limits. An invalid
+// configuration is reported and the running one kept.
+func reloadConfig(args []string, service *URLService, handler *URLHandler, logger LoggerInterface) {
+	config, err := LoadConfig(args)
+	if err == nil {
+		err = service.Reload(config.Service)
+	}
+	if err != nil {
+		logger.Log(BackendStack, ErrorLevel, ConfigPackage, fmt.Sprintf("Config reload failed, keeping the current settings: %v", err))
+		fmt.Printf("Config reload failed, keeping the current settings: %v\n", err)
+		return
+	}
+	handler.SetRateLimits(config.RateLimit, RealClock())
+	fmt.Println("Config reloaded")
+}
+
+// openStore opens the store selected by config
+func openStore(config StorageConfig) (Store, error) {
+	switch config.Backend {
+	case StorePostgres:
+		fmt.Printf("Storing short URLs in PostgreSQL (pool of %d connections)\n", config.Postgres.MaxConns)
+		return NewPostgresStore(config.Postgres)
+	case StoreRedis:
+		fmt.Println("Storing short URLs in Redis")
+		return NewRedisStore(config.Redis)
+	case StoreSQLite:
+		fmt.Printf("Storing short URLs in SQLite database %s\n", config.SQLitePath)
+		return NewSQLiteStore(config.SQLitePath)
+	default:
+		return NewMemoryStore(), nil
+	}
 }
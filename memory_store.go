@@ -0,0 +1,186 @@
+package main
+
+import (
+	"sync"
+)
+
+// MemoryStore is an in-memory Store backed by a map
+type MemoryStore struct {
+	urls map[string]*ShortURL
+	// byOriginalURL maps each stored original URL to the codes pointing at
+	// it, so lookups by destination avoid scanning every entry
+	byOriginalURL map[string][]string
+	sequences     map[string]uint64
+	mutex         sync.RWMutex
+}
+
+// NewMemoryStore creates an empty in-memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		urls:          make(map[string]*ShortURL),
+		byOriginalURL: make(map[string][]string),
+		sequences:     make(map[string]uint64),
+	}
+}
+
+// Get returns the entry for a shortcode
+func (m *MemoryStore) Get(shortCode string) (*ShortURL, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	shortURL, exists := m.urls[shortCode]
+	if !exists {
+		return nil, ErrShortCodeNotFound
+	}
+	return shortURL, nil
+}
+
+// Insert stores an entry unless its shortcode is taken
+func (m *MemoryStore) Insert(shortURL *ShortURL) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if _, exists := m.urls[shortURL.ShortCode]; exists {
+		return ErrShortCodeExists
+	}
+	m.urls[shortURL.ShortCode] = shortURL
+	m.indexAdd(shortURL)
+	return nil
+}
+
+// Put inserts or replaces an entry, keeping a replaced entry's click data.
+// An entry whose destination changes must be a new value, not the stored
+// one changed in place, so the destination index sees the old URL.
+func (m *MemoryStore) Put(shortURL *ShortURL) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	existing, exists := m.urls[shortURL.ShortCode]
+	if exists && existing != shortURL {
+		shortURL.ClickCount = existing.ClickCount
+		shortURL.ClickHistory = existing.ClickHistory
+		shortURL.LastAccessedAt = existing.LastAccessedAt
+	}
+	if exists && existing.OriginalURL != shortURL.OriginalURL {
+		m.indexRemove(existing)
+	}
+	if !exists || existing.OriginalURL != shortURL.OriginalURL {
+		m.indexAdd(shortURL)
+	}
+	m.urls[shortURL.ShortCode] = shortURL
+	return nil
+}
+
+// indexAdd records an entry in the destination index. Callers must hold m.mutex.
+func (m *MemoryStore) indexAdd(shortURL *ShortURL) {
+	m.byOriginalURL[shortURL.OriginalURL] = append(m.byOriginalURL[shortURL.OriginalURL], shortURL.ShortCode)
+}
+
+// indexRemove drops an entry from the destination index. Callers must hold m.mutex.
+func (m *MemoryStore) indexRemove(shortURL *ShortURL) {
+	codes := m.byOriginalURL[shortURL.OriginalURL]
+	for i, code := range codes {
+		if code == shortURL.ShortCode {
+			codes = append(codes[:i:i], codes[i+1:]...)
+			break
+		}
+	}
+	if len(codes) == 0 {
+		delete(m.byOriginalURL, shortURL.OriginalURL)
+		return
+	}
+	m.byOriginalURL[shortURL.OriginalURL] = codes
+}
+
+// RecordClicks counts clicks against an entry under the store's lock. The
+// entry is updated in place, so callers still reading it must serialize with
+// RecordClicks, as URLService's click locks do.
+func (m *MemoryStore) RecordClicks(shortCode string, clicks []Click, maxHistory int) (int, int, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	shortURL, exists := m.urls[shortCode]
+	if !exists {
+		return 0, 0, ErrShortCodeNotFound
+	}
+	recorded := countClicks(len(clicks), shortURL.ClickCount, shortURL.MaxClicks)
+	if recorded == 0 {
+		return 0, shortURL.ClickCount, nil
+	}
+	shortURL.ClickCount += recorded
+	shortURL.ClickHistory = append(shortURL.ClickHistory, clicks[:recorded]...)
+	shortURL.LastAccessedAt = lastClick(shortURL.LastAccessedAt, clicks[:recorded])
+	if maxHistory > 0 && len(shortURL.ClickHistory) > maxHistory {
+		// Reslice instead of shifting in place: ClickHistory callers may still
+		// be reading the previous window. The next append that outgrows the
+		// array copies only the retained clicks, so memory stays bounded.
+		shortURL.ClickHistory = shortURL.ClickHistory[len(shortURL.ClickHistory)-maxHistory:]
+	}
+	return recorded, shortURL.ClickCount, nil
+}
+
+// Delete removes an entry
+func (m *MemoryStore) Delete(shortCode string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	shortURL, exists := m.urls[shortCode]
+	if !exists {
+		return ErrShortCodeNotFound
+	}
+	delete(m.urls, shortCode)
+	m.indexRemove(shortURL)
+	return nil
+}
+
+// Exists reports whether a shortcode is stored
+func (m *MemoryStore) Exists(shortCode string) (bool, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	_, exists := m.urls[shortCode]
+	return exists, nil
+}
+
+// List returns all stored entries
+func (m *MemoryStore) List() ([]*ShortURL, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	shortURLs := make([]*ShortURL, 0, len(m.urls))
+	for _, shortURL := range m.urls {
+		shortURLs = append(shortURLs, shortURL)
+	}
+	return shortURLs, nil
+}
+
+// ListByOriginalURL returns the entries for a destination using the index
+func (m *MemoryStore) ListByOriginalURL(originalURL string) ([]*ShortURL, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	codes := m.byOriginalURL[originalURL]
+	shortURLs := make([]*ShortURL, 0, len(codes))
+	for _, code := range codes {
+		shortURLs = append(shortURLs, m.urls[code])
+	}
+	return shortURLs, nil
+}
+
+// Count returns the number of stored entries
+func (m *MemoryStore) Count() (int, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	return len(m.urls), nil
+}
+
+// NextSequence increments a counter. Counters are not part of snapshots, so
+// they start again at 1 when the process does.
+func (m *MemoryStore) NextSequence(name string) (uint64, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.sequences[name]++
+	return m.sequences[name], nil
+}
@@ -0,0 +1,432 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/net/html"
+)
+
+// ErrMetadataQueueFull is returned when a link is queued for a metadata fetch while the queue is full
+var ErrMetadataQueueFull = errors.New("metadata queue full")
+
+// metadataUserAgent identifies the fetcher to the sites it reads
+const metadataUserAgent = "TrimURL-preview/1.0"
+
+// maxMetadataLength bounds a fetched title or description, in characters
+const maxMetadataLength = 500
+
+// MetadataConfig controls the background fetches of destination metadata
+type MetadataConfig struct {
+	Workers   int           // goroutines fetching pages
+	QueueSize int           // links waiting for a fetch before new ones are dropped
+	Timeout   time.Duration // longest one fetch may take, redirects included
+	MaxBytes  int64         // most of a page read while looking for its metadata
+}
+
+// DefaultMetadataConfig returns the config used by main unless overridden
+func DefaultMetadataConfig() MetadataConfig {
+	return MetadataConfig{
+		Workers:   2,
+		QueueSize: 1024,
+		Timeout:   5 * time.Second,
+		MaxBytes:  512 << 10,
+	}
+}
+
+// MetadataFetcher reads the title, description and favicon of new links'
+// destinations in the background, so creating a link never waits on the
+// destination site, and stores them on the link
+type MetadataFetcher struct {
+	service  *URLService
+	logger   LoggerInterface
+	client   *http.Client
+	queue    chan string
+	timeout  time.Duration
+	maxBytes int64
+
+	closeMu sync.RWMutex
+	closed  bool
+	done    sync.WaitGroup
+}
+
+// NewMetadataFetcher starts config.Workers workers storing metadata through
+// service; zero fields take their defaults
+func NewMetadataFetcher(service *URLService, logger LoggerInterface, config MetadataConfig) *MetadataFetcher {
+	defaults := DefaultMetadataConfig()
+	if config.Workers <= 0 {
+		config.Workers = defaults.Workers
+	}
+	if config.QueueSize <= 0 {
+		config.QueueSize = defaults.QueueSize
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = defaults.Timeout
+	}
+	if config.MaxBytes <= 0 {
+		config.MaxBytes = defaults.MaxBytes
+	}
+
+	// Without a proxy every connection goes straight to the destination, so
+	// the policy sees the address actually dialled
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	dialer := &net.Dialer{Timeout: config.Timeout, Control: service.controlDestination}
+	transport.DialContext = dialer.DialContext
+
+	f := &MetadataFetcher{
+		service:  service,
+		logger:   logger,
+		client:   &http.Client{Timeout: config.Timeout, Transport: transport},
+		queue:    make(chan string, config.QueueSize),
+		timeout:  config.Timeout,
+		maxBytes: config.MaxBytes,
+	}
+	for i := 0; i < config.Workers; i++ {
+		f.done.Add(1)
+		go f.run()
+	}
+	return f
+}
+
+// Queue asks for a link's metadata to be fetched without blocking. It
+// returns ErrMetadataQueueFull when the request had to be dropped.
+func (f *MetadataFetcher) Queue(shortCode string) error {
+	f.closeMu.RLock()
+	defer f.closeMu.RUnlock()
+	if f.closed {
+		return fmt.Errorf("metadata fetcher is closed")
+	}
+
+	select {
+	case f.queue <- f.service.normalizeCode(shortCode):
+		return nil
+	default:
+		return ErrMetadataQueueFull
+	}
+}
+
+// Close stops accepting links and waits until the queued ones are fetched
+// or ctx is done
+func (f *MetadataFetcher) Close(ctx context.Context) error {
+	f.closeMu.Lock()
+	if !f.closed {
+		f.closed = true
+		close(f.queue)
+	}
+	f.closeMu.Unlock()
+
+	drained := make(chan struct{})
+	go func() {
+		f.done.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("metadata fetches still queued: %v", ctx.Err())
+	}
+}
+
+// run fetches queued links until the queue is closed and empty
+func (f *MetadataFetcher) run() {
+	defer f.done.Done()
+	for shortCode := range f.queue {
+		f.refresh(shortCode)
+	}
+}
+
+// refresh fetches a link's destination unless its metadata is already for
+// that destination. Failures are logged and stored as metadata with the
+// error, so the preview says why nothing was found.
+func (f *MetadataFetcher) refresh(shortCode string) {
+	destination, current, err := f.service.destination(shortCode)
+	if err != nil {
+		f.logger.Log(BackendStack, WarnLevel, ServicePackage, fmt.Sprintf("Metadata for %s not fetched: %v", shortCode, err))
+		return
+	}
+	if current {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), f.timeout)
+	defer cancel()
+	metadata, err := f.fetch(ctx, destination)
+	if err != nil {
+		f.logger.Log(BackendStack, WarnLevel, ServicePackage, fmt.Sprintf("Failed to fetch metadata for %s from %s: %v", shortCode, destination, err))
+		metadata = &PageMetadata{Error: err.Error()}
+	}
+	metadata.URL = destination
+	metadata.FetchedAt = f.service.clock.Now()
+	if err := f.service.SetMetadata(ctx, shortCode, metadata); err != nil {
+		f.logger.Log(BackendStack, WarnLevel, ServicePackage, fmt.Sprintf("Metadata for %s not stored: %v", shortCode, err))
+	}
+}
+
+// fetch reads up to maxBytes of an HTML page and returns its metadata
+func (f *MetadataFetcher) fetch(ctx context.Context, destination string) (*PageMetadata, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, destination, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", metadataUserAgent)
+	req.Header.Set("Accept", "text/html,application/xhtml+xml;q=0.9,*/*;q=0.1")
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("destination answered %s", resp.Status)
+	}
+	if mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); mediaType != "text/html" && mediaType != "application/xhtml+xml" {
+		// Not a page, but its site may still have a favicon
+		return &PageMetadata{FaviconURL: defaultFavicon(resp.Request.URL)}, nil
+	}
+	return parseMetadata(io.LimitReader(resp.Body, f.maxBytes), resp.Request.URL), nil
+}
+
+// parseMetadata reads the title, description and favicon from an HTML page's
+// head, preferring Open Graph tags. base is the page's final URL, which
+// relative favicon links are resolved against.
+func parseMetadata(page io.Reader, base *url.URL) *PageMetadata {
+	var title, ogTitle, description, ogDescription, favicon string
+	tokenizer := html.NewTokenizer(page)
+	inTitle := false
+	for {
+		tokenType := tokenizer.Next()
+		if tokenType == html.ErrorToken {
+			break
+		}
+		token := tokenizer.Token()
+		switch tokenType {
+		case html.StartTagToken, html.SelfClosingTagToken:
+			attrs := make(map[string]string, len(token.Attr))
+			for _, attr := range token.Attr {
+				attrs[strings.ToLower(attr.Key)] = attr.Val
+			}
+			switch token.Data {
+			case "title":
+				inTitle = title == ""
+			case "meta":
+				name := attrs["property"]
+				if name == "" {
+					name = attrs["name"]
+				}
+				switch strings.ToLower(name) {
+				case "og:title":
+					ogTitle = attrs["content"]
+				case "og:description":
+					ogDescription = attrs["content"]
+				case "description":
+					description = attrs["content"]
+				}
+			case "link":
+				rel := " " + strings.ToLower(attrs["rel"]) + " "
+				if favicon == "" && attrs["href"] != "" && (strings.Contains(rel, " icon ") || strings.Contains(rel, " apple-touch-icon ")) {
+					if resolved, err := base.Parse(attrs["href"]); err == nil && (resolved.Scheme == "http" || resolved.Scheme == "https") {
+						favicon = resolved.String()
+					}
+				}
+			case "body":
+				// Metadata lives in the head, so there is no need to read on
+				return finishMetadata(title, ogTitle, description, ogDescription, favicon, base)
+			}
+		case html.TextToken:
+			if inTitle {
+				title += token.Data
+			}
+		case html.EndTagToken:
+			if token.Data == "title" {
+				inTitle = false
+			}
+		}
+	}
+	return finishMetadata(title, ogTitle, description, ogDescription, favicon, base)
+}
+
+// finishMetadata picks the Open Graph values over the plain ones, tidies
+// their whitespace and falls back to the site's /favicon.ico
+func finishMetadata(title, ogTitle, description, ogDescription, favicon string, base *url.URL) *PageMetadata {
+	if ogTitle != "" {
+		title = ogTitle
+	}
+	if ogDescription != "" {
+		description = ogDescription
+	}
+	if favicon == "" {
+		favicon = defaultFavicon(base)
+	}
+	return &PageMetadata{
+		Title:       tidyMetadata(title),
+		Description: tidyMetadata(description),
+		FaviconURL:  favicon,
+	}
+}
+
+// tidyMetadata collapses whitespace and cuts overly long values
+func tidyMetadata(value string) string {
+	value = strings.Join(strings.Fields(value), " ")
+	if runes := []rune(value); len(runes) > maxMetadataLength {
+		return string(runes[:maxMetadataLength-1]) + "…"
+	}
+	return value
+}
+
+// defaultFavicon returns the conventional favicon location of a site
+func defaultFavicon(base *url.URL) string {
+	return (&url.URL{Scheme: base.Scheme, Host: base.Host, Path: "/favicon.ico"}).String()
+}
+
+// destination returns a link's destination and whether its stored metadata
+// was already fetched from it
+func (s *URLService) destination(shortCode string) (string, bool, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	clickLock := s.clickLock(shortCode)
+	clickLock.Lock()
+	defer clickLock.Unlock()
+
+	shortURL, err := s.liveEntry(shortCode)
+	if err != nil {
+		return "", false, err
+	}
+	return shortURL.OriginalURL, shortURL.Metadata != nil && shortURL.Metadata.URL == shortURL.OriginalURL, nil
+}
+
+// SetMetadata stores metadata fetched for a link. It is dropped if the link
+// was deleted or given another destination while the page was fetched.
+func (s *URLService) SetMetadata(ctx context.Context, shortCode string, metadata *PageMetadata) error {
+	shortCode = s.normalizeCode(shortCode)
+	ctx, span := tracer.Start(ctx, "URLService.SetMetadata", trace.WithAttributes(attribute.String("trimurl.shortcode", shortCode)))
+	defer span.End()
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	storeSpan := startStoreSpan(ctx, "Get", shortCode)
+	shortURL, err := s.liveEntry(shortCode)
+	endStoreSpan(storeSpan, err)
+	if err != nil {
+		return err
+	}
+	if shortURL.OriginalURL != metadata.URL {
+		return fmt.Errorf("destination changed while its metadata was fetched")
+	}
+
+	updated := *shortURL
+	updated.Metadata = metadata
+	storeSpan = startStoreSpan(ctx, "Put", shortCode)
+	err = s.store.Put(&updated)
+	endStoreSpan(storeSpan, err)
+	if err != nil {
+		s.logger.LogContext(ctx, BackendStack, ErrorLevel, RepositoryPackage, fmt.Sprintf("Failed to store metadata for %s: %v", shortCode, err))
+		return fmt.Errorf("failed to store metadata: %v", err)
+	}
+	return nil
+}
+
+// GetPreview returns what a link's destination says about itself. Metadata
+// from an earlier destination is left out, and fetchedAt is nil until a
+// fetch for the current one finishes. Password-protected links return
+// ErrPasswordRequired, since their destination is only for those with the
+// password.
+func (s *URLService) GetPreview(ctx context.Context, shortCode string) (*LinkPreview, error) {
+	shortCode = s.normalizeCode(shortCode)
+	ctx, span := tracer.Start(ctx, "URLService.GetPreview", trace.WithAttributes(attribute.String("trimurl.shortcode", shortCode)))
+	defer span.End()
+	s.logger.LogContext(ctx, BackendStack, InfoLevel, ServicePackage, fmt.Sprintf("Retrieving preview for: %s", shortCode))
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if !s.validSignature(shortCode) {
+		return nil, ErrShortCodeNotFound
+	}
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	clickLock := s.clickLock(shortCode)
+	clickLock.Lock()
+	defer clickLock.Unlock()
+
+	storeSpan := startStoreSpan(ctx, "Get", shortCode)
+	shortURL, err := s.liveEntry(shortCode)
+	endStoreSpan(storeSpan, err)
+	if err != nil {
+		s.logger.LogContext(ctx, BackendStack, ErrorLevel, DomainPackage, fmt.Sprintf("Preview lookup failed for %s: %v", shortCode, err))
+		return nil, err
+	}
+	if !canAccess(ctx, shortURL) {
+		s.logger.LogContext(ctx, BackendStack, WarnLevel, DomainPackage, fmt.Sprintf("Preview of %s refused: not the owner", shortCode))
+		return nil, ErrShortCodeNotFound
+	}
+	if shortURL.PasswordHash != "" {
+		return nil, ErrPasswordRequired
+	}
+
+	preview := &LinkPreview{ShortCode: shortURL.ShortCode, URL: shortURL.OriginalURL}
+	if metadata := shortURL.Metadata; metadata != nil && metadata.URL == shortURL.OriginalURL {
+		fetchedAt := metadata.FetchedAt
+		preview.Title = metadata.Title
+		preview.Description = metadata.Description
+		preview.FaviconURL = metadata.FaviconURL
+		preview.FetchedAt = &fetchedAt
+		preview.Error = metadata.Error
+	}
+	return preview, nil
+}
+
+// GetPreview handles GET /shorturls/:shortcode/preview
+func (h *URLHandler) GetPreview(w http.ResponseWriter, r *http.Request) {
+	shortCode := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/shorturls/"), "/preview")
+	h.logger.LogContext(r.Context(), BackendStack, InfoLevel, HandlerPackage, fmt.Sprintf("GET /shorturls/%s/preview - Getting destination preview", shortCode))
+
+	ctx, cancel := h.requestContext(r)
+	defer cancel()
+
+	preview, err := h.urlService.GetPreview(ctx, shortCode)
+	if err != nil {
+		h.logger.LogContext(r.Context(), BackendStack, ErrorLevel, HandlerPackage, fmt.Sprintf("Failed to get preview for %s: %v", shortCode, err))
+		switch {
+		case isContextError(err):
+			h.sendErrorResponse(w, r, "Request timed out", http.StatusServiceUnavailable)
+		case errors.Is(err, ErrShortCodeNotFound):
+			h.sendErrorResponse(w, r, err.Error(), http.StatusNotFound)
+		case errors.Is(err, ErrPasswordRequired):
+			h.sendErrorResponse(w, r, "This short URL is password protected", http.StatusForbidden)
+		default:
+			h.sendErrorResponse(w, r, "Failed to get preview", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(preview)
+}
+
+// queueMetadata asks for a link's metadata when fetching is enabled
+func (h *URLHandler) queueMetadata(r *http.Request, shortCode string) {
+	if h.Metadata == nil {
+		return
+	}
+	if err := h.Metadata.Queue(shortCode); err != nil {
+		h.logger.LogContext(r.Context(), BackendStack, WarnLevel, HandlerPackage, fmt.Sprintf("Failed to queue metadata fetch for %s: %v", shortCode, err))
+	}
+}
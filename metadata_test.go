@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseMetadata(t *testing.T) {
+	base, _ := url.Parse("https://example.com/blog/post")
+	tests := []struct {
+		name string
+		page string
+		want PageMetadata
+	}{
+		{
+			name: "plain tags",
+			page: `<html><head><title> Hello
+				world </title><meta name="Description" content="A post"><link rel="shortcut icon" href="/static/icon.png"></head><body><title>ignored</title></body></html>`,
+			want: PageMetadata{Title: "Hello world", Description: "A post", FaviconURL: "https://example.com/static/icon.png"},
+		},
+		{
+			name: "open graph wins",
+			page: `<head><title>Plain</title><meta property="og:title" content="Rich"><meta name="description" content="plain"><meta property="og:description" content="rich"></head>`,
+			want: PageMetadata{Title: "Rich", Description: "rich", FaviconURL: "https://example.com/favicon.ico"},
+		},
+		{
+			name: "unsafe icon scheme",
+			page: `<link rel="icon" href="javascript:alert(1)"><link rel="apple-touch-icon" href="touch.png">`,
+			want: PageMetadata{FaviconURL: "https://example.com/blog/touch.png"},
+		},
+		{
+			name: "not html",
+			page: "just text",
+			want: PageMetadata{FaviconURL: "https://example.com/favicon.ico"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseMetadata(strings.NewReader(tt.page), base); *got != tt.want {
+				t.Errorf("parseMetadata = %+v, want %+v", *got, tt.want)
+			}
+		})
+	}
+
+	long := strings.Repeat("é", maxMetadataLength+10)
+	if got := tidyMetadata(long); len([]rune(got)) != maxMetadataLength || !strings.HasSuffix(got, "…") {
+		t.Errorf("tidyMetadata kept %d characters, want %d ending in an ellipsis", len([]rune(got)), maxMetadataLength)
+	}
+}
+
+func TestMetadataFetcher(t *testing.T) {
+	site := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.UserAgent() != metadataUserAgent {
+			t.Errorf("fetch sent User-Agent %q", r.UserAgent())
+		}
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(`<html><head><title>Launch day</title><meta name="description" content="Everything new"></head></html>`))
+	}))
+	defer site.Close()
+
+	h := newTestHandler(t)
+	ctx := context.Background()
+	for code, path := range map[string]string{"fetched": "/launch", "broken": "/missing"} {
+		if _, err := h.urlService.CreateShortURL(ctx, CreateShortURLRequest{URL: site.URL + path, ShortCode: code}); err != nil {
+			t.Fatalf("CreateShortURL %s: %v", code, err)
+		}
+	}
+	preview, err := h.urlService.GetPreview(ctx, "fetched")
+	if err != nil || preview.FetchedAt != nil {
+		t.Fatalf("GetPreview before the fetch = %+v, %v, want no metadata yet", preview, err)
+	}
+
+	fetcher := NewMetadataFetcher(h.urlService, h.logger, MetadataConfig{Timeout: time.Second})
+	for _, code := range []string{"fetched", "broken"} {
+		if err := fetcher.Queue(code); err != nil {
+			t.Fatalf("Queue %s: %v", code, err)
+		}
+	}
+	closeCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	if err := fetcher.Close(closeCtx); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	preview, err = h.urlService.GetPreview(ctx, "fetched")
+	if err != nil || preview.FetchedAt == nil || preview.Title != "Launch day" || preview.Description != "Everything new" || preview.FaviconURL != site.URL+"/favicon.ico" {
+		t.Errorf("GetPreview = %+v, %v, want the page's metadata", preview, err)
+	}
+	if broken, err := h.urlService.GetPreview(ctx, "broken"); err != nil || broken.FetchedAt == nil || !strings.Contains(broken.Error, "404") {
+		t.Errorf("GetPreview of a failed fetch = %+v, %v, want the error", broken, err)
+	}
+
+	// A new destination hides the old metadata until it is fetched again
+	if _, err := h.urlService.UpdateShortURL(ctx, "fetched", UpdateShortURLRequest{URL: site.URL + "/other"}); err != nil {
+		t.Fatalf("UpdateShortURL: %v", err)
+	}
+	if preview, err := h.urlService.GetPreview(ctx, "fetched"); err != nil || preview.FetchedAt != nil || preview.Title != "" {
+		t.Errorf("GetPreview after a new destination = %+v, %v, want no metadata", preview, err)
+	}
+	if err := h.urlService.SetMetadata(ctx, "fetched", &PageMetadata{URL: site.URL + "/launch", Title: "stale"}); err == nil {
+		t.Error("SetMetadata stored metadata for an old destination")
+	}
+}
+
+func TestPreviewEndpoint(t *testing.T) {
+	h := newTestHandler(t)
+	ctx := context.Background()
+	if _, err := h.urlService.CreateShortURL(ctx, CreateShortURLRequest{URL: "https://example.com/shop", ShortCode: "shop"}); err != nil {
+		t.Fatalf("CreateShortURL: %v", err)
+	}
+	if _, err := h.urlService.CreateShortURL(ctx, CreateShortURLRequest{URL: "https://example.com/vault", ShortCode: "vault", Password: "opensesame"}); err != nil {
+		t.Fatalf("CreateShortURL: %v", err)
+	}
+	metadata := &PageMetadata{URL: "https://example.com/shop", Title: "The shop", Description: "Deals", FaviconURL: "https://example.com/shop.ico", FetchedAt: time.Now()}
+	if err := h.urlService.SetMetadata(ctx, "shop", metadata); err != nil {
+		t.Fatalf("SetMetadata: %v", err)
+	}
+
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.ShortURLResource(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec
+	}
+	rec := get("/shorturls/shop/preview")
+	var preview LinkPreview
+	if err := json.Unmarshal(rec.Body.Bytes(), &preview); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("GET preview = %d %s", rec.Code, rec.Body.String())
+	}
+	if preview.URL != "https://example.com/shop" || preview.Title != "The shop" || preview.FaviconURL != "https://example.com/shop.ico" {
+		t.Errorf("preview = %+v, want the stored metadata", preview)
+	}
+	if rec := get("/shorturls/vault/preview"); rec.Code != http.StatusForbidden {
+		t.Errorf("preview of a protected link = %d, want 403", rec.Code)
+	}
+	if rec := get("/shorturls/nowhere/preview"); rec.Code != http.StatusNotFound {
+		t.Errorf("preview of an unknown link = %d, want 404", rec.Code)
+	}
+	if _, err := h.urlService.GetPreview(ctx, "vault"); !errors.Is(err, ErrPasswordRequired) {
+		t.Errorf("GetPreview of a protected link = %v, want ErrPasswordRequired", err)
+	}
+
+	// The interstitial shows the fetched metadata too
+	rec = httptest.NewRecorder()
+	h.RedirectURL(rec, httptest.NewRequest(http.MethodGet, "/shop+", nil))
+	if body := rec.Body.String(); !strings.Contains(body, "The shop") || !strings.Contains(body, "Deals") || !strings.Contains(body, "shop.ico") {
+		t.Errorf("preview page = %q, want the destination's metadata", body)
+	}
+}
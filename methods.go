@@ -0,0 +1,42 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// Methods routes a request to the handler registered for its method. Other
+// methods get 405 Method Not Allowed with an Allow header listing the
+// registered ones; HEAD is served by the GET handler when there is one.
+type Methods map[string]http.HandlerFunc
+
+// ServeHTTP dispatches on r.Method
+func (m Methods) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if handler, ok := m[r.Method]; ok {
+		handler(w, r)
+		return
+	}
+	if handler, ok := m[http.MethodGet]; ok && r.Method == http.MethodHead {
+		handler(w, r)
+		return
+	}
+
+	w.Header().Set("Allow", m.allow())
+	http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+}
+
+// allow returns the Allow header value for the registered methods
+func (m Methods) allow() string {
+	methods := make([]string, 0, len(m)+1)
+	for method := range m {
+		methods = append(methods, method)
+	}
+	if _, ok := m[http.MethodGet]; ok {
+		if _, ok := m[http.MethodHead]; !ok {
+			methods = append(methods, http.MethodHead)
+		}
+	}
+	sort.Strings(methods)
+	return strings.Join(methods, ", ")
+}
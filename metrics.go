@@ -0,0 +1,55 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Prometheus collectors registered on the default registry and served at /metrics
+var (
+	urlsCreatedTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "trimurl_urls_created_total",
+		Help: "Total number of short URLs created.",
+	})
+
+	redirectsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "trimurl_redirects_total",
+		Help: "Total number of redirects served.",
+	})
+
+	urlsEvictedTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "trimurl_urls_evicted_total",
+		Help: "Total number of short URLs evicted to stay within capacity.",
+	})
+
+	urlsPurgedTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "trimurl_urls_purged_total",
+		Help: "Total number of expired short URLs removed by the cleanup worker.",
+	})
+
+	expiredHitsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "trimurl_expired_hits_total",
+		Help: "Total number of lookups for expired short URLs.",
+	})
+
+	clicksDroppedTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "trimurl_clicks_dropped_total",
+		Help: "Total number of clicks that could not be recorded.",
+	})
+
+	logEntriesDroppedTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "trimurl_log_entries_dropped_total",
+		Help: "Total number of log entries dropped because the logger buffer was full.",
+	})
+
+	rateLimitedTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "trimurl_rate_limited_total",
+		Help: "Total number of requests rejected by a rate limit.",
+	})
+
+	createLatency = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "trimurl_create_request_duration_seconds",
+		Help:    "Latency of POST /shorturls requests.",
+		Buckets: prometheus.DefBuckets,
+	})
+)
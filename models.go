@@ -6,44 +6,295 @@ import (
 
 // ShortURL represents a shortened URL entry
 type ShortURL struct {
-	ShortCode    string    `json:"shortcode"`
-	OriginalURL  string    `json:"original_url"`
-	CreatedAt    time.Time `json:"created_at"`
-	ExpiresAt    time.Time `json:"expires_at"`
-	ClickCount   int       `json:"click_count"`
-	ClickHistory []Click   `json:"click_history"`
+	ShortCode      string        `json:"shortcode"`
+	OriginalURL    string        `json:"original_url"`
+	CreatedAt      time.Time     `json:"created_at"`
+	ExpiresAt      time.Time     `json:"expires_at"`
+	ActivatesAt    time.Time     `json:"activates_at"` // zero unless created to start redirecting later
+	DeletedAt      time.Time     `json:"deleted_at"`   // zero unless the link is in the trash
+	ClickCount     int           `json:"click_count"`
+	ClickHistory   []Click       `json:"click_history"`
+	PasswordHash   string        `json:"password_hash,omitempty"`
+	LastAccessedAt time.Time     `json:"last_accessed_at"` // zero if never visited
+	ForwardQuery   bool          `json:"forward_query,omitempty"`
+	RedirectStatus int           `json:"redirect_status,omitempty"` // 0 uses the service default
+	Disabled       bool          `json:"disabled,omitempty"`        // set by an operator; redirects return 403
+	MaxClicks      int           `json:"max_clicks,omitempty"`      // 0 means unlimited
+	Title          string        `json:"title,omitempty"`
+	Description    string        `json:"description,omitempty"`
+	OwnerID        string        `json:"owner_id,omitempty"` // user who created the link; empty when made without an account
+	Metadata       *PageMetadata `json:"metadata,omitempty"` // what the destination says about itself; nil until fetched
+}
+
+// PageMetadata is what a link's destination page says about itself, as read
+// by the MetadataFetcher
+type PageMetadata struct {
+	URL         string    `json:"url"` // destination the metadata was fetched from
+	Title       string    `json:"title,omitempty"`
+	Description string    `json:"description,omitempty"`
+	FaviconURL  string    `json:"favicon_url,omitempty"`
+	FetchedAt   time.Time `json:"fetched_at"`
+	Error       string    `json:"error,omitempty"` // why the fetch failed, if it did
 }
 
 // Click represents a click event on a short URL
 type Click struct {
 	Timestamp time.Time `json:"timestamp"`
 	Source    string    `json:"source"`
-	Location  string    `json:"location"`
+	Location  string    `json:"location"`          // "City, CC", "CC" or "unknown"
+	Country   string    `json:"country,omitempty"` // ISO country code of Location
+	UserAgent string    `json:"userAgent"`
+	Browser   string    `json:"browser,omitempty"` // parsed from UserAgent; see ParseUserAgent
+	OS        string    `json:"os,omitempty"`
+	Device    string    `json:"device,omitempty"` // desktop, mobile, tablet, bot or unknown
+	IP        string    `json:"ip,omitempty"`
 }
 
 // CreateShortURLRequest represents the request to create a short URL
 type CreateShortURLRequest struct {
-	URL       string `json:"url"`
-	Validity  int    `json:"validity,omitempty"`
-	ShortCode string `json:"shortcode,omitempty"`
+	URL            string `json:"url"`
+	Validity       int    `json:"validity,omitempty"`
+	ExpiresIn      string `json:"expiresIn,omitempty"`   // e.g. "24h" or "7d"; overrides validity
+	ActivatesAt    string `json:"activatesAt,omitempty"` // RFC3339 time the link starts redirecting; its lifetime runs from then
+	Permanent      bool   `json:"permanent,omitempty"`   // never expire; validity and expiresIn must be unset
+	ShortCode      string `json:"shortcode,omitempty"`
+	Deduplicate    bool   `json:"deduplicate,omitempty"`
+	Password       string `json:"password,omitempty"`
+	DryRun         bool   `json:"dryRun,omitempty"`
+	ForwardQuery   bool   `json:"forwardQuery,omitempty"`
+	RedirectStatus int    `json:"redirectStatus,omitempty"`
+	MaxClicks      int    `json:"maxClicks,omitempty"`
+	Title          string `json:"title,omitempty"`
+	Description    string `json:"description,omitempty"`
 }
 
 // CreateShortURLResponse represents the response for creating a short URL
 type CreateShortURLResponse struct {
-	ShortLink string `json:"shortLink"`
-	Expiry    string `json:"expiry"`
+	ShortCode   string `json:"shortcode"`
+	ShortLink   string `json:"shortLink"`
+	Expiry      string `json:"expiry"`
+	ActivatesAt string `json:"activatesAt,omitempty"` // set for links that are not active yet
+	Permanent   bool   `json:"permanent,omitempty"`   // the link never expires; expiry is 9999-12-31
+	DryRun      bool   `json:"dryRun,omitempty"`
+}
+
+// BulkCreateResult is one item's outcome in a POST /shorturls/bulk response
+type BulkCreateResult struct {
+	Index   int                     `json:"index"` // position of the item in the request
+	Created *CreateShortURLResponse `json:"created,omitempty"`
+	Error   string                  `json:"error,omitempty"` // set instead of created when the item failed
+	Details []FieldError            `json:"details,omitempty"`
+}
+
+// BulkCreateResponse is the response of POST /shorturls/bulk
+type BulkCreateResponse struct {
+	Results []BulkCreateResult `json:"results"`
+	Created int                `json:"created"`
+	Failed  int                `json:"failed"`
+}
+
+// UpdateShortURLRequest changes a short URL's destination and/or expiry;
+// omitted fields are left as they are
+type UpdateShortURLRequest struct {
+	URL       string `json:"url,omitempty"`
+	Validity  int    `json:"validity,omitempty"`  // minutes added to the current expiry
+	ExpiresIn string `json:"expiresIn,omitempty"` // e.g. "24h"; sets the expiry that long from now
+}
+
+// UpdateShortURLResponse represents the response for updating a short URL
+type UpdateShortURLResponse struct {
+	URL    string `json:"url"`
+	Expiry string `json:"expiry"`
 }
 
 // ShortURLStats represents statistics for a short URL
 type ShortURLStats struct {
-	TotalClicks int       `json:"totalClicks"`
+	Title          string         `json:"title,omitempty"`
+	Description    string         `json:"description,omitempty"`
+	TotalClicks    int            `json:"totalClicks"`
+	MatchingClicks int            `json:"matchingClicks"`
+	CreatedAt      time.Time      `json:"createdAt"`
+	ExpiresAt      time.Time      `json:"expiresAt"`
+	ActivatesAt    *time.Time     `json:"activatesAt,omitempty"` // set for scheduled links
+	Permanent      bool           `json:"permanent,omitempty"`   // never expires
+	Expired        bool           `json:"expired"`
+	Disabled       bool           `json:"disabled"`
+	MaxClicks      int            `json:"maxClicks,omitempty"` // omitted when unlimited
+	LastAccessedAt *time.Time     `json:"lastAccessedAt"`      // null if never visited
+	Clicks         []Click        `json:"clicks"`
+	UserAgents     map[string]int `json:"userAgents"`
+
+	// Matching clicks by parsed user agent
+	Browsers         map[string]int `json:"browsers"`
+	OperatingSystems map[string]int `json:"operatingSystems"`
+	Devices          map[string]int `json:"devices"`
+}
+
+// BulkStatsResult is one code's entry in a POST /shorturls/stats response
+type BulkStatsResult struct {
+	Stats *ShortURLStats `json:"stats,omitempty"`
+	Error string         `json:"error,omitempty"` // set instead of stats for unknown codes
+}
+
+// StatsFilter narrows the clicks returned with statistics
+type StatsFilter struct {
+	From   time.Time // zero means no lower bound
+	To     time.Time // zero means no upper bound
+	Offset int
+	Limit  int // zero means no limit
+}
+
+// ClickTimeSeries is GET /shorturls/:shortcode/stats/timeseries: clicks in
+// [from, to) counted per bucket, with the most common referrers and countries
+type ClickTimeSeries struct {
+	Bucket       TimeBucket        `json:"bucket"`
+	From         time.Time         `json:"from"`
+	To           time.Time         `json:"to"`
+	TotalClicks  int               `json:"totalClicks"` // clicks in the range
+	Series       []TimeSeriesPoint `json:"series"`      // every bucket in the range, empty ones included
+	TopReferrers []RankedValue     `json:"topReferrers"`
+	TopCountries []RankedValue     `json:"topCountries"`
+}
+
+// TimeSeriesPoint is the number of clicks in the bucket starting at Start
+type TimeSeriesPoint struct {
+	Start  time.Time `json:"start"`
+	Clicks int       `json:"clicks"`
+}
+
+// RankedValue is a referrer or country and how many clicks came from it
+type RankedValue struct {
+	Value  string `json:"value"`
+	Clicks int    `json:"clicks"`
+}
+
+// ShortCodeAvailability reports whether a custom shortcode can be created
+type ShortCodeAvailability struct {
+	Available bool   `json:"available"`
+	Reason    string `json:"reason,omitempty"`
+}
+
+// ShortURLSummary describes a link without its click history or secrets
+type ShortURLSummary struct {
+	ShortCode   string    `json:"shortcode"`
+	ShortLink   string    `json:"shortLink"`
 	CreatedAt   time.Time `json:"createdAt"`
 	ExpiresAt   time.Time `json:"expiresAt"`
-	Clicks      []Click   `json:"clicks"`
+	Expired     bool      `json:"expired"`
+	Disabled    bool      `json:"disabled"`
+	TotalClicks int       `json:"totalClicks"`
+	OwnerID     string    `json:"ownerId,omitempty"`
+
+	// Set in trash listings
+	DeletedAt       *time.Time `json:"deletedAt,omitempty"`
+	RestorableUntil *time.Time `json:"restorableUntil,omitempty"`
+}
+
+// Sort orders accepted by GET /shorturls
+const (
+	SortByCreatedAt  = "created_at"  // newest first
+	SortByClickCount = "click_count" // most clicked first
+)
+
+// ListOptions pages and orders the links returned by ListShortURLs
+type ListOptions struct {
+	Sort    string // SortByCreatedAt (the default) or SortByClickCount
+	Offset  int
+	Limit   int  // zero means no limit
+	Trashed bool // list restorable links in the trash, most recently deleted first, instead of live ones
+}
+
+// ShortURLList is the response of GET /shorturls
+type ShortURLList struct {
+	ShortURLs []ShortURLSummary `json:"shortUrls"`
+	Total     int               `json:"total"` // links across all pages
+	Offset    int               `json:"offset"`
+	Limit     int               `json:"limit"`
+}
+
+// ReverseLookupResponse lists the links pointing at a destination
+type ReverseLookupResponse struct {
+	URL       string            `json:"url"` // the normalized destination that was looked up
+	ShortURLs []ShortURLSummary `json:"shortUrls"`
+}
+
+// LinkPreview is what GET /shorturls/:shortcode/preview tells about a
+// link's destination
+type LinkPreview struct {
+	ShortCode   string     `json:"shortcode"`
+	URL         string     `json:"url"`
+	Title       string     `json:"title,omitempty"`
+	Description string     `json:"description,omitempty"`
+	FaviconURL  string     `json:"faviconUrl,omitempty"`
+	FetchedAt   *time.Time `json:"fetchedAt"`       // nil until the destination has been fetched
+	Error       string     `json:"error,omitempty"` // why the last fetch found nothing
+}
+
+// SetEnabledRequest enables or disables a link
+type SetEnabledRequest struct {
+	Enabled *bool `json:"enabled"`
+}
+
+// LogLevelSetting is the minimum log level read from and sent to /admin/log-level
+type LogLevelSetting struct {
+	Level Level `json:"level"`
+}
+
+// ImportResponse reports the outcome of an import
+type ImportResponse struct {
+	Imported int `json:"imported"`
+	Skipped  int `json:"skipped"`
+}
+
+// CredentialsRequest is the body of POST /auth/register and POST /auth/login
+type CredentialsRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// UserResponse describes a registered user without its password hash
+type UserResponse struct {
+	ID        string    `json:"id"`
+	Username  string    `json:"username"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// TokenResponse is the response of POST /auth/login
+type TokenResponse struct {
+	Token     string `json:"token"`
+	TokenType string `json:"tokenType"`
+	ExpiresAt string `json:"expiresAt"`
+	Username  string `json:"username"`
+	Role      string `json:"role"`
+}
+
+// FieldError describes a problem with one request field
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
 }
 
 // ErrorResponse represents an error response
 type ErrorResponse struct {
-	Error   string `json:"error"`
-	Message string `json:"message"`
+	Error     string       `json:"error"`
+	Message   string       `json:"message"`
+	RequestID string       `json:"requestId,omitempty"`
+	Details   []FieldError `json:"details,omitempty"`
+}
+
+// VersionResponse reports the build information of the running service
+type VersionResponse struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildTime string `json:"buildTime"`
+}
+
+// HealthResponse represents the health check response
+type HealthResponse struct {
+	Status          string `json:"status"`
+	Message         string `json:"message"`
+	Time            string `json:"time"`
+	URLCount        int    `json:"urlCount"`
+	StoreHealthy    bool   `json:"storeHealthy"`
+	LoggerReachable bool   `json:"loggerReachable"`
 }
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// LoggerInterface is what the service, handlers and middleware log through.
+// *Logger delivers entries to the remote log server; NoopLogger discards them.
+type LoggerInterface interface {
+	Log(stack Stack, level Level, pkg Package, message string) error
+	LogContext(ctx context.Context, stack Stack, level Level, pkg Package, message string) error
+	LogSync(stack Stack, level Level, pkg Package, message string) error
+	Ping(message string, timeout time.Duration) error
+	Close()
+}
+
+var (
+	_ LoggerInterface = (*Logger)(nil)
+	_ LoggerInterface = NoopLogger{}
+)
+
+// NoopLogger discards every entry, for local development and tests that
+// should not reach the network. Ping always succeeds, so health checks do
+// not report a logging outage.
+type NoopLogger struct{}
+
+func (NoopLogger) Log(Stack, Level, Package, string) error { return nil }
+
+func (NoopLogger) LogContext(context.Context, Stack, Level, Package, string) error { return nil }
+
+func (NoopLogger) LogSync(Stack, Level, Package, string) error { return nil }
+
+func (NoopLogger) Ping(string, time.Duration) error { return nil }
+
+func (NoopLogger) Close() {}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+// openAPISpec is the OpenAPI 3 document describing the HTTP API. Its schemas
+// mirror the models in models.go; openapi_test.go keeps them in sync.
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
+// OpenAPISpec handles GET /openapi.json
+func (h *URLHandler) OpenAPISpec(w http.ResponseWriter, r *http.Request) {
+	h.logger.LogContext(r.Context(), BackendStack, DebugLevel, HandlerPackage, "GET /openapi.json - Serving API spec")
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(openAPISpec)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// TestOpenAPISchemasMatchModels checks every schema's properties against the
+// JSON field names of the model it documents
+func TestOpenAPISchemasMatchModels(t *testing.T) {
+	var spec struct {
+		Components struct {
+			Schemas map[string]struct {
+				Properties map[string]json.RawMessage `json:"properties"`
+			} `json:"schemas"`
+		} `json:"components"`
+	}
+	if err := json.Unmarshal(openAPISpec, &spec); err != nil {
+		t.Fatalf("openapi.json is not valid JSON: %v", err)
+	}
+
+	models := map[string]interface{}{
+		"CreateShortURLRequest":  CreateShortURLRequest{},
+		"CreateShortURLResponse": CreateShortURLResponse{},
+		"UpdateShortURLRequest":  UpdateShortURLRequest{},
+		"UpdateShortURLResponse": UpdateShortURLResponse{},
+		"Click":                  Click{},
+		"ShortURLStats":          ShortURLStats{},
+		"ErrorResponse":          ErrorResponse{},
+		"HealthResponse":         HealthResponse{},
+		"ShortURL":               ShortURL{},
+		"ImportResponse":         ImportResponse{},
+		"LogLevelSetting":        LogLevelSetting{},
+		"ShortCodeAvailability":  ShortCodeAvailability{},
+		"FieldError":             FieldError{},
+		"SetEnabledRequest":      SetEnabledRequest{},
+		"VersionResponse":        VersionResponse{},
+		"BulkStatsResult":        BulkStatsResult{},
+		"BulkCreateResult":       BulkCreateResult{},
+		"BulkCreateResponse":     BulkCreateResponse{},
+		"ShortURLSummary":        ShortURLSummary{},
+		"ShortURLList":           ShortURLList{},
+		"ReverseLookupResponse":  ReverseLookupResponse{},
+		"CredentialsRequest":     CredentialsRequest{},
+		"UserResponse":           UserResponse{},
+		"TokenResponse":          TokenResponse{},
+		"ClickTimeSeries":        ClickTimeSeries{},
+		"TimeSeriesPoint":        TimeSeriesPoint{},
+		"RankedValue":            RankedValue{},
+		"PageMetadata":           PageMetadata{},
+		"LinkPreview":            LinkPreview{},
+	}
+
+	for name, model := range models {
+		schema, ok := spec.Components.Schemas[name]
+		if !ok {
+			t.Errorf("schema %s missing from openapi.json", name)
+			continue
+		}
+
+		var documented []string
+		for property := range schema.Properties {
+			documented = append(documented, property)
+		}
+		sort.Strings(documented)
+
+		if fields := jsonFieldNames(model); !reflect.DeepEqual(documented, fields) {
+			t.Errorf("schema %s documents %v, model has %v", name, documented, fields)
+		}
+	}
+}
+
+// jsonFieldNames returns the sorted JSON names of a struct's encoded fields
+func jsonFieldNames(model interface{}) []string {
+	var names []string
+	modelType := reflect.TypeOf(model)
+	for i := 0; i < modelType.NumField(); i++ {
+		field := modelType.Field(i)
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if name == "-" || !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
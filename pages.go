@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// notFoundPageTemplate is the page browsers get for unknown shortcodes unless
+// NOT_FOUND_PAGE_TEMPLATE names a file of its own
+var notFoundPageTemplate = template.Must(template.New("notfound").Parse(`<!DOCTYPE html>
+<html>
+<head><title>Link not found</title></head>
+<body>
+<h1>This link does not exist</h1>
+<p>There is no short link /{{.ShortCode}}. Check it was copied in full, or ask whoever shared it for a new one.</p>
+{{if .Homepage}}<p><a href="{{.Homepage}}">Go to the homepage</a></p>{{end}}
+</body>
+</html>
+`))
+
+// previewPageTemplate is the interstitial that shows where a link goes
+// before the visitor follows it
+var previewPageTemplate = template.Must(template.New("preview").Parse(`<!DOCTYPE html>
+<html>
+<head><title>{{if .Title}}{{.Title}}{{else}}Link preview{{end}}</title></head>
+<body>
+<h1>You are being sent to another site</h1>
+{{if .Title}}<p>{{if .FaviconURL}}<img src="{{.FaviconURL}}" alt="" width="16" height="16"> {{end}}<strong>{{.Title}}</strong></p>{{end}}
+{{if .Description}}<p>{{.Description}}</p>{{end}}
+<p>/{{.ShortCode}} leads to:</p>
+<p><code>{{.Destination}}</code></p>
+<p>Only continue if you trust this address.</p>
+<p><a href="{{.ContinueURL}}">Continue</a></p>
+{{if .Homepage}}<p><a href="{{.Homepage}}">Go to the homepage</a></p>{{end}}
+</body>
+</html>
+`))
+
+// pageData is what the HTML page templates are rendered with
+type pageData struct {
+	ShortCode string
+	Homepage  string // empty when HOMEPAGE_URL is unset
+
+	// Set on the preview page only
+	Destination string
+	Title       string // the link's own title, or the destination's
+	Description string // from the destination's metadata
+	FaviconURL  string
+	ContinueURL string
+}
+
+// LoadPageTemplate parses the html/template file at path
+func LoadPageTemplate(path string) (*template.Template, error) {
+	page, err := template.ParseFiles(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load page template: %v", err)
+	}
+	return page, nil
+}
+
+// ParseHomepage checks that a homepage is an absolute http or https URL
+func ParseHomepage(value string) (string, error) {
+	parsed, err := url.Parse(value)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return "", fmt.Errorf("must be an absolute http or https URL")
+	}
+	return value, nil
+}
+
+// wantsHTML reports whether the Accept header ranks text/html above
+// application/json, as browsers do; API clients that send neither, or */*,
+// get JSON
+func wantsHTML(r *http.Request) bool {
+	htmlQ, jsonQ := 0.0, 0.0
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		for _, param := range strings.Split(params, ";") {
+			if name, value, ok := strings.Cut(strings.TrimSpace(param), "="); ok && name == "q" {
+				if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+					q = parsed
+				}
+			}
+		}
+		switch strings.ToLower(strings.TrimSpace(mediaType)) {
+		case "text/html":
+			htmlQ = q
+		case "application/json":
+			jsonQ = q
+		}
+	}
+	return htmlQ > jsonQ
+}
+
+// renderPage writes an HTML page with statusCode, logging rather than
+// returning template errors since the status is already sent
+func (h *URLHandler) renderPage(w http.ResponseWriter, r *http.Request, page *template.Template, data pageData, statusCode int) {
+	data.Homepage = h.Homepage
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(statusCode)
+	if err := page.Execute(w, data); err != nil {
+		h.logger.LogContext(r.Context(), BackendStack, ErrorLevel, HandlerPackage, fmt.Sprintf("Failed to render %s page for %s: %v", page.Name(), data.ShortCode, err))
+	}
+}
+
+// previewRequested reports whether a redirect should show the preview page:
+// when the path ends in "+", when ?preview= is true, or when PREVIEW_LINKS
+// is on and ?preview= is not false
+func (h *URLHandler) previewRequested(r *http.Request, plusSuffix bool) bool {
+	if plusSuffix {
+		return true
+	}
+	if value := r.URL.Query().Get("preview"); value != "" {
+		preview, err := strconv.ParseBool(value)
+		return err == nil && preview
+	}
+	return h.PreviewLinks
+}
+
+// renderPreview writes the preview page for a link, with its destination's
+// metadata when it has been fetched. Its Continue link is the short link
+// again with ?preview=0, so following it is counted as a click and the rest
+// of the query is still forwarded.
+func (h *URLHandler) renderPreview(w http.ResponseWriter, r *http.Request, shortURL *ShortURL) {
+	query := r.URL.Query()
+	query.Set("preview", "0")
+	data := pageData{
+		ShortCode:   shortURL.ShortCode,
+		Destination: h.urlService.RedirectTarget(shortURL, r.URL.Query()),
+		Title:       shortURL.Title,
+		ContinueURL: "/" + url.PathEscape(shortURL.ShortCode) + "?" + query.Encode(),
+	}
+	if metadata := shortURL.Metadata; metadata != nil && metadata.URL == shortURL.OriginalURL {
+		if data.Title == "" {
+			data.Title = metadata.Title
+		}
+		data.Description = metadata.Description
+		data.FaviconURL = metadata.FaviconURL
+	}
+	h.renderPage(w, r, previewPageTemplate, data, http.StatusOK)
+}
+
+// sendNotFound answers a redirect to a missing link: browsers get the
+// not-found page, or the expired page for expired links when one is
+// configured, and API clients get the JSON 404
+func (h *URLHandler) sendNotFound(w http.ResponseWriter, r *http.Request, shortCode string, expired bool) {
+	switch {
+	case !wantsHTML(r):
+		h.sendErrorResponse(w, r, "Short URL not found or expired", http.StatusNotFound)
+	case expired && h.ExpiredPage != nil:
+		h.renderPage(w, r, h.ExpiredPage, pageData{ShortCode: shortCode}, http.StatusGone)
+	default:
+		h.renderPage(w, r, h.NotFoundPage, pageData{ShortCode: shortCode}, http.StatusNotFound)
+	}
+}
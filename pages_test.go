@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// browserAccept is the Accept header browsers send for navigations
+const browserAccept = "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8"
+
+func TestWantsHTML(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   bool
+	}{
+		{browserAccept, true},
+		{"", false},
+		{"*/*", false},
+		{"application/json", false},
+		{"text/html, application/json", false},
+		{"application/json;q=0.5, text/html", true},
+		{"text/html;q=0", false},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/missing", nil)
+		req.Header.Set("Accept", tt.accept)
+		if got := wantsHTML(req); got != tt.want {
+			t.Errorf("wantsHTML(%q) = %v, want %v", tt.accept, got, tt.want)
+		}
+	}
+}
+
+func TestNotFoundPage(t *testing.T) {
+	h := newTestHandler(t)
+	h.Homepage = "https://example.com"
+
+	serve := func(accept string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/nosuchcode", nil)
+		req.Header.Set("Accept", accept)
+		rec := httptest.NewRecorder()
+		h.RedirectURL(rec, req)
+		return rec
+	}
+	rec := serve(browserAccept)
+	if rec.Code != http.StatusNotFound || !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/html") {
+		t.Errorf("browser redirect to an unknown code = %d %s, want a 404 HTML page", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	if body := rec.Body.String(); !strings.Contains(body, "/nosuchcode") || !strings.Contains(body, "https://example.com") {
+		t.Errorf("not-found page = %q, want the shortcode and the homepage", body)
+	}
+	if rec := serve("application/json"); rec.Code != http.StatusNotFound || !strings.HasPrefix(rec.Header().Get("Content-Type"), "application/json") {
+		t.Errorf("API redirect to an unknown code = %d %s, want a JSON 404", rec.Code, rec.Header().Get("Content-Type"))
+	}
+}
+
+func TestExpiredPage(t *testing.T) {
+	clock := NewFakeClock(time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC))
+	s := newTestService(t, URLServiceConfig{Clock: clock})
+	h := NewURLHandler(s, s.logger)
+	if _, err := s.CreateShortURL(context.Background(), CreateShortURLRequest{URL: "example.com", ShortCode: "lapsed", Validity: 1}); err != nil {
+		t.Fatalf("CreateShortURL: %v", err)
+	}
+	clock.Advance(time.Hour)
+
+	redirect := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/lapsed", nil)
+		req.Header.Set("Accept", browserAccept)
+		rec := httptest.NewRecorder()
+		h.RedirectURL(rec, req)
+		return rec
+	}
+	if rec := redirect(); rec.Code != http.StatusNotFound || !strings.Contains(rec.Body.String(), "does not exist") {
+		t.Errorf("expired redirect without a page = %d %s, want the not-found page", rec.Code, rec.Body.String())
+	}
+
+	path := filepath.Join(t.TempDir(), "expired.html")
+	if err := os.WriteFile(path, []byte(`<h1>{{.ShortCode}} is gone</h1>{{if .Homepage}}<a href="{{.Homepage}}">home</a>{{end}}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	page, err := LoadPageTemplate(path)
+	if err != nil {
+		t.Fatalf("LoadPageTemplate: %v", err)
+	}
+	h.ExpiredPage = page
+	h.Homepage = "https://example.com/?a=1&b=2"
+
+	rec := redirect()
+	body := rec.Body.String()
+	if rec.Code != http.StatusGone || !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/html") {
+		t.Errorf("expired redirect = %d %s, want a 410 HTML page", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	if !strings.Contains(body, "lapsed is gone") || !strings.Contains(body, `href="https://example.com/?a=1&amp;b=2"`) {
+		t.Errorf("expired page = %q, want the shortcode and an escaped homepage link", body)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/lapsed", nil)
+	rec = httptest.NewRecorder()
+	h.RedirectURL(rec, req)
+	if rec.Code != http.StatusNotFound || !strings.HasPrefix(rec.Header().Get("Content-Type"), "application/json") {
+		t.Errorf("expired redirect from an API client = %d %s, want a JSON 404", rec.Code, rec.Header().Get("Content-Type"))
+	}
+
+	if _, err := LoadPageTemplate(filepath.Join(t.TempDir(), "missing.html")); err == nil {
+		t.Error("LoadPageTemplate of a missing file succeeded")
+	}
+}
+
+func TestPreviewPage(t *testing.T) {
+	h := newTestHandler(t)
+	ctx := context.Background()
+	if _, err := h.urlService.CreateShortURL(ctx, CreateShortURLRequest{URL: "https://example.com/landing", ShortCode: "peek", Title: "Launch <notes>", ForwardQuery: true}); err != nil {
+		t.Fatalf("CreateShortURL: %v", err)
+	}
+
+	redirect := func(target string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.RedirectURL(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		return rec
+	}
+	for _, target := range []string{"/peek+", "/peek?preview=1&utm_source=mail"} {
+		rec := redirect(target)
+		body := rec.Body.String()
+		if rec.Code != http.StatusOK || !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/html") {
+			t.Errorf("GET %s = %d %s, want the preview page", target, rec.Code, rec.Header().Get("Content-Type"))
+		}
+		if !strings.Contains(body, "https://example.com/landing") || !strings.Contains(body, "Launch &lt;notes&gt;") || !strings.Contains(body, "preview=0") {
+			t.Errorf("GET %s preview = %q, want the destination, escaped title and a continue link", target, body)
+		}
+	}
+	if body := redirect("/peek?preview=1&utm_source=mail").Body.String(); !strings.Contains(body, "landing?utm_source=mail") {
+		t.Errorf("preview = %q, want the forwarded destination", body)
+	}
+
+	if rec := redirect("/peek?preview=0&utm_source=mail"); rec.Code != http.StatusFound || rec.Header().Get("Location") != "https://example.com/landing?utm_source=mail" {
+		t.Errorf("continue = %d %s, want a redirect without the preview parameter", rec.Code, rec.Header().Get("Location"))
+	}
+	h.PreviewLinks = true
+	if rec := redirect("/peek"); rec.Code != http.StatusOK {
+		t.Errorf("GET /peek with PREVIEW_LINKS = %d, want the preview page", rec.Code)
+	}
+	if rec := redirect("/peek?preview=0"); rec.Code != http.StatusFound {
+		t.Errorf("GET /peek?preview=0 with PREVIEW_LINKS = %d, want a redirect", rec.Code)
+	}
+
+	stats, err := h.urlService.GetStats(ctx, "peek")
+	if err != nil || stats.TotalClicks != 2 {
+		t.Errorf("GetStats = %+v, %v, want only the 2 redirects counted", stats, err)
+	}
+}
+
+func TestPreviewKeepsProtectedDestinationHidden(t *testing.T) {
+	h := newTestHandler(t)
+	if _, err := h.urlService.CreateShortURL(context.Background(), CreateShortURLRequest{URL: "https://example.com/secret", ShortCode: "locked", Password: "opensesame"}); err != nil {
+		t.Fatalf("CreateShortURL: %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	h.RedirectURL(rec, httptest.NewRequest(http.MethodGet, "/locked+", nil))
+	if rec.Code != http.StatusUnauthorized || strings.Contains(rec.Body.String(), "example.com") {
+		t.Errorf("preview of a protected link = %d %q, want the password form", rec.Code, rec.Body.String())
+	}
+}
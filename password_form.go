@@ -0,0 +1,32 @@
+package main
+
+import (
+	"html/template"
+	"net/http"
+)
+
+// passwordFormTemplate prompts for the passphrase of a protected short link
+var passwordFormTemplate = template.Must(template.New("password").Parse(`<!DOCTYPE html>
+<html>
+<head><title>Password required</title></head>
+<body>
+<h1>This link is password protected</h1>
+{{if .Error}}<p style="color:red">{{.Error}}</p>{{end}}
+<form method="POST" action="/{{.ShortCode}}">
+<input type="password" name="password" placeholder="Password" autofocus>
+<button type="submit">Continue</button>
+</form>
+</body>
+</html>
+`))
+
+// renderPasswordForm writes the password prompt for a protected short link
+func renderPasswordForm(w http.ResponseWriter, shortCode, errorMessage string, statusCode int) error {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(statusCode)
+	return passwordFormTemplate.Execute(w, struct {
+		ShortCode string
+		Error     string
+	}{shortCode, errorMessage})
+}
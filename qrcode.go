@@ -0,0 +1,27 @@
+package main
+
+import (
+	"fmt"
+
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+const (
+	defaultQRSize = 256
+	minQRSize     = 64
+	maxQRSize     = 1024
+)
+
+// generateQRCode encodes content as a square PNG QR code of the given pixel size
+func generateQRCode(content string, size int) ([]byte, error) {
+	if size < minQRSize || size > maxQRSize {
+		return nil, fmt.Errorf("size must be between %d and %d pixels", minQRSize, maxQRSize)
+	}
+
+	png, err := qrcode.Encode(content, qrcode.Medium, size)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate QR code: %v", err)
+	}
+
+	return png, nil
+}
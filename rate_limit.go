@@ -0,0 +1,234 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultMaxRateLimitBuckets is how many clients a limiter tracks before it
+// sweeps out the ones whose buckets have refilled
+const defaultMaxRateLimitBuckets = 10000
+
+// Rate is a token bucket's size and refill: Requests may be made at once, and
+// the bucket refills at Requests per Per
+type Rate struct {
+	Requests int
+	Per      time.Duration
+}
+
+// ParseRate parses a rate such as "30/m", "5/s" or "1000/h"; "" and "0" disable limiting
+func ParseRate(value string) (Rate, error) {
+	value = strings.TrimSpace(value)
+	if value == "" || value == "0" {
+		return Rate{}, nil
+	}
+	count, unit, found := strings.Cut(value, "/")
+	requests, err := strconv.Atoi(strings.TrimSpace(count))
+	if !found || err != nil || requests <= 0 {
+		return Rate{}, fmt.Errorf("rate must look like 30/m, got %q", value)
+	}
+	rate := Rate{Requests: requests}
+	switch strings.TrimSpace(unit) {
+	case "s":
+		rate.Per = time.Second
+	case "m":
+		rate.Per = time.Minute
+	case "h":
+		rate.Per = time.Hour
+	default:
+		return Rate{}, fmt.Errorf("rate unit must be s, m or h, got %q", value)
+	}
+	return rate, nil
+}
+
+// RateLimitConfig sets the limits on create requests and redirects
+type RateLimitConfig struct {
+	Create   Rate // per client on POST /shorturls and /shorturls/bulk; zero disables
+	Redirect Rate // per client IP on redirects; zero disables
+	PerKey   bool // count authenticated creates per API key or user instead of per IP
+}
+
+// rateLimits are the limiters one RateLimitConfig builds; nil limiters
+// disable their limit
+type rateLimits struct {
+	config   RateLimitConfig
+	create   *RateLimiter
+	redirect *RateLimiter
+}
+
+// SetRateLimits applies config to requests from now on. A limit whose rate
+// is unchanged keeps its limiter, so reloading does not refill the buckets.
+func (h *URLHandler) SetRateLimits(config RateLimitConfig, clock Clock) {
+	limits := &rateLimits{config: config}
+	current := h.limits.Load()
+	if current != nil && current.config.Create == config.Create {
+		limits.create = current.create
+	} else {
+		limits.create = NewRateLimiter(config.Create, clock)
+	}
+	if current != nil && current.config.Redirect == config.Redirect {
+		limits.redirect = current.redirect
+	} else {
+		limits.redirect = NewRateLimiter(config.Redirect, clock)
+	}
+	h.limits.Store(limits)
+}
+
+// createLimiter returns the current limiter on creates, or nil
+func (h *URLHandler) createLimiter() *RateLimiter {
+	if limits := h.limits.Load(); limits != nil {
+		return limits.create
+	}
+	return nil
+}
+
+// redirectLimiter returns the current limiter on redirects, or nil
+func (h *URLHandler) redirectLimiter() *RateLimiter {
+	if limits := h.limits.Load(); limits != nil {
+		return limits.redirect
+	}
+	return nil
+}
+
+// tokenBucket is one client's remaining requests as of updated
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// RateLimiter keeps a token bucket per client key. Buckets that have refilled
+// are indistinguishable from new ones, so they are dropped when the limiter
+// grows past its bound.
+type RateLimiter struct {
+	burst     float64
+	perSecond float64
+	clock     Clock
+	maxKeys   int
+
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	nextSweep int
+}
+
+// NewRateLimiter returns a limiter for rate, or nil when rate is zero
+func NewRateLimiter(rate Rate, clock Clock) *RateLimiter {
+	if rate.Requests <= 0 || rate.Per <= 0 {
+		return nil
+	}
+	return &RateLimiter{
+		burst:     float64(rate.Requests),
+		perSecond: float64(rate.Requests) / rate.Per.Seconds(),
+		clock:     clock,
+		maxKeys:   defaultMaxRateLimitBuckets,
+		buckets:   make(map[string]*tokenBucket),
+		nextSweep: defaultMaxRateLimitBuckets,
+	}
+}
+
+// Allow takes a token from key's bucket. When it is empty it returns false
+// and how long until the next token.
+func (l *RateLimiter) Allow(key string) (bool, time.Duration) {
+	return l.AllowN(key, 1)
+}
+
+// AllowN takes n tokens from key's bucket, or none if it holds fewer, in
+// which case it returns false and how long until it holds n. More than the
+// burst is never allowed.
+func (l *RateLimiter) AllowN(key string, n int) (bool, time.Duration) {
+	now := l.clock.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	bucket, ok := l.buckets[key]
+	if !ok {
+		if len(l.buckets) >= l.nextSweep {
+			l.sweep(now)
+		}
+		bucket = &tokenBucket{tokens: l.burst, updated: now}
+		l.buckets[key] = bucket
+	}
+	bucket.tokens = l.refilled(bucket, now)
+	bucket.updated = now
+
+	if bucket.tokens < float64(n) {
+		wait := time.Duration((float64(n) - bucket.tokens) / l.perSecond * float64(time.Second))
+		return false, wait
+	}
+	bucket.tokens -= float64(n)
+	return true, 0
+}
+
+// refilled returns bucket's tokens at now
+func (l *RateLimiter) refilled(bucket *tokenBucket, now time.Time) float64 {
+	elapsed := now.Sub(bucket.updated).Seconds()
+	if elapsed <= 0 {
+		return bucket.tokens
+	}
+	return math.Min(l.burst, bucket.tokens+elapsed*l.perSecond)
+}
+
+// sweep drops full buckets. If most clients are still active it lets the map
+// grow to twice its size before sweeping again. Callers must hold l.mu.
+func (l *RateLimiter) sweep(now time.Time) {
+	for key, bucket := range l.buckets {
+		if l.refilled(bucket, now) >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+	l.nextSweep = max(l.maxKeys, 2*len(l.buckets))
+}
+
+// rateLimitKey identifies the client a request is counted against: its IP,
+// or with byKey and RATE_LIMIT_PER_KEY set its account or API key when it sent one
+func (h *URLHandler) rateLimitKey(r *http.Request, byKey bool) string {
+	if limits := h.limits.Load(); byKey && limits != nil && limits.config.PerKey {
+		if owner := ownerOf(r.Context()); owner != "" {
+			return "user:" + owner
+		}
+		if credential := credentialFromRequest(r); h.validAPIKey(credential) {
+			sum := sha256.Sum256([]byte(credential))
+			return "key:" + hex.EncodeToString(sum[:8])
+		}
+	}
+	return "ip:" + clientIP(r, h.TrustedProxies)
+}
+
+// limited wraps next in the limiter current returns for each request,
+// answering 429 with Retry-After once the client's bucket is empty. byKey
+// allows per-credential buckets for routes behind RequireCredentials. A nil
+// limiter lets every request through.
+func (h *URLHandler) limited(current func() *RateLimiter, byKey bool, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if h.allowRequest(w, r, current(), byKey, 1) {
+			next(w, r)
+		}
+	}
+}
+
+// allowRequest charges n tokens for a request to the client's bucket in
+// limiter, or answers 429 with Retry-After and returns false when the
+// bucket holds fewer. A nil limiter allows everything.
+func (h *URLHandler) allowRequest(w http.ResponseWriter, r *http.Request, limiter *RateLimiter, byKey bool, n int) bool {
+	if limiter == nil {
+		return true
+	}
+	key := h.rateLimitKey(r, byKey)
+	ok, wait := limiter.AllowN(key, n)
+	if ok {
+		return true
+	}
+	retryAfter := int(math.Ceil(wait.Seconds()))
+	rateLimitedTotal.Inc()
+	h.logger.LogContext(r.Context(), BackendStack, WarnLevel, HandlerPackage, fmt.Sprintf("%s %s - Rate limit exceeded for %s", r.Method, r.URL.Path, key))
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	h.sendErrorResponse(w, r, fmt.Sprintf("Too many requests; retry in %d seconds", retryAfter), http.StatusTooManyRequests)
+	return false
+}
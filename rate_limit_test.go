@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterRefills(t *testing.T) {
+	clock := NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	limiter := NewRateLimiter(Rate{Requests: 2, Per: time.Minute}, clock)
+
+	for i := 0; i < 2; i++ {
+		if ok, _ := limiter.Allow("a"); !ok {
+			t.Fatalf("request %d within the burst was refused", i+1)
+		}
+	}
+	ok, wait := limiter.Allow("a")
+	if ok || wait != 30*time.Second {
+		t.Errorf("Allow past the burst = %t, %s; want refused with a 30s wait", ok, wait)
+	}
+	if ok, _ := limiter.Allow("b"); !ok {
+		t.Error("another key shares the first key's bucket")
+	}
+
+	clock.Advance(30 * time.Second)
+	if ok, _ := limiter.Allow("a"); !ok {
+		t.Error("Allow after a token refilled was refused")
+	}
+	if ok, _ := limiter.Allow("a"); ok {
+		t.Error("Allow took more than the refilled token")
+	}
+
+	limiter.Allow("b")
+	ok, wait = limiter.AllowN("b", 2)
+	if ok || wait != 30*time.Second {
+		t.Errorf("AllowN(2) with one token left = %t, %s; want refused with a 30s wait", ok, wait)
+	}
+	if ok, _ := limiter.Allow("b"); !ok {
+		t.Error("a refused AllowN took tokens")
+	}
+
+	clock.Advance(time.Hour)
+	limiter.maxKeys = 1
+	limiter.sweep(clock.Now())
+	if len(limiter.buckets) != 0 {
+		t.Errorf("sweep kept %d refilled buckets", len(limiter.buckets))
+	}
+}
+
+func TestRateLimitedRoutes(t *testing.T) {
+	h := newTestHandler(t)
+	h.APIKeys = []string{testAPIKey, "other-key-0123456789"}
+	h.SetRateLimits(RateLimitConfig{Create: Rate{Requests: 1, Per: time.Minute}, Redirect: Rate{Requests: 2, Per: time.Hour}, PerKey: true}, RealClock())
+	router := NewRouter(h, newTestLogger(t))
+	if _, err := h.urlService.CreateShortURL(context.Background(), CreateShortURLRequest{URL: "example.com", ShortCode: "limited"}); err != nil {
+		t.Fatalf("CreateShortURL: %v", err)
+	}
+
+	do := func(method, path, key, remoteAddr string) *httptest.ResponseRecorder {
+		body := `{"url": "example.com"}`
+		if strings.HasSuffix(path, "/bulk") {
+			body = "[" + body + "]"
+		}
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set(APIKeyHeader, key)
+		req.RemoteAddr = remoteAddr
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := do(http.MethodPost, "/shorturls", testAPIKey, "192.0.2.1:1000"); rec.Code != http.StatusCreated {
+		t.Fatalf("first create = %d, want 201", rec.Code)
+	}
+	rec := do(http.MethodPost, "/shorturls", testAPIKey, "192.0.2.2:1000")
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "60" {
+		t.Errorf("second create with the same key = %d, Retry-After %q; want 429 after 60", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	if rec := do(http.MethodPost, "/shorturls/bulk", testAPIKey, "192.0.2.1:1000"); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("bulk create with the same key = %d, want the create limit to apply", rec.Code)
+	}
+	if rec := do(http.MethodPost, "/shorturls", "other-key-0123456789", "192.0.2.1:1000"); rec.Code != http.StatusCreated {
+		t.Errorf("create with another key = %d, want its own bucket", rec.Code)
+	}
+	if rec := do(http.MethodGet, "/shorturls/limited", testAPIKey, "192.0.2.1:1000"); rec.Code != http.StatusOK {
+		t.Errorf("stats = %d, want reads to stay unlimited", rec.Code)
+	}
+
+	for i, want := range []int{http.StatusFound, http.StatusFound, http.StatusTooManyRequests} {
+		if rec := do(http.MethodGet, "/limited", "", "198.51.100.7:1000"); rec.Code != want {
+			t.Errorf("redirect %d = %d, want %d", i+1, rec.Code, want)
+		}
+	}
+	if rec := do(http.MethodGet, "/limited", "", "198.51.100.8:1000"); rec.Code != http.StatusFound {
+		t.Errorf("redirect from another IP = %d, want 302", rec.Code)
+	}
+}
+
+func TestBulkCreateChargesPerLink(t *testing.T) {
+	h := newTestHandler(t)
+	h.SetRateLimits(RateLimitConfig{Create: Rate{Requests: 3, Per: time.Minute}}, RealClock())
+	router := NewRouter(h, newTestLogger(t))
+
+	bulk := func(links int) int {
+		items := make([]string, links)
+		for i := range items {
+			items[i] = fmt.Sprintf(`{"url": "example.com/%d"}`, i)
+		}
+		req := httptest.NewRequest(http.MethodPost, "/shorturls/bulk", strings.NewReader("["+strings.Join(items, ",")+"]"))
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	if code := bulk(2); code != http.StatusOK {
+		t.Fatalf("bulk create of 2 within a burst of 3 = %d, want 200", code)
+	}
+	if code := bulk(2); code != http.StatusTooManyRequests {
+		t.Errorf("bulk create of 2 with 1 token left = %d, want 429", code)
+	}
+	if count, _ := h.urlService.URLCount(); count != 2 {
+		t.Errorf("store holds %d links, want the refused batch not created", count)
+	}
+	if code := bulk(1); code != http.StatusOK {
+		t.Errorf("bulk create of 1 with 1 token left = %d, want 200", code)
+	}
+}
+
+func TestParseRate(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    Rate
+		wantErr bool
+	}{
+		{value: "", want: Rate{}},
+		{value: "30/m", want: Rate{Requests: 30, Per: time.Minute}},
+		{value: " 5 / s ", want: Rate{Requests: 5, Per: time.Second}},
+		{value: "1000/h", want: Rate{Requests: 1000, Per: time.Hour}},
+		{value: "30", wantErr: true},
+		{value: "-1/m", wantErr: true},
+		{value: "30/d", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseRate(tt.value)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseRate(%q) = %+v, %v; want %+v, error %t", tt.value, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,489 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// redisTimeout bounds each Redis command, since Store methods take no context
+const redisTimeout = 5 * time.Second
+
+// redisScanBatch is the COUNT hint used when listing keys
+const redisScanBatch = 500
+
+// RedisConfig holds connection settings for RedisStore
+type RedisConfig struct {
+	URL       string        // e.g. redis://:password@host:6379/0
+	KeyPrefix string        // namespace for this service's keys
+	Retention time.Duration // how long entries outlive their expiry; 0 lets Redis drop them on expiry
+}
+
+// DefaultRedisConfig returns the key prefix and retention used by default.
+// Expired entries are kept as long as the cleanup worker keeps them in the
+// other stores, so their stats survive the same window.
+func DefaultRedisConfig() RedisConfig {
+	return RedisConfig{
+		KeyPrefix: "trimurl:url:",
+		Retention: DefaultCleanupConfig().Retention,
+	}
+}
+
+// redisPutScript writes an entry's settings and, for a new entry or one
+// written before clicks had keys of their own, its click data, then gives
+// all three keys the entry's expiry and files the shortcode in its
+// destination's set. In "insert" mode it does nothing and returns 0 when
+// the entry exists. KEYS are the entry, stats and history keys and the
+// destination set; ARGV are the mode, shortcode, entry JSON, expiry in Unix
+// milliseconds (0 for none), current time in Unix milliseconds, destination
+// set prefix, click count, max clicks and last access, then the click
+// history. A changed destination's old set is found from the stored JSON,
+// so this script is not for Redis Cluster.
+var redisPutScript = redis.NewScript(`
+local stored = redis.call('GET', KEYS[1])
+if stored then
+	if ARGV[1] == 'insert' then
+		return 0
+	end
+	local previous = ARGV[6] .. cjson.decode(stored).original_url
+	if previous ~= KEYS[4] then
+		redis.call('SREM', previous, ARGV[2])
+	end
+else
+	redis.call('DEL', KEYS[2], KEYS[3])
+end
+if redis.call('EXISTS', KEYS[2]) == 0 then
+	redis.call('HSET', KEYS[2], 'count', ARGV[7])
+	if ARGV[9] ~= '' then
+		redis.call('HSET', KEYS[2], 'last', ARGV[9])
+	end
+	for i = 10, #ARGV do
+		redis.call('RPUSH', KEYS[3], ARGV[i])
+	end
+end
+redis.call('HSET', KEYS[2], 'max', ARGV[8])
+redis.call('SET', KEYS[1], ARGV[3])
+local expireAt = tonumber(ARGV[4])
+for i = 1, 3 do
+	if expireAt > 0 then
+		redis.call('PEXPIREAT', KEYS[i], expireAt)
+	else
+		redis.call('PERSIST', KEYS[i])
+	end
+end
+-- The set lives as long as its longest-lived shortcode
+local ttl = redis.call('PTTL', KEYS[4])
+redis.call('SADD', KEYS[4], ARGV[2])
+if expireAt == 0 then
+	redis.call('PERSIST', KEYS[4])
+elseif ttl == -2 or (ttl >= 0 and expireAt > tonumber(ARGV[5]) + ttl) then
+	redis.call('PEXPIREAT', KEYS[4], expireAt)
+end
+return 1
+`)
+
+// redisDeleteScript removes an entry, its click data and its shortcode
+// from its destination's set, returning 0 if there is no such entry. KEYS
+// are as for redisPutScript without the set; ARGV are the shortcode and the
+// destination set prefix.
+var redisDeleteScript = redis.NewScript(`
+local stored = redis.call('GET', KEYS[1])
+if not stored then
+	return 0
+end
+redis.call('SREM', ARGV[2] .. cjson.decode(stored).original_url, ARGV[1])
+redis.call('DEL', KEYS[1], KEYS[2], KEYS[3])
+return 1
+`)
+
+// redisClickScript counts clicks within an entry's budget, appends them to
+// its history and trims it, all in one atomic step. KEYS are as for
+// redisPutScript; ARGV are the history limit (0 for none), then each click's
+// timestamp in storedTimeFormat and its JSON. It returns the clicks
+// recorded and the count afterwards, or nil for a missing entry.
+var redisClickScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	return false
+end
+if redis.call('EXISTS', KEYS[2]) == 0 then
+	-- Written before clicks had keys of their own
+	local link = cjson.decode(redis.call('GET', KEYS[1]))
+	redis.call('HSET', KEYS[2], 'count', tonumber(link.click_count) or 0, 'max', tonumber(link.max_clicks) or 0)
+	if type(link.click_history) == 'table' then
+		for _, click in ipairs(link.click_history) do
+			redis.call('RPUSH', KEYS[3], cjson.encode(click))
+		end
+	end
+end
+local count = tonumber(redis.call('HGET', KEYS[2], 'count'))
+local max = tonumber(redis.call('HGET', KEYS[2], 'max'))
+local last = redis.call('HGET', KEYS[2], 'last') or ''
+local recorded = 0
+for i = 2, #ARGV, 2 do
+	if max > 0 and count >= max then
+		break
+	end
+	count = count + 1
+	recorded = recorded + 1
+	if ARGV[i] > last then
+		last = ARGV[i]
+	end
+	redis.call('RPUSH', KEYS[3], ARGV[i + 1])
+end
+if recorded > 0 then
+	redis.call('HSET', KEYS[2], 'count', count, 'last', last)
+	local limit = tonumber(ARGV[1])
+	if limit > 0 then
+		redis.call('LTRIM', KEYS[3], -limit, -1)
+	end
+end
+local ttl = redis.call('PTTL', KEYS[1])
+if ttl > 0 then
+	redis.call('PEXPIRE', KEYS[2], ttl)
+	redis.call('PEXPIRE', KEYS[3], ttl)
+end
+return {recorded, count}
+`)
+
+// RedisStore is a Store backed by Redis. Each entry's settings are a JSON
+// string whose TTL ends at its expiry (plus Retention), so Redis removes
+// expired links itself and several instances can share one database. Its
+// click count and last access are a hash under "stats:" and its click
+// history a list under "clicks:" in front of the entry's key; they share its
+// TTL and are only changed by scripts, so clicks from every instance count.
+type RedisStore struct {
+	client    *redis.Client
+	prefix    string
+	retention time.Duration
+}
+
+// NewRedisStore connects using config and checks that the server answers
+func NewRedisStore(config RedisConfig) (*RedisStore, error) {
+	if config.URL == "" {
+		return nil, fmt.Errorf("Redis URL is required")
+	}
+	if config.KeyPrefix == "" {
+		config.KeyPrefix = DefaultRedisConfig().KeyPrefix
+	}
+	if config.Retention < 0 {
+		return nil, fmt.Errorf("Redis retention must not be negative")
+	}
+
+	options, err := redis.ParseURL(config.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Redis URL: %v", err)
+	}
+	client := redis.NewClient(options)
+
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to connect to Redis: %v", err)
+	}
+
+	store := &RedisStore{client: client, prefix: config.KeyPrefix, retention: config.Retention}
+	if err := store.indexLegacyEntries(); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to index stored URLs: %v", err)
+	}
+	return store, nil
+}
+
+// indexLegacyEntries files entries written before destinations had sets in
+// them, once per database. Their sets get no TTL; lookups drop the codes
+// of entries Redis has since expired.
+func (s *RedisStore) indexLegacyEntries() error {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	marker := "indexed:" + s.prefix
+	if indexed, err := s.client.Exists(ctx, marker).Result(); err != nil || indexed > 0 {
+		return err
+	}
+	shortURLs, err := s.List()
+	if err != nil {
+		return err
+	}
+	for _, shortURL := range shortURLs {
+		if err := s.client.SAdd(ctx, s.urlSet(shortURL.OriginalURL), shortURL.ShortCode).Err(); err != nil {
+			return err
+		}
+	}
+	return s.client.Set(ctx, marker, 1, 0).Err()
+}
+
+func (s *RedisStore) key(shortCode string) string {
+	return s.prefix + shortCode
+}
+
+// keys returns the entry, stats and history keys of a shortcode
+func (s *RedisStore) keys(shortCode string) []string {
+	key := s.key(shortCode)
+	return []string{key, "stats:" + key, "clicks:" + key}
+}
+
+// urlSetPrefix starts the keys of the sets of shortcodes per destination
+func (s *RedisStore) urlSetPrefix() string {
+	return "urls:" + s.prefix
+}
+
+// urlSet returns the key of the set of shortcodes for a destination
+func (s *RedisStore) urlSet(originalURL string) string {
+	return s.urlSetPrefix() + originalURL
+}
+
+// Get returns the entry for a shortcode
+func (s *RedisStore) Get(shortCode string) (*ShortURL, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	return s.get(ctx, shortCode)
+}
+
+// get reads an entry's three keys in one transaction, so its settings and
+// clicks are seen as of the same moment
+func (s *RedisStore) get(ctx context.Context, shortCode string) (*ShortURL, error) {
+	keys := s.keys(shortCode)
+	var entry *redis.StringCmd
+	var stats *redis.MapStringStringCmd
+	var history *redis.StringSliceCmd
+	_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		entry = pipe.Get(ctx, keys[0])
+		stats = pipe.HGetAll(ctx, keys[1])
+		history = pipe.LRange(ctx, keys[2], 0, -1)
+		return nil
+	})
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrShortCodeNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	shortURL, err := decodeStoredEntry([]byte(entry.Val()))
+	if err != nil {
+		return nil, err
+	}
+	if len(stats.Val()) == 0 {
+		// Written before clicks had keys of their own, and not clicked
+		// since; its click data is still in the JSON
+		return shortURL, nil
+	}
+	if shortURL.ClickCount, err = strconv.Atoi(stats.Val()["count"]); err != nil {
+		return nil, fmt.Errorf("failed to decode click count of %s: %v", shortCode, err)
+	}
+	if last := stats.Val()["last"]; last != "" {
+		if shortURL.LastAccessedAt, err = time.Parse(time.RFC3339Nano, last); err != nil {
+			return nil, fmt.Errorf("failed to decode access time of %s: %v", shortCode, err)
+		}
+	}
+	shortURL.ClickHistory = make([]Click, len(history.Val()))
+	for i, click := range history.Val() {
+		if err := json.Unmarshal([]byte(click), &shortURL.ClickHistory[i]); err != nil {
+			return nil, fmt.Errorf("failed to decode clicks of %s: %v", shortCode, err)
+		}
+	}
+	return shortURL, nil
+}
+
+// Insert stores an entry and its clicks unless its shortcode is taken; a
+// script checks and writes in one step, so of several instances creating
+// the same shortcode at once only one succeeds
+func (s *RedisStore) Insert(shortURL *ShortURL) error {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	if !time.Now().Before(shortURL.ExpiresAt.Add(s.retention)) {
+		// Redis would drop it at once; still refuse a taken shortcode
+		exists, err := s.Exists(shortURL.ShortCode)
+		if err == nil && exists {
+			err = ErrShortCodeExists
+		}
+		return err
+	}
+	inserted, err := s.write(ctx, "insert", shortURL)
+	if err == nil && !inserted {
+		err = ErrShortCodeExists
+	}
+	return err
+}
+
+// Put inserts an entry with its clicks, or replaces a stored entry's
+// settings, resetting its TTL to match ExpiresAt; permanent links get none.
+// An entry already past its retention is deleted instead.
+func (s *RedisStore) Put(shortURL *ShortURL) error {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	if !time.Now().Before(shortURL.ExpiresAt.Add(s.retention)) {
+		return s.delete(ctx, shortURL.ShortCode)
+	}
+	_, err := s.write(ctx, "put", shortURL)
+	return err
+}
+
+// write runs redisPutScript in mode, reporting whether the entry was written
+func (s *RedisStore) write(ctx context.Context, mode string, shortURL *ShortURL) (bool, error) {
+	deadline := shortURL.ExpiresAt.Add(s.retention)
+	entry, err := encodeStoredSettings(shortURL)
+	if err != nil {
+		return false, err
+	}
+	expireAt := deadline.UnixMilli()
+	if isPermanent(shortURL) {
+		// No TTL, rather than one thousands of years out
+		expireAt = 0
+	}
+	lastAccessed := ""
+	if !shortURL.LastAccessedAt.IsZero() {
+		lastAccessed = shortURL.LastAccessedAt.UTC().Format(storedTimeFormat)
+	}
+	args := []any{mode, shortURL.ShortCode, entry, expireAt, time.Now().UnixMilli(), s.urlSetPrefix(),
+		shortURL.ClickCount, shortURL.MaxClicks, lastAccessed}
+	for _, click := range shortURL.ClickHistory {
+		encoded, err := json.Marshal(click)
+		if err != nil {
+			return false, fmt.Errorf("failed to encode clicks of %s: %v", shortURL.ShortCode, err)
+		}
+		args = append(args, encoded)
+	}
+	keys := append(s.keys(shortURL.ShortCode), s.urlSet(shortURL.OriginalURL))
+	written, err := redisPutScript.Run(ctx, s.client, keys, args...).Int()
+	return written == 1, err
+}
+
+// RecordClicks counts clicks with one script, which Redis runs without
+// interleaving any other command
+func (s *RedisStore) RecordClicks(shortCode string, clicks []Click, maxHistory int) (int, int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	args := []any{maxHistory}
+	for _, click := range clicks {
+		encoded, err := json.Marshal(click)
+		if err != nil {
+			return 0, 0, fmt.Errorf("failed to encode click: %v", err)
+		}
+		args = append(args, click.Timestamp.UTC().Format(storedTimeFormat), encoded)
+	}
+	result, err := redisClickScript.Run(ctx, s.client, s.keys(shortCode), args...).Int64Slice()
+	if errors.Is(err, redis.Nil) {
+		return 0, 0, ErrShortCodeNotFound
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+	return int(result[0]), int(result[1]), nil
+}
+
+// Delete removes an entry with its clicks
+func (s *RedisStore) Delete(shortCode string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	return s.delete(ctx, shortCode)
+}
+
+// delete runs redisDeleteScript
+func (s *RedisStore) delete(ctx context.Context, shortCode string) error {
+	deleted, err := redisDeleteScript.Run(ctx, s.client, s.keys(shortCode), shortCode, s.urlSetPrefix()).Int()
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return ErrShortCodeNotFound
+	}
+	return nil
+}
+
+// Exists reports whether a shortcode is stored
+func (s *RedisStore) Exists(shortCode string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	count, err := s.client.Exists(ctx, s.key(shortCode)).Result()
+	return count > 0, err
+}
+
+// List returns all stored entries. It scans the key prefix, so it costs time
+// proportional to the database size; entries expiring mid-scan are skipped.
+func (s *RedisStore) List() ([]*ShortURL, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	var shortURLs []*ShortURL
+	iter := s.client.Scan(ctx, 0, s.prefix+"*", redisScanBatch).Iterator()
+	for iter.Next(ctx) {
+		shortURL, err := s.get(ctx, strings.TrimPrefix(iter.Val(), s.prefix))
+		if errors.Is(err, ErrShortCodeNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		shortURLs = append(shortURLs, shortURL)
+	}
+	return shortURLs, iter.Err()
+}
+
+// ListByOriginalURL returns the entries in a destination's set, dropping
+// the codes of entries that have expired or moved to another destination
+func (s *RedisStore) ListByOriginalURL(originalURL string) ([]*ShortURL, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	set := s.urlSet(originalURL)
+	codes, err := s.client.SMembers(ctx, set).Result()
+	if err != nil {
+		return nil, err
+	}
+	shortURLs := make([]*ShortURL, 0, len(codes))
+	for _, code := range codes {
+		shortURL, err := s.get(ctx, code)
+		if errors.Is(err, ErrShortCodeNotFound) || (err == nil && shortURL.OriginalURL != originalURL) {
+			s.client.SRem(ctx, set, code)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		shortURLs = append(shortURLs, shortURL)
+	}
+	return shortURLs, nil
+}
+
+// Count returns the number of stored entries, scanning the key prefix
+func (s *RedisStore) Count() (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	count := 0
+	iter := s.client.Scan(ctx, 0, s.prefix+"*", redisScanBatch).Iterator()
+	for iter.Next(ctx) {
+		count++
+	}
+	return count, iter.Err()
+}
+
+// NextSequence increments a counter with INCR. Counter keys start with
+// "seq:" rather than the key prefix, so List and Count never see them, as
+// with the stats and history keys.
+func (s *RedisStore) NextSequence(name string) (uint64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	value, err := s.client.Incr(ctx, "seq:"+s.prefix+name).Uint64()
+	return value, err
+}
+
+// Close closes the connection pool
+func (s *RedisStore) Close() error {
+	return s.client.Close()
+}
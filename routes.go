@@ -0,0 +1,49 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+)
+
+// NewRouter registers every route on a new mux, each wrapped in request
+// logging and tracing except /metrics. Exact paths are matched before the /shorturls/
+// and / prefixes, and each route only accepts its own methods. The /shorturls
+// API needs an API key or account token once either is enabled; redirects stay
+// public. Creates and redirects go through h's rate limiters.
+func NewRouter(h *URLHandler, logger LoggerInterface) *http.ServeMux {
+	logged := LoggingMiddleware(logger, BackendStack, RoutePackage)
+
+	mux := http.NewServeMux()
+	// Each route is served in a span named after its method and pattern,
+	// continuing any trace context the caller sent
+	handle := func(pattern string, handler http.Handler) {
+		mux.Handle(pattern, otelhttp.NewHandler(logged(handler), pattern,
+			otelhttp.WithSpanNameFormatter(func(pattern string, r *http.Request) string {
+				return r.Method + " " + pattern
+			})))
+	}
+	handle("/health", Methods{http.MethodGet: h.HealthCheck})
+	handle("/version", Methods{http.MethodGet: h.Version})
+	mux.Handle("/metrics", promhttp.Handler())
+	handle("/openapi.json", Methods{http.MethodGet: h.OpenAPISpec})
+	handle("/admin/export", Methods{http.MethodGet: h.ExportURLs})
+	handle("/admin/import", Methods{http.MethodPost: h.ImportURLs})
+	handle("/admin/shorturls/", Methods{http.MethodPatch: h.SetEnabled})
+	handle("/admin/log-level", Methods{http.MethodGet: h.LogLevel, http.MethodPut: h.LogLevel})
+	handle("/auth/register", Methods{http.MethodPost: h.Register})
+	handle("/auth/login", Methods{http.MethodPost: h.Login})
+	handle("/shorturls/", h.RequireCredentials(http.HandlerFunc(h.ShortURLResource)))
+	handle("/shorturls", h.RequireCredentials(Methods{
+		http.MethodGet:  h.ListShortURLs,
+		http.MethodPost: h.limited(h.createLimiter, true, h.CreateShortURL),
+	}))
+	// POST carries the password form for protected links
+	redirect := h.limited(h.redirectLimiter, false, h.RedirectURL)
+	handle("/", Methods{
+		http.MethodGet:  redirect,
+		http.MethodPost: redirect,
+	})
+	return mux
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRouterDispatchesByMethod(t *testing.T) {
+	h := newTestHandler(t)
+	router := NewRouter(h, h.logger)
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/shorturls", strings.NewReader(`{"url": "example.com", "shortcode": "listed"}`)))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("POST /shorturls = %d, want 201: %s", rec.Code, rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/shorturls", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET /shorturls = %d, want 200: %s", rec.Code, rec.Body.String())
+	}
+	var list ShortURLList
+	if err := json.Unmarshal(rec.Body.Bytes(), &list); err != nil {
+		t.Fatalf("decode list: %v", err)
+	}
+	if len(list.ShortURLs) != 1 || list.ShortURLs[0].ShortCode != "listed" {
+		t.Errorf("GET /shorturls = %+v, want the created link", list)
+	}
+
+	tests := []struct {
+		method, path, allow string
+	}{
+		{http.MethodDelete, "/shorturls", "GET, HEAD, POST"},
+		{http.MethodPut, "/shorturls/listed", "DELETE, GET, HEAD, PATCH"},
+		{http.MethodGet, "/shorturls/stats", "POST"},
+		{http.MethodGet, "/shorturls/bulk", "POST"},
+		{http.MethodPost, "/shorturls/check", "GET, HEAD"},
+		{http.MethodDelete, "/listed", "GET, HEAD, POST"},
+		{http.MethodGet, "/admin/import", "POST"},
+		{http.MethodGet, "/auth/login", "POST"},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.path, nil))
+		if rec.Code != http.StatusMethodNotAllowed || rec.Header().Get("Allow") != tt.allow {
+			t.Errorf("%s %s = %d with Allow %q, want 405 with Allow %q", tt.method, tt.path, rec.Code, rec.Header().Get("Allow"), tt.allow)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// SnapshotConfig controls periodic snapshots of the in-memory store
+type SnapshotConfig struct {
+	Path     string        // file the snapshot is written to and loaded from
+	Interval time.Duration // time between snapshots
+}
+
+// DefaultSnapshotConfig returns the interval used when only a path is given
+func DefaultSnapshotConfig() SnapshotConfig {
+	return SnapshotConfig{
+		Interval: time.Minute,
+	}
+}
+
+// SaveSnapshot writes every entry as a JSON array, in the same format as
+// /admin/export, to a temporary file that then replaces path, so a crash
+// mid-write leaves the previous snapshot intact. It returns the number of
+// entries written.
+func SaveSnapshot(ctx context.Context, service *URLService, path string) (int, error) {
+	file, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return 0, fmt.Errorf("failed to create snapshot file: %v", err)
+	}
+	defer os.Remove(file.Name()) // no-op once renamed
+
+	writer := bufio.NewWriter(file)
+	encoder := json.NewEncoder(writer)
+	count := 0
+	writer.WriteString("[")
+	err = service.ExportShortURLs(ctx, func(shortURL *ShortURL) error {
+		if count > 0 {
+			writer.WriteString(",")
+		}
+		count++
+		return encoder.Encode(shortURL)
+	})
+	if err == nil {
+		writer.WriteString("]\n")
+		err = writer.Flush()
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to write snapshot: %v", err)
+	}
+
+	if err := os.Rename(file.Name(), path); err != nil {
+		return 0, fmt.Errorf("failed to replace snapshot: %v", err)
+	}
+	return count, nil
+}
+
+// LoadSnapshot imports the entries in the snapshot at path, returning how
+// many were imported. A missing file is not an error, so the first start
+// with snapshots enabled begins empty.
+func LoadSnapshot(ctx context.Context, service *URLService, path string) (int, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to open snapshot: %v", err)
+	}
+	defer file.Close()
+
+	decoder := json.NewDecoder(bufio.NewReader(file))
+	if _, err := decoder.Token(); err != nil {
+		return 0, fmt.Errorf("snapshot is not a JSON array: %v", err)
+	}
+	imported := 0
+	for decoder.More() {
+		var shortURL ShortURL
+		if err := decoder.Decode(&shortURL); err != nil {
+			return imported, fmt.Errorf("failed to decode snapshot entry %d: %v", imported+1, err)
+		}
+		ok, err := service.ImportShortURL(ctx, &shortURL)
+		if err != nil {
+			return imported, fmt.Errorf("failed to restore %s: %v", shortURL.ShortCode, err)
+		}
+		if ok {
+			imported++
+		}
+	}
+	return imported, nil
+}
+
+// Snapshotter saves snapshots on an interval in the background until closed
+type Snapshotter struct {
+	service  *URLService
+	logger   LoggerInterface
+	path     string
+	interval time.Duration
+
+	stop      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// StartSnapshotter begins saving snapshots of service every config.Interval
+func StartSnapshotter(service *URLService, logger LoggerInterface, config SnapshotConfig) (*Snapshotter, error) {
+	if config.Path == "" {
+		return nil, fmt.Errorf("snapshot path is required")
+	}
+	if config.Interval <= 0 {
+		config.Interval = DefaultSnapshotConfig().Interval
+	}
+	s := &Snapshotter{
+		service:  service,
+		logger:   logger,
+		path:     config.Path,
+		interval: config.Interval,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go s.run()
+	return s, nil
+}
+
+func (s *Snapshotter) run() {
+	defer close(s.done)
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.save()
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// Close stops the loop and writes a final snapshot
+func (s *Snapshotter) Close() error {
+	var err error
+	s.closeOnce.Do(func() {
+		close(s.stop)
+		<-s.done
+		err = s.save()
+	})
+	return err
+}
+
+func (s *Snapshotter) save() error {
+	count, err := SaveSnapshot(context.Background(), s.service, s.path)
+	if err != nil {
+		s.logger.Log(BackendStack, ErrorLevel, CronJobPackage, fmt.Sprintf("Snapshot to %s failed: %v", s.path, err))
+		return err
+	}
+	s.logger.Log(BackendStack, DebugLevel, CronJobPackage, fmt.Sprintf("Snapshot of %d short URLs written to %s", count, s.path))
+	return nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+	ctx := context.Background()
+
+	s := newTestService(t, URLServiceConfig{})
+	for _, code := range []string{"first", "second"} {
+		if _, err := s.CreateShortURL(ctx, CreateShortURLRequest{URL: "example.com/" + code, ShortCode: code}); err != nil {
+			t.Fatalf("CreateShortURL(%s): %v", code, err)
+		}
+	}
+	if err := s.RecordClick(ctx, "second", Click{Source: "direct"}); err != nil {
+		t.Fatalf("RecordClick: %v", err)
+	}
+
+	if count, err := SaveSnapshot(ctx, s, path); err != nil || count != 2 {
+		t.Fatalf("SaveSnapshot = %d, %v, want 2 entries", count, err)
+	}
+
+	restored := newTestService(t, URLServiceConfig{})
+	if count, err := LoadSnapshot(ctx, restored, path); err != nil || count != 2 {
+		t.Fatalf("LoadSnapshot = %d, %v, want 2 entries", count, err)
+	}
+	stats, err := restored.GetStats(ctx, "second")
+	if err != nil {
+		t.Fatalf("GetStats after restore: %v", err)
+	}
+	if stats.TotalClicks != 1 || len(stats.Clicks) != 1 {
+		t.Errorf("restored stats = %+v, want the recorded click", stats)
+	}
+
+	// No temporary files are left next to the snapshot
+	if matches, _ := filepath.Glob(path + ".*.tmp"); len(matches) != 0 {
+		t.Errorf("leftover temporary files: %v", matches)
+	}
+}
+
+func TestLoadSnapshotErrors(t *testing.T) {
+	dir := t.TempDir()
+	s := newTestService(t, URLServiceConfig{})
+
+	if count, err := LoadSnapshot(context.Background(), s, filepath.Join(dir, "missing.json")); err != nil || count != 0 {
+		t.Errorf("LoadSnapshot of a missing file = %d, %v, want 0, nil", count, err)
+	}
+
+	corrupt := filepath.Join(dir, "corrupt.json")
+	os.WriteFile(corrupt, []byte(`{"not": "an array"}`), 0o644)
+	if _, err := LoadSnapshot(context.Background(), s, corrupt); err == nil {
+		t.Error("LoadSnapshot accepted a file that is not a JSON array")
+	}
+}
+
+func TestSnapshotterWritesOnClose(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+	s := newTestService(t, URLServiceConfig{})
+
+	snapshotter, err := StartSnapshotter(s, s.logger, SnapshotConfig{Path: path, Interval: time.Hour})
+	if err != nil {
+		t.Fatalf("StartSnapshotter: %v", err)
+	}
+	if _, err := s.CreateShortURL(context.Background(), CreateShortURLRequest{URL: "example.com", ShortCode: "flushed"}); err != nil {
+		t.Fatalf("CreateShortURL: %v", err)
+	}
+	if err := snapshotter.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if err := snapshotter.Close(); err != nil {
+		t.Errorf("second Close: %v", err)
+	}
+
+	restored := newTestService(t, URLServiceConfig{})
+	if count, err := LoadSnapshot(context.Background(), restored, path); err != nil || count != 1 {
+		t.Errorf("LoadSnapshot after Close = %d, %v, want the link created before Close", count, err)
+	}
+}
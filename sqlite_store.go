@@ -0,0 +1,367 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite" // registers the "sqlite" database/sql driver
+)
+
+// sqliteSchema is applied on open; every statement is idempotent so an
+// existing database is left as it is
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS short_urls (
+	shortcode        TEXT PRIMARY KEY,
+	original_url     TEXT NOT NULL,
+	created_at       TEXT NOT NULL,
+	expires_at       TEXT NOT NULL,
+	entry            TEXT NOT NULL,
+	click_count      INTEGER NOT NULL DEFAULT 0,
+	max_clicks       INTEGER NOT NULL DEFAULT 0,
+	last_accessed_at TEXT
+);
+CREATE INDEX IF NOT EXISTS short_urls_expires_at ON short_urls (expires_at);
+CREATE INDEX IF NOT EXISTS short_urls_original_url ON short_urls (original_url);
+CREATE TABLE IF NOT EXISTS clicks (
+	id        INTEGER PRIMARY KEY,
+	shortcode TEXT NOT NULL REFERENCES short_urls (shortcode) ON DELETE CASCADE,
+	click     TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS clicks_shortcode ON clicks (shortcode, id);
+CREATE TABLE IF NOT EXISTS sequences (
+	name  TEXT PRIMARY KEY,
+	value INTEGER NOT NULL
+);
+`
+
+// sqliteClickColumns were added to short_urls when clicks moved out of the
+// entry JSON; databases created before then get them on open
+var sqliteClickColumns = []string{
+	"click_count INTEGER NOT NULL DEFAULT 0",
+	"max_clicks INTEGER NOT NULL DEFAULT 0",
+	"last_accessed_at TEXT",
+}
+
+// sqliteLegacyClicks move the click data of entries written before it had
+// columns and a table of its own out of their JSON
+var sqliteLegacyClicks = []string{
+	`INSERT INTO clicks (shortcode, click)
+		SELECT short_urls.shortcode, history.value
+		FROM short_urls, json_each(short_urls.entry, '$.click_history') AS history
+		WHERE json_type(short_urls.entry, '$.click_history') = 'array'
+		ORDER BY short_urls.shortcode, history.key`,
+	`UPDATE short_urls SET
+		click_count = COALESCE(json_extract(entry, '$.click_count'), 0),
+		max_clicks = COALESCE(json_extract(entry, '$.max_clicks'), 0),
+		last_accessed_at = NULLIF(json_extract(entry, '$.last_accessed_at'), '0001-01-01T00:00:00Z'),
+		entry = json_remove(entry, '$.click_count', '$.click_history', '$.last_accessed_at')
+		WHERE json_type(entry, '$.click_history') IS NOT NULL`,
+}
+
+// sqliteEntryColumns select what decodeStoredRow needs, the click history
+// aggregated in the order it was recorded
+const sqliteEntryColumns = `entry, click_count, last_accessed_at,
+	(SELECT json_group_array(json(click) ORDER BY id) FROM clicks WHERE clicks.shortcode = short_urls.shortcode)`
+
+// SQLiteStore is a Store backed by a SQLite database file, so links and
+// their click history survive restarts. Each entry's settings are kept as
+// JSON next to the columns worth querying on; its clicks are counted in a
+// column and kept in their own table.
+type SQLiteStore struct {
+	db *sql.DB
+}
+
+// NewSQLiteStore opens (creating if needed) the database at path and
+// ensures the schema exists
+func NewSQLiteStore(path string) (*SQLiteStore, error) {
+	// Transactions take the write lock when they begin, so a click's read of
+	// the click count and its update cannot interleave with another process's
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_pragma=foreign_keys(1)&_txlock=immediate")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open SQLite database %s: %v", path, err)
+	}
+	// SQLite allows one writer; a single connection avoids SQLITE_BUSY
+	// between this process's own goroutines
+	db.SetMaxOpenConns(1)
+
+	if err := migrateSQLite(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create SQLite schema in %s: %v", path, err)
+	}
+	return &SQLiteStore{db: db}, nil
+}
+
+// migrateSQLite creates the schema and brings a database from before the
+// clicks table up to date
+func migrateSQLite(db *sql.DB) error {
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		return err
+	}
+	for _, column := range sqliteClickColumns {
+		name, _, _ := strings.Cut(column, " ")
+		var exists bool
+		if err := db.QueryRow(`SELECT EXISTS (SELECT 1 FROM pragma_table_info('short_urls') WHERE name = ?)`, name).Scan(&exists); err != nil {
+			return err
+		}
+		if !exists {
+			if _, err := db.Exec(`ALTER TABLE short_urls ADD COLUMN ` + column); err != nil {
+				return err
+			}
+		}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, statement := range sqliteLegacyClicks {
+		if _, err := tx.Exec(statement); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// scanSQLiteEntry decodes a row selected with sqliteEntryColumns
+func scanSQLiteEntry(row interface{ Scan(...any) error }) (*ShortURL, error) {
+	var entry, clicks string
+	var clickCount int
+	var lastAccessed sql.NullString
+	if err := row.Scan(&entry, &clickCount, &lastAccessed, &clicks); err != nil {
+		return nil, err
+	}
+	var lastAccessedAt time.Time
+	if lastAccessed.Valid {
+		parsed, err := time.Parse(time.RFC3339Nano, lastAccessed.String)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode stored access time: %v", err)
+		}
+		lastAccessedAt = parsed
+	}
+	return decodeStoredRow([]byte(entry), clickCount, lastAccessedAt, []byte(clicks))
+}
+
+// Get returns the entry for a shortcode
+func (s *SQLiteStore) Get(shortCode string) (*ShortURL, error) {
+	shortURL, err := scanSQLiteEntry(s.db.QueryRow(`SELECT `+sqliteEntryColumns+` FROM short_urls WHERE shortcode = ?`, shortCode))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrShortCodeNotFound
+	}
+	return shortURL, err
+}
+
+// Insert stores an entry and its clicks unless its shortcode is taken
+func (s *SQLiteStore) Insert(shortURL *ShortURL) error {
+	entry, err := encodeStoredSettings(shortURL)
+	if err != nil {
+		return err
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	inserted, err := s.insert(tx, shortURL, entry)
+	if err != nil {
+		return err
+	}
+	if !inserted {
+		return ErrShortCodeExists
+	}
+	return tx.Commit()
+}
+
+// Put inserts an entry with its clicks, or replaces a stored entry's settings
+func (s *SQLiteStore) Put(shortURL *ShortURL) error {
+	entry, err := encodeStoredSettings(shortURL)
+	if err != nil {
+		return err
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	inserted, err := s.insert(tx, shortURL, entry)
+	if err != nil {
+		return err
+	}
+	if !inserted {
+		_, err = tx.Exec(`
+			UPDATE short_urls SET original_url = ?, created_at = ?, expires_at = ?, max_clicks = ?, entry = ?
+			WHERE shortcode = ?`,
+			shortURL.OriginalURL, shortURL.CreatedAt.UTC().Format(time.RFC3339Nano), shortURL.ExpiresAt.UTC().Format(time.RFC3339Nano),
+			shortURL.MaxClicks, string(entry), shortURL.ShortCode)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// insert adds an entry and its click history unless its shortcode is
+// stored, reporting whether it did
+func (s *SQLiteStore) insert(tx *sql.Tx, shortURL *ShortURL, entry []byte) (bool, error) {
+	var lastAccessed sql.NullString
+	if !shortURL.LastAccessedAt.IsZero() {
+		lastAccessed = sql.NullString{String: shortURL.LastAccessedAt.UTC().Format(storedTimeFormat), Valid: true}
+	}
+	result, err := tx.Exec(`
+		INSERT INTO short_urls (shortcode, original_url, created_at, expires_at, entry, click_count, max_clicks, last_accessed_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (shortcode) DO NOTHING`,
+		shortURL.ShortCode, shortURL.OriginalURL,
+		shortURL.CreatedAt.UTC().Format(time.RFC3339Nano), shortURL.ExpiresAt.UTC().Format(time.RFC3339Nano),
+		string(entry), shortURL.ClickCount, shortURL.MaxClicks, lastAccessed)
+	if err != nil {
+		return false, err
+	}
+	if inserted, err := result.RowsAffected(); err != nil || inserted == 0 {
+		return false, err
+	}
+	return true, insertSQLiteClicks(tx, shortURL.ShortCode, shortURL.ClickHistory)
+}
+
+// insertSQLiteClicks appends clicks to a shortcode's history in order
+func insertSQLiteClicks(tx *sql.Tx, shortCode string, clicks []Click) error {
+	if len(clicks) == 0 {
+		return nil
+	}
+	encoded, err := encodeStoredClicks(clicks)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`INSERT INTO clicks (shortcode, click) SELECT ?, value FROM json_each(?) ORDER BY key`, shortCode, string(encoded))
+	return err
+}
+
+// RecordClicks counts clicks within one transaction, which holds SQLite's
+// write lock from its start
+func (s *SQLiteStore) RecordClicks(shortCode string, clicks []Click, maxHistory int) (int, int, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback()
+
+	var count, maxClicks int
+	var lastAccessed sql.NullString
+	err = tx.QueryRow(`SELECT click_count, max_clicks, last_accessed_at FROM short_urls WHERE shortcode = ?`, shortCode).Scan(&count, &maxClicks, &lastAccessed)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, 0, ErrShortCodeNotFound
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+	recorded := countClicks(len(clicks), count, maxClicks)
+	if recorded == 0 {
+		return 0, count, nil
+	}
+
+	var after time.Time
+	if lastAccessed.Valid {
+		// Unparsable times are older than any click
+		after, _ = time.Parse(time.RFC3339Nano, lastAccessed.String)
+	}
+	count += recorded
+	last := lastClick(after, clicks[:recorded]).UTC().Format(storedTimeFormat)
+	if _, err := tx.Exec(`UPDATE short_urls SET click_count = ?, last_accessed_at = ? WHERE shortcode = ?`, count, last, shortCode); err != nil {
+		return 0, 0, err
+	}
+	if err := insertSQLiteClicks(tx, shortCode, clicks[:recorded]); err != nil {
+		return 0, 0, err
+	}
+	if maxHistory > 0 {
+		_, err := tx.Exec(`
+			DELETE FROM clicks WHERE shortcode = ? AND id <= (
+				SELECT id FROM clicks WHERE shortcode = ? ORDER BY id DESC LIMIT 1 OFFSET ?)`,
+			shortCode, shortCode, maxHistory)
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, 0, err
+	}
+	return recorded, count, nil
+}
+
+// Delete removes an entry
+func (s *SQLiteStore) Delete(shortCode string) error {
+	result, err := s.db.Exec(`DELETE FROM short_urls WHERE shortcode = ?`, shortCode)
+	if err != nil {
+		return err
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return ErrShortCodeNotFound
+	}
+	return nil
+}
+
+// Exists reports whether a shortcode is stored
+func (s *SQLiteStore) Exists(shortCode string) (bool, error) {
+	var exists bool
+	err := s.db.QueryRow(`SELECT EXISTS (SELECT 1 FROM short_urls WHERE shortcode = ?)`, shortCode).Scan(&exists)
+	return exists, err
+}
+
+// List returns all stored entries
+func (s *SQLiteStore) List() ([]*ShortURL, error) {
+	return s.query(`SELECT ` + sqliteEntryColumns + ` FROM short_urls`)
+}
+
+// ListByOriginalURL returns the entries for a destination using its index
+func (s *SQLiteStore) ListByOriginalURL(originalURL string) ([]*ShortURL, error) {
+	return s.query(`SELECT `+sqliteEntryColumns+` FROM short_urls WHERE original_url = ?`, originalURL)
+}
+
+// query returns the entries selected with sqliteEntryColumns
+func (s *SQLiteStore) query(query string, args ...any) ([]*ShortURL, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var shortURLs []*ShortURL
+	for rows.Next() {
+		shortURL, err := scanSQLiteEntry(rows)
+		if err != nil {
+			return nil, err
+		}
+		shortURLs = append(shortURLs, shortURL)
+	}
+	return shortURLs, rows.Err()
+}
+
+// Count returns the number of stored entries
+func (s *SQLiteStore) Count() (int, error) {
+	var count int
+	err := s.db.QueryRow(`SELECT COUNT(*) FROM short_urls`).Scan(&count)
+	return count, err
+}
+
+// NextSequence increments a counter in the sequences table
+func (s *SQLiteStore) NextSequence(name string) (uint64, error) {
+	var value uint64
+	err := s.db.QueryRow(`
+		INSERT INTO sequences (name, value) VALUES (?, 1)
+		ON CONFLICT (name) DO UPDATE SET value = value + 1
+		RETURNING value`, name).Scan(&value)
+	return value, err
+}
+
+// Close closes the database
+func (s *SQLiteStore) Close() error {
+	return s.db.Close()
+}
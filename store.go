@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Store persists short URLs for the URL service.
+// Implementations must be safe for concurrent use.
+type Store interface {
+	// Get returns the entry for a shortcode or ErrShortCodeNotFound
+	Get(shortCode string) (*ShortURL, error)
+	// Insert stores a new entry with its clicks, or returns
+	// ErrShortCodeExists if its shortcode is already stored. The check and
+	// the write are one atomic step, also across instances sharing the store.
+	Insert(shortURL *ShortURL) error
+	// Put inserts or replaces an entry keyed by its shortcode. Replacing an
+	// entry keeps its stored click count, history and last access time,
+	// which only RecordClicks changes.
+	Put(shortURL *ShortURL) error
+	// RecordClicks atomically counts clicks against an entry and appends
+	// them to its history, stopping once its MaxClicks budget is used up, and
+	// keeps at most maxHistory clicks of history (0 keeps all). It returns
+	// how many clicks were recorded and the entry's click count afterwards,
+	// or ErrShortCodeNotFound.
+	RecordClicks(shortCode string, clicks []Click, maxHistory int) (recorded, total int, err error)
+	// Delete removes an entry, returning ErrShortCodeNotFound if absent
+	Delete(shortCode string) error
+	// Exists reports whether a shortcode is stored
+	Exists(shortCode string) (bool, error)
+	// List returns all stored entries in no particular order
+	List() ([]*ShortURL, error)
+	// ListByOriginalURL returns the stored entries whose OriginalURL is
+	// originalURL, in no particular order
+	ListByOriginalURL(originalURL string) ([]*ShortURL, error)
+	// Count returns the number of stored entries
+	Count() (int, error)
+}
+
+// SequenceStore is implemented by stores that keep named counters, such as
+// the one CounterCodeGenerator draws shortcodes from. Every instance sharing
+// a database-backed store sees the same counters.
+type SequenceStore interface {
+	// NextSequence atomically increments the named counter and returns its
+	// new value; a counter's first value is 1
+	NextSequence(name string) (uint64, error)
+}
+
+// storedTimeFormat is how stores write click and access times they compare
+// as text: fixed width and always UTC, so later times sort later
+const storedTimeFormat = "2006-01-02T15:04:05.000000000Z"
+
+// storedSettings is an entry as the database-backed stores encode it. Its
+// click count, history and last access are left out; those stores keep them
+// beside the entry, where RecordClicks can update them atomically.
+type storedSettings struct {
+	*ShortURL
+	ClickCount     int        `json:"click_count,omitempty"`
+	ClickHistory   []Click    `json:"click_history,omitempty"`
+	LastAccessedAt *time.Time `json:"last_accessed_at,omitempty"`
+}
+
+// encodeStoredSettings encodes an entry without its click data
+func encodeStoredSettings(shortURL *ShortURL) ([]byte, error) {
+	entry, err := json.Marshal(storedSettings{ShortURL: shortURL})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode %s: %v", shortURL.ShortCode, err)
+	}
+	return entry, nil
+}
+
+// encodeStoredClicks encodes clicks as the JSON array the SQL stores insert
+// into their clicks tables
+func encodeStoredClicks(clicks []Click) ([]byte, error) {
+	if clicks == nil {
+		clicks = []Click{}
+	}
+	encoded, err := json.Marshal(clicks)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode clicks: %v", err)
+	}
+	return encoded, nil
+}
+
+// countClicks returns how many of n clicks fit in the budget of an entry
+// with maxClicks that has been clicked count times
+func countClicks(n, count, maxClicks int) int {
+	if maxClicks <= 0 {
+		return n
+	}
+	return max(0, min(n, maxClicks-count))
+}
+
+// lastClick returns the latest of after and the clicks' timestamps
+func lastClick(after time.Time, clicks []Click) time.Time {
+	for _, click := range clicks {
+		if click.Timestamp.After(after) {
+			after = click.Timestamp
+		}
+	}
+	return after
+}
+
+// decodeStoredEntry decodes an entry kept as JSON by a database-backed store.
+// Entries written before click data moved out of the JSON still carry it.
+func decodeStoredEntry(entry []byte) (*ShortURL, error) {
+	var shortURL ShortURL
+	if err := json.Unmarshal(entry, &shortURL); err != nil {
+		return nil, fmt.Errorf("failed to decode stored entry: %v", err)
+	}
+	if shortURL.ClickHistory == nil {
+		shortURL.ClickHistory = []Click{}
+	}
+	return &shortURL, nil
+}
+
+// decodeStoredRow decodes an entry from a SQL store's row: the settings
+// JSON, the click count and last access columns, and the click history as a
+// JSON array
+func decodeStoredRow(entry []byte, clickCount int, lastAccessedAt time.Time, clicks []byte) (*ShortURL, error) {
+	shortURL, err := decodeStoredEntry(entry)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(clicks, &shortURL.ClickHistory); err != nil {
+		return nil, fmt.Errorf("failed to decode clicks of %s: %v", shortURL.ShortCode, err)
+	}
+	shortURL.ClickCount = clickCount
+	shortURL.LastAccessedAt = lastAccessedAt
+	return shortURL, nil
+}
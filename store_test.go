@@ -0,0 +1,503 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+// testStoreContract checks the behaviour every Store implementation must share
+func testStoreContract(t *testing.T, store Store) {
+	t.Helper()
+
+	if _, err := store.Get("missing"); !errors.Is(err, ErrShortCodeNotFound) {
+		t.Errorf("Get(missing) = %v, want ErrShortCodeNotFound", err)
+	}
+	if err := store.Delete("missing"); !errors.Is(err, ErrShortCodeNotFound) {
+		t.Errorf("Delete(missing) = %v, want ErrShortCodeNotFound", err)
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	entry := &ShortURL{
+		ShortCode:    "stored",
+		OriginalURL:  "https://example.com",
+		CreatedAt:    now,
+		ExpiresAt:    now.Add(time.Hour),
+		ClickHistory: []Click{{Timestamp: now, Source: "direct"}},
+		ClickCount:   1,
+		PasswordHash: "hash",
+	}
+	if err := store.Put(entry); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	got, err := store.Get("stored")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if got.OriginalURL != entry.OriginalURL || got.ClickCount != 1 || len(got.ClickHistory) != 1 || got.PasswordHash != "hash" || !got.ExpiresAt.Equal(entry.ExpiresAt) {
+		t.Errorf("Get = %+v, want %+v", got, entry)
+	}
+
+	// Replacing an entry changes its settings but never its clicks
+	replaced := *got
+	replaced.Title = "replaced"
+	replaced.ClickCount = 5
+	replaced.ClickHistory = nil
+	if err := store.Put(&replaced); err != nil {
+		t.Fatalf("Put (replace): %v", err)
+	}
+	if count, err := store.Count(); err != nil || count != 1 {
+		t.Errorf("Count after replace = %d, %v, want 1", count, err)
+	}
+	if exists, err := store.Exists("stored"); err != nil || !exists {
+		t.Errorf("Exists(stored) = %t, %v, want true", exists, err)
+	}
+	if list, err := store.List(); err != nil || len(list) != 1 || list[0].Title != "replaced" || list[0].ClickCount != 1 || len(list[0].ClickHistory) != 1 {
+		t.Errorf("List = %v, %v, want the replaced entry with its click kept", list, err)
+	}
+
+	if _, _, err := store.RecordClicks("missing", []Click{{Timestamp: now}}, 0); !errors.Is(err, ErrShortCodeNotFound) {
+		t.Errorf("RecordClicks(missing) = %v, want ErrShortCodeNotFound", err)
+	}
+	limited := &ShortURL{ShortCode: "limited", OriginalURL: "https://example.com", CreatedAt: now, ExpiresAt: now.Add(time.Hour), MaxClicks: 3}
+	if err := store.Put(limited); err != nil {
+		t.Fatalf("Put limited: %v", err)
+	}
+	clicks := []Click{{Timestamp: now.Add(time.Second), Source: "a"}, {Timestamp: now.Add(3 * time.Second), Source: "b"}}
+	if recorded, total, err := store.RecordClicks("limited", clicks, 2); err != nil || recorded != 2 || total != 2 {
+		t.Errorf("RecordClicks = %d, %d, %v, want 2 recorded of 2", recorded, total, err)
+	}
+	clicks = []Click{{Timestamp: now.Add(4 * time.Second), Source: "c"}, {Timestamp: now.Add(5 * time.Second), Source: "d"}}
+	if recorded, total, err := store.RecordClicks("limited", clicks, 2); err != nil || recorded != 1 || total != 3 {
+		t.Errorf("RecordClicks past the budget = %d, %d, %v, want 1 recorded of 3", recorded, total, err)
+	}
+	if recorded, total, err := store.RecordClicks("limited", clicks, 2); err != nil || recorded != 0 || total != 3 {
+		t.Errorf("RecordClicks with the budget used up = %d, %d, %v, want none recorded", recorded, total, err)
+	}
+	got, err = store.Get("limited")
+	if err != nil {
+		t.Fatalf("Get limited: %v", err)
+	}
+	if got.ClickCount != 3 || len(got.ClickHistory) != 2 || got.ClickHistory[0].Source != "b" || got.ClickHistory[1].Source != "c" {
+		t.Errorf("clicks = %d, %+v, want 3 counted and the newest 2 kept", got.ClickCount, got.ClickHistory)
+	}
+	if want := now.Add(4 * time.Second); !got.LastAccessedAt.Equal(want) {
+		t.Errorf("LastAccessedAt = %s, want the last click counted, %s", got.LastAccessedAt, want)
+	}
+
+	taken := &ShortURL{ShortCode: "stored", OriginalURL: "https://example.net", CreatedAt: now, ExpiresAt: now.Add(time.Hour)}
+	if err := store.Insert(taken); !errors.Is(err, ErrShortCodeExists) {
+		t.Errorf("Insert of a taken shortcode = %v, want ErrShortCodeExists", err)
+	}
+	if got, err := store.Get("stored"); err != nil || got.OriginalURL != entry.OriginalURL {
+		t.Errorf("Get after refused Insert = %+v, %v, want the entry unchanged", got, err)
+	}
+	if found, err := store.ListByOriginalURL("https://example.com"); err != nil || len(found) != 2 {
+		t.Errorf("ListByOriginalURL = %v, %v, want both entries", found, err)
+	}
+	if found, err := store.ListByOriginalURL("https://example.net"); err != nil || len(found) != 0 {
+		t.Errorf("ListByOriginalURL of a refused Insert = %v, %v, want nothing", found, err)
+	}
+
+	// Changing a destination moves the entry between lookups
+	moved := *got
+	moved.OriginalURL = "https://example.org"
+	if err := store.Put(&moved); err != nil {
+		t.Fatalf("Put (move): %v", err)
+	}
+	if found, err := store.ListByOriginalURL("https://example.com"); err != nil || len(found) != 1 || found[0].ShortCode != "stored" {
+		t.Errorf("ListByOriginalURL of the old destination = %v, %v, want only stored", found, err)
+	}
+	if found, err := store.ListByOriginalURL("https://example.org"); err != nil || len(found) != 1 || found[0].ShortCode != "limited" || found[0].ClickCount != 3 {
+		t.Errorf("ListByOriginalURL of the new destination = %v, %v, want limited with its clicks", found, err)
+	}
+	if err := store.Delete("limited"); err != nil {
+		t.Fatalf("Delete limited: %v", err)
+	}
+	if found, err := store.ListByOriginalURL("https://example.org"); err != nil || len(found) != 0 {
+		t.Errorf("ListByOriginalURL after Delete = %v, %v, want nothing", found, err)
+	}
+
+	if err := store.Delete("stored"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if exists, err := store.Exists("stored"); err != nil || exists {
+		t.Errorf("Exists after Delete = %t, %v, want false", exists, err)
+	}
+	if err := store.Insert(taken); err != nil {
+		t.Fatalf("Insert of a freed shortcode: %v", err)
+	}
+	if got, err := store.Get("stored"); err != nil || got.OriginalURL != taken.OriginalURL || got.ClickCount != 0 {
+		t.Errorf("Get after Insert = %+v, %v, want the new entry without the old clicks", got, err)
+	}
+	if err := store.Delete("stored"); err != nil {
+		t.Fatalf("Delete inserted: %v", err)
+	}
+
+	if sequence, ok := store.(SequenceStore); ok {
+		for want := uint64(1); want <= 3; want++ {
+			if got, err := sequence.NextSequence("contract"); err != nil || got != want {
+				t.Errorf("NextSequence = %d, %v, want %d", got, err, want)
+			}
+		}
+		if got, err := sequence.NextSequence("other"); err != nil || got != 1 {
+			t.Errorf("NextSequence(other) = %d, %v, want its own counter starting at 1", got, err)
+		}
+		if count, err := store.Count(); err != nil || count != 0 {
+			t.Errorf("Count with counters = %d, %v, want counters not counted as entries", count, err)
+		}
+	}
+}
+
+func TestMemoryStoreContract(t *testing.T) {
+	testStoreContract(t, NewMemoryStore())
+}
+
+func TestSQLiteStoreContract(t *testing.T) {
+	store, err := NewSQLiteStore(filepath.Join(t.TempDir(), "trimurl.db"))
+	if err != nil {
+		t.Fatalf("NewSQLiteStore: %v", err)
+	}
+	defer store.Close()
+
+	testStoreContract(t, store)
+}
+
+// TestPostgresStoreContract runs against the database in
+// TRIMURL_TEST_POSTGRES_DSN and is skipped without one. The tables are emptied first.
+func TestPostgresStoreContract(t *testing.T) {
+	dsn := os.Getenv("TRIMURL_TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("TRIMURL_TEST_POSTGRES_DSN is not set")
+	}
+	store, err := NewPostgresStore(PostgresConfig{DSN: dsn, MaxConns: 2})
+	if err != nil {
+		t.Fatalf("NewPostgresStore: %v", err)
+	}
+	defer store.Close()
+	for _, table := range []string{"clicks", "short_urls", "sequences"} {
+		if _, err := store.db.Exec(`DELETE FROM ` + table); err != nil {
+			t.Fatalf("clear %s: %v", table, err)
+		}
+	}
+
+	testStoreContract(t, store)
+}
+
+func TestPostgresStoreRequiresDSN(t *testing.T) {
+	if _, err := NewPostgresStore(PostgresConfig{}); err == nil {
+		t.Error("NewPostgresStore accepted an empty DSN")
+	}
+}
+
+func newTestRedisStore(t *testing.T, retention time.Duration) (*RedisStore, *miniredis.Miniredis) {
+	t.Helper()
+
+	server := miniredis.RunT(t)
+	store, err := NewRedisStore(RedisConfig{URL: "redis://" + server.Addr(), Retention: retention})
+	if err != nil {
+		t.Fatalf("NewRedisStore: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store, server
+}
+
+func TestRedisStoreContract(t *testing.T) {
+	store, _ := newTestRedisStore(t, 0)
+	testStoreContract(t, store)
+}
+
+func TestRedisStoreExpiresEntries(t *testing.T) {
+	store, server := newTestRedisStore(t, 0)
+	s, err := NewURLServiceWithConfig(newTestLogger(t), store, URLServiceConfig{})
+	if err != nil {
+		t.Fatalf("NewURLServiceWithConfig: %v", err)
+	}
+	ctx := context.Background()
+	if _, err := s.CreateShortURL(ctx, CreateShortURLRequest{URL: "example.com", ShortCode: "fleeting", Validity: 10}); err != nil {
+		t.Fatalf("CreateShortURL: %v", err)
+	}
+
+	ttl := server.TTL(store.key("fleeting"))
+	if ttl <= 9*time.Minute || ttl > 10*time.Minute {
+		t.Errorf("TTL = %s, want about the 10 minute validity", ttl)
+	}
+
+	server.FastForward(11 * time.Minute)
+	if _, err := s.GetOriginalURL(ctx, "fleeting"); !errors.Is(err, ErrShortCodeNotFound) {
+		t.Errorf("GetOriginalURL after TTL = %v, want ErrShortCodeNotFound", err)
+	}
+	if found, err := s.FindByOriginalURL(ctx, "example.com"); err != nil || len(found) != 0 {
+		t.Errorf("FindByOriginalURL after TTL = %v, %v, want no links and no error", found, err)
+	}
+}
+
+func TestRedisStoreRetention(t *testing.T) {
+	store, server := newTestRedisStore(t, time.Hour)
+
+	expired := &ShortURL{ShortCode: "lapsed", OriginalURL: "https://example.com", ExpiresAt: time.Now().Add(-time.Minute)}
+	if err := store.Put(expired); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if ttl := server.TTL(store.key("lapsed")); ttl <= 58*time.Minute || ttl > time.Hour {
+		t.Errorf("TTL with retention = %s, want about 59 minutes", ttl)
+	}
+
+	permanent := &ShortURL{ShortCode: "forever", OriginalURL: "https://example.com", ExpiresAt: neverExpires}
+	if err := store.Put(permanent); err != nil {
+		t.Fatalf("Put permanent: %v", err)
+	}
+	if ttl := server.TTL(store.key("forever")); ttl != 0 {
+		t.Errorf("TTL of a permanent link = %s, want none", ttl)
+	}
+
+	expired.ExpiresAt = time.Now().Add(-2 * time.Hour)
+	if err := store.Put(expired); err != nil {
+		t.Fatalf("Put past retention: %v", err)
+	}
+	if exists, _ := store.Exists("lapsed"); exists {
+		t.Error("entry past its retention is still stored")
+	}
+}
+
+// legacyEntry is an entry as stores wrote it before clicks were kept apart
+// from the entry JSON
+func legacyEntry(t *testing.T, now time.Time) []byte {
+	t.Helper()
+	entry, err := json.Marshal(&ShortURL{
+		ShortCode:      "legacy",
+		OriginalURL:    "https://example.com",
+		CreatedAt:      now,
+		ExpiresAt:      now.Add(time.Hour),
+		ClickCount:     2,
+		ClickHistory:   []Click{{Timestamp: now, Source: "first"}, {Timestamp: now.Add(time.Second), Source: "second"}},
+		LastAccessedAt: now.Add(time.Second),
+		MaxClicks:      3,
+	})
+	if err != nil {
+		t.Fatalf("encode legacy entry: %v", err)
+	}
+	return entry
+}
+
+// checkLegacyClicks checks that a store reads legacy's clicks and counts new
+// ones against its budget
+func checkLegacyClicks(t *testing.T, store Store, now time.Time) {
+	t.Helper()
+	got, err := store.Get("legacy")
+	if err != nil {
+		t.Fatalf("Get legacy: %v", err)
+	}
+	if got.ClickCount != 2 || len(got.ClickHistory) != 2 || got.ClickHistory[1].Source != "second" || !got.LastAccessedAt.Equal(now.Add(time.Second)) {
+		t.Errorf("legacy entry = %+v, want its two clicks", got)
+	}
+	clicks := []Click{{Timestamp: now.Add(time.Minute), Source: "third"}, {Timestamp: now.Add(2 * time.Minute), Source: "fourth"}}
+	if recorded, total, err := store.RecordClicks("legacy", clicks, 0); err != nil || recorded != 1 || total != 3 {
+		t.Errorf("RecordClicks on a legacy entry = %d, %d, %v, want 1 recorded of 3", recorded, total, err)
+	}
+	if got, err := store.Get("legacy"); err != nil || got.ClickCount != 3 || len(got.ClickHistory) != 3 || got.ClickHistory[2].Source != "third" {
+		t.Errorf("legacy entry after a click = %+v, %v, want three clicks", got, err)
+	}
+}
+
+func TestSQLiteStoreMovesLegacyClicks(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "trimurl.db")
+	now := time.Now().UTC().Truncate(time.Second)
+
+	db, err := sql.Open("sqlite", "file:"+path)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	for _, statement := range []string{
+		`CREATE TABLE short_urls (shortcode TEXT PRIMARY KEY, original_url TEXT NOT NULL, created_at TEXT NOT NULL, expires_at TEXT NOT NULL, entry TEXT NOT NULL)`,
+		`INSERT INTO short_urls VALUES ('legacy', 'https://example.com', '', '', '` + string(legacyEntry(t, now)) + `')`,
+	} {
+		if _, err := db.Exec(statement); err != nil {
+			t.Fatalf("create legacy database: %v", err)
+		}
+	}
+	db.Close()
+
+	store, err := NewSQLiteStore(path)
+	if err != nil {
+		t.Fatalf("NewSQLiteStore: %v", err)
+	}
+	checkLegacyClicks(t, store, now)
+	store.Close()
+
+	// Opening again must not move the clicks a second time
+	reopened, err := NewSQLiteStore(path)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer reopened.Close()
+	if got, err := reopened.Get("legacy"); err != nil || got.ClickCount != 3 || len(got.ClickHistory) != 3 {
+		t.Errorf("legacy entry after reopening = %+v, %v, want its three clicks once", got, err)
+	}
+}
+
+func TestRedisStoreReadsLegacyEntries(t *testing.T) {
+	store, server := newTestRedisStore(t, 0)
+	now := time.Now().UTC().Truncate(time.Second)
+	if err := server.Set(store.key("legacy"), string(legacyEntry(t, now))); err != nil {
+		t.Fatalf("write legacy entry: %v", err)
+	}
+	checkLegacyClicks(t, store, now)
+}
+
+// testSharedStore runs two services against stores sharing one database, as
+// two instances would
+func testSharedStore(t *testing.T, first, second Store) {
+	t.Helper()
+	ctx := context.Background()
+
+	var services [2]*URLService
+	for i, store := range []Store{first, second} {
+		s, err := NewURLServiceWithConfig(newTestLogger(t), store, URLServiceConfig{})
+		if err != nil {
+			t.Fatalf("NewURLServiceWithConfig: %v", err)
+		}
+		services[i] = s
+	}
+
+	const attempts = 10
+	var wg sync.WaitGroup
+	errs := make([]error, attempts)
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = services[i%2].CreateShortURL(ctx, CreateShortURLRequest{URL: fmt.Sprintf("example.com/%d", i), ShortCode: "contested"})
+		}(i)
+	}
+	wg.Wait()
+	created := 0
+	for _, err := range errs {
+		switch {
+		case err == nil:
+			created++
+		case !errors.Is(err, ErrShortCodeExists):
+			t.Errorf("CreateShortURL = %v, want ErrShortCodeExists for the losers", err)
+		}
+	}
+	if created != 1 {
+		t.Errorf("%d creates of one shortcode succeeded, want 1", created)
+	}
+
+	if _, err := services[0].CreateShortURL(ctx, CreateShortURLRequest{URL: "example.com/budget", ShortCode: "budget", MaxClicks: 5}); err != nil {
+		t.Fatalf("CreateShortURL budget: %v", err)
+	}
+	counted := make([]bool, 4*attempts)
+	for i := range counted {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			err := services[i%2].RecordClick(ctx, "budget", Click{Source: "direct"})
+			if err != nil && !errors.Is(err, ErrClickLimitReached) {
+				t.Errorf("RecordClick = %v, want nil or ErrClickLimitReached", err)
+			}
+			counted[i] = err == nil
+		}(i)
+	}
+	wg.Wait()
+	accepted := 0
+	for _, ok := range counted {
+		if ok {
+			accepted++
+		}
+	}
+	if stats, err := services[1].GetStats(ctx, "budget"); err != nil || accepted != 5 || stats.TotalClicks != 5 {
+		t.Errorf("%d clicks accepted, stats %+v, %v, want exactly the budget of 5", accepted, stats, err)
+	}
+
+	// Each instance deduplicates against and finds the other's links
+	original, err := services[0].CreateShortURL(ctx, CreateShortURLRequest{URL: "example.com/shared", Validity: 60, Deduplicate: true})
+	if err != nil {
+		t.Fatalf("CreateShortURL shared: %v", err)
+	}
+	if again, err := services[1].CreateShortURL(ctx, CreateShortURLRequest{URL: "example.com/shared", Validity: 30, Deduplicate: true}); err != nil || again.ShortCode != original.ShortCode {
+		t.Errorf("dedupe on the other instance = %+v, %v, want %s reused", again, err, original.ShortCode)
+	}
+	if found, err := services[1].FindByOriginalURL(ctx, "example.com/shared"); err != nil || len(found) != 1 || found[0].ShortCode != original.ShortCode {
+		t.Errorf("FindByOriginalURL on the other instance = %v, %v, want %s", found, err, original.ShortCode)
+	}
+}
+
+func TestSQLiteStoreSharedByInstances(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "trimurl.db")
+	first, err := NewSQLiteStore(path)
+	if err != nil {
+		t.Fatalf("NewSQLiteStore: %v", err)
+	}
+	defer first.Close()
+	second, err := NewSQLiteStore(path)
+	if err != nil {
+		t.Fatalf("NewSQLiteStore (second): %v", err)
+	}
+	defer second.Close()
+
+	testSharedStore(t, first, second)
+}
+
+func TestRedisStoreSharedByInstances(t *testing.T) {
+	first, server := newTestRedisStore(t, 0)
+	second, err := NewRedisStore(RedisConfig{URL: "redis://" + server.Addr()})
+	if err != nil {
+		t.Fatalf("NewRedisStore (second): %v", err)
+	}
+	defer second.Close()
+
+	testSharedStore(t, first, second)
+}
+
+func TestSQLiteStoreSurvivesRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "trimurl.db")
+	ctx := context.Background()
+
+	store, err := NewSQLiteStore(path)
+	if err != nil {
+		t.Fatalf("NewSQLiteStore: %v", err)
+	}
+	s, err := NewURLServiceWithConfig(newTestLogger(t), store, URLServiceConfig{})
+	if err != nil {
+		t.Fatalf("NewURLServiceWithConfig: %v", err)
+	}
+	if _, err := s.CreateShortURL(ctx, CreateShortURLRequest{URL: "example.com", ShortCode: "durable"}); err != nil {
+		t.Fatalf("CreateShortURL: %v", err)
+	}
+	if err := s.RecordClick(ctx, "durable", Click{Source: "direct"}); err != nil {
+		t.Fatalf("RecordClick: %v", err)
+	}
+	store.Close()
+
+	reopened, err := NewSQLiteStore(path)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer reopened.Close()
+	s, err = NewURLServiceWithConfig(newTestLogger(t), reopened, URLServiceConfig{})
+	if err != nil {
+		t.Fatalf("NewURLServiceWithConfig after reopen: %v", err)
+	}
+
+	stats, err := s.GetStats(ctx, "durable")
+	if err != nil {
+		t.Fatalf("GetStats after reopen: %v", err)
+	}
+	if stats.TotalClicks != 1 || len(stats.Clicks) != 1 {
+		t.Errorf("stats after reopen = %+v, want the recorded click", stats)
+	}
+	if found, err := s.FindByOriginalURL(ctx, "example.com"); err != nil || len(found) != 1 {
+		t.Errorf("FindByOriginalURL after reopen = %v, %v, want the link indexed from the store", found, err)
+	}
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TimeBucket is the width of the buckets a click time series is counted in
+type TimeBucket string
+
+const (
+	BucketHour TimeBucket = "hour"
+	BucketDay  TimeBucket = "day"
+)
+
+const (
+	// MaxTimeSeriesBuckets bounds the buckets in one time series, about six weeks of hours
+	MaxTimeSeriesBuckets = 1000
+	// DefaultTopValues is how many referrers and countries a time series ranks by default
+	DefaultTopValues = 5
+	// MaxTopValues is the most referrers and countries a request may ask for
+	MaxTopValues = 50
+)
+
+// TimeSeriesQuery selects the clicks GetTimeSeries counts
+type TimeSeriesQuery struct {
+	Bucket TimeBucket // empty means BucketDay
+	From   time.Time  // zero means 24 buckets of hours or 30 of days before To
+	To     time.Time  // exclusive; zero means now
+	Top    int        // referrers and countries ranked; zero means DefaultTopValues
+}
+
+// duration returns the width of a bucket
+func (b TimeBucket) duration() time.Duration {
+	if b == BucketHour {
+		return time.Hour
+	}
+	return 24 * time.Hour
+}
+
+// GetTimeSeries counts a link's clicks per hour or day over the query's
+// range, in UTC, and ranks where those clicks came from. Only the counts are
+// returned, so the result stays small however long the click history is.
+func (s *URLService) GetTimeSeries(ctx context.Context, shortCode string, query TimeSeriesQuery) (*ClickTimeSeries, error) {
+	shortCode = s.normalizeCode(shortCode)
+	ctx, span := tracer.Start(ctx, "URLService.GetTimeSeries", trace.WithAttributes(attribute.String("trimurl.shortcode", shortCode)))
+	defer span.End()
+	s.logger.LogContext(ctx, BackendStack, InfoLevel, ServicePackage, fmt.Sprintf("Retrieving click time series for: %s", shortCode))
+
+	if err := s.resolveTimeSeriesQuery(&query); err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if !s.validSignature(shortCode) {
+		return nil, ErrShortCodeNotFound
+	}
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	clickLock := s.clickLock(shortCode)
+	clickLock.Lock()
+	defer clickLock.Unlock()
+
+	storeSpan := startStoreSpan(ctx, "Get", shortCode)
+	shortURL, err := s.liveEntry(shortCode)
+	endStoreSpan(storeSpan, err)
+	if err != nil {
+		s.logger.LogContext(ctx, BackendStack, ErrorLevel, DomainPackage, fmt.Sprintf("Time series lookup failed for %s: %v", shortCode, err))
+		return nil, err
+	}
+	if !canAccess(ctx, shortURL) {
+		s.logger.LogContext(ctx, BackendStack, WarnLevel, DomainPackage, fmt.Sprintf("Time series for %s refused: not the owner", shortCode))
+		return nil, ErrShortCodeNotFound
+	}
+
+	width := query.Bucket.duration()
+	first := query.From.Truncate(width)
+	series := make([]TimeSeriesPoint, 0, int(query.To.Sub(first)/width)+1)
+	for start := first; start.Before(query.To); start = start.Add(width) {
+		series = append(series, TimeSeriesPoint{Start: start})
+	}
+
+	total := 0
+	referrers := make(map[string]int)
+	countries := make(map[string]int)
+	for _, click := range shortURL.ClickHistory {
+		if click.Timestamp.Before(query.From) || !click.Timestamp.Before(query.To) {
+			continue
+		}
+		total++
+		series[int(click.Timestamp.Sub(first)/width)].Clicks++
+		referrers[click.Source]++
+		country := click.Country
+		if country == "" {
+			country = unknownLocation
+		}
+		countries[country]++
+	}
+
+	return &ClickTimeSeries{
+		Bucket:       query.Bucket,
+		From:         query.From,
+		To:           query.To,
+		TotalClicks:  total,
+		Series:       series,
+		TopReferrers: topValues(referrers, query.Top),
+		TopCountries: topValues(countries, query.Top),
+	}, nil
+}
+
+// resolveTimeSeriesQuery fills in the query's defaults, in UTC, and checks
+// the range is ordered and not too many buckets long
+func (s *URLService) resolveTimeSeriesQuery(query *TimeSeriesQuery) error {
+	validationErr := &ValidationError{}
+	switch query.Bucket {
+	case "":
+		query.Bucket = BucketDay
+	case BucketHour, BucketDay:
+	default:
+		validationErr.Add("bucket", fmt.Sprintf("bucket must be %q or %q", BucketHour, BucketDay))
+	}
+	if query.To.IsZero() {
+		query.To = s.clock.Now()
+	}
+	query.To = query.To.UTC()
+	if query.From.IsZero() {
+		defaultBuckets := 30
+		if query.Bucket == BucketHour {
+			defaultBuckets = 24
+		}
+		query.From = query.To.Add(-time.Duration(defaultBuckets) * query.Bucket.duration())
+	}
+	query.From = query.From.UTC()
+	width := query.Bucket.duration()
+	if !query.From.Before(query.To) {
+		validationErr.Add("from", "from must be before to")
+	} else if buckets := (query.To.Sub(query.From.Truncate(width)) + width - 1) / width; buckets > MaxTimeSeriesBuckets {
+		validationErr.Add("to", fmt.Sprintf("the range spans more than %d %s buckets", MaxTimeSeriesBuckets, query.Bucket))
+	}
+	switch {
+	case query.Top == 0:
+		query.Top = DefaultTopValues
+	case query.Top < 0 || query.Top > MaxTopValues:
+		validationErr.Add("top", fmt.Sprintf("top must be between 1 and %d", MaxTopValues))
+	}
+	if len(validationErr.Errors) > 0 {
+		return validationErr
+	}
+	return nil
+}
+
+// topValues returns the n values with the most clicks, most first, breaking
+// ties alphabetically so the order is stable
+func topValues(counts map[string]int, n int) []RankedValue {
+	ranked := make([]RankedValue, 0, len(counts))
+	for value, clicks := range counts {
+		ranked = append(ranked, RankedValue{Value: value, Clicks: clicks})
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		if ranked[i].Clicks != ranked[j].Clicks {
+			return ranked[i].Clicks > ranked[j].Clicks
+		}
+		return ranked[i].Value < ranked[j].Value
+	})
+	if len(ranked) > n {
+		ranked = ranked[:n]
+	}
+	return ranked
+}
+
+// GetTimeSeries handles GET /shorturls/:shortcode/stats/timeseries with
+// optional bucket (hour or day), from and to (RFC3339) and top parameters
+func (h *URLHandler) GetTimeSeries(w http.ResponseWriter, r *http.Request) {
+	shortCode := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/shorturls/"), "/stats/timeseries")
+	h.logger.LogContext(r.Context(), BackendStack, InfoLevel, HandlerPackage, fmt.Sprintf("GET /shorturls/%s/stats/timeseries - Getting click time series", shortCode))
+
+	query, err := parseTimeSeriesQuery(r)
+	if err != nil {
+		h.logger.LogContext(r.Context(), BackendStack, ErrorLevel, HandlerPackage, fmt.Sprintf("Invalid time series query for %s: %v", shortCode, err))
+		h.sendErrorResponse(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := h.requestContext(r)
+	defer cancel()
+
+	series, err := h.urlService.GetTimeSeries(ctx, shortCode, query)
+	if err != nil {
+		h.logger.LogContext(r.Context(), BackendStack, ErrorLevel, HandlerPackage, fmt.Sprintf("Failed to get time series for %s: %v", shortCode, err))
+		var validationErr *ValidationError
+		switch {
+		case errors.As(err, &validationErr):
+			h.sendErrorDetails(w, r, err.Error(), http.StatusBadRequest, validationErr.Errors)
+		case isContextError(err):
+			h.sendErrorResponse(w, r, "Request timed out", http.StatusServiceUnavailable)
+		case errors.Is(err, ErrShortCodeNotFound):
+			h.sendErrorResponse(w, r, err.Error(), http.StatusNotFound)
+		default:
+			h.sendErrorResponse(w, r, "Failed to get statistics", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	h.logger.LogContext(r.Context(), BackendStack, InfoLevel, HandlerPackage, fmt.Sprintf("Time series retrieved for %s: %d clicks in %d buckets", shortCode, series.TotalClicks, len(series.Series)))
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(series)
+}
+
+// parseTimeSeriesQuery reads the bucket, from, to and top query parameters;
+// the service checks their values
+func parseTimeSeriesQuery(r *http.Request) (TimeSeriesQuery, error) {
+	values := r.URL.Query()
+	query := TimeSeriesQuery{Bucket: TimeBucket(values.Get("bucket"))}
+	for name, target := range map[string]*time.Time{"from": &query.From, "to": &query.To} {
+		if value := values.Get(name); value != "" {
+			parsed, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return query, fmt.Errorf("%s must be an RFC3339 timestamp", name)
+			}
+			*target = parsed
+		}
+	}
+	if top := values.Get("top"); top != "" {
+		parsed, err := strconv.Atoi(top)
+		if err != nil || parsed <= 0 {
+			return query, fmt.Errorf("top must be a positive integer")
+		}
+		query.Top = parsed
+	}
+	return query, nil
+}
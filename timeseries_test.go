@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestClickTimeSeries(t *testing.T) {
+	clock := NewFakeClock(time.Date(2024, 1, 20, 10, 30, 0, 0, time.UTC))
+	s := newTestService(t, URLServiceConfig{Clock: clock})
+	h := NewURLHandler(s, s.logger)
+	router := NewRouter(h, newTestLogger(t))
+	ctx := context.Background()
+	if _, err := s.CreateShortURL(ctx, CreateShortURLRequest{URL: "example.com", ShortCode: "series", Validity: 7 * 24 * 60}); err != nil {
+		t.Fatalf("CreateShortURL: %v", err)
+	}
+	for _, step := range []struct {
+		advance time.Duration
+		click   Click
+	}{
+		{0, Click{Source: "https://google.com", Country: "GB"}},
+		{15 * time.Minute, Click{Source: "direct"}},
+		{85 * time.Minute, Click{Source: "https://google.com", Country: "DE"}},
+	} {
+		clock.Advance(step.advance)
+		if err := s.RecordClick(ctx, "series", step.click); err != nil {
+			t.Fatalf("RecordClick: %v", err)
+		}
+	}
+
+	get := func(query string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/shorturls/series/stats/timeseries"+query, nil))
+		return rec
+	}
+
+	rec := get("?bucket=hour&from=2024-01-20T10:00:00Z&to=2024-01-20T13:00:00Z&top=2")
+	var series ClickTimeSeries
+	if rec.Code != http.StatusOK || json.Unmarshal(rec.Body.Bytes(), &series) != nil {
+		t.Fatalf("time series = %d: %s", rec.Code, rec.Body.String())
+	}
+	var counts []int
+	for _, point := range series.Series {
+		counts = append(counts, point.Clicks)
+	}
+	if len(counts) != 3 || counts[0] != 2 || counts[1] != 0 || counts[2] != 1 || series.TotalClicks != 3 {
+		t.Errorf("hourly counts = %v, total %d; want [2 0 1], total 3", counts, series.TotalClicks)
+	}
+	if !series.Series[1].Start.Equal(time.Date(2024, 1, 20, 11, 0, 0, 0, time.UTC)) {
+		t.Errorf("second bucket starts at %s, want 11:00", series.Series[1].Start)
+	}
+	if len(series.TopReferrers) != 2 || series.TopReferrers[0] != (RankedValue{"https://google.com", 2}) {
+		t.Errorf("top referrers = %+v, want google first with 2", series.TopReferrers)
+	}
+	if len(series.TopCountries) != 2 || series.TopCountries[0].Value != "DE" || series.TopCountries[1].Value != "GB" {
+		t.Errorf("top countries = %+v, want the two-way tie DE, GB", series.TopCountries)
+	}
+
+	// By default the last 30 days are counted per day, ending now
+	clock.Advance(time.Minute)
+	rec = get("")
+	json.Unmarshal(rec.Body.Bytes(), &series)
+	if rec.Code != http.StatusOK || series.Bucket != BucketDay || !series.To.Equal(clock.Now()) || len(series.Series) != 31 || series.Series[30].Clicks != 3 {
+		t.Errorf("default time series = %d, %s to %s in %d buckets", rec.Code, series.From, series.To, len(series.Series))
+	}
+
+	for _, query := range []string{"?bucket=week", "?from=2024-01-21T00:00:00Z&to=2024-01-20T00:00:00Z", "?bucket=hour&from=2023-01-01T00:00:00Z", "?top=0", "?to=yesterday"} {
+		if rec := get(query); rec.Code != http.StatusBadRequest {
+			t.Errorf("time series%s = %d, want 400", query, rec.Code)
+		}
+	}
+	if rec := get("?bucket=hour"); rec.Code != http.StatusOK {
+		t.Errorf("hourly time series = %d, want 200", rec.Code)
+	}
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/shorturls/missing/stats/timeseries", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("time series of an unknown code = %d, want 404", rec.Code)
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"logging-middleware/version"
+)
+
+// tracer starts the service's spans. It follows the global provider, so
+// spans are no-ops until StartTracing installs an exporting one.
+var tracer = otel.Tracer("logging-middleware")
+
+// tracingFlushTimeout bounds how long shutdown waits for queued spans to be exported
+const tracingFlushTimeout = 5 * time.Second
+
+// TracingConfig configures OpenTelemetry tracing
+type TracingConfig struct {
+	Endpoint    string  // OTLP/HTTP base URL such as http://localhost:4318; empty disables tracing
+	ServiceName string  // service.name of exported spans
+	SampleRatio float64 // fraction of new traces sampled; sampled parents are always followed
+}
+
+// DefaultTracingConfig returns the default tracing configuration, with tracing disabled
+func DefaultTracingConfig() TracingConfig {
+	return TracingConfig{ServiceName: "trimurl", SampleRatio: 1}
+}
+
+// StartTracing installs a global tracer provider exporting spans over
+// OTLP/HTTP to config.Endpoint, and W3C trace context propagation. The
+// returned function flushes spans still queued and stops the exporter.
+func StartTracing(ctx context.Context, config TracingConfig) (shutdown func(context.Context) error, err error) {
+	endpoint, err := url.Parse(config.Endpoint)
+	if err != nil || endpoint.Host == "" {
+		return nil, fmt.Errorf("tracing endpoint must be a URL such as http://localhost:4318, got %q", config.Endpoint)
+	}
+	// As with OTEL_EXPORTER_OTLP_ENDPOINT, the endpoint is a base URL that the traces path is added to
+	endpoint.Path = strings.TrimSuffix(endpoint.Path, "/") + "/v1/traces"
+
+	exporter, err := otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(endpoint.String()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create trace exporter: %v", err)
+	}
+	serviceResource, err := resource.Merge(resource.Default(), resource.NewWithAttributes(semconv.SchemaURL,
+		semconv.ServiceName(config.ServiceName),
+		semconv.ServiceVersion(version.Version),
+	))
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe service for tracing: %v", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(serviceResource),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(config.SampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	return provider.Shutdown, nil
+}
+
+// startStoreSpan starts a span for one store call made on behalf of ctx
+func startStoreSpan(ctx context.Context, operation, shortCode string) trace.Span {
+	_, span := tracer.Start(ctx, "store."+operation, trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("trimurl.shortcode", shortCode)))
+	return span
+}
+
+// endStoreSpan ends a span from startStoreSpan, recording err unless it is
+// nil or ErrShortCodeNotFound, which is an answer rather than a failure
+func endStoreSpan(span trace.Span, err error) {
+	if err != nil && !errors.Is(err, ErrShortCodeNotFound) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// recordSpans installs a tracer provider that keeps ended spans in memory
+// until the test finishes
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+	recorder := tracetest.NewSpanRecorder()
+	previousProvider, previousPropagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() {
+		otel.SetTracerProvider(previousProvider)
+		otel.SetTextMapPropagator(previousPropagator)
+	})
+	return recorder
+}
+
+func TestRequestsAreTracedIntoTheStore(t *testing.T) {
+	recorder := recordSpans(t)
+	h := newTestHandler(t)
+	router := NewRouter(h, newTestLogger(t))
+	if _, err := h.urlService.CreateShortURL(context.Background(), CreateShortURLRequest{URL: "example.com", ShortCode: "traced"}); err != nil {
+		t.Fatalf("CreateShortURL: %v", err)
+	}
+
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	req := httptest.NewRequest(http.MethodGet, "/traced", nil)
+	req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusFound {
+		t.Fatalf("redirect = %d, want 302", rec.Code)
+	}
+
+	// Spans by name and by parent, for the spans in the caller's trace
+	spans := make(map[string]sdktrace.ReadOnlySpan)
+	children := make(map[string][]string)
+	for _, span := range recorder.Ended() {
+		if span.SpanContext().TraceID().String() == traceID {
+			spans[span.Name()] = span
+			children[span.Parent().SpanID().String()] = append(children[span.Parent().SpanID().String()], span.Name())
+		}
+	}
+	server := spans["GET /"]
+	if server == nil {
+		t.Fatalf("spans in the caller's trace = %v, want the request span", spans)
+	}
+	if got := children[server.SpanContext().SpanID().String()]; len(got) != 2 {
+		t.Errorf("request span children = %v, want resolve and click recording", got)
+	}
+	for _, name := range []string{"URLService.ResolveShortURL", "URLService.RecordClick"} {
+		span := spans[name]
+		if span == nil || span.Parent().SpanID() != server.SpanContext().SpanID() {
+			t.Errorf("%s is not a child of the request span", name)
+			continue
+		}
+		if got := children[span.SpanContext().SpanID().String()]; len(got) == 0 || got[0] != "store.Get" {
+			t.Errorf("%s children = %v, want a store.Get span", name, got)
+		}
+	}
+}
+
+func TestStartTracingRejectsBadEndpoint(t *testing.T) {
+	for _, endpoint := range []string{"localhost:4318", "://"} {
+		if _, err := StartTracing(context.Background(), TracingConfig{Endpoint: endpoint, ServiceName: "trimurl", SampleRatio: 1}); err == nil {
+			t.Errorf("StartTracing accepted endpoint %q", endpoint)
+		}
+	}
+}
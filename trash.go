@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// defaultTrashRetention is how long deleted links can be restored when the config does not say
+const defaultTrashRetention = 30 * 24 * time.Hour
+
+// ErrNotInTrash is returned when restoring a link that has not been deleted
+var ErrNotInTrash = errors.New("shortcode is not in the trash")
+
+// liveEntry returns the stored entry for a shortcode, treating a link in
+// the trash as not found. Everything but the trash routes reads through it.
+func (s *URLService) liveEntry(shortCode string) (*ShortURL, error) {
+	shortURL, err := s.store.Get(shortCode)
+	if err != nil {
+		return nil, err
+	}
+	if !shortURL.DeletedAt.IsZero() {
+		return nil, ErrShortCodeNotFound
+	}
+	return shortURL, nil
+}
+
+// restorableUntil returns when a link in the trash stops being restorable
+func (s *URLService) restorableUntil(shortURL *ShortURL) time.Time {
+	return shortURL.DeletedAt.Add(s.settings.Load().trashRetention)
+}
+
+// RestoreShortURL takes a link out of the trash with its stats as they were
+// when it was deleted. Links deleted longer than the trash retention ago, and
+// links the caller in ctx may not access, return ErrShortCodeNotFound; links
+// that are not in the trash return ErrNotInTrash.
+func (s *URLService) RestoreShortURL(ctx context.Context, shortCode string) error {
+	shortCode = s.normalizeCode(shortCode)
+	ctx, span := tracer.Start(ctx, "URLService.RestoreShortURL", trace.WithAttributes(attribute.String("trimurl.shortcode", shortCode)))
+	defer span.End()
+	s.logger.LogContext(ctx, BackendStack, InfoLevel, ServicePackage, fmt.Sprintf("Restoring %s", shortCode))
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if !s.validSignature(shortCode) {
+		return ErrShortCodeNotFound
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	storeSpan := startStoreSpan(ctx, "Get", shortCode)
+	shortURL, err := s.store.Get(shortCode)
+	endStoreSpan(storeSpan, err)
+	if err != nil {
+		s.logger.LogContext(ctx, BackendStack, ErrorLevel, DomainPackage, fmt.Sprintf("Restore lookup failed for %s: %v", shortCode, err))
+		return err
+	}
+	if !canAccess(ctx, shortURL) {
+		s.logger.LogContext(ctx, BackendStack, WarnLevel, DomainPackage, fmt.Sprintf("Restore of %s refused: not the owner", shortCode))
+		return ErrShortCodeNotFound
+	}
+	if shortURL.DeletedAt.IsZero() {
+		return ErrNotInTrash
+	}
+	if !s.clock.Now().Before(s.restorableUntil(shortURL)) {
+		s.logger.LogContext(ctx, BackendStack, WarnLevel, DomainPackage, fmt.Sprintf("Restore of %s refused: deleted %s, past the trash retention", shortCode, shortURL.DeletedAt.Format(time.RFC3339)))
+		return ErrShortCodeNotFound
+	}
+
+	restored := *shortURL
+	restored.DeletedAt = time.Time{}
+	storeSpan = startStoreSpan(ctx, "Put", shortCode)
+	err = s.store.Put(&restored)
+	endStoreSpan(storeSpan, err)
+	if err != nil {
+		s.logger.LogContext(ctx, BackendStack, ErrorLevel, RepositoryPackage, fmt.Sprintf("Failed to restore %s: %v", shortCode, err))
+		return fmt.Errorf("failed to restore short URL: %v", err)
+	}
+
+	s.logger.LogContext(ctx, BackendStack, InfoLevel, ServicePackage, fmt.Sprintf("Audit: %s restored from the trash (url %s)", shortCode, restored.OriginalURL))
+	return nil
+}
+
+// ListTrash handles GET /shorturls/trash, which lists deleted links that
+// can still be restored with the same paging as GET /shorturls
+func (h *URLHandler) ListTrash(w http.ResponseWriter, r *http.Request) {
+	h.logger.LogContext(r.Context(), BackendStack, InfoLevel, HandlerPackage, "GET /shorturls/trash - Listing deleted short URLs")
+
+	options, err := parseListOptions(r)
+	if err != nil {
+		h.logger.LogContext(r.Context(), BackendStack, ErrorLevel, HandlerPackage, fmt.Sprintf("Invalid list options: %v", err))
+		h.sendErrorResponse(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	options.Trashed = true
+	h.sendShortURLList(w, r, options)
+}
+
+// RestoreShortURL handles POST /shorturls/:shortcode/restore. Like deletion
+// it needs the admin token unless a signed-in user restores their own link.
+func (h *URLHandler) RestoreShortURL(w http.ResponseWriter, r *http.Request) {
+	shortCode := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/shorturls/"), "/restore")
+	h.logger.LogContext(r.Context(), BackendStack, InfoLevel, HandlerPackage, fmt.Sprintf("POST /shorturls/%s/restore - Restoring short URL", shortCode))
+
+	if PrincipalFromContext(r.Context()) == nil && !h.authorizeAdmin(w, r) {
+		return
+	}
+
+	ctx, cancel := h.requestContext(r)
+	defer cancel()
+
+	if err := h.urlService.RestoreShortURL(ctx, shortCode); err != nil {
+		h.logger.LogContext(r.Context(), BackendStack, ErrorLevel, HandlerPackage, fmt.Sprintf("Failed to restore %s: %v", shortCode, err))
+		switch {
+		case isContextError(err):
+			h.sendErrorResponse(w, r, "Request timed out", http.StatusServiceUnavailable)
+		case errors.Is(err, ErrShortCodeNotFound):
+			h.sendErrorResponse(w, r, err.Error(), http.StatusNotFound)
+		case errors.Is(err, ErrNotInTrash):
+			h.sendErrorResponse(w, r, err.Error(), http.StatusConflict)
+		default:
+			h.sendErrorResponse(w, r, "Failed to restore short URL", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestDeleteMovesLinkToTrash(t *testing.T) {
+	clock := NewFakeClock(time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC))
+	s := newTestService(t, URLServiceConfig{Clock: clock, TrashRetention: 48 * time.Hour})
+	ctx := context.Background()
+
+	if _, err := s.CreateShortURL(ctx, CreateShortURLRequest{URL: "example.com", ShortCode: "oops", Validity: 7 * 24 * 60}); err != nil {
+		t.Fatalf("CreateShortURL: %v", err)
+	}
+	if err := s.RecordClick(ctx, "oops", Click{Source: "direct"}); err != nil {
+		t.Fatalf("RecordClick: %v", err)
+	}
+	if err := s.RestoreShortURL(ctx, "oops"); !errors.Is(err, ErrNotInTrash) {
+		t.Errorf("RestoreShortURL of a live link = %v, want ErrNotInTrash", err)
+	}
+
+	if err := s.DeleteShortURL(ctx, "oops"); err != nil {
+		t.Fatalf("DeleteShortURL: %v", err)
+	}
+	if _, err := s.ResolveShortURL(ctx, "oops"); !errors.Is(err, ErrShortCodeNotFound) {
+		t.Errorf("ResolveShortURL of a trashed link = %v, want ErrShortCodeNotFound", err)
+	}
+	if _, err := s.GetStats(ctx, "oops"); !errors.Is(err, ErrShortCodeNotFound) {
+		t.Errorf("GetStats of a trashed link = %v, want ErrShortCodeNotFound", err)
+	}
+	if err := s.DeleteShortURL(ctx, "oops"); !errors.Is(err, ErrShortCodeNotFound) {
+		t.Errorf("second DeleteShortURL = %v, want ErrShortCodeNotFound", err)
+	}
+	if _, err := s.CreateShortURL(ctx, CreateShortURLRequest{URL: "example.org", ShortCode: "oops"}); err == nil {
+		t.Error("CreateShortURL reused the shortcode of a trashed link")
+	}
+
+	live, _, err := s.ListShortURLs(ctx, ListOptions{Limit: 10})
+	if err != nil || len(live) != 0 {
+		t.Errorf("ListShortURLs = %+v, %v, want no live links", live, err)
+	}
+	trash, total, err := s.ListShortURLs(ctx, ListOptions{Limit: 10, Trashed: true})
+	if err != nil || total != 1 || len(trash) != 1 {
+		t.Fatalf("ListShortURLs trashed = %+v, %d, %v, want the deleted link", trash, total, err)
+	}
+	if want := clock.Now().Add(48 * time.Hour); trash[0].RestorableUntil == nil || !trash[0].RestorableUntil.Equal(want) {
+		t.Errorf("restorableUntil = %v, want %v", trash[0].RestorableUntil, want)
+	}
+
+	clock.Advance(24 * time.Hour)
+	if err := s.RestoreShortURL(ctx, "oops"); err != nil {
+		t.Fatalf("RestoreShortURL: %v", err)
+	}
+	stats, err := s.GetStats(ctx, "oops")
+	if err != nil || stats.TotalClicks != 1 {
+		t.Errorf("GetStats after restore = %+v, %v, want the click kept", stats, err)
+	}
+}
+
+func TestTrashRetention(t *testing.T) {
+	clock := NewFakeClock(time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC))
+	s := newTestService(t, URLServiceConfig{Clock: clock, TrashRetention: time.Hour})
+	ctx := context.Background()
+
+	for _, code := range []string{"gone", "kept"} {
+		if _, err := s.CreateShortURL(ctx, CreateShortURLRequest{URL: "example.com/" + code, ShortCode: code}); err != nil {
+			t.Fatalf("CreateShortURL %s: %v", code, err)
+		}
+	}
+	if err := s.DeleteShortURL(ctx, "gone"); err != nil {
+		t.Fatalf("DeleteShortURL: %v", err)
+	}
+	clock.Advance(2 * time.Hour)
+
+	if err := s.RestoreShortURL(ctx, "gone"); !errors.Is(err, ErrShortCodeNotFound) {
+		t.Errorf("RestoreShortURL past the retention = %v, want ErrShortCodeNotFound", err)
+	}
+	if trash, _, _ := s.ListShortURLs(ctx, ListOptions{Limit: 10, Trashed: true}); len(trash) != 0 {
+		t.Errorf("trash lists %+v past the retention, want nothing", trash)
+	}
+	purged, err := s.PurgeExpired(ctx, 24*time.Hour)
+	if err != nil || purged != 1 {
+		t.Fatalf("PurgeExpired = %d, %v, want the trashed link purged", purged, err)
+	}
+	if _, err := s.store.Get("gone"); !errors.Is(err, ErrShortCodeNotFound) {
+		t.Errorf("store still holds the purged link: %v", err)
+	}
+	if _, err := s.store.Get("kept"); err != nil {
+		t.Errorf("PurgeExpired removed a live link: %v", err)
+	}
+
+	// Negative retention turns the trash off
+	if err := s.Reload(URLServiceConfig{TrashRetention: -1}); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	if err := s.DeleteShortURL(ctx, "kept"); err != nil {
+		t.Fatalf("DeleteShortURL: %v", err)
+	}
+	if _, err := s.store.Get("kept"); !errors.Is(err, ErrShortCodeNotFound) {
+		t.Errorf("DeleteShortURL without a trash left %v in the store", err)
+	}
+}
+
+func TestTrashRoutes(t *testing.T) {
+	h := newTestHandler(t)
+	h.AdminToken = "secret"
+	if _, err := h.urlService.CreateShortURL(context.Background(), CreateShortURLRequest{URL: "example.com", ShortCode: "binned"}); err != nil {
+		t.Fatalf("CreateShortURL: %v", err)
+	}
+
+	serve := func(method, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set(AdminTokenHeader, "secret")
+		rec := httptest.NewRecorder()
+		h.ShortURLResource(rec, req)
+		return rec
+	}
+
+	if rec := serve(http.MethodPost, "/shorturls/binned/restore"); rec.Code != http.StatusConflict {
+		t.Errorf("restore of a live link = %d, want 409", rec.Code)
+	}
+	if rec := serve(http.MethodDelete, "/shorturls/binned"); rec.Code != http.StatusNoContent {
+		t.Fatalf("delete = %d, want 204: %s", rec.Code, rec.Body.String())
+	}
+
+	rec := serve(http.MethodGet, "/shorturls/trash")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET /shorturls/trash = %d, want 200: %s", rec.Code, rec.Body.String())
+	}
+	var list ShortURLList
+	if err := json.Unmarshal(rec.Body.Bytes(), &list); err != nil {
+		t.Fatalf("decode trash: %v", err)
+	}
+	if len(list.ShortURLs) != 1 || list.ShortURLs[0].ShortCode != "binned" || list.ShortURLs[0].DeletedAt == nil {
+		t.Errorf("GET /shorturls/trash = %+v, want the deleted link", list)
+	}
+
+	if rec := serve(http.MethodPost, "/shorturls/binned/restore"); rec.Code != http.StatusNoContent {
+		t.Errorf("restore = %d, want 204: %s", rec.Code, rec.Body.String())
+	}
+	if rec := serve(http.MethodGet, "/shorturls/binned"); rec.Code != http.StatusOK {
+		t.Errorf("stats after restore = %d, want 200", rec.Code)
+	}
+	if rec := serve(http.MethodPost, "/shorturls/missing/restore"); rec.Code != http.StatusNotFound {
+		t.Errorf("restore of an unknown link = %d, want 404", rec.Code)
+	}
+}
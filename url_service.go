@@ -1,213 +1,1877 @@
 package main
 
 import (
-	"crypto/rand"
-	"encoding/hex"
+	"context"
+	"errors"
 	"fmt"
+	"hash/fnv"
+	"net"
+	"net/http"
 	"net/url"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+	"unicode/utf8"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// HexAlphabet is the default alphabet for generated shortcodes
+	HexAlphabet = "0123456789abcdef"
+	// Base62Alphabet uses digits and both letter cases for a denser namespace
+	Base62Alphabet = "0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	// Base58Alphabet is base62 without 0, O, I and l, which are easily misread
+	Base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+	// pathSafeCodeChars are the URL-path-safe (RFC 3986 unreserved) characters allowed in alphabets
+	pathSafeCodeChars = "0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ-._~"
+
+	defaultCodeLength = 8
+	// clickLockStripes is the number of locks click updates are spread over
+	clickLockStripes = 256
+	// minShortCodeLength and maxShortCodeLength bound every shortcode
+	minShortCodeLength = 4
+	maxShortCodeLength = 20
+	// maxGenerateAttempts bounds the collision-retry loop in generateShortCode
+	maxGenerateAttempts = 10
+)
+
+// reservedShortCodes are route names that cannot be claimed as shortcodes:
+// top-level routes, which RedirectURL never treats as shortcodes, and
+// sub-routes of /shorturls/ that would shadow a code's stats. Add new routes
+// here; URLServiceConfig.ReservedCodes adds words on top of them.
+var reservedShortCodes = []string{"shorturls", "health", "metrics", "openapi.json", "admin", "check", "version", "stats", "reverse", "bulk", "auth", "trash", "restore", "preview", "qr"}
+
+var (
+	// ErrShortCodeNotFound is returned when a shortcode does not exist
+	ErrShortCodeNotFound = errors.New("shortcode not found")
+	// ErrShortCodeExpired is returned when a shortcode exists but has expired
+	ErrShortCodeExpired = errors.New("shortcode expired")
+	// ErrShortCodeExists is returned when a custom shortcode is already taken
+	ErrShortCodeExists = errors.New("shortcode already exists")
+	// ErrPasswordRequired is returned when a protected link is accessed without a password
+	ErrPasswordRequired = errors.New("password required")
+	// ErrInvalidPassword is returned when the supplied password does not match
+	ErrInvalidPassword = errors.New("invalid password")
+	// ErrShortCodeNotActive is returned before a scheduled link's activation time
+	ErrShortCodeNotActive = errors.New("shortcode not active yet")
+	// ErrShortCodeDisabled is returned when an operator has disabled a link
+	ErrShortCodeDisabled = errors.New("shortcode disabled")
+	// ErrClickLimitReached is returned once a link has used up its click budget
+	ErrClickLimitReached = errors.New("click limit reached")
+	// ErrTooManyPasswordAttempts is returned while a link is locked after repeated wrong passwords
+	ErrTooManyPasswordAttempts = errors.New("too many password attempts")
+)
+
+const (
+	// maxPasswordBytes is the longest password bcrypt can hash
+	maxPasswordBytes = 72
+	// maxTitleLength and maxDescriptionLength cap link metadata, in characters
+	maxTitleLength       = 200
+	maxDescriptionLength = 1000
+	// maxPasswordFailures wrong passwords lock a link for passwordLockout
+	maxPasswordFailures = 5
+	passwordLockout     = time.Minute
+	// defaultValidityMinutes applies when a request gives no validity
+	defaultValidityMinutes = 30
+	// maxValidityMinutes is the hard cap (one year) on configured maximum
+	// validity, so durations cannot overflow
+	maxValidityMinutes = 366 * 24 * 60
+	// defaultMaxClickHistory is how many clicks a link keeps when the config does not say
+	defaultMaxClickHistory = 10000
 )
 
-// URLService handles URL shortening operations
-type URLService struct {
-	urls   map[string]*ShortURL
-	mutex  sync.RWMutex
-	logger *Logger
+// neverExpires is the expiry of permanent links. A far-future time rather
+// than the zero time keeps every "has it expired" comparison correct as it is.
+var neverExpires = time.Date(9999, 12, 31, 23, 59, 59, 0, time.UTC)
+
+// isPermanent reports whether a link was created to never expire
+func isPermanent(shortURL *ShortURL) bool {
+	return !shortURL.ExpiresAt.Before(neverExpires)
+}
+
+// URLServiceConfig holds tunable settings for the URL service
+type URLServiceConfig struct {
+	CodeLength      int    // length of generated shortcodes
+	CodeAlphabet    string // unique URL-path-safe ASCII characters used for generated shortcodes and their signatures
+	Deduplicate     bool   // reuse an existing non-expired link for the same URL
+	CaseInsensitive bool   // lowercase shortcodes on creation and lookup
+	MaxURLs         int    // maximum stored URLs before eviction, 0 for unlimited
+	DefaultValidity int    // minutes a link lives when the request gives no validity
+	MaxValidity     int    // longest validity or renewal in minutes a request may ask for
+	ForwardQuery    bool   // pass redirect query parameters on to every destination
+	RedirectStatus  int    // status for links that do not set their own: 301, 302, 307 or 308
+	Clock           Clock  // time source for creation, expiry and clicks; the system clock if nil
+	BaseURL         string // scheme and host short links are served from
+	SigningSecret   string // when set, generated codes carry an HMAC suffix and unsigned codes are rejected
+	MaxClickHistory int    // clicks kept per link, oldest dropped first; 0 means the default, negative keeps every click
+	NoPermanent     bool   // refuse requests for links that never expire
+	// TrashRetention is how long deleted links stay restorable before
+	// cleanup removes them; 0 means the default, negative deletes at once
+	TrashRetention time.Duration
+	// ReservedCodes are words, such as brand names or offensive terms, that
+	// no custom or generated shortcode may be, ignoring case. Route names
+	// are always reserved.
+	ReservedCodes []string
+	// Generator proposes shortcodes for links created without one; nil
+	// means a RandomCodeGenerator using CodeAlphabet and CodeLength
+	Generator ShortCodeGenerator
+	// SequentialCodes selects a CounterCodeGenerator over CodeAlphabet when
+	// Generator is nil; the store must implement SequenceStore
+	SequentialCodes bool
+	// Destinations blocks links to private and internal addresses; nil
+	// allows every address
+	Destinations *DestinationPolicy
+}
+
+// DefaultURLServiceConfig returns the config used by NewURLService
+func DefaultURLServiceConfig() URLServiceConfig {
+	return URLServiceConfig{
+		CodeLength:      defaultCodeLength,
+		CodeAlphabet:    HexAlphabet,
+		DefaultValidity: defaultValidityMinutes,
+		MaxValidity:     maxValidityMinutes,
+		RedirectStatus:  http.StatusFound,
+		BaseURL:         "http://localhost:3000",
+		MaxClickHistory: defaultMaxClickHistory,
+		TrashRetention:  defaultTrashRetention,
+		Destinations:    &DestinationPolicy{},
+	}
+}
+
+// URLService handles URL shortening operations
+type URLService struct {
+	store        Store
+	mutex        sync.RWMutex // serializes read-modify-write sequences against the store
+	logger       LoggerInterface
+	generator    ShortCodeGenerator
+	codeAlphabet string
+	lowerCodes   bool
+	maxURLs      int
+	settings     atomic.Pointer[serviceSettings] // swapped by Reload
+
+	clock      Clock
+	baseURL    string // normalized, without a trailing slash
+	baseHost   string // host[:port] of baseURL; links to it are rejected
+	signingKey []byte // nil unless codes are signed
+
+	// clickLocks guard click data per shortcode (by hash) so clicks on
+	// different links need only s.mutex's read lock and do not contend
+	clickLocks [clickLockStripes]sync.Mutex
+
+	attemptsMu       sync.Mutex
+	passwordAttempts map[string]*passwordAttempts
+}
+
+// passwordAttempts tracks consecutive wrong passwords for a protected link
+type passwordAttempts struct {
+	failures    int
+	lockedUntil time.Time
+}
+
+// NewURLService creates a new URL service backed by store
+func NewURLService(logger LoggerInterface, store Store) *URLService {
+	// The default config is always valid
+	service, _ := NewURLServiceWithConfig(logger, store, DefaultURLServiceConfig())
+	return service
+}
+
+// NewURLServiceWithConfig creates a new URL service using the given config,
+// falling back to defaults for unset fields
+func NewURLServiceWithConfig(logger LoggerInterface, store Store, config URLServiceConfig) (*URLService, error) {
+	defaults := DefaultURLServiceConfig()
+	if config.CodeLength <= 0 {
+		config.CodeLength = defaults.CodeLength
+	}
+	if config.CodeAlphabet == "" {
+		config.CodeAlphabet = defaults.CodeAlphabet
+	}
+	if err := validateAlphabet(config.CodeAlphabet); err != nil {
+		return nil, fmt.Errorf("invalid shortcode alphabet: %v", err)
+	}
+	settings, err := newServiceSettings(config)
+	if err != nil {
+		return nil, err
+	}
+	if config.Clock == nil {
+		config.Clock = RealClock()
+	}
+	if config.BaseURL == "" {
+		config.BaseURL = defaults.BaseURL
+	}
+	if scheme := urlScheme(config.BaseURL); scheme != "http" && scheme != "https" {
+		return nil, fmt.Errorf("base URL must start with http:// or https://")
+	}
+	base, err := (&URLService{}).normalizeURL(config.BaseURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid base URL: %v", err)
+	}
+	baseURL, err := url.Parse(base)
+	if err != nil {
+		return nil, fmt.Errorf("invalid base URL: %v", err)
+	}
+	var signingKey []byte
+	if config.SigningSecret != "" {
+		if len(config.SigningSecret) < minSigningSecretBytes {
+			return nil, fmt.Errorf("signing secret must be at least %d bytes", minSigningSecretBytes)
+		}
+		if config.CodeLength+signatureLength > maxShortCodeLength {
+			return nil, fmt.Errorf("shortcode length must be at most %d when codes are signed", maxShortCodeLength-signatureLength)
+		}
+		signingKey = []byte(config.SigningSecret)
+	}
+	if config.CaseInsensitive {
+		// Fold the alphabet once so every generated symbol stays equally likely
+		config.CodeAlphabet = lowercaseAlphabet(config.CodeAlphabet)
+	}
+
+	service := &URLService{
+		store:        store,
+		logger:       logger,
+		generator:    config.Generator,
+		codeAlphabet: config.CodeAlphabet,
+		lowerCodes:   config.CaseInsensitive,
+		maxURLs:      config.MaxURLs,
+		clock:        config.Clock,
+		baseURL:      strings.TrimSuffix(base, "/"),
+		baseHost:     baseURL.Host,
+		signingKey:   signingKey,
+
+		passwordAttempts: make(map[string]*passwordAttempts),
+	}
+	switch {
+	case service.generator != nil:
+	case config.SequentialCodes:
+		sequence, ok := store.(SequenceStore)
+		if !ok {
+			return nil, fmt.Errorf("sequential shortcodes need a store with counters, and %T has none", store)
+		}
+		service.generator = CounterCodeGenerator{Sequence: sequence, Alphabet: config.CodeAlphabet, MinLength: minShortCodeLength}
+	default:
+		service.generator = RandomCodeGenerator{Alphabet: config.CodeAlphabet, Length: config.CodeLength}
+	}
+	service.settings.Store(settings)
+	return service, nil
+}
+
+// serviceSettings are the URLServiceConfig fields Reload can change while
+// requests are running. They are replaced as a whole, so a request sees one
+// consistent set.
+type serviceSettings struct {
+	deduplicate     bool
+	noPermanent     bool
+	forwardQuery    bool
+	redirectStatus  int
+	defaultValidity int
+	maxValidity     int
+	maxClickHistory int                // 0 keeps every click
+	trashRetention  time.Duration      // 0 deletes at once
+	reservedCodes   map[string]bool    // lowercased ReservedCodes
+	destinations    *DestinationPolicy // nil allows every destination address
+}
+
+// newServiceSettings validates the reloadable fields of config, using the
+// defaults for unset ones
+func newServiceSettings(config URLServiceConfig) (*serviceSettings, error) {
+	defaults := DefaultURLServiceConfig()
+	if config.DefaultValidity <= 0 {
+		config.DefaultValidity = defaults.DefaultValidity
+	}
+	if config.MaxValidity <= 0 {
+		config.MaxValidity = defaults.MaxValidity
+	}
+	if config.MaxValidity > maxValidityMinutes {
+		return nil, fmt.Errorf("maximum validity must be at most %d minutes", maxValidityMinutes)
+	}
+	if config.DefaultValidity > config.MaxValidity {
+		return nil, fmt.Errorf("default validity %d exceeds maximum validity %d minutes", config.DefaultValidity, config.MaxValidity)
+	}
+	if config.RedirectStatus == 0 {
+		config.RedirectStatus = defaults.RedirectStatus
+	}
+	if err := validateRedirectStatus(config.RedirectStatus); err != nil {
+		return nil, err
+	}
+	switch {
+	case config.MaxClickHistory == 0:
+		config.MaxClickHistory = defaults.MaxClickHistory
+	case config.MaxClickHistory < 0:
+		config.MaxClickHistory = 0
+	}
+	switch {
+	case config.TrashRetention == 0:
+		config.TrashRetention = defaults.TrashRetention
+	case config.TrashRetention < 0:
+		config.TrashRetention = 0
+	}
+	reservedCodes := make(map[string]bool)
+	for _, word := range config.ReservedCodes {
+		if word = strings.TrimSpace(word); word != "" {
+			reservedCodes[strings.ToLower(word)] = true
+		}
+	}
+	return &serviceSettings{
+		deduplicate:     config.Deduplicate,
+		noPermanent:     config.NoPermanent,
+		forwardQuery:    config.ForwardQuery,
+		redirectStatus:  config.RedirectStatus,
+		defaultValidity: config.DefaultValidity,
+		maxValidity:     config.MaxValidity,
+		maxClickHistory: config.MaxClickHistory,
+		trashRetention:  config.TrashRetention,
+		reservedCodes:   reservedCodes,
+		destinations:    config.Destinations,
+	}, nil
+}
+
+// Reload applies the validity, permanent link, redirect status, query forwarding,
+// deduplication, click history, trash retention, reserved word and destination policy settings
+// of config to new requests; links already stored under a newly reserved word or pointing at a
+// newly blocked address keep working. The other fields shape
+// stored codes or the store and are ignored; they need a restart. An invalid
+// config leaves the current settings in place.
+func (s *URLService) Reload(config URLServiceConfig) error {
+	settings, err := newServiceSettings(config)
+	if err != nil {
+		return err
+	}
+	s.settings.Store(settings)
+	allowed, denied := 0, 0
+	if settings.destinations != nil {
+		allowed, denied = len(settings.destinations.Allow), len(settings.destinations.Deny)
+	}
+	s.logger.Log(BackendStack, InfoLevel, ConfigPackage, fmt.Sprintf("Settings reloaded: default validity %d, max validity %d minutes, redirect status %d, forward query %t, deduplicate %t, max click history %d, %d reserved words, %d allowed and %d blocked destination networks",
+		settings.defaultValidity, settings.maxValidity, settings.redirectStatus, settings.forwardQuery, settings.deduplicate, settings.maxClickHistory, len(settings.reservedCodes), allowed, denied))
+	return nil
+}
+
+// lowercaseAlphabet lowercases an alphabet and drops the duplicates that creates
+func lowercaseAlphabet(alphabet string) string {
+	var folded strings.Builder
+	for _, char := range strings.ToLower(alphabet) {
+		if !strings.ContainsRune(folded.String(), char) {
+			folded.WriteRune(char)
+		}
+	}
+	return folded.String()
+}
+
+// validateAlphabet checks that a shortcode alphabet has at least two unique,
+// URL-path-safe ASCII characters
+func validateAlphabet(alphabet string) error {
+	if len(alphabet) < 2 {
+		return fmt.Errorf("alphabet must have at least 2 characters")
+	}
+
+	seen := make(map[rune]bool)
+	for _, char := range alphabet {
+		if !strings.ContainsRune(pathSafeCodeChars, char) {
+			return fmt.Errorf("alphabet character %q is not URL-path-safe (allowed: letters, digits, - . _ ~)", char)
+		}
+		if seen[char] {
+			return fmt.Errorf("alphabet character %q is duplicated", char)
+		}
+		seen[char] = true
+	}
+
+	return nil
+}
+
+// ValidationError lists every invalid field of a request
+type ValidationError struct {
+	Errors []FieldError
+}
+
+// Add records a problem with a field
+func (e *ValidationError) Add(field, message string) {
+	e.Errors = append(e.Errors, FieldError{Field: field, Message: message})
+}
+
+func (e *ValidationError) Error() string {
+	messages := make([]string, len(e.Errors))
+	for i, fieldErr := range e.Errors {
+		messages[i] = fieldErr.Message
+	}
+	return strings.Join(messages, "; ")
+}
+
+// CreateShortURL creates a new shortened URL. Invalid requests return a
+// *ValidationError naming each bad field. Nothing is stored once ctx is
+// done, or when req.DryRun asks only for a preview of the response.
+func (s *URLService) CreateShortURL(ctx context.Context, req CreateShortURLRequest) (*CreateShortURLResponse, error) {
+	ctx, span := tracer.Start(ctx, "URLService.CreateShortURL")
+	defer span.End()
+	s.logger.LogContext(ctx, BackendStack, InfoLevel, ServicePackage, "Creating short URL")
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	// Validate every field before failing so all problems are reported together
+	var validation ValidationError
+
+	originalURL, err := s.normalizeURL(req.URL)
+	if req.URL == "" {
+		validation.Add("url", "URL is required")
+	} else if err != nil {
+		validation.Add("url", fmt.Sprintf("invalid URL: %v", err))
+	} else if s.pointsAtService(originalURL) {
+		validation.Add("url", fmt.Sprintf("URL must not point at this service (%s); shorten the destination instead", s.baseHost))
+	} else if err := s.checkDestination(ctx, originalURL); err != nil {
+		validation.Add("url", err.Error())
+	}
+
+	// Apply the configured default validity; expiresIn takes precedence
+	settings := s.settings.Load()
+	validity := req.Validity
+	if validity <= 0 {
+		validity = settings.defaultValidity
+	}
+	lifetime := time.Duration(validity) * time.Minute
+	if req.ExpiresIn != "" {
+		expiresIn, err := parseExpiresIn(req.ExpiresIn, time.Duration(settings.maxValidity)*time.Minute)
+		if err != nil {
+			validation.Add("expiresIn", err.Error())
+		} else {
+			lifetime = expiresIn
+		}
+	} else if validity > settings.maxValidity {
+		validation.Add("validity", fmt.Sprintf("validity must be at most %d minutes", settings.maxValidity))
+	}
+	if req.Permanent {
+		switch {
+		case settings.noPermanent:
+			validation.Add("permanent", "permanent links are disabled on this service")
+		case req.Validity != 0 || req.ExpiresIn != "":
+			validation.Add("permanent", "give either permanent or a validity, not both")
+		}
+	}
+
+	// A scheduled link may be created up to the maximum validity ahead
+	var activatesAt time.Time
+	if req.ActivatesAt != "" {
+		parsed, err := time.Parse(time.RFC3339, req.ActivatesAt)
+		switch {
+		case err != nil:
+			validation.Add("activatesAt", "activatesAt must be an RFC3339 timestamp")
+		case parsed.After(s.clock.Now().Add(time.Duration(settings.maxValidity) * time.Minute)):
+			validation.Add("activatesAt", fmt.Sprintf("activatesAt must be at most %d minutes ahead", settings.maxValidity))
+		default:
+			activatesAt = parsed.UTC()
+		}
+	}
+
+	if req.RedirectStatus != 0 {
+		if err := validateRedirectStatus(req.RedirectStatus); err != nil {
+			validation.Add("redirectStatus", err.Error())
+		}
+	}
+
+	// Validate a custom shortcode; generated codes are assigned when the entry is inserted
+	shortCode := req.ShortCode
+	if shortCode != "" && s.signingKey != nil {
+		validation.Add("shortcode", "custom shortcodes are not available when shortcodes are signed")
+	} else if shortCode != "" {
+		if err := s.validateShortCode(shortCode); err != nil {
+			validation.Add("shortcode", fmt.Sprintf("invalid shortcode: %v", err))
+		}
+		shortCode = s.normalizeCode(shortCode)
+	}
+
+	if len(req.Password) > maxPasswordBytes {
+		validation.Add("password", fmt.Sprintf("password must be at most %d bytes", maxPasswordBytes))
+	}
+
+	if req.MaxClicks < 0 {
+		validation.Add("maxClicks", "maxClicks must not be negative")
+	}
+
+	validation.Errors = append(validation.Errors, validateMetadata(req.Title, req.Description)...)
+
+	if len(validation.Errors) > 0 {
+		s.logger.LogContext(ctx, BackendStack, ErrorLevel, DomainPackage, fmt.Sprintf("Invalid create request: %v", &validation))
+		return nil, &validation
+	}
+
+	if req.Permanent {
+		s.logger.LogContext(ctx, BackendStack, DebugLevel, ServicePackage, "URL set to never expire")
+	} else {
+		s.logger.LogContext(ctx, BackendStack, DebugLevel, ServicePackage, fmt.Sprintf("URL validity set to %s", lifetime))
+	}
+
+	// Hash the password for protected links; the plaintext is never stored
+	var passwordHash string
+	if req.Password != "" {
+		if !req.DryRun {
+			hash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+			if err != nil {
+				s.logger.LogContext(ctx, BackendStack, ErrorLevel, ServicePackage, fmt.Sprintf("Password hashing failed: %v", err))
+				return nil, fmt.Errorf("failed to hash password")
+			}
+			passwordHash = string(hash)
+		}
+	}
+
+	// Hashing is slow; give up before touching the store if the caller has gone
+	if err := ctx.Err(); err != nil {
+		s.logger.LogContext(ctx, BackendStack, WarnLevel, ServicePackage, fmt.Sprintf("Create cancelled: %v", err))
+		return nil, err
+	}
+
+	// Create short URL entry; a link scheduled for later lives from its
+	// activation, and one scheduled in the past is simply active now
+	now := s.clock.Now()
+	start := now
+	if activatesAt.After(now) {
+		start = activatesAt
+	} else {
+		activatesAt = time.Time{}
+	}
+	expiresAt := start.Add(lifetime)
+	if req.Permanent {
+		expiresAt = neverExpires
+	}
+	shortURL := &ShortURL{
+		ShortCode:      shortCode,
+		OriginalURL:    originalURL,
+		CreatedAt:      now,
+		ExpiresAt:      expiresAt,
+		ActivatesAt:    activatesAt,
+		ClickCount:     0,
+		ClickHistory:   []Click{},
+		PasswordHash:   passwordHash,
+		ForwardQuery:   req.ForwardQuery,
+		RedirectStatus: req.RedirectStatus,
+		MaxClicks:      req.MaxClicks,
+		Title:          req.Title,
+		Description:    req.Description,
+		OwnerID:        ownerOf(ctx),
+	}
+
+	// Reuse an existing link when deduplication is requested and no custom
+	// code, password, click budget or activation time was given
+	dedupe := (settings.deduplicate || req.Deduplicate) && req.ShortCode == "" && req.Password == "" && req.MaxClicks == 0 && activatesAt.IsZero()
+
+	storeSpan := startStoreSpan(ctx, "Insert", shortCode)
+	stored, reused, err := s.insertShortURL(shortURL, dedupe, req.DryRun)
+	endStoreSpan(storeSpan, err)
+	if errors.Is(err, ErrShortCodeExists) {
+		s.logger.LogContext(ctx, BackendStack, ErrorLevel, DomainPackage, fmt.Sprintf("Shortcode collision: %s", shortCode))
+		if s.lowerCodes {
+			return nil, fmt.Errorf("%w (shortcodes are case-insensitive)", ErrShortCodeExists)
+		}
+		return nil, ErrShortCodeExists
+	}
+	if err != nil {
+		s.logger.LogContext(ctx, BackendStack, ErrorLevel, RepositoryPackage, fmt.Sprintf("Failed to store short URL: %v", err))
+		return nil, fmt.Errorf("failed to store short URL: %v", err)
+	}
+	if reused {
+		s.logger.LogContext(ctx, BackendStack, InfoLevel, ServicePackage, fmt.Sprintf("Reusing existing shortcode %s for %s", stored.ShortCode, originalURL))
+		resp := s.buildCreateResponse(stored)
+		resp.DryRun = req.DryRun
+		return resp, nil
+	}
+	if req.DryRun {
+		s.logger.LogContext(ctx, BackendStack, InfoLevel, ServicePackage, fmt.Sprintf("Dry run: would create %s -> %s", shortURL.ShortCode, originalURL))
+		resp := s.buildCreateResponse(shortURL)
+		resp.DryRun = true
+		return resp, nil
+	}
+	urlsCreatedTotal.Inc()
+	span.SetAttributes(attribute.String("trimurl.shortcode", shortURL.ShortCode))
+
+	s.logger.LogContext(ctx, BackendStack, InfoLevel, ServicePackage, fmt.Sprintf("Short URL created: %s -> %s", shortURL.ShortCode, originalURL))
+
+	return s.buildCreateResponse(shortURL), nil
+}
+
+// bulkCreateWorkers bounds how many creates of one CreateShortURLs call run at once
+const bulkCreateWorkers = 8
+
+// CreateShortURLs creates each request as CreateShortURL would, several at a
+// time, and reports every outcome at the request's index. A failed item does
+// not stop the others; items still queued when ctx is done fail with its error.
+func (s *URLService) CreateShortURLs(ctx context.Context, reqs []CreateShortURLRequest) []BulkCreateResult {
+	s.logger.LogContext(ctx, BackendStack, InfoLevel, ServicePackage, fmt.Sprintf("Creating %d short URLs", len(reqs)))
+
+	results := make([]BulkCreateResult, len(reqs))
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for worker := 0; worker < min(bulkCreateWorkers, len(reqs)); worker++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				results[i] = BulkCreateResult{Index: i}
+				created, err := s.CreateShortURL(ctx, reqs[i])
+				if err != nil {
+					results[i].Error = err.Error()
+					var validationErr *ValidationError
+					if errors.As(err, &validationErr) {
+						results[i].Details = validationErr.Errors
+					}
+					continue
+				}
+				results[i].Created = created
+			}
+		}()
+	}
+	for i := range reqs {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+	return results
+}
+
+// insertShortURL stores a new entry, evicting first if at capacity. An empty
+// ShortCode is filled with a generated one; a custom code that is taken yields
+// ErrShortCodeExists. With dedupe set, an active entry for the same URL that
+// lives at least as long as the new one is returned instead. The store's
+// Insert claims the code atomically, so instances sharing a store never
+// overwrite each other's links; a generated code another instance took in
+// the meantime is replaced with a fresh one. The dedupe lookup and the
+// insert share one critical section, which keeps this instance from
+// inserting identical URLs twice but not two instances. With dryRun set
+// every check runs but nothing is evicted or stored.
+func (s *URLService) insertShortURL(shortURL *ShortURL, dedupe, dryRun bool) (*ShortURL, bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if dedupe {
+		existing, err := s.findActiveByOriginalURL(shortURL)
+		if err != nil {
+			return nil, false, fmt.Errorf("deduplication lookup failed: %v", err)
+		}
+		if existing != nil {
+			return existing, true, nil
+		}
+	}
+
+	generate := shortURL.ShortCode == ""
+	if !generate {
+		exists, err := s.shortCodeExists(shortURL.ShortCode)
+		if err != nil {
+			return nil, false, fmt.Errorf("shortcode lookup failed: %v", err)
+		}
+		if exists {
+			return nil, false, ErrShortCodeExists
+		}
+	}
+
+	if !dryRun {
+		if err := s.evictForCapacity(); err != nil {
+			return nil, false, err
+		}
+	}
+	for attempt := 0; ; attempt++ {
+		if generate {
+			generated, err := s.generateShortCode()
+			if err != nil {
+				return nil, false, err
+			}
+			shortURL.ShortCode = generated
+			s.logger.Log(BackendStack, DebugLevel, ServicePackage, fmt.Sprintf("Generated shortcode: %s", generated))
+		}
+		if dryRun {
+			return shortURL, false, nil
+		}
+		err := s.store.Insert(shortURL)
+		if errors.Is(err, ErrShortCodeExists) && generate && attempt < maxGenerateAttempts {
+			continue
+		}
+		if err != nil {
+			return nil, false, err
+		}
+		return shortURL, false, nil
+	}
+}
+
+// evictForCapacity removes one entry when the store is at capacity, preferring
+// one in the trash or expired and otherwise the one expiring soonest. Callers must hold s.mutex.
+func (s *URLService) evictForCapacity() error {
+	if s.maxURLs <= 0 {
+		return nil
+	}
+
+	count, err := s.store.Count()
+	if err != nil {
+		return err
+	}
+	if count < s.maxURLs {
+		return nil
+	}
+
+	shortURLs, err := s.store.List()
+	if err != nil {
+		return err
+	}
+
+	now := s.clock.Now()
+	var victim *ShortURL
+	for _, shortURL := range shortURLs {
+		if !shortURL.DeletedAt.IsZero() || now.After(shortURL.ExpiresAt) {
+			victim = shortURL
+			break
+		}
+		if victim == nil || shortURL.ExpiresAt.Before(victim.ExpiresAt) {
+			victim = shortURL
+		}
+	}
+
+	if victim == nil {
+		return fmt.Errorf("store is at capacity (%d URLs) and nothing can be evicted", s.maxURLs)
+	}
+
+	if err := s.store.Delete(victim.ShortCode); err != nil {
+		return fmt.Errorf("failed to evict %s: %v", victim.ShortCode, err)
+	}
+	urlsEvictedTotal.Inc()
+
+	s.logger.Log(BackendStack, WarnLevel, ServicePackage, fmt.Sprintf("Evicted %s (expires %s) to stay within capacity of %d", victim.ShortCode, victim.ExpiresAt.Format(time.RFC3339), s.maxURLs))
+
+	return nil
+}
+
+// ShortLink returns the full short link for a shortcode
+func (s *URLService) ShortLink(shortCode string) string {
+	return fmt.Sprintf("%s/%s", s.baseURL, shortCode)
+}
+
+// pointsAtService reports whether a normalized URL is on the host short links
+// are served from, so shortening it would create a redirect chain or loop
+func (s *URLService) pointsAtService(normalizedURL string) bool {
+	parsed, err := url.Parse(normalizedURL)
+	return err == nil && parsed.Host == s.baseHost
+}
+
+// summarize describes an entry for listings
+func (s *URLService) summarize(shortURL *ShortURL) ShortURLSummary {
+	summary := ShortURLSummary{
+		ShortCode:   shortURL.ShortCode,
+		ShortLink:   s.ShortLink(shortURL.ShortCode),
+		CreatedAt:   shortURL.CreatedAt,
+		ExpiresAt:   shortURL.ExpiresAt,
+		Expired:     s.clock.Now().After(shortURL.ExpiresAt),
+		Disabled:    shortURL.Disabled,
+		TotalClicks: shortURL.ClickCount,
+		OwnerID:     shortURL.OwnerID,
+	}
+	if !shortURL.DeletedAt.IsZero() {
+		deletedAt, restorableUntil := shortURL.DeletedAt, s.restorableUntil(shortURL)
+		summary.DeletedAt, summary.RestorableUntil = &deletedAt, &restorableUntil
+	}
+	return summary
+}
+
+// buildCreateResponse builds the create response for a stored short URL
+func (s *URLService) buildCreateResponse(shortURL *ShortURL) *CreateShortURLResponse {
+	resp := &CreateShortURLResponse{
+		ShortCode: shortURL.ShortCode,
+		ShortLink: s.ShortLink(shortURL.ShortCode),
+		Expiry:    shortURL.ExpiresAt.Format(time.RFC3339),
+		Permanent: isPermanent(shortURL),
+	}
+	if s.clock.Now().Before(shortURL.ActivatesAt) {
+		resp.ActivatesAt = shortURL.ActivatesAt.Format(time.RFC3339)
+	}
+	return resp
+}
+
+// entriesForOriginalURL returns the stored entries outside the trash for a
+// normalized original URL, asking the store so links other instances
+// created are found too. Callers must hold s.mutex.
+func (s *URLService) entriesForOriginalURL(originalURL string) ([]*ShortURL, error) {
+	stored, err := s.store.ListByOriginalURL(originalURL)
+	if err != nil {
+		return nil, err
+	}
+	shortURLs := stored[:0]
+	for _, shortURL := range stored {
+		if shortURL.DeletedAt.IsZero() {
+			shortURLs = append(shortURLs, shortURL)
+		}
+	}
+	return shortURLs, nil
+}
+
+// FindByOriginalURL returns copies of every stored entry the caller in ctx
+// may access, expired or not, whose destination normalizes to the same URL as rawURL
+func (s *URLService) FindByOriginalURL(ctx context.Context, rawURL string) ([]*ShortURL, error) {
+	originalURL, err := s.normalizeURL(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	s.logger.LogContext(ctx, BackendStack, InfoLevel, ServicePackage, fmt.Sprintf("Reverse lookup for: %s", originalURL))
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	shortURLs, err := s.entriesForOriginalURL(originalURL)
+	if err != nil {
+		s.logger.LogContext(ctx, BackendStack, ErrorLevel, DomainPackage, fmt.Sprintf("Reverse lookup failed for %s: %v", originalURL, err))
+		return nil, err
+	}
+
+	copies := make([]*ShortURL, 0, len(shortURLs))
+	for _, shortURL := range shortURLs {
+		if !canAccess(ctx, shortURL) {
+			continue
+		}
+		clickLock := s.clickLock(shortURL.ShortCode)
+		clickLock.Lock()
+		entry := *shortURL
+		clickLock.Unlock()
+		copies = append(copies, &entry)
+	}
+	return copies, nil
+}
+
+// findActiveByOriginalURL returns an unprotected entry with the same owner,
+// original URL and redirect behaviour as candidate that expires no earlier
+// than it, if any. Callers must hold s.mutex.
+func (s *URLService) findActiveByOriginalURL(candidate *ShortURL) (*ShortURL, error) {
+	shortURLs, err := s.entriesForOriginalURL(candidate.OriginalURL)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, shortURL := range shortURLs {
+		if shortURL.OwnerID == candidate.OwnerID && shortURL.PasswordHash == "" && !shortURL.Disabled &&
+			shortURL.ForwardQuery == candidate.ForwardQuery && shortURL.RedirectStatus == candidate.RedirectStatus &&
+			shortURL.MaxClicks == candidate.MaxClicks && !s.clock.Now().Before(shortURL.ActivatesAt) &&
+			shortURL.Title == candidate.Title && shortURL.Description == candidate.Description &&
+			!shortURL.ExpiresAt.Before(candidate.ExpiresAt) {
+			return shortURL, nil
+		}
+	}
+	return nil, nil
+}
+
+// GetOriginalURL retrieves the original URL for a short code
+func (s *URLService) GetOriginalURL(ctx context.Context, shortCode string) (string, error) {
+	shortURL, err := s.ResolveShortURL(ctx, shortCode)
+	if err != nil {
+		return "", err
+	}
+	return shortURL.OriginalURL, nil
+}
+
+// ResolveShortURL returns a copy of the active entry for a short code, or
+// ErrShortCodeExpired once it has expired, ErrShortCodeNotActive before its
+// activation time, ErrShortCodeDisabled while an
+// operator has disabled it and ErrClickLimitReached once its click budget is
+// used up
+func (s *URLService) ResolveShortURL(ctx context.Context, shortCode string) (*ShortURL, error) {
+	shortCode = s.normalizeCode(shortCode)
+	ctx, span := tracer.Start(ctx, "URLService.ResolveShortURL", trace.WithAttributes(attribute.String("trimurl.shortcode", shortCode)))
+	defer span.End()
+	s.logger.LogContext(ctx, BackendStack, InfoLevel, ServicePackage, fmt.Sprintf("Retrieving original URL for: %s", shortCode))
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	// Forged codes are turned away before the store is consulted
+	if !s.validSignature(shortCode) {
+		s.logger.LogContext(ctx, BackendStack, WarnLevel, DomainPackage, fmt.Sprintf("Rejected shortcode with invalid signature: %s", shortCode))
+		return nil, ErrShortCodeNotFound
+	}
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	clickLock := s.clickLock(shortCode)
+	clickLock.Lock()
+	defer clickLock.Unlock()
+
+	storeSpan := startStoreSpan(ctx, "Get", shortCode)
+	shortURL, err := s.liveEntry(shortCode)
+	endStoreSpan(storeSpan, err)
+	if err != nil {
+		s.logger.LogContext(ctx, BackendStack, ErrorLevel, DomainPackage, fmt.Sprintf("Shortcode lookup failed for %s: %v", shortCode, err))
+		return nil, err
+	}
+
+	// Check if expired
+	if s.clock.Now().After(shortURL.ExpiresAt) {
+		s.logger.LogContext(ctx, BackendStack, WarnLevel, DomainPackage, fmt.Sprintf("Shortcode expired: %s", shortCode))
+		expiredHitsTotal.Inc()
+		return nil, ErrShortCodeExpired
+	}
+
+	if s.clock.Now().Before(shortURL.ActivatesAt) {
+		s.logger.LogContext(ctx, BackendStack, WarnLevel, DomainPackage, fmt.Sprintf("Shortcode not active until %s: %s", shortURL.ActivatesAt.Format(time.RFC3339), shortCode))
+		return nil, ErrShortCodeNotActive
+	}
+
+	if shortURL.Disabled {
+		s.logger.LogContext(ctx, BackendStack, WarnLevel, DomainPackage, fmt.Sprintf("Shortcode disabled: %s", shortCode))
+		return nil, ErrShortCodeDisabled
+	}
+
+	if clickLimitReached(shortURL) {
+		s.logger.LogContext(ctx, BackendStack, WarnLevel, DomainPackage, fmt.Sprintf("Click limit reached: %s", shortCode))
+		return nil, ErrClickLimitReached
+	}
+
+	resolved := *shortURL
+	return &resolved, nil
+}
+
+// clickLimitReached reports whether a link has used up its click budget
+func clickLimitReached(shortURL *ShortURL) bool {
+	return shortURL.MaxClicks > 0 && shortURL.ClickCount >= shortURL.MaxClicks
+}
+
+// RedirectStatus returns the status code to redirect a link with. Protected
+// and click-limited links never use a permanent status, which browsers would
+// cache past the password check or the click budget.
+func (s *URLService) RedirectStatus(shortURL *ShortURL) int {
+	status := shortURL.RedirectStatus
+	if status == 0 {
+		status = s.settings.Load().redirectStatus
+	}
+	if shortURL.PasswordHash != "" || shortURL.MaxClicks > 0 {
+		switch status {
+		case http.StatusMovedPermanently:
+			return http.StatusFound
+		case http.StatusPermanentRedirect:
+			return http.StatusTemporaryRedirect
+		}
+	}
+	return status
+}
+
+// RedirectTarget returns the destination for a redirect. When the link or
+// the service forwards queries, parameters from the short-link request are
+// appended to the original URL; parameters the original URL already sets win,
+// and the pw password and preview parameters are never forwarded.
+func (s *URLService) RedirectTarget(shortURL *ShortURL, query url.Values) string {
+	if !(shortURL.ForwardQuery || s.settings.Load().forwardQuery) || len(query) == 0 {
+		return shortURL.OriginalURL
+	}
+
+	target, err := url.Parse(shortURL.OriginalURL)
+	if err != nil {
+		return shortURL.OriginalURL
+	}
+	existing := target.Query()
+
+	forwarded := url.Values{}
+	for key, values := range query {
+		if key == "pw" || key == "preview" || existing.Has(key) {
+			continue
+		}
+		forwarded[key] = values
+	}
+	if len(forwarded) == 0 {
+		return shortURL.OriginalURL
+	}
+
+	// Append rather than re-encode so the original query keeps its exact form
+	if target.RawQuery == "" {
+		target.RawQuery = forwarded.Encode()
+	} else {
+		target.RawQuery += "&" + forwarded.Encode()
+	}
+	return target.String()
+}
+
+// CheckPassword verifies the password for a short link and reports whether
+// the stored link is protected; unprotected links always pass. After
+// maxPasswordFailures wrong guesses the link rejects passwords for
+// passwordLockout without comparing them.
+func (s *URLService) CheckPassword(shortCode, password string) (bool, error) {
+	shortCode = s.normalizeCode(shortCode)
+
+	s.mutex.RLock()
+	shortURL, err := s.liveEntry(shortCode)
+	s.mutex.RUnlock()
+	if err != nil {
+		return false, err
+	}
+
+	if shortURL.PasswordHash == "" {
+		return false, nil
+	}
+	if password == "" {
+		return true, ErrPasswordRequired
+	}
+
+	s.attemptsMu.Lock()
+	attempts := s.passwordAttempts[shortCode]
+	locked := attempts != nil && s.clock.Now().Before(attempts.lockedUntil)
+	s.attemptsMu.Unlock()
+	if locked {
+		s.logger.Log(BackendStack, WarnLevel, DomainPackage, fmt.Sprintf("Password attempt rejected for locked shortcode %s", shortCode))
+		return true, ErrTooManyPasswordAttempts
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(shortURL.PasswordHash), []byte(password)); err != nil {
+		s.logger.Log(BackendStack, WarnLevel, DomainPackage, fmt.Sprintf("Wrong password for %s", shortCode))
+		s.recordPasswordFailure(shortCode)
+		return true, ErrInvalidPassword
+	}
+
+	s.attemptsMu.Lock()
+	delete(s.passwordAttempts, shortCode)
+	s.attemptsMu.Unlock()
+	return true, nil
 }
 
-// NewURLService creates a new URL service
-func NewURLService(logger *Logger) *URLService {
-	return &URLService{
-		urls:   make(map[string]*ShortURL),
-		logger: logger,
+// recordPasswordFailure counts a wrong password and locks the link once the limit is reached
+func (s *URLService) recordPasswordFailure(shortCode string) {
+	s.attemptsMu.Lock()
+	defer s.attemptsMu.Unlock()
+
+	attempts := s.passwordAttempts[shortCode]
+	if attempts == nil {
+		attempts = &passwordAttempts{}
+		s.passwordAttempts[shortCode] = attempts
+	}
+
+	attempts.failures++
+	if attempts.failures >= maxPasswordFailures {
+		attempts.failures = 0
+		attempts.lockedUntil = s.clock.Now().Add(passwordLockout)
+		s.logger.Log(BackendStack, WarnLevel, DomainPackage, fmt.Sprintf("Shortcode %s locked for %s after %d wrong passwords", shortCode, passwordLockout, maxPasswordFailures))
 	}
 }
 
-// CreateShortURL creates a new shortened URL
-func (s *URLService) CreateShortURL(req CreateShortURLRequest) (*CreateShortURLResponse, error) {
-	s.logger.Log(BackendStack, InfoLevel, ServicePackage, "Creating short URL")
+// CheckAvailability reports whether a custom shortcode could be created now,
+// giving the validation failure or "already taken" as the reason when not
+func (s *URLService) CheckAvailability(ctx context.Context, shortCode string) (*ShortCodeAvailability, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 
-	// Validate URL
-	if err := s.validateURL(req.URL); err != nil {
-		s.logger.Log(BackendStack, ErrorLevel, DomainPackage, fmt.Sprintf("Invalid URL: %v", err))
-		return nil, fmt.Errorf("invalid URL: %v", err)
+	if s.signingKey != nil {
+		return &ShortCodeAvailability{Available: false, Reason: "custom shortcodes are not available when shortcodes are signed"}, nil
+	}
+	if err := s.validateShortCode(shortCode); err != nil {
+		return &ShortCodeAvailability{Available: false, Reason: err.Error()}, nil
 	}
 
-	// Set default validity to 30 minutes
-	validity := req.Validity
-	if validity <= 0 {
-		validity = 30
+	s.mutex.RLock()
+	exists, err := s.shortCodeExists(s.normalizeCode(shortCode))
+	s.mutex.RUnlock()
+	if err != nil {
+		s.logger.LogContext(ctx, BackendStack, ErrorLevel, RepositoryPackage, fmt.Sprintf("Availability lookup failed for %s: %v", shortCode, err))
+		return nil, fmt.Errorf("shortcode lookup failed: %v", err)
+	}
+	if exists {
+		reason := "shortcode is already taken"
+		if s.lowerCodes {
+			reason += " (shortcodes are case-insensitive)"
+		}
+		return &ShortCodeAvailability{Available: false, Reason: reason}, nil
 	}
 
-	s.logger.Log(BackendStack, DebugLevel, ServicePackage, fmt.Sprintf("URL validity set to %d minutes", validity))
+	return &ShortCodeAvailability{Available: true}, nil
+}
 
-	// Generate or validate shortcode
-	shortCode := req.ShortCode
-	if shortCode == "" {
-		shortCode = s.generateShortCode()
-		s.logger.Log(BackendStack, DebugLevel, ServicePackage, fmt.Sprintf("Generated shortcode: %s", shortCode))
-	} else {
-		if err := s.validateShortCode(shortCode); err != nil {
-			s.logger.Log(BackendStack, ErrorLevel, DomainPackage, fmt.Sprintf("Invalid shortcode: %v", err))
-			return nil, fmt.Errorf("invalid shortcode: %v", err)
+// clickLock returns the lock guarding click data for a shortcode. Callers
+// must hold s.mutex (either mode) first and must not hold another click lock.
+func (s *URLService) clickLock(shortCode string) *sync.Mutex {
+	hash := fnv.New32a()
+	hash.Write([]byte(shortCode))
+	return &s.clickLocks[hash.Sum32()%clickLockStripes]
+}
+
+// RecordClick records a click on a short URL, stamping it with the current time
+func (s *URLService) RecordClick(ctx context.Context, shortCode string, click Click) error {
+	click.Timestamp = s.clock.Now()
+	return s.RecordClicks(ctx, shortCode, []Click{click})
+}
+
+// RecordClicks appends clicks that already carry their timestamps to a
+// short URL with one store update, which the store makes atomic, so
+// instances sharing a store neither lose clicks nor overshoot a click
+// budget. Clicks past the link's click limit are not recorded and
+// ErrClickLimitReached is returned.
+func (s *URLService) RecordClicks(ctx context.Context, shortCode string, clicks []Click) error {
+	shortCode = s.normalizeCode(shortCode)
+	ctx, span := tracer.Start(ctx, "URLService.RecordClick", trace.WithAttributes(attribute.String("trimurl.shortcode", shortCode), attribute.Int("trimurl.clicks", len(clicks))))
+	defer span.End()
+	s.logger.LogContext(ctx, BackendStack, DebugLevel, ServicePackage, fmt.Sprintf("Recording clicks for: %s (%d)", shortCode, len(clicks)))
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	// The read lock keeps the entry from being replaced or evicted meanwhile;
+	// the stripe lock keeps readers of an in-memory entry from seeing it
+	// half updated
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	clickLock := s.clickLock(shortCode)
+	clickLock.Lock()
+	defer clickLock.Unlock()
+
+	storeSpan := startStoreSpan(ctx, "Get", shortCode)
+	_, err := s.liveEntry(shortCode)
+	endStoreSpan(storeSpan, err)
+	if err != nil {
+		return err
+	}
+
+	storeSpan = startStoreSpan(ctx, "RecordClicks", shortCode)
+	recorded, total, err := s.store.RecordClicks(shortCode, clicks, s.settings.Load().maxClickHistory)
+	endStoreSpan(storeSpan, err)
+	if errors.Is(err, ErrShortCodeNotFound) {
+		return err
+	}
+	if err != nil {
+		s.logger.LogContext(ctx, BackendStack, ErrorLevel, RepositoryPackage, fmt.Sprintf("Failed to persist click for %s: %v", shortCode, err))
+		return fmt.Errorf("failed to record click: %v", err)
+	}
+	if recorded == 0 {
+		s.logger.LogContext(ctx, BackendStack, WarnLevel, DomainPackage, fmt.Sprintf("Click limit reached: %s", shortCode))
+		return ErrClickLimitReached
+	}
+
+	s.logger.LogContext(ctx, BackendStack, InfoLevel, ServicePackage, fmt.Sprintf("Clicks recorded for %s: %d (total: %d)", shortCode, recorded, total))
+	if recorded < len(clicks) {
+		s.logger.LogContext(ctx, BackendStack, WarnLevel, DomainPackage, fmt.Sprintf("Click limit reached: %s, %d clicks not recorded", shortCode, len(clicks)-recorded))
+		return ErrClickLimitReached
+	}
+	return nil
+}
+
+// GetStats retrieves statistics for a short URL
+func (s *URLService) GetStats(ctx context.Context, shortCode string) (*ShortURLStats, error) {
+	return s.GetStatsFiltered(ctx, shortCode, StatsFilter{})
+}
+
+// GetStatsFiltered retrieves statistics for a short URL, returning only the
+// clicks within the filter's time range and page. Expired links keep their
+// stats and are flagged; only unknown codes, and links the caller in ctx may
+// not access, return ErrShortCodeNotFound.
+func (s *URLService) GetStatsFiltered(ctx context.Context, shortCode string, filter StatsFilter) (*ShortURLStats, error) {
+	shortCode = s.normalizeCode(shortCode)
+	ctx, span := tracer.Start(ctx, "URLService.GetStats", trace.WithAttributes(attribute.String("trimurl.shortcode", shortCode)))
+	defer span.End()
+	s.logger.LogContext(ctx, BackendStack, InfoLevel, ServicePackage, fmt.Sprintf("Retrieving stats for: %s", shortCode))
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if !s.validSignature(shortCode) {
+		return nil, ErrShortCodeNotFound
+	}
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	clickLock := s.clickLock(shortCode)
+	clickLock.Lock()
+	defer clickLock.Unlock()
+
+	storeSpan := startStoreSpan(ctx, "Get", shortCode)
+	shortURL, err := s.liveEntry(shortCode)
+	endStoreSpan(storeSpan, err)
+	if err != nil {
+		s.logger.LogContext(ctx, BackendStack, ErrorLevel, DomainPackage, fmt.Sprintf("Stats lookup failed for %s: %v", shortCode, err))
+		return nil, err
+	}
+	if !canAccess(ctx, shortURL) {
+		s.logger.LogContext(ctx, BackendStack, WarnLevel, DomainPackage, fmt.Sprintf("Stats for %s refused: not the owner", shortCode))
+		return nil, ErrShortCodeNotFound
+	}
+
+	return s.buildStats(shortURL, filter), nil
+}
+
+// GetStatsBulk retrieves unfiltered statistics for several codes in a single
+// pass under the read lock. Unknown codes, and links the caller in ctx may not
+// access, are left out of the result rather than failing the whole lookup;
+// store errors do fail it.
+func (s *URLService) GetStatsBulk(ctx context.Context, shortCodes []string) (map[string]*ShortURLStats, error) {
+	s.logger.LogContext(ctx, BackendStack, InfoLevel, ServicePackage, fmt.Sprintf("Retrieving stats for %d codes", len(shortCodes)))
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	stats := make(map[string]*ShortURLStats, len(shortCodes))
+	for _, requested := range shortCodes {
+		if _, seen := stats[requested]; seen {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		shortCode := s.normalizeCode(requested)
+		if !s.validSignature(shortCode) {
+			continue
+		}
+		clickLock := s.clickLock(shortCode)
+		clickLock.Lock()
+		shortURL, err := s.liveEntry(shortCode)
+		if err == nil && canAccess(ctx, shortURL) {
+			stats[requested] = s.buildStats(shortURL, StatsFilter{})
 		}
+		clickLock.Unlock()
 
-		// Check if shortcode already exists
-		if s.shortCodeExists(shortCode) {
-			s.logger.Log(BackendStack, ErrorLevel, DomainPackage, fmt.Sprintf("Shortcode collision: %s", shortCode))
-			return nil, fmt.Errorf("shortcode already exists")
+		if err != nil && !errors.Is(err, ErrShortCodeNotFound) {
+			s.logger.LogContext(ctx, BackendStack, ErrorLevel, DomainPackage, fmt.Sprintf("Bulk stats lookup failed for %s: %v", shortCode, err))
+			return nil, err
 		}
 	}
+	return stats, nil
+}
 
-	// Create short URL entry
-	now := time.Now()
-	shortURL := &ShortURL{
-		ShortCode:    shortCode,
-		OriginalURL:  req.URL,
-		CreatedAt:    now,
-		ExpiresAt:    now.Add(time.Duration(validity) * time.Minute),
-		ClickCount:   0,
-		ClickHistory: []Click{},
+// buildStats summarises a stored entry; callers hold its click lock
+func (s *URLService) buildStats(shortURL *ShortURL, filter StatsFilter) *ShortURLStats {
+	matching := filterClicks(shortURL.ClickHistory, filter.From, filter.To)
+
+	var lastAccessedAt *time.Time
+	if !shortURL.LastAccessedAt.IsZero() {
+		lastAccessed := shortURL.LastAccessedAt
+		lastAccessedAt = &lastAccessed
 	}
 
-	// Store the short URL
-	s.mutex.Lock()
-	s.urls[shortCode] = shortURL
-	s.mutex.Unlock()
+	var activatesAt *time.Time
+	if !shortURL.ActivatesAt.IsZero() {
+		activates := shortURL.ActivatesAt
+		activatesAt = &activates
+	}
+
+	browsers, operatingSystems, devices := aggregateAgents(matching)
+	return &ShortURLStats{
+		TotalClicks:    shortURL.ClickCount,
+		MatchingClicks: len(matching),
+		CreatedAt:      shortURL.CreatedAt,
+		ExpiresAt:      shortURL.ExpiresAt,
+		ActivatesAt:    activatesAt,
+		Permanent:      isPermanent(shortURL),
+		Expired:        s.clock.Now().After(shortURL.ExpiresAt),
+		Disabled:       shortURL.Disabled,
+		MaxClicks:      shortURL.MaxClicks,
+		Title:          shortURL.Title,
+		Description:    shortURL.Description,
+		LastAccessedAt: lastAccessedAt,
+		Clicks:         paginateClicks(matching, filter.Offset, filter.Limit),
+		UserAgents:     aggregateUserAgents(matching),
 
-	s.logger.Log(BackendStack, InfoLevel, ServicePackage, fmt.Sprintf("Short URL created: %s -> %s", shortCode, req.URL))
+		Browsers:         browsers,
+		OperatingSystems: operatingSystems,
+		Devices:          devices,
+	}
+}
 
-	return &CreateShortURLResponse{
-		ShortLink: fmt.Sprintf("http://localhost:3000/%s", shortCode),
-		Expiry:    shortURL.ExpiresAt.Format(time.RFC3339),
-	}, nil
+// filterClicks returns clicks within [from, to]; zero bounds are open
+func filterClicks(clicks []Click, from, to time.Time) []Click {
+	if from.IsZero() && to.IsZero() {
+		return clicks
+	}
+
+	matching := make([]Click, 0, len(clicks))
+	for _, click := range clicks {
+		if !from.IsZero() && click.Timestamp.Before(from) {
+			continue
+		}
+		if !to.IsZero() && click.Timestamp.After(to) {
+			continue
+		}
+		matching = append(matching, click)
+	}
+	return matching
 }
 
-// GetOriginalURL retrieves the original URL for a short code
-func (s *URLService) GetOriginalURL(shortCode string) (string, error) {
-	s.logger.Log(BackendStack, InfoLevel, ServicePackage, fmt.Sprintf("Retrieving original URL for: %s", shortCode))
+// paginateClicks returns the page of clicks starting at offset; a zero limit returns the rest
+func paginateClicks(clicks []Click, offset, limit int) []Click {
+	if offset >= len(clicks) {
+		return []Click{}
+	}
+	clicks = clicks[offset:]
+	if limit > 0 && limit < len(clicks) {
+		clicks = clicks[:limit]
+	}
+	return clicks
+}
+
+// ClickHistory returns the recorded clicks of a link, expired or not. Clicks
+// are only ever appended, and old ones dropped by reslicing, so the slice
+// taken under the lock stays valid while later clicks are recorded; callers
+// must not modify it.
+func (s *URLService) ClickHistory(ctx context.Context, shortCode string) ([]Click, error) {
+	shortCode = s.normalizeCode(shortCode)
+	s.logger.LogContext(ctx, BackendStack, InfoLevel, ServicePackage, fmt.Sprintf("Retrieving click history for: %s", shortCode))
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if !s.validSignature(shortCode) {
+		return nil, ErrShortCodeNotFound
+	}
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	clickLock := s.clickLock(shortCode)
+	clickLock.Lock()
+	defer clickLock.Unlock()
+
+	shortURL, err := s.liveEntry(shortCode)
+	if err != nil {
+		s.logger.LogContext(ctx, BackendStack, ErrorLevel, DomainPackage, fmt.Sprintf("Click history lookup failed for %s: %v", shortCode, err))
+		return nil, err
+	}
+	if !canAccess(ctx, shortURL) {
+		return nil, ErrShortCodeNotFound
+	}
+	return shortURL.ClickHistory, nil
+}
+
+// ListShortURLs summarizes the stored links the caller in ctx may access,
+// expired or not, in the order and page given by options. Links in the trash
+// are listed instead of live ones when options.Trashed is set, as long as
+// they can still be restored. It also returns the number of those links
+// across all pages.
+func (s *URLService) ListShortURLs(ctx context.Context, options ListOptions) ([]ShortURLSummary, int, error) {
+	ctx, span := tracer.Start(ctx, "URLService.ListShortURLs")
+	defer span.End()
+	s.logger.LogContext(ctx, BackendStack, InfoLevel, ServicePackage, "Listing short URLs")
+
+	if err := ctx.Err(); err != nil {
+		return nil, 0, err
+	}
 
 	s.mutex.RLock()
-	shortURL, exists := s.urls[shortCode]
+	storeSpan := startStoreSpan(ctx, "List", "")
+	shortURLs, err := s.store.List()
+	endStoreSpan(storeSpan, err)
+	now := s.clock.Now()
+	summaries := make([]ShortURLSummary, 0, len(shortURLs))
+	for _, shortURL := range shortURLs {
+		trashed := !shortURL.DeletedAt.IsZero()
+		if !canAccess(ctx, shortURL) || trashed != options.Trashed || (trashed && !now.Before(s.restorableUntil(shortURL))) {
+			continue
+		}
+		clickLock := s.clickLock(shortURL.ShortCode)
+		clickLock.Lock()
+		summaries = append(summaries, s.summarize(shortURL))
+		clickLock.Unlock()
+	}
 	s.mutex.RUnlock()
+	if err != nil {
+		s.logger.LogContext(ctx, BackendStack, ErrorLevel, RepositoryPackage, fmt.Sprintf("Listing failed: %v", err))
+		return nil, 0, err
+	}
+
+	// Ties fall back to the shortcode so pages are stable between requests
+	sort.Slice(summaries, func(i, j int) bool {
+		a, b := summaries[i], summaries[j]
+		if options.Sort == SortByClickCount && a.TotalClicks != b.TotalClicks {
+			return a.TotalClicks > b.TotalClicks
+		}
+		if options.Sort != SortByClickCount && options.Trashed && !a.DeletedAt.Equal(*b.DeletedAt) {
+			return a.DeletedAt.After(*b.DeletedAt)
+		}
+		if !a.CreatedAt.Equal(b.CreatedAt) {
+			return a.CreatedAt.After(b.CreatedAt)
+		}
+		return a.ShortCode < b.ShortCode
+	})
 
-	if !exists {
-		s.logger.Log(BackendStack, ErrorLevel, DomainPackage, fmt.Sprintf("Shortcode not found: %s", shortCode))
-		return "", fmt.Errorf("shortcode not found")
+	total := len(summaries)
+	if options.Offset >= total {
+		return []ShortURLSummary{}, total, nil
+	}
+	summaries = summaries[options.Offset:]
+	if options.Limit > 0 && options.Limit < len(summaries) {
+		summaries = summaries[:options.Limit]
 	}
+	return summaries, total, nil
+}
 
-	// Check if expired
-	if time.Now().After(shortURL.ExpiresAt) {
-		s.logger.Log(BackendStack, WarnLevel, DomainPackage, fmt.Sprintf("Shortcode expired: %s", shortCode))
-		return "", fmt.Errorf("shortcode expired")
+// ExportShortURLs calls fn with a snapshot of every stored entry, stopping at
+// the first error. Entries are copied under the lock so fn can run without it.
+func (s *URLService) ExportShortURLs(ctx context.Context, fn func(*ShortURL) error) error {
+	s.mutex.RLock()
+	shortURLs, err := s.store.List()
+	snapshot := make([]ShortURL, len(shortURLs))
+	for i, shortURL := range shortURLs {
+		clickLock := s.clickLock(shortURL.ShortCode)
+		clickLock.Lock()
+		snapshot[i] = *shortURL
+		clickLock.Unlock()
+	}
+	s.mutex.RUnlock()
+	if err != nil {
+		s.logger.LogContext(ctx, BackendStack, ErrorLevel, RepositoryPackage, fmt.Sprintf("Export listing failed: %v", err))
+		return err
 	}
 
-	return shortURL.OriginalURL, nil
+	s.logger.LogContext(ctx, BackendStack, InfoLevel, ServicePackage, fmt.Sprintf("Exporting %d short URLs", len(snapshot)))
+
+	for i := range snapshot {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(&snapshot[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ImportShortURL stores an exported entry unless its shortcode is already
+// taken, reporting whether it was imported
+func (s *URLService) ImportShortURL(ctx context.Context, shortURL *ShortURL) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	if shortURL.ShortCode == "" {
+		return false, fmt.Errorf("shortcode is required")
+	}
+	originalURL, err := s.normalizeURL(shortURL.OriginalURL)
+	if err != nil {
+		return false, fmt.Errorf("invalid URL for %s: %v", shortURL.ShortCode, err)
+	}
+	if shortURL.MaxClicks < 0 {
+		return false, fmt.Errorf("max_clicks must not be negative for %s", shortURL.ShortCode)
+	}
+	if errs := validateMetadata(shortURL.Title, shortURL.Description); errs != nil {
+		return false, fmt.Errorf("invalid metadata for %s: %s", shortURL.ShortCode, errs[0].Message)
+	}
+	shortURL.OriginalURL = originalURL
+	shortURL.ShortCode = s.normalizeCode(shortURL.ShortCode)
+	if !s.validSignature(shortURL.ShortCode) {
+		return false, fmt.Errorf("shortcode %s is not signed with the configured secret", shortURL.ShortCode)
+	}
+	if shortURL.ClickHistory == nil {
+		shortURL.ClickHistory = []Click{}
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	exists, err := s.shortCodeExists(shortURL.ShortCode)
+	if err != nil {
+		return false, fmt.Errorf("shortcode lookup failed: %v", err)
+	}
+	if exists {
+		s.logger.LogContext(ctx, BackendStack, DebugLevel, ServicePackage, fmt.Sprintf("Import skipped existing shortcode %s", shortURL.ShortCode))
+		return false, nil
+	}
+
+	if err := s.evictForCapacity(); err != nil {
+		return false, err
+	}
+	err = s.store.Insert(shortURL)
+	if errors.Is(err, ErrShortCodeExists) {
+		// Another instance stored it since the check above
+		s.logger.LogContext(ctx, BackendStack, DebugLevel, ServicePackage, fmt.Sprintf("Import skipped existing shortcode %s", shortURL.ShortCode))
+		return false, nil
+	}
+	if err != nil {
+		s.logger.LogContext(ctx, BackendStack, ErrorLevel, RepositoryPackage, fmt.Sprintf("Failed to import %s: %v", shortURL.ShortCode, err))
+		return false, fmt.Errorf("failed to store short URL: %v", err)
+	}
+	return true, nil
+}
+
+// URLCount returns the number of stored short URLs
+func (s *URLService) URLCount() (int, error) {
+	return s.store.Count()
 }
 
-// RecordClick records a click on a short URL
-func (s *URLService) RecordClick(shortCode, source, location string) error {
-	s.logger.Log(BackendStack, DebugLevel, ServicePackage, fmt.Sprintf("Recording click for: %s", shortCode))
+// UpdateShortURL changes a non-expired link's destination and/or expiry.
+// Validity adds minutes to the current expiry, as renewals always have;
+// ExpiresIn instead sets the expiry to that long from now, so it can also
+// shorten a link. Fields are validated as on creation and every problem is
+// reported in one ValidationError. Links the caller in ctx may not access
+// return ErrShortCodeNotFound. The change is logged as an audit entry.
+func (s *URLService) UpdateShortURL(ctx context.Context, shortCode string, req UpdateShortURLRequest) (*UpdateShortURLResponse, error) {
+	shortCode = s.normalizeCode(shortCode)
+	ctx, span := tracer.Start(ctx, "URLService.UpdateShortURL", trace.WithAttributes(attribute.String("trimurl.shortcode", shortCode)))
+	defer span.End()
+	s.logger.LogContext(ctx, BackendStack, InfoLevel, ServicePackage, fmt.Sprintf("Updating %s", shortCode))
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if req.URL == "" && req.Validity == 0 && req.ExpiresIn == "" {
+		return nil, fmt.Errorf("at least one of url, validity or expiresIn is required")
+	}
+
+	var validation ValidationError
+
+	var originalURL string
+	if req.URL != "" {
+		normalized, err := s.normalizeURL(req.URL)
+		if err != nil {
+			validation.Add("url", fmt.Sprintf("invalid URL: %v", err))
+		} else if s.pointsAtService(normalized) {
+			validation.Add("url", fmt.Sprintf("URL must not point at this service (%s); shorten the destination instead", s.baseHost))
+		} else if err := s.checkDestination(ctx, normalized); err != nil {
+			validation.Add("url", err.Error())
+		}
+		originalURL = normalized
+	}
+
+	var expiresIn time.Duration
+	maxValidity := s.settings.Load().maxValidity
+	switch {
+	case req.Validity != 0 && req.ExpiresIn != "":
+		validation.Add("expiresIn", "give either validity or expiresIn, not both")
+	case req.ExpiresIn != "":
+		duration, err := parseExpiresIn(req.ExpiresIn, time.Duration(maxValidity)*time.Minute)
+		if err != nil {
+			validation.Add("expiresIn", err.Error())
+		}
+		expiresIn = duration
+	case req.Validity < 0:
+		validation.Add("validity", "validity must be a positive number of minutes")
+	case req.Validity > maxValidity:
+		validation.Add("validity", fmt.Sprintf("validity must be at most %d minutes", maxValidity))
+	}
+
+	if len(validation.Errors) > 0 {
+		s.logger.LogContext(ctx, BackendStack, ErrorLevel, DomainPackage, fmt.Sprintf("Invalid update for %s: %v", shortCode, &validation))
+		return nil, &validation
+	}
+	if !s.validSignature(shortCode) {
+		return nil, ErrShortCodeNotFound
+	}
 
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
-	shortURL, exists := s.urls[shortCode]
-	if !exists {
-		return fmt.Errorf("shortcode not found")
+	storeSpan := startStoreSpan(ctx, "Get", shortCode)
+	shortURL, err := s.liveEntry(shortCode)
+	endStoreSpan(storeSpan, err)
+	if err != nil {
+		s.logger.LogContext(ctx, BackendStack, ErrorLevel, DomainPackage, fmt.Sprintf("Update lookup failed for %s: %v", shortCode, err))
+		return nil, err
+	}
+	if !canAccess(ctx, shortURL) {
+		s.logger.LogContext(ctx, BackendStack, WarnLevel, DomainPackage, fmt.Sprintf("Update of %s refused: not the owner", shortCode))
+		return nil, ErrShortCodeNotFound
+	}
+
+	now := s.clock.Now()
+	if now.After(shortURL.ExpiresAt) {
+		s.logger.LogContext(ctx, BackendStack, WarnLevel, DomainPackage, fmt.Sprintf("Cannot update expired shortcode: %s", shortCode))
+		return nil, ErrShortCodeExpired
+	}
+
+	updated := *shortURL
+	if originalURL != "" {
+		updated.OriginalURL = originalURL
+	}
+	switch {
+	case expiresIn > 0:
+		updated.ExpiresAt = now.Add(expiresIn)
+	case req.Validity > 0 && !isPermanent(shortURL):
+		// Extending a permanent link leaves it permanent
+		updated.ExpiresAt = shortURL.ExpiresAt.Add(time.Duration(req.Validity) * time.Minute)
+	}
+
+	storeSpan = startStoreSpan(ctx, "Put", shortCode)
+	err = s.store.Put(&updated)
+	endStoreSpan(storeSpan, err)
+	if err != nil {
+		s.logger.LogContext(ctx, BackendStack, ErrorLevel, RepositoryPackage, fmt.Sprintf("Failed to persist update for %s: %v", shortCode, err))
+		return nil, fmt.Errorf("failed to update short URL: %v", err)
 	}
 
-	// Record the click
-	click := Click{
-		Timestamp: time.Now(),
-		Source:    source,
-		Location:  location,
+	s.logger.LogContext(ctx, BackendStack, InfoLevel, ServicePackage, fmt.Sprintf("Audit: %s updated: url %s -> %s, expiry %s -> %s",
+		shortCode, shortURL.OriginalURL, updated.OriginalURL, shortURL.ExpiresAt.Format(time.RFC3339), updated.ExpiresAt.Format(time.RFC3339)))
+
+	return &UpdateShortURLResponse{
+		URL:    updated.OriginalURL,
+		Expiry: updated.ExpiresAt.Format(time.RFC3339),
+	}, nil
+}
+
+// DeleteShortURL moves a link, expired or not, to the trash, where it stops
+// redirecting and counts as not found but can be restored with its stats
+// until the trash retention passes. With no trash retention the link and its
+// stats are removed at once. Links the caller in ctx may not access return
+// ErrShortCodeNotFound. The deletion is logged as an audit entry.
+func (s *URLService) DeleteShortURL(ctx context.Context, shortCode string) error {
+	shortCode = s.normalizeCode(shortCode)
+	ctx, span := tracer.Start(ctx, "URLService.DeleteShortURL", trace.WithAttributes(attribute.String("trimurl.shortcode", shortCode)))
+	defer span.End()
+	s.logger.LogContext(ctx, BackendStack, InfoLevel, ServicePackage, fmt.Sprintf("Deleting %s", shortCode))
+
+	if err := ctx.Err(); err != nil {
+		return err
 	}
+	if !s.validSignature(shortCode) {
+		return ErrShortCodeNotFound
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
 
-	shortURL.ClickCount++
-	shortURL.ClickHistory = append(shortURL.ClickHistory, click)
+	storeSpan := startStoreSpan(ctx, "Get", shortCode)
+	shortURL, err := s.liveEntry(shortCode)
+	endStoreSpan(storeSpan, err)
+	if err != nil {
+		s.logger.LogContext(ctx, BackendStack, ErrorLevel, DomainPackage, fmt.Sprintf("Delete lookup failed for %s: %v", shortCode, err))
+		return err
+	}
+	if !canAccess(ctx, shortURL) {
+		s.logger.LogContext(ctx, BackendStack, WarnLevel, DomainPackage, fmt.Sprintf("Delete of %s refused: not the owner", shortCode))
+		return ErrShortCodeNotFound
+	}
 
-	s.logger.Log(BackendStack, InfoLevel, ServicePackage, fmt.Sprintf("Click recorded for %s (total: %d)", shortCode, shortURL.ClickCount))
+	trashRetention := s.settings.Load().trashRetention
+	if trashRetention > 0 {
+		trashed := *shortURL
+		trashed.DeletedAt = s.clock.Now()
+		storeSpan = startStoreSpan(ctx, "Put", shortCode)
+		err = s.store.Put(&trashed)
+	} else {
+		storeSpan = startStoreSpan(ctx, "Delete", shortCode)
+		err = s.store.Delete(shortCode)
+	}
+	endStoreSpan(storeSpan, err)
+	if err != nil {
+		s.logger.LogContext(ctx, BackendStack, ErrorLevel, RepositoryPackage, fmt.Sprintf("Failed to delete %s: %v", shortCode, err))
+		return fmt.Errorf("failed to delete short URL: %v", err)
+	}
+	s.attemptsMu.Lock()
+	delete(s.passwordAttempts, shortCode)
+	s.attemptsMu.Unlock()
 
+	if trashRetention > 0 {
+		s.logger.LogContext(ctx, BackendStack, InfoLevel, ServicePackage, fmt.Sprintf("Audit: %s moved to the trash for %s (url %s, %d clicks)", shortCode, trashRetention, shortURL.OriginalURL, shortURL.ClickCount))
+	} else {
+		s.logger.LogContext(ctx, BackendStack, InfoLevel, ServicePackage, fmt.Sprintf("Audit: %s deleted (url %s, %d clicks)", shortCode, shortURL.OriginalURL, shortURL.ClickCount))
+	}
 	return nil
 }
 
-// GetStats retrieves statistics for a short URL
-func (s *URLService) GetStats(shortCode string) (*ShortURLStats, error) {
-	s.logger.Log(BackendStack, InfoLevel, ServicePackage, fmt.Sprintf("Retrieving stats for: %s", shortCode))
+// SetEnabled disables or re-enables a link without touching its stats
+func (s *URLService) SetEnabled(shortCode string, enabled bool) error {
+	shortCode = s.normalizeCode(shortCode)
+	s.logger.Log(BackendStack, InfoLevel, ServicePackage, fmt.Sprintf("Setting %s enabled=%t", shortCode, enabled))
 
-	s.mutex.RLock()
-	defer s.mutex.RUnlock()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
 
-	shortURL, exists := s.urls[shortCode]
-	if !exists {
-		s.logger.Log(BackendStack, ErrorLevel, DomainPackage, fmt.Sprintf("Shortcode not found for stats: %s", shortCode))
-		return nil, fmt.Errorf("shortcode not found")
+	shortURL, err := s.liveEntry(shortCode)
+	if err != nil {
+		s.logger.Log(BackendStack, ErrorLevel, DomainPackage, fmt.Sprintf("Enable lookup failed for %s: %v", shortCode, err))
+		return err
 	}
 
-	return &ShortURLStats{
-		TotalClicks: shortURL.ClickCount,
-		CreatedAt:   shortURL.CreatedAt,
-		ExpiresAt:   shortURL.ExpiresAt,
-		Clicks:      shortURL.ClickHistory,
-	}, nil
+	shortURL.Disabled = !enabled
+	if err := s.store.Put(shortURL); err != nil {
+		s.logger.Log(BackendStack, ErrorLevel, RepositoryPackage, fmt.Sprintf("Failed to persist enabled flag for %s: %v", shortCode, err))
+		return fmt.Errorf("failed to update short URL: %v", err)
+	}
+
+	return nil
+}
+
+// aggregateUserAgents counts clicks per user agent, grouping clicks without one as "unknown"
+func aggregateUserAgents(clicks []Click) map[string]int {
+	counts := make(map[string]int)
+	for _, click := range clicks {
+		userAgent := click.UserAgent
+		if userAgent == "" {
+			userAgent = "unknown"
+		}
+		counts[userAgent]++
+	}
+	return counts
+}
+
+// urlScheme returns the lowercased scheme a URL starts with, or "" if it has
+// none. A colon followed by a digit is a port ("example.com:8080"), not a scheme.
+func urlScheme(rawURL string) string {
+	colon := strings.IndexByte(rawURL, ':')
+	if colon <= 0 || (colon+1 < len(rawURL) && rawURL[colon+1] >= '0' && rawURL[colon+1] <= '9') {
+		return ""
+	}
+	for i, c := range rawURL[:colon] {
+		isLetter := (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+		if !isLetter && (i == 0 || !(c >= '0' && c <= '9' || c == '+' || c == '-' || c == '.')) {
+			return ""
+		}
+	}
+	return strings.ToLower(rawURL[:colon])
 }
 
-// validateURL validates if a URL is properly formatted
-func (s *URLService) validateURL(rawURL string) error {
+// normalizeURL validates a URL and returns its normalized absolute form:
+// scheme added if missing, scheme and host lowercased, default ports stripped.
+// Only http and https are accepted, so links cannot redirect to javascript:,
+// data: or other dangerous schemes.
+func (s *URLService) normalizeURL(rawURL string) (string, error) {
 	if rawURL == "" {
-		return fmt.Errorf("URL cannot be empty")
+		return "", fmt.Errorf("URL cannot be empty")
 	}
 
 	// Add protocol if missing
-	if !strings.HasPrefix(rawURL, "http://") && !strings.HasPrefix(rawURL, "https://") {
+	switch scheme := urlScheme(rawURL); scheme {
+	case "":
 		rawURL = "https://" + rawURL
+	case "http", "https":
+	default:
+		return "", fmt.Errorf("unsupported scheme %q: only http and https are allowed", scheme)
+	}
+
+	parsed, err := url.Parse(rawURL)
+	if err != nil || parsed.Host == "" {
+		return "", fmt.Errorf("invalid URL format")
+	}
+
+	parsed.Scheme = strings.ToLower(parsed.Scheme)
+
+	host := strings.ToLower(parsed.Hostname())
+	port := parsed.Port()
+	if (parsed.Scheme == "http" && port == "80") || (parsed.Scheme == "https" && port == "443") {
+		port = ""
+	}
+	if port != "" {
+		host = net.JoinHostPort(host, port)
+	} else if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	parsed.Host = host
+
+	if parsed.Path == "" {
+		parsed.Path = "/"
+	}
+
+	return parsed.String(), nil
+}
+
+// validateRedirectStatus accepts the redirect codes a link may use. 301 and
+// 308 are permanent: browsers and proxies cache them and stop asking, which
+// saves round trips but means repeat visits are no longer counted as clicks
+// and a renewed or expired link keeps redirecting from cache. 302 and 307
+// reach the server on every visit, so click counts stay accurate.
+func validateRedirectStatus(status int) error {
+	switch status {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return nil
+	}
+	return fmt.Errorf("redirect status must be 301, 302, 307 or 308")
+}
+
+// parseExpiresIn parses a positive duration of at most max, such as "90m",
+// "24h" or "7d". Besides the units time.ParseDuration accepts, a leading whole
+// number of days may be given, optionally followed by a Go duration ("1d12h").
+func parseExpiresIn(value string, max time.Duration) (time.Duration, error) {
+	tooLong := fmt.Errorf("expiresIn must be at most %d minutes", int(max.Minutes()))
+
+	var days time.Duration
+	rest := value
+	if i := strings.IndexByte(value, 'd'); i >= 0 {
+		count, err := strconv.Atoi(value[:i])
+		switch {
+		case err != nil:
+			return 0, fmt.Errorf("expiresIn %q must be a duration such as 90m, 24h or 7d", value)
+		case count < 0:
+			return 0, fmt.Errorf("expiresIn must be positive")
+		case time.Duration(count) > max/(24*time.Hour):
+			return 0, tooLong
+		}
+		days = time.Duration(count) * 24 * time.Hour
+		rest = value[i+1:]
+		if rest == "" {
+			rest = "0s"
+		}
 	}
 
-	_, err := url.Parse(rawURL)
+	duration, err := time.ParseDuration(rest)
 	if err != nil {
-		return fmt.Errorf("invalid URL format")
+		return 0, fmt.Errorf("expiresIn %q must be a duration such as 90m, 24h or 7d", value)
+	}
+	if duration < 0 || days+duration <= 0 {
+		return 0, fmt.Errorf("expiresIn must be positive")
+	}
+	if days+duration > max {
+		return 0, tooLong
 	}
+	return days + duration, nil
+}
 
-	return nil
+// validateMetadata checks the optional title and description against their
+// length limits, returning one FieldError per field that is too long
+func validateMetadata(title, description string) []FieldError {
+	var errs []FieldError
+	if utf8.RuneCountInString(title) > maxTitleLength {
+		errs = append(errs, FieldError{Field: "title", Message: fmt.Sprintf("title must be at most %d characters", maxTitleLength)})
+	}
+	if utf8.RuneCountInString(description) > maxDescriptionLength {
+		errs = append(errs, FieldError{Field: "description", Message: fmt.Sprintf("description must be at most %d characters", maxDescriptionLength)})
+	}
+	return errs
 }
 
-// validateShortCode validates if a shortcode is valid
+// validateShortCode validates if a shortcode is valid. Length is counted in
+// characters, not bytes, and the first disallowed character is named.
 func (s *URLService) validateShortCode(shortCode string) error {
-	if len(shortCode) < 4 || len(shortCode) > 20 {
-		return fmt.Errorf("shortcode must be 4-20 characters")
+	if !utf8.ValidString(shortCode) {
+		return fmt.Errorf("shortcode is not valid UTF-8")
 	}
 
-	// Check if alphanumeric
+	if length := utf8.RuneCountInString(shortCode); length < minShortCodeLength || length > maxShortCodeLength {
+		return fmt.Errorf("shortcode must be %d-%d characters, got %d", minShortCodeLength, maxShortCodeLength, length)
+	}
+
+	// Check if alphanumeric or part of the configured alphabet
+	position := 0
 	for _, char := range shortCode {
-		if !((char >= 'a' && char <= 'z') || (char >= 'A' && char <= 'Z') || (char >= '0' && char <= '9')) {
-			return fmt.Errorf("shortcode must be alphanumeric")
+		position++
+		if !((char >= 'a' && char <= 'z') || (char >= 'A' && char <= 'Z') || (char >= '0' && char <= '9')) && !strings.ContainsRune(s.codeAlphabet, char) {
+			return fmt.Errorf("shortcode character %q at position %d is not allowed: use alphanumerics or characters from the configured alphabet", char, position)
 		}
 	}
 
+	if isReservedShortCode(shortCode) {
+		return fmt.Errorf("shortcode %q is reserved for an API route", shortCode)
+	}
+	if s.settings.Load().reservedCodes[strings.ToLower(shortCode)] {
+		return fmt.Errorf("shortcode %q is reserved", shortCode)
+	}
+
 	return nil
 }
 
-// generateShortCode generates a unique shortcode
-func (s *URLService) generateShortCode() string {
-	for {
-		bytes := make([]byte, 4)
-		rand.Read(bytes)
-		shortCode := hex.EncodeToString(bytes)[:8]
-
-		if !s.shortCodeExists(shortCode) {
-			return shortCode
+// isReservedShortCode reports whether a shortcode names an API route. The
+// comparison ignores case so a code cannot shadow a route once lowercased.
+func isReservedShortCode(shortCode string) bool {
+	for _, reserved := range reservedShortCodes {
+		if strings.EqualFold(shortCode, reserved) {
+			return true
 		}
 	}
+	return false
 }
 
-// shortCodeExists checks if a shortcode already exists
-func (s *URLService) shortCodeExists(shortCode string) bool {
-	s.mutex.RLock()
-	defer s.mutex.RUnlock()
+// normalizeCode lowercases a shortcode when the service is case-insensitive
+func (s *URLService) normalizeCode(shortCode string) string {
+	if s.lowerCodes {
+		return strings.ToLower(shortCode)
+	}
+	return shortCode
+}
 
-	_, exists := s.urls[shortCode]
-	return exists
+// shortCodeExists checks if a shortcode already exists
+func (s *URLService) shortCodeExists(shortCode string) (bool, error) {
+	return s.store.Exists(shortCode)
 }
@@ -0,0 +1,1281 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+// newTestLogger returns a logger that discards entries, keeping service and
+// handler tests off the network
+func newTestLogger(t *testing.T) LoggerInterface {
+	t.Helper()
+	return NoopLogger{}
+}
+
+// newTestService returns a URL service backed by a fresh in-memory store
+func newTestService(t *testing.T, config URLServiceConfig) *URLService {
+	t.Helper()
+
+	s, err := NewURLServiceWithConfig(newTestLogger(t), NewMemoryStore(), config)
+	if err != nil {
+		t.Fatalf("NewURLServiceWithConfig: %v", err)
+	}
+	return s
+}
+
+func TestNormalizeURL(t *testing.T) {
+	s := &URLService{}
+
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"scheme-less host", "example.com", "https://example.com/"},
+		{"scheme-less with trailing slash", "example.com/", "https://example.com/"},
+		{"path trailing slash preserved", "https://example.com/a/", "https://example.com/a/"},
+		{"uppercase scheme and host with default http port", "HTTP://Example.COM:80", "http://example.com/"},
+		{"default https port stripped and fragment kept", "https://x.com:443/a#frag", "https://x.com/a#frag"},
+		{"non-default port kept", "http://example.com:8080/x", "http://example.com:8080/x"},
+		{"IPv6 host with default port", "https://[2001:DB8::1]:443/p", "https://[2001:db8::1]/p"},
+		{"IPv6 host with custom port", "http://[::1]:8080", "http://[::1]:8080/"},
+		{"scheme-less host with port", "example.com:8080/x", "https://example.com:8080/x"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := s.normalizeURL(tt.input)
+			if err != nil {
+				t.Fatalf("normalizeURL(%q) returned error: %v", tt.input, err)
+			}
+			if got != tt.want {
+				t.Errorf("normalizeURL(%q) = %q, want %q", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormalizeURLRejectsInvalid(t *testing.T) {
+	s := &URLService{}
+
+	for _, input := range []string{"", "https://", "http://%zz"} {
+		if got, err := s.normalizeURL(input); err == nil {
+			t.Errorf("normalizeURL(%q) = %q, want error", input, got)
+		}
+	}
+}
+
+func TestNormalizeURLRejectsNonHTTPSchemes(t *testing.T) {
+	s := &URLService{}
+
+	for _, input := range []string{
+		"javascript:alert(1)",
+		"JavaScript:alert(1)",
+		"data:text/html;base64,PHNjcmlwdD4=",
+		"ftp://example.com/file",
+		"mailto:user@example.com",
+	} {
+		got, err := s.normalizeURL(input)
+		if err == nil || !strings.Contains(err.Error(), "only http and https are allowed") {
+			t.Errorf("normalizeURL(%q) = %q, %v; want unsupported scheme error", input, got, err)
+		}
+	}
+}
+
+func TestCaseInsensitiveCustomCodesCollide(t *testing.T) {
+	s := newTestService(t, URLServiceConfig{CaseInsensitive: true})
+
+	if _, err := s.CreateShortURL(context.Background(), CreateShortURLRequest{URL: "example.com", ShortCode: "AbCd"}); err != nil {
+		t.Fatalf("creating AbCd: %v", err)
+	}
+
+	_, err := s.CreateShortURL(context.Background(), CreateShortURLRequest{URL: "example.org", ShortCode: "abcd"})
+	if err == nil {
+		t.Fatal("creating abcd after AbCd succeeded, want collision")
+	}
+	if !strings.Contains(err.Error(), "case-insensitive") {
+		t.Errorf("collision error %q does not mention case-insensitivity", err)
+	}
+}
+
+func TestCaseInsensitiveLookup(t *testing.T) {
+	s := newTestService(t, URLServiceConfig{CaseInsensitive: true})
+
+	if _, err := s.CreateShortURL(context.Background(), CreateShortURLRequest{URL: "example.com", ShortCode: "AbCd"}); err != nil {
+		t.Fatalf("creating AbCd: %v", err)
+	}
+
+	got, err := s.GetOriginalURL(context.Background(), "ABCD")
+	if err != nil {
+		t.Fatalf("GetOriginalURL(ABCD): %v", err)
+	}
+	if got != "https://example.com/" {
+		t.Errorf("GetOriginalURL(ABCD) = %q, want https://example.com/", got)
+	}
+}
+
+func TestCaseSensitiveByDefault(t *testing.T) {
+	s := newTestService(t, URLServiceConfig{})
+
+	if _, err := s.CreateShortURL(context.Background(), CreateShortURLRequest{URL: "example.com", ShortCode: "AbCd"}); err != nil {
+		t.Fatalf("creating AbCd: %v", err)
+	}
+	if _, err := s.CreateShortURL(context.Background(), CreateShortURLRequest{URL: "example.org", ShortCode: "abcd"}); err != nil {
+		t.Errorf("creating abcd alongside AbCd: %v", err)
+	}
+	if _, err := s.GetOriginalURL(context.Background(), "ABCD"); !errors.Is(err, ErrShortCodeNotFound) {
+		t.Errorf("GetOriginalURL(ABCD) error = %v, want ErrShortCodeNotFound", err)
+	}
+}
+
+func TestCaseInsensitiveFoldsAlphabet(t *testing.T) {
+	s := newTestService(t, URLServiceConfig{CodeAlphabet: Base62Alphabet, CaseInsensitive: true})
+
+	if want := "0123456789abcdefghijklmnopqrstuvwxyz"; s.codeAlphabet != want {
+		t.Errorf("codeAlphabet = %q, want %q", s.codeAlphabet, want)
+	}
+}
+
+func TestReservedShortCodesRejected(t *testing.T) {
+	s := newTestService(t, URLServiceConfig{CodeAlphabet: pathSafeCodeChars})
+
+	for _, code := range []string{"health", "shorturls", "metrics", "openapi.json", "HEALTH", "restore", "preview"} {
+		_, err := s.CreateShortURL(context.Background(), CreateShortURLRequest{URL: "example.com", ShortCode: code})
+		if err == nil || !strings.Contains(err.Error(), "reserved") {
+			t.Errorf("CreateShortURL(%q) error = %v, want reserved shortcode error", code, err)
+		}
+	}
+}
+
+func TestConfiguredReservedCodes(t *testing.T) {
+	s := newTestService(t, URLServiceConfig{ReservedCodes: []string{" Acme ", "badword", ""}})
+	ctx := context.Background()
+
+	for _, code := range []string{"acme", "ACME", "BadWord"} {
+		if _, err := s.CreateShortURL(ctx, CreateShortURLRequest{URL: "example.com", ShortCode: code}); err == nil || !strings.Contains(err.Error(), "reserved") {
+			t.Errorf("CreateShortURL(%q) error = %v, want reserved shortcode error", code, err)
+		}
+	}
+	if got, _ := s.CheckAvailability(ctx, "acme"); got.Available {
+		t.Error("CheckAvailability reports a reserved word as available")
+	}
+	if _, err := s.CreateShortURL(ctx, CreateShortURLRequest{URL: "example.com", ShortCode: "acme2"}); err != nil {
+		t.Errorf("CreateShortURL(acme2) = %v, want only whole codes reserved", err)
+	}
+
+	// Reserving a word later keeps its existing link but blocks new ones
+	if _, err := s.CreateShortURL(ctx, CreateShortURLRequest{URL: "example.com", ShortCode: "later"}); err != nil {
+		t.Fatalf("CreateShortURL(later): %v", err)
+	}
+	if err := s.Reload(URLServiceConfig{ReservedCodes: []string{"later"}}); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	if _, err := s.ResolveShortURL(ctx, "later"); err != nil {
+		t.Errorf("ResolveShortURL(later) after reserving it = %v", err)
+	}
+	if err := s.validateShortCode("later"); err == nil {
+		t.Error("validateShortCode accepts a word reserved by Reload")
+	}
+	if err := s.validateShortCode("acme"); err != nil {
+		t.Errorf("validateShortCode(acme) after Reload dropped it = %v", err)
+	}
+}
+
+func TestCreateShortURLHonoursCancelledContext(t *testing.T) {
+	s := newTestService(t, URLServiceConfig{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := s.CreateShortURL(ctx, CreateShortURLRequest{URL: "example.com", ShortCode: "gone"}); !errors.Is(err, context.Canceled) {
+		t.Fatalf("CreateShortURL error = %v, want context.Canceled", err)
+	}
+	if count, _ := s.URLCount(); count != 0 {
+		t.Errorf("URLCount = %d after cancelled create, want 0", count)
+	}
+}
+
+func TestCreateShortURLDryRun(t *testing.T) {
+	s := newTestService(t, URLServiceConfig{})
+
+	resp, err := s.CreateShortURL(context.Background(), CreateShortURLRequest{URL: "example.com", ShortCode: "drafted", DryRun: true})
+	if err != nil {
+		t.Fatalf("dry run: %v", err)
+	}
+	if !resp.DryRun || !strings.HasSuffix(resp.ShortLink, "/drafted") {
+		t.Errorf("dry run response = %+v, want dryRun link ending in /drafted", resp)
+	}
+	if count, _ := s.URLCount(); count != 0 {
+		t.Errorf("URLCount = %d after dry run, want 0", count)
+	}
+
+	// The code is still free, so a real create succeeds
+	if _, err := s.CreateShortURL(context.Background(), CreateShortURLRequest{URL: "example.com", ShortCode: "drafted"}); err != nil {
+		t.Fatalf("create after dry run: %v", err)
+	}
+	if _, err := s.CreateShortURL(context.Background(), CreateShortURLRequest{URL: "example.com", ShortCode: "drafted", DryRun: true}); !errors.Is(err, ErrShortCodeExists) {
+		t.Errorf("dry run on taken code error = %v, want ErrShortCodeExists", err)
+	}
+}
+
+func TestLastAccessedAt(t *testing.T) {
+	s := newTestService(t, URLServiceConfig{})
+	ctx := context.Background()
+
+	if _, err := s.CreateShortURL(ctx, CreateShortURLRequest{URL: "example.com", ShortCode: "seen"}); err != nil {
+		t.Fatalf("CreateShortURL: %v", err)
+	}
+
+	stats, err := s.GetStats(ctx, "seen")
+	if err != nil {
+		t.Fatalf("GetStats: %v", err)
+	}
+	if stats.LastAccessedAt != nil {
+		t.Errorf("LastAccessedAt = %v before any click, want nil", stats.LastAccessedAt)
+	}
+
+	before := time.Now()
+	if err := s.RecordClick(ctx, "seen", Click{Source: "direct", UserAgent: "test"}); err != nil {
+		t.Fatalf("RecordClick: %v", err)
+	}
+
+	stats, err = s.GetStats(ctx, "seen")
+	if err != nil {
+		t.Fatalf("GetStats: %v", err)
+	}
+	if stats.LastAccessedAt == nil || stats.LastAccessedAt.Before(before) {
+		t.Errorf("LastAccessedAt = %v after click, want at or after %v", stats.LastAccessedAt, before)
+	}
+}
+
+func TestValidityBoundaries(t *testing.T) {
+	s := newTestService(t, URLServiceConfig{DefaultValidity: 24 * 60, MaxValidity: 7 * 24 * 60})
+	ctx := context.Background()
+
+	tests := []struct {
+		name     string
+		validity int
+		wantErr  bool
+		wantLife time.Duration
+	}{
+		{"default when omitted", 0, false, 24 * time.Hour},
+		{"default when negative", -5, false, 24 * time.Hour},
+		{"one minute", 1, false, time.Minute},
+		{"exactly the maximum", 7 * 24 * 60, false, 7 * 24 * time.Hour},
+		{"one over the maximum", 7*24*60 + 1, true, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := time.Now()
+			resp, err := s.CreateShortURL(ctx, CreateShortURLRequest{URL: "example.com", Validity: tt.validity})
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("CreateShortURL(validity %d) succeeded, want error", tt.validity)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("CreateShortURL(validity %d): %v", tt.validity, err)
+			}
+
+			expiry, err := time.Parse(time.RFC3339, resp.Expiry)
+			if err != nil {
+				t.Fatalf("parsing expiry %q: %v", resp.Expiry, err)
+			}
+			// Expiry is formatted to the second
+			want := before.Add(tt.wantLife).Truncate(time.Second)
+			if expiry.Before(want) || expiry.After(want.Add(2*time.Second)) {
+				t.Errorf("expiry = %v, want about %v", expiry, want)
+			}
+		})
+	}
+}
+
+func TestValidityConfigRejected(t *testing.T) {
+	tests := []struct {
+		name   string
+		config URLServiceConfig
+	}{
+		{"maximum above the hard cap", URLServiceConfig{MaxValidity: maxValidityMinutes + 1}},
+		{"default above the maximum", URLServiceConfig{DefaultValidity: 120, MaxValidity: 60}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewURLServiceWithConfig(newTestLogger(t), NewMemoryStore(), tt.config); err == nil {
+				t.Error("NewURLServiceWithConfig succeeded, want error")
+			}
+		})
+	}
+}
+
+func TestRedirectTarget(t *testing.T) {
+	tests := []struct {
+		name         string
+		serviceWide  bool
+		linkForwards bool
+		original     string
+		query        string
+		want         string
+	}{
+		{"off by default", false, false, "https://example.com/", "utm_source=x", "https://example.com/"},
+		{"per link", false, true, "https://example.com/", "utm_source=x", "https://example.com/?utm_source=x"},
+		{"service wide", true, false, "https://example.com/", "utm_source=x", "https://example.com/?utm_source=x"},
+		{"appends to existing query", false, true, "https://example.com/p?id=7", "utm_source=x", "https://example.com/p?id=7&utm_source=x"},
+		{"existing parameters win", false, true, "https://example.com/p?id=7", "id=8&ref=y", "https://example.com/p?id=7&ref=y"},
+		{"password never forwarded", false, true, "https://example.com/", "pw=secret", "https://example.com/"},
+		{"fragment kept", false, true, "https://example.com/a#top", "utm_source=x", "https://example.com/a?utm_source=x#top"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &URLService{}
+			s.settings.Store(&serviceSettings{forwardQuery: tt.serviceWide})
+			query, _ := url.ParseQuery(tt.query)
+
+			got := s.RedirectTarget(&ShortURL{OriginalURL: tt.original, ForwardQuery: tt.linkForwards}, query)
+			if got != tt.want {
+				t.Errorf("RedirectTarget(%q, %q) = %q, want %q", tt.original, tt.query, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateShortCodeMessages(t *testing.T) {
+	s := newTestService(t, URLServiceConfig{})
+
+	tests := []struct {
+		code string
+		want string
+	}{
+		{"ééé", "4-20 characters, got 3"},
+		{"éééé", "character 'é' at position 1"},
+		{"ab😀cd", "character '😀' at position 3"},
+		{"abc\x00d", `character '\x00' at position 4`},
+		{"abc\xffd", "not valid UTF-8"},
+	}
+
+	for _, tt := range tests {
+		err := s.validateShortCode(tt.code)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("validateShortCode(%q) = %v, want error containing %q", tt.code, err, tt.want)
+		}
+	}
+}
+
+// FuzzValidateShortCode checks that validation never panics and only accepts
+// 4-20 characters from the allowed set
+func FuzzValidateShortCode(f *testing.F) {
+	for _, seed := range []string{"abcd", "abc-def", "ééééé", "😀😀😀😀", "a\x00bcd", "\xff\xfe\xfd\xfc", "health", "ａｂｃｄ", "abcd\u200b", ""} {
+		f.Add(seed)
+	}
+
+	s := &URLService{codeAlphabet: Base62Alphabet + "-_"}
+	s.settings.Store(&serviceSettings{})
+	f.Fuzz(func(t *testing.T, code string) {
+		if err := s.validateShortCode(code); err != nil {
+			return
+		}
+		if n := utf8.RuneCountInString(code); n < 4 || n > 20 {
+			t.Fatalf("accepted %q with %d characters", code, n)
+		}
+		for _, char := range code {
+			if char >= utf8.RuneSelf || !strings.ContainsRune(Base62Alphabet+"-_", char) {
+				t.Fatalf("accepted %q containing %q", code, char)
+			}
+		}
+	})
+}
+
+func TestParseExpiresIn(t *testing.T) {
+	const max = 366 * 24 * time.Hour
+
+	valid := []struct {
+		input string
+		want  time.Duration
+	}{
+		{"90m", 90 * time.Minute},
+		{"24h", 24 * time.Hour},
+		{"7d", 7 * 24 * time.Hour},
+		{"1d12h", 36 * time.Hour},
+		{"366d", max},
+	}
+	for _, tt := range valid {
+		if got, err := parseExpiresIn(tt.input, max); err != nil || got != tt.want {
+			t.Errorf("parseExpiresIn(%q) = %v, %v; want %v", tt.input, got, err, tt.want)
+		}
+	}
+
+	invalid := []struct {
+		input string
+		want  string
+	}{
+		{"soon", "must be a duration"},
+		{"7 days", "must be a duration"},
+		{"d", "must be a duration"},
+		{"-1h", "must be positive"},
+		{"-2d", "must be positive"},
+		{"1d-1h", "must be positive"},
+		{"0s", "must be positive"},
+		{"367d", "at most"},
+		{"99999999999d", "at most"},
+	}
+	for _, tt := range invalid {
+		if got, err := parseExpiresIn(tt.input, max); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("parseExpiresIn(%q) = %v, %v; want error containing %q", tt.input, got, err, tt.want)
+		}
+	}
+}
+
+func TestExpiresInOverridesValidity(t *testing.T) {
+	s := newTestService(t, URLServiceConfig{})
+	ctx := context.Background()
+
+	if _, err := s.CreateShortURL(ctx, CreateShortURLRequest{URL: "example.com", ShortCode: "weekly", Validity: 5, ExpiresIn: "7d"}); err != nil {
+		t.Fatalf("CreateShortURL: %v", err)
+	}
+	stats, err := s.GetStats(ctx, "weekly")
+	if err != nil {
+		t.Fatalf("GetStats: %v", err)
+	}
+	if lifetime := stats.ExpiresAt.Sub(stats.CreatedAt); lifetime != 7*24*time.Hour {
+		t.Errorf("lifetime = %v, want 168h", lifetime)
+	}
+
+	_, err = s.CreateShortURL(ctx, CreateShortURLRequest{URL: "example.com", ExpiresIn: "-1h"})
+	var validation *ValidationError
+	if !errors.As(err, &validation) || validation.Errors[0].Field != "expiresIn" {
+		t.Errorf("CreateShortURL with negative expiresIn = %v, want an expiresIn field error", err)
+	}
+}
+
+func TestRejectsLinksToTheService(t *testing.T) {
+	s := newTestService(t, URLServiceConfig{BaseURL: "https://sho.rt"})
+	ctx := context.Background()
+
+	for _, target := range []string{"https://sho.rt/abc123", "http://SHO.RT:80/abc123", "sho.rt"} {
+		_, err := s.CreateShortURL(ctx, CreateShortURLRequest{URL: target})
+		var validation *ValidationError
+		if !errors.As(err, &validation) || !strings.Contains(err.Error(), "must not point at this service") {
+			t.Errorf("CreateShortURL(%q) = %v, want a self-reference validation error", target, err)
+		}
+	}
+
+	// Other hosts, including subdomains and other ports, are fine
+	for _, target := range []string{"https://www.sho.rt/", "https://sho.rt:8443/"} {
+		resp, err := s.CreateShortURL(ctx, CreateShortURLRequest{URL: target})
+		if err != nil {
+			t.Errorf("CreateShortURL(%q) = %v, want success", target, err)
+			continue
+		}
+		if !strings.HasPrefix(resp.ShortLink, "https://sho.rt/") {
+			t.Errorf("ShortLink = %q, want it on the configured base URL", resp.ShortLink)
+		}
+	}
+
+	if _, err := NewURLServiceWithConfig(newTestLogger(t), NewMemoryStore(), URLServiceConfig{BaseURL: "ftp://sho.rt"}); err == nil {
+		t.Error("NewURLServiceWithConfig accepted a non-HTTP base URL")
+	}
+}
+
+func TestLinkMetadata(t *testing.T) {
+	s := newTestService(t, URLServiceConfig{})
+	ctx := context.Background()
+
+	req := CreateShortURLRequest{URL: "example.com", ShortCode: "labeled", Title: "Spring sale", Description: "Newsletter link"}
+	if _, err := s.CreateShortURL(ctx, req); err != nil {
+		t.Fatalf("CreateShortURL: %v", err)
+	}
+	stats, err := s.GetStats(ctx, "labeled")
+	if err != nil {
+		t.Fatalf("GetStats: %v", err)
+	}
+	if stats.Title != req.Title || stats.Description != req.Description {
+		t.Errorf("stats metadata = %q / %q, want %q / %q", stats.Title, stats.Description, req.Title, req.Description)
+	}
+
+	_, err = s.CreateShortURL(ctx, CreateShortURLRequest{
+		URL:         "example.com",
+		Title:       strings.Repeat("é", maxTitleLength+1),
+		Description: strings.Repeat("x", maxDescriptionLength+1),
+	})
+	var validation *ValidationError
+	if !errors.As(err, &validation) || len(validation.Errors) != 2 {
+		t.Fatalf("CreateShortURL with long metadata = %v, want title and description errors", err)
+	}
+	if validation.Errors[0].Field != "title" || validation.Errors[1].Field != "description" {
+		t.Errorf("fields = %+v, want title then description", validation.Errors)
+	}
+}
+
+func TestCheckAvailability(t *testing.T) {
+	s := newTestService(t, URLServiceConfig{})
+	ctx := context.Background()
+
+	if _, err := s.CreateShortURL(ctx, CreateShortURLRequest{URL: "example.com", ShortCode: "taken"}); err != nil {
+		t.Fatalf("CreateShortURL: %v", err)
+	}
+
+	tests := []struct {
+		code       string
+		available  bool
+		wantReason string
+	}{
+		{"free1", true, ""},
+		{"taken", false, "already taken"},
+		{"abc", false, "4-20 characters"},
+		{"no spaces", false, "alphanumeric"},
+		{"health", false, "reserved"},
+		{"check", false, "reserved"},
+	}
+
+	for _, tt := range tests {
+		got, err := s.CheckAvailability(ctx, tt.code)
+		if err != nil {
+			t.Fatalf("CheckAvailability(%q): %v", tt.code, err)
+		}
+		if got.Available != tt.available || !strings.Contains(got.Reason, tt.wantReason) {
+			t.Errorf("CheckAvailability(%q) = %+v, want available=%v with reason containing %q", tt.code, got, tt.available, tt.wantReason)
+		}
+	}
+}
+
+func TestClickHistoryRetentionCap(t *testing.T) {
+	s := newTestService(t, URLServiceConfig{MaxClickHistory: 3})
+	ctx := context.Background()
+	if _, err := s.CreateShortURL(ctx, CreateShortURLRequest{URL: "example.com", ShortCode: "viral"}); err != nil {
+		t.Fatalf("CreateShortURL: %v", err)
+	}
+	click := func(source string) {
+		t.Helper()
+		if err := s.RecordClick(ctx, "viral", Click{Source: source}); err != nil {
+			t.Fatalf("RecordClick: %v", err)
+		}
+	}
+	sources := func(clicks []Click) string {
+		names := make([]string, len(clicks))
+		for i, click := range clicks {
+			names[i] = click.Source
+		}
+		return strings.Join(names, ",")
+	}
+
+	for _, source := range []string{"a", "b", "c", "d"} {
+		click(source)
+	}
+	earlier, err := s.ClickHistory(ctx, "viral")
+	if err != nil {
+		t.Fatalf("ClickHistory: %v", err)
+	}
+	if got := sources(earlier); got != "b,c,d" {
+		t.Errorf("history = %s, want the 3 newest clicks b,c,d", got)
+	}
+
+	for _, source := range []string{"e", "f", "g"} {
+		click(source)
+	}
+	if got := sources(earlier); got != "b,c,d" {
+		t.Errorf("history read before later clicks changed to %s", got)
+	}
+	stats, err := s.GetStats(ctx, "viral")
+	if err != nil {
+		t.Fatalf("GetStats: %v", err)
+	}
+	if stats.TotalClicks != 7 || sources(stats.Clicks) != "e,f,g" {
+		t.Errorf("stats = %d clicks, history %s; want 7 counted and e,f,g kept", stats.TotalClicks, sources(stats.Clicks))
+	}
+
+	// A negative cap keeps every click
+	if err := s.Reload(URLServiceConfig{MaxClickHistory: -1}); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	click("h")
+	if history, _ := s.ClickHistory(ctx, "viral"); len(history) != 4 {
+		t.Errorf("history after lifting the cap has %d clicks, want 4", len(history))
+	}
+}
+
+func TestStatsForExpiredLink(t *testing.T) {
+	clock := NewFakeClock(time.Date(2024, 1, 20, 14, 0, 0, 0, time.UTC))
+	s := newTestService(t, URLServiceConfig{Clock: clock})
+	ctx := context.Background()
+
+	if _, err := s.CreateShortURL(ctx, CreateShortURLRequest{URL: "example.com", ShortCode: "lapsed"}); err != nil {
+		t.Fatalf("CreateShortURL: %v", err)
+	}
+	if err := s.RecordClick(ctx, "lapsed", Click{Source: "direct", UserAgent: "test"}); err != nil {
+		t.Fatalf("RecordClick: %v", err)
+	}
+
+	stats, err := s.GetStats(ctx, "lapsed")
+	if err != nil || stats.Expired {
+		t.Fatalf("GetStats before expiry = %+v, %v; want unexpired stats", stats, err)
+	}
+
+	clock.Advance(defaultValidityMinutes*time.Minute + time.Second)
+
+	stats, err = s.GetStats(ctx, "lapsed")
+	if err != nil {
+		t.Fatalf("GetStats after expiry: %v", err)
+	}
+	if !stats.Expired || stats.TotalClicks != 1 {
+		t.Errorf("GetStats after expiry = %+v, want expired with 1 click", stats)
+	}
+
+	if _, err := s.GetStats(ctx, "unknown"); !errors.Is(err, ErrShortCodeNotFound) {
+		t.Errorf("GetStats(unknown) error = %v, want ErrShortCodeNotFound", err)
+	}
+}
+
+// failingStore wraps MemoryStore and fails every write
+type failingStore struct {
+	*MemoryStore
+}
+
+func (f failingStore) Insert(shortURL *ShortURL) error {
+	return errors.New("store unavailable")
+}
+
+func (f failingStore) Put(shortURL *ShortURL) error {
+	return errors.New("store unavailable")
+}
+
+func (f failingStore) RecordClicks(shortCode string, clicks []Click, maxHistory int) (int, int, error) {
+	return 0, 0, errors.New("store unavailable")
+}
+
+func TestCreateShortURLPropagatesStoreErrors(t *testing.T) {
+	store := failingStore{NewMemoryStore()}
+	s, err := NewURLServiceWithConfig(newTestLogger(t), store, URLServiceConfig{})
+	if err != nil {
+		t.Fatalf("NewURLServiceWithConfig: %v", err)
+	}
+
+	if _, err := s.CreateShortURL(context.Background(), CreateShortURLRequest{URL: "example.com"}); err == nil {
+		t.Fatal("CreateShortURL succeeded with a failing store")
+	}
+	if count, _ := store.Count(); count != 0 {
+		t.Errorf("store holds %d entries after failed create, want 0", count)
+	}
+}
+
+func TestConcurrentCustomCodeCreation(t *testing.T) {
+	s := newTestService(t, URLServiceConfig{})
+
+	const workers = 50
+	var wg sync.WaitGroup
+	errs := make(chan error, workers)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := s.CreateShortURL(context.Background(), CreateShortURLRequest{URL: "example.com", ShortCode: "taken"})
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	succeeded := 0
+	for err := range errs {
+		switch {
+		case err == nil:
+			succeeded++
+		case !errors.Is(err, ErrShortCodeExists):
+			t.Errorf("unexpected error: %v", err)
+		}
+	}
+	if succeeded != 1 {
+		t.Errorf("%d creates succeeded, want exactly 1", succeeded)
+	}
+}
+
+func TestCheckPassword(t *testing.T) {
+	s := newTestService(t, URLServiceConfig{})
+
+	if _, err := s.CreateShortURL(context.Background(), CreateShortURLRequest{URL: "example.com", ShortCode: "open"}); err != nil {
+		t.Fatalf("creating open link: %v", err)
+	}
+	if _, err := s.CreateShortURL(context.Background(), CreateShortURLRequest{URL: "example.com", ShortCode: "locked", Password: "secret"}); err != nil {
+		t.Fatalf("creating protected link: %v", err)
+	}
+
+	if protected, err := s.CheckPassword("open", "anything"); protected || err != nil {
+		t.Errorf("CheckPassword(open) = %v, %v; want false, nil", protected, err)
+	}
+	if protected, err := s.CheckPassword("locked", ""); !protected || !errors.Is(err, ErrPasswordRequired) {
+		t.Errorf("CheckPassword(locked, empty) = %v, %v; want true, ErrPasswordRequired", protected, err)
+	}
+	if protected, err := s.CheckPassword("locked", "secret"); !protected || err != nil {
+		t.Errorf("CheckPassword(locked, secret) = %v, %v; want true, nil", protected, err)
+	}
+
+	for i := 0; i < maxPasswordFailures; i++ {
+		if _, err := s.CheckPassword("locked", "wrong"); !errors.Is(err, ErrInvalidPassword) {
+			t.Fatalf("wrong attempt %d: error = %v, want ErrInvalidPassword", i+1, err)
+		}
+	}
+	if _, err := s.CheckPassword("locked", "secret"); !errors.Is(err, ErrTooManyPasswordAttempts) {
+		t.Errorf("after %d failures error = %v, want ErrTooManyPasswordAttempts", maxPasswordFailures, err)
+	}
+}
+
+func TestExpiryWithFakeClock(t *testing.T) {
+	start := time.Date(2024, 1, 20, 14, 0, 0, 0, time.UTC)
+	clock := NewFakeClock(start)
+	s := newTestService(t, URLServiceConfig{Clock: clock})
+	ctx := context.Background()
+
+	if _, err := s.CreateShortURL(ctx, CreateShortURLRequest{URL: "example.com", ShortCode: "timed", Validity: 10}); err != nil {
+		t.Fatalf("CreateShortURL: %v", err)
+	}
+	if err := s.RecordClick(ctx, "timed", Click{Source: "direct"}); err != nil {
+		t.Fatalf("RecordClick: %v", err)
+	}
+
+	stats, err := s.GetStats(ctx, "timed")
+	if err != nil {
+		t.Fatalf("GetStats: %v", err)
+	}
+	if !stats.CreatedAt.Equal(start) || !stats.ExpiresAt.Equal(start.Add(10*time.Minute)) || !stats.Clicks[0].Timestamp.Equal(start) {
+		t.Errorf("stats times = created %v, expires %v, click %v; want all from the fake clock", stats.CreatedAt, stats.ExpiresAt, stats.Clicks[0].Timestamp)
+	}
+
+	// Expiry is exclusive of the exact ExpiresAt instant
+	clock.Advance(10 * time.Minute)
+	if _, err := s.ResolveShortURL(ctx, "timed"); err != nil {
+		t.Errorf("ResolveShortURL at ExpiresAt = %v, want still active", err)
+	}
+	clock.Advance(time.Nanosecond)
+	if _, err := s.ResolveShortURL(ctx, "timed"); !errors.Is(err, ErrShortCodeExpired) {
+		t.Errorf("ResolveShortURL after ExpiresAt = %v, want ErrShortCodeExpired", err)
+	}
+}
+
+func TestPasswordLockoutEndsWithFakeClock(t *testing.T) {
+	clock := NewFakeClock(time.Date(2024, 1, 20, 14, 0, 0, 0, time.UTC))
+	s := newTestService(t, URLServiceConfig{Clock: clock})
+
+	if _, err := s.CreateShortURL(context.Background(), CreateShortURLRequest{URL: "example.com", ShortCode: "locked", Password: "secret"}); err != nil {
+		t.Fatalf("creating protected link: %v", err)
+	}
+	for i := 0; i < maxPasswordFailures; i++ {
+		s.CheckPassword("locked", "wrong")
+	}
+	if _, err := s.CheckPassword("locked", "secret"); !errors.Is(err, ErrTooManyPasswordAttempts) {
+		t.Fatalf("during lockout error = %v, want ErrTooManyPasswordAttempts", err)
+	}
+
+	clock.Advance(passwordLockout)
+	if _, err := s.CheckPassword("locked", "secret"); err != nil {
+		t.Errorf("after lockout error = %v, want nil", err)
+	}
+}
+
+func TestConcurrentClicksOnOneCode(t *testing.T) {
+	s := newTestService(t, URLServiceConfig{})
+	ctx := context.Background()
+
+	if _, err := s.CreateShortURL(ctx, CreateShortURLRequest{URL: "example.com", ShortCode: "busy"}); err != nil {
+		t.Fatalf("CreateShortURL: %v", err)
+	}
+
+	const clicks = 200
+	var wg sync.WaitGroup
+	for i := 0; i < clicks; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := s.RecordClick(ctx, "busy", Click{Source: "direct", UserAgent: "test"}); err != nil {
+				t.Errorf("RecordClick: %v", err)
+			}
+			if _, err := s.GetStats(ctx, "busy"); err != nil {
+				t.Errorf("GetStats: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	stats, err := s.GetStats(ctx, "busy")
+	if err != nil {
+		t.Fatalf("GetStats: %v", err)
+	}
+	if stats.TotalClicks != clicks || len(stats.Clicks) != clicks {
+		t.Errorf("TotalClicks = %d with %d clicks in history, want %d of each", stats.TotalClicks, len(stats.Clicks), clicks)
+	}
+}
+
+func TestClickBudgetIsNotOvershot(t *testing.T) {
+	s := newTestService(t, URLServiceConfig{})
+	ctx := context.Background()
+
+	const budget = 10
+	if _, err := s.CreateShortURL(ctx, CreateShortURLRequest{URL: "example.com", ShortCode: "limited", MaxClicks: budget}); err != nil {
+		t.Fatalf("CreateShortURL: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	recorded, refused := 0, 0
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := s.RecordClick(ctx, "limited", Click{Source: "direct"})
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case err == nil:
+				recorded++
+			case errors.Is(err, ErrClickLimitReached):
+				refused++
+			default:
+				t.Errorf("RecordClick: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if recorded != budget || refused != 50-budget {
+		t.Errorf("recorded %d and refused %d clicks, want %d and %d", recorded, refused, budget, 50-budget)
+	}
+	if _, err := s.ResolveShortURL(ctx, "limited"); !errors.Is(err, ErrClickLimitReached) {
+		t.Errorf("ResolveShortURL after budget = %v, want ErrClickLimitReached", err)
+	}
+	if _, err := s.CreateShortURL(ctx, CreateShortURLRequest{URL: "example.com", MaxClicks: -1}); err == nil {
+		t.Error("CreateShortURL with negative maxClicks succeeded")
+	}
+}
+
+func TestScheduledActivation(t *testing.T) {
+	clock := NewFakeClock(time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC))
+	s := newTestService(t, URLServiceConfig{Clock: clock})
+	ctx := context.Background()
+
+	launch := clock.Now().Add(48 * time.Hour)
+	created, err := s.CreateShortURL(ctx, CreateShortURLRequest{URL: "example.com", ShortCode: "launch", ActivatesAt: launch.Format(time.RFC3339), ExpiresIn: "24h"})
+	if err != nil {
+		t.Fatalf("CreateShortURL: %v", err)
+	}
+	if created.ActivatesAt != launch.Format(time.RFC3339) || created.Expiry != launch.Add(24*time.Hour).Format(time.RFC3339) {
+		t.Errorf("created = %+v, want activation at %s and a lifetime counted from it", created, launch)
+	}
+	if _, err := s.ResolveShortURL(ctx, "launch"); !errors.Is(err, ErrShortCodeNotActive) {
+		t.Errorf("ResolveShortURL before activation = %v, want ErrShortCodeNotActive", err)
+	}
+
+	clock.Set(launch)
+	if _, err := s.ResolveShortURL(ctx, "launch"); err != nil {
+		t.Errorf("ResolveShortURL at activation = %v", err)
+	}
+	if stats, err := s.GetStats(ctx, "launch"); err != nil || stats.ActivatesAt == nil || !stats.ActivatesAt.Equal(launch) {
+		t.Errorf("GetStats = %v, %v, want activatesAt %s", stats, err, launch)
+	}
+
+	past, err := s.CreateShortURL(ctx, CreateShortURLRequest{URL: "example.com/past", ActivatesAt: clock.Now().Add(-time.Hour).Format(time.RFC3339)})
+	if err != nil || past.ActivatesAt != "" {
+		t.Errorf("CreateShortURL activating in the past = %+v, %v, want an active link", past, err)
+	}
+	for _, activatesAt := range []string{"tomorrow", clock.Now().AddDate(2, 0, 0).Format(time.RFC3339)} {
+		if _, err := s.CreateShortURL(ctx, CreateShortURLRequest{URL: "example.com", ActivatesAt: activatesAt}); err == nil || !strings.Contains(err.Error(), "activatesAt") {
+			t.Errorf("CreateShortURL(activatesAt %q) = %v, want an activatesAt validation error", activatesAt, err)
+		}
+	}
+}
+
+func TestPermanentLinks(t *testing.T) {
+	clock := NewFakeClock(time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC))
+	s := newTestService(t, URLServiceConfig{Clock: clock})
+	ctx := context.Background()
+
+	created, err := s.CreateShortURL(ctx, CreateShortURLRequest{URL: "example.com", ShortCode: "forever", Permanent: true})
+	if err != nil {
+		t.Fatalf("CreateShortURL: %v", err)
+	}
+	if !created.Permanent || created.Expiry != neverExpires.Format(time.RFC3339) {
+		t.Errorf("created = %+v, want a permanent link", created)
+	}
+
+	if _, err := s.UpdateShortURL(ctx, "forever", UpdateShortURLRequest{Validity: 60}); err != nil {
+		t.Fatalf("UpdateShortURL: %v", err)
+	}
+	clock.Advance(50 * 365 * 24 * time.Hour)
+	stats, err := s.GetStats(ctx, "forever")
+	if err != nil || !stats.Permanent || stats.Expired {
+		t.Errorf("GetStats after 50 years = %+v, %v, want permanent and not expired", stats, err)
+	}
+
+	for name, req := range map[string]CreateShortURLRequest{
+		"with validity":  {URL: "example.com", Permanent: true, Validity: 60},
+		"with expiresIn": {URL: "example.com", Permanent: true, ExpiresIn: "7d"},
+	} {
+		if _, err := s.CreateShortURL(ctx, req); err == nil || !strings.Contains(err.Error(), "permanent") {
+			t.Errorf("CreateShortURL %s = %v, want a permanent validation error", name, err)
+		}
+	}
+
+	if err := s.Reload(URLServiceConfig{NoPermanent: true}); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	if _, err := s.CreateShortURL(ctx, CreateShortURLRequest{URL: "example.com", Permanent: true}); err == nil || !strings.Contains(err.Error(), "disabled") {
+		t.Errorf("CreateShortURL with permanent links disabled = %v, want a validation error", err)
+	}
+}
+
+// BenchmarkRecordClickParallel records clicks concurrently, each goroutine on
+// its own shortcode, as redirects to unrelated links would
+func BenchmarkRecordClickParallel(b *testing.B) {
+	config := DefaultLoggerConfig()
+	config.Fallback = io.Discard
+	config.BufferSize = 1
+	logger := NewLoggerWithConfig("http://127.0.0.1:0", config)
+	defer logger.Close()
+
+	s, err := NewURLServiceWithConfig(logger, NewMemoryStore(), URLServiceConfig{})
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	const codes = 64
+	for i := 0; i < codes; i++ {
+		if _, err := s.CreateShortURL(context.Background(), CreateShortURLRequest{URL: "example.com", ShortCode: fmt.Sprintf("bench%02d", i)}); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	var next int32
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		code := fmt.Sprintf("bench%02d", atomic.AddInt32(&next, 1)%codes)
+		for pb.Next() {
+			s.RecordClick(context.Background(), code, Click{Source: "direct", UserAgent: "bench"})
+		}
+	})
+}
+
+func TestFindByOriginalURL(t *testing.T) {
+	store := NewMemoryStore()
+	store.Put(&ShortURL{ShortCode: "preexisting", OriginalURL: "https://example.com/page", ExpiresAt: time.Now().Add(time.Hour)})
+
+	s, err := NewURLServiceWithConfig(newTestLogger(t), store, URLServiceConfig{MaxURLs: 3})
+	if err != nil {
+		t.Fatalf("NewURLServiceWithConfig: %v", err)
+	}
+	ctx := context.Background()
+
+	for _, req := range []CreateShortURLRequest{
+		{URL: "HTTPS://Example.com/page", ShortCode: "second", Validity: 60},
+		{URL: "https://example.com/other", ShortCode: "other", Validity: 60},
+	} {
+		if _, err := s.CreateShortURL(ctx, req); err != nil {
+			t.Fatalf("CreateShortURL(%s): %v", req.ShortCode, err)
+		}
+	}
+
+	codes := func(rawURL string) []string {
+		t.Helper()
+		found, err := s.FindByOriginalURL(ctx, rawURL)
+		if err != nil {
+			t.Fatalf("FindByOriginalURL(%q): %v", rawURL, err)
+		}
+		var codes []string
+		for _, shortURL := range found {
+			codes = append(codes, shortURL.ShortCode)
+		}
+		sort.Strings(codes)
+		return codes
+	}
+
+	if got := codes("example.com/page"); !reflect.DeepEqual(got, []string{"preexisting", "second"}) {
+		t.Errorf("links to example.com/page = %v, want [preexisting second]", got)
+	}
+	if got := codes("https://nowhere.example/"); len(got) != 0 {
+		t.Errorf("links to an unknown URL = %v, want none", got)
+	}
+
+	// At capacity the soonest-expiring entry is evicted and leaves the index
+	if _, err := s.CreateShortURL(ctx, CreateShortURLRequest{URL: "https://example.com/new", ShortCode: "newest", Validity: 120}); err != nil {
+		t.Fatalf("CreateShortURL(newest): %v", err)
+	}
+	if got := codes("example.com/page"); len(got) != 1 {
+		t.Errorf("links to example.com/page after eviction = %v, want one left", got)
+	}
+
+	if imported, err := s.ImportShortURL(ctx, &ShortURL{ShortCode: "imported", OriginalURL: "https://example.com/other", ExpiresAt: time.Now().Add(3 * time.Hour)}); err != nil || !imported {
+		t.Fatalf("ImportShortURL = %t, %v", imported, err)
+	}
+	if got := codes("https://example.com/other"); !reflect.DeepEqual(got, []string{"imported", "other"}) {
+		t.Errorf("links to example.com/other after import = %v, want [imported other]", got)
+	}
+}
+
+func TestListShortURLsNewestFirst(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	s := newTestService(t, URLServiceConfig{Clock: clock})
+	for _, code := range []string{"older", "newer"} {
+		if _, err := s.CreateShortURL(context.Background(), CreateShortURLRequest{URL: "example.com/" + code, ShortCode: code}); err != nil {
+			t.Fatalf("CreateShortURL(%s): %v", code, err)
+		}
+		clock.Advance(time.Second)
+	}
+
+	summaries, _, err := s.ListShortURLs(context.Background(), ListOptions{})
+	if err != nil {
+		t.Fatalf("ListShortURLs: %v", err)
+	}
+	if len(summaries) != 2 || summaries[0].ShortCode != "newer" || summaries[1].ShortCode != "older" {
+		t.Errorf("ListShortURLs = %+v, want newer then older", summaries)
+	}
+}
+
+func TestListShortURLsPagesAndSorts(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	s := newTestService(t, URLServiceConfig{Clock: clock})
+	ctx := context.Background()
+	for i, code := range []string{"first", "second", "third"} {
+		if _, err := s.CreateShortURL(ctx, CreateShortURLRequest{URL: "example.com/" + code, ShortCode: code}); err != nil {
+			t.Fatalf("CreateShortURL(%s): %v", code, err)
+		}
+		for c := 0; c < 2-i; c++ {
+			if err := s.RecordClick(ctx, code, Click{Source: "test"}); err != nil {
+				t.Fatalf("RecordClick(%s): %v", code, err)
+			}
+		}
+		clock.Advance(time.Second)
+	}
+
+	codes := func(options ListOptions) ([]string, int) {
+		summaries, total, err := s.ListShortURLs(ctx, options)
+		if err != nil {
+			t.Fatalf("ListShortURLs(%+v): %v", options, err)
+		}
+		got := make([]string, len(summaries))
+		for i, summary := range summaries {
+			got[i] = summary.ShortCode
+		}
+		return got, total
+	}
+
+	if got, total := codes(ListOptions{Offset: 1, Limit: 1}); !reflect.DeepEqual(got, []string{"second"}) || total != 3 {
+		t.Errorf("second page = %v of %d, want [second] of 3", got, total)
+	}
+	if got, _ := codes(ListOptions{Sort: SortByClickCount}); !reflect.DeepEqual(got, []string{"first", "second", "third"}) {
+		t.Errorf("by click count = %v, want [first second third]", got)
+	}
+	if got, total := codes(ListOptions{Offset: 5}); len(got) != 0 || total != 3 {
+		t.Errorf("past the end = %v of %d, want nothing of 3", got, total)
+	}
+}
+
+func TestSignedShortCodes(t *testing.T) {
+	s := newTestService(t, URLServiceConfig{SigningSecret: "0123456789abcdef-test-secret"})
+	ctx := context.Background()
+
+	resp, err := s.CreateShortURL(ctx, CreateShortURLRequest{URL: "example.com"})
+	if err != nil {
+		t.Fatalf("CreateShortURL: %v", err)
+	}
+	code := resp.ShortCode
+	if len(code) != defaultCodeLength+signatureLength {
+		t.Fatalf("signed code %q has length %d, want %d", code, len(code), defaultCodeLength+signatureLength)
+	}
+	if _, err := s.GetOriginalURL(ctx, code); err != nil {
+		t.Fatalf("GetOriginalURL(%q): %v", code, err)
+	}
+
+	// Flip one character of the body and then of the signature
+	tamper := func(code string, i int) string {
+		b := []byte(code)
+		if b[i] == 'a' {
+			b[i] = 'b'
+		} else {
+			b[i] = 'a'
+		}
+		return string(b)
+	}
+	for _, forged := range []string{tamper(code, 0), tamper(code, len(code)-1), code[:defaultCodeLength], "short"} {
+		if _, err := s.GetOriginalURL(ctx, forged); !errors.Is(err, ErrShortCodeNotFound) {
+			t.Errorf("GetOriginalURL(%q) = %v, want ErrShortCodeNotFound", forged, err)
+		}
+	}
+
+	// A code from another secret does not verify even if it is stored
+	other := newTestService(t, URLServiceConfig{SigningSecret: "a-different-secret-entirely"})
+	if _, err := s.ImportShortURL(ctx, &ShortURL{ShortCode: other.signCode("deadbeef"), OriginalURL: "https://example.com", ExpiresAt: time.Now().Add(time.Hour)}); err == nil {
+		t.Error("ImportShortURL accepted a code signed with another secret")
+	}
+
+	if _, err := s.CreateShortURL(ctx, CreateShortURLRequest{URL: "example.com", ShortCode: "custom123"}); err == nil {
+		t.Error("CreateShortURL accepted a custom shortcode in signed mode")
+	}
+}
+
+func TestSigningSecretConfig(t *testing.T) {
+	for name, config := range map[string]URLServiceConfig{
+		"short secret":        {SigningSecret: "too-short"},
+		"code too long":       {SigningSecret: "0123456789abcdef-test-secret", CodeLength: 16},
+		"longest signed code": {SigningSecret: "0123456789abcdef-test-secret", CodeLength: 12},
+	} {
+		_, err := NewURLServiceWithConfig(newTestLogger(t), NewMemoryStore(), config)
+		if wantErr := name != "longest signed code"; (err != nil) != wantErr {
+			t.Errorf("%s: NewURLServiceWithConfig error = %v, want error %t", name, err, wantErr)
+		}
+	}
+}
+
+func TestUpdateShortURL(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	s := newTestService(t, URLServiceConfig{Clock: clock})
+	ctx := context.Background()
+	created, err := s.CreateShortURL(ctx, CreateShortURLRequest{URL: "example.com/old", ShortCode: "moving", Validity: 60})
+	if err != nil {
+		t.Fatalf("CreateShortURL: %v", err)
+	}
+	createdExpiry, _ := time.Parse(time.RFC3339, created.Expiry)
+
+	resp, err := s.UpdateShortURL(ctx, "moving", UpdateShortURLRequest{Validity: 30})
+	if err != nil {
+		t.Fatalf("UpdateShortURL(validity): %v", err)
+	}
+	if want := createdExpiry.Add(30 * time.Minute).Format(time.RFC3339); resp.Expiry != want {
+		t.Errorf("expiry after extending = %s, want %s", resp.Expiry, want)
+	}
+
+	resp, err = s.UpdateShortURL(ctx, "moving", UpdateShortURLRequest{URL: "example.com/new", ExpiresIn: "10m"})
+	if err != nil {
+		t.Fatalf("UpdateShortURL(url, expiresIn): %v", err)
+	}
+	if resp.URL != "https://example.com/new" || resp.Expiry != clock.Now().Add(10*time.Minute).Format(time.RFC3339) {
+		t.Errorf("update = %+v, want the new URL expiring in 10 minutes", resp)
+	}
+	if target, err := s.GetOriginalURL(ctx, "moving"); err != nil || target != "https://example.com/new" {
+		t.Errorf("GetOriginalURL after update = %q, %v", target, err)
+	}
+	if found, _ := s.FindByOriginalURL(ctx, "example.com/old"); len(found) != 0 {
+		t.Errorf("old destination still indexed: %v", found)
+	}
+	if found, _ := s.FindByOriginalURL(ctx, "example.com/new"); len(found) != 1 {
+		t.Errorf("new destination not indexed: %v", found)
+	}
+
+	for name, req := range map[string]UpdateShortURLRequest{
+		"bad url":        {URL: "ftp://example.com"},
+		"both durations": {Validity: 5, ExpiresIn: "1h"},
+		"bad duration":   {ExpiresIn: "soon"},
+		"too long":       {Validity: maxValidityMinutes + 1},
+	} {
+		var validation *ValidationError
+		if _, err := s.UpdateShortURL(ctx, "moving", req); !errors.As(err, &validation) {
+			t.Errorf("%s: UpdateShortURL = %v, want a ValidationError", name, err)
+		}
+	}
+	if _, err := s.UpdateShortURL(ctx, "moving", UpdateShortURLRequest{}); err == nil {
+		t.Error("UpdateShortURL accepted an empty update")
+	}
+	if _, err := s.UpdateShortURL(ctx, "missing", UpdateShortURLRequest{Validity: 5}); !errors.Is(err, ErrShortCodeNotFound) {
+		t.Errorf("UpdateShortURL(missing) = %v, want ErrShortCodeNotFound", err)
+	}
+
+	clock.Advance(11 * time.Minute)
+	if _, err := s.UpdateShortURL(ctx, "moving", UpdateShortURLRequest{Validity: 5}); !errors.Is(err, ErrShortCodeExpired) {
+		t.Errorf("UpdateShortURL after expiry = %v, want ErrShortCodeExpired", err)
+	}
+}
+
+func TestCreateShortURLsReportsEachItem(t *testing.T) {
+	s := newTestService(t, URLServiceConfig{})
+	reqs := []CreateShortURLRequest{
+		{URL: "example.com/a", ShortCode: "batchA"},
+		{URL: ""},
+		{URL: "example.com/b", ShortCode: "batchA"},
+	}
+	for i := 0; i < 20; i++ {
+		reqs = append(reqs, CreateShortURLRequest{URL: fmt.Sprintf("example.com/%d", i)})
+	}
+
+	results := s.CreateShortURLs(context.Background(), reqs)
+	if len(results) != len(reqs) {
+		t.Fatalf("got %d results for %d requests", len(results), len(reqs))
+	}
+	for i, result := range results {
+		if result.Index != i {
+			t.Errorf("result %d has index %d", i, result.Index)
+		}
+	}
+	if results[1].Created != nil || len(results[1].Details) != 1 || results[1].Details[0].Field != "url" {
+		t.Errorf("missing url result = %+v, want a url field error", results[1])
+	}
+	// The two items claiming batchA race; exactly one may win
+	if (results[0].Created == nil) == (results[2].Created == nil) {
+		t.Errorf("duplicate shortcode results = %+v and %+v, want one created and one failed", results[0], results[2])
+	}
+	created := 0
+	for _, result := range results[3:] {
+		if result.Created != nil {
+			created++
+		}
+	}
+	if created != 20 {
+		t.Errorf("created %d of 20 generated-code items", created)
+	}
+	if count, _ := s.URLCount(); count != 21 {
+		t.Errorf("stored %d links, want 21", count)
+	}
+}
+
+func TestReloadChangesSettingsForNewRequests(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	s := newTestService(t, URLServiceConfig{Clock: clock, DefaultValidity: 30})
+	ctx := context.Background()
+
+	if err := s.Reload(URLServiceConfig{DefaultValidity: 90, MaxValidity: 120, RedirectStatus: http.StatusMovedPermanently}); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	created, err := s.CreateShortURL(ctx, CreateShortURLRequest{URL: "example.com"})
+	if err != nil {
+		t.Fatalf("CreateShortURL: %v", err)
+	}
+	if want := clock.Now().Add(90 * time.Minute).Format(time.RFC3339); created.Expiry != want {
+		t.Errorf("expiry after reload = %s, want %s", created.Expiry, want)
+	}
+	if _, err := s.CreateShortURL(ctx, CreateShortURLRequest{URL: "example.com", Validity: 121}); err == nil {
+		t.Error("validity above the reloaded maximum was accepted")
+	}
+	if status := s.RedirectStatus(&ShortURL{}); status != http.StatusMovedPermanently {
+		t.Errorf("redirect status after reload = %d, want 301", status)
+	}
+
+	if err := s.Reload(URLServiceConfig{RedirectStatus: 200}); err == nil {
+		t.Error("Reload accepted an invalid redirect status")
+	}
+	if status := s.RedirectStatus(&ShortURL{}); status != http.StatusMovedPermanently {
+		t.Errorf("failed reload changed the redirect status to %d", status)
+	}
+
+	if _, err := s.CreateShortURL(ctx, CreateShortURLRequest{URL: "http://203.0.113.7/"}); err != nil {
+		t.Fatalf("CreateShortURL before blocking: %v", err)
+	}
+	blocked, err := ParseDestinationNetworks("203.0.113.0/24")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Reload(URLServiceConfig{Destinations: &DestinationPolicy{Deny: blocked}}); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	if _, err := s.CreateShortURL(ctx, CreateShortURLRequest{URL: "http://203.0.113.8/"}); !strings.Contains(fmt.Sprint(err), "not allowed") {
+		t.Errorf("CreateShortURL to a newly blocked network = %v, want it refused", err)
+	}
+}
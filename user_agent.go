@@ -0,0 +1,130 @@
+package main
+
+import "strings"
+
+// Device types a user agent is classified as
+const (
+	DeviceDesktop = "desktop"
+	DeviceMobile  = "mobile"
+	DeviceTablet  = "tablet"
+	DeviceBot     = "bot"
+)
+
+// unknownAgent names a browser, OS or device type that could not be recognised
+const unknownAgent = "unknown"
+
+// UserAgentInfo is what a User-Agent header says about the client
+type UserAgentInfo struct {
+	Browser string // e.g. "Chrome", "Safari", "curl"; "Other" when unrecognised
+	OS      string // e.g. "Windows", "iOS", "Android"; "Other" when unrecognised
+	Device  string // one of the Device* types, or "unknown"
+}
+
+// botMarkers are substrings of the lower-cased user agents of crawlers,
+// link previewers and HTTP libraries
+var botMarkers = []string{
+	"bot", "crawler", "spider", "slurp", "facebookexternalhit", "preview",
+	"curl/", "wget/", "python-requests", "go-http-client", "okhttp", "java/", "headless",
+}
+
+// browserMarkers identify browsers by a token in their user agent. Order
+// matters: Edge, Opera and Samsung Internet also claim to be Chrome, and
+// Chrome also claims to be Safari.
+var browserMarkers = []struct{ token, name string }{
+	{"Edg/", "Edge"},
+	{"EdgA/", "Edge"},
+	{"EdgiOS/", "Edge"},
+	{"Edge/", "Edge"},
+	{"OPR/", "Opera"},
+	{"SamsungBrowser/", "Samsung Internet"},
+	{"Firefox/", "Firefox"},
+	{"FxiOS/", "Firefox"},
+	{"CriOS/", "Chrome"},
+	{"Chrome/", "Chrome"},
+	{"Safari/", "Safari"},
+	{"MSIE ", "Internet Explorer"},
+	{"Trident/", "Internet Explorer"},
+	{"curl/", "curl"},
+	{"Wget/", "Wget"},
+}
+
+// osMarkers identify operating systems; Android precedes Linux and iOS
+// precedes macOS, since their user agents also mention the latter
+var osMarkers = []struct{ token, name string }{
+	{"Windows", "Windows"},
+	{"Android", "Android"},
+	{"iPhone", "iOS"},
+	{"iPad", "iOS"},
+	{"iPod", "iOS"},
+	{"CrOS", "ChromeOS"},
+	{"Mac OS X", "macOS"},
+	{"Macintosh", "macOS"},
+	{"Linux", "Linux"},
+}
+
+// ParseUserAgent classifies a User-Agent header by the tokens common
+// browsers, systems and bots send. It is a heuristic: clients may send
+// anything, and an empty header is unknown on every count.
+func ParseUserAgent(userAgent string) UserAgentInfo {
+	if strings.TrimSpace(userAgent) == "" {
+		return UserAgentInfo{Browser: unknownAgent, OS: unknownAgent, Device: unknownAgent}
+	}
+	info := UserAgentInfo{Browser: "Other", OS: "Other", Device: unknownAgent}
+	for _, marker := range browserMarkers {
+		if strings.Contains(userAgent, marker.token) {
+			info.Browser = marker.name
+			break
+		}
+	}
+	for _, marker := range osMarkers {
+		if strings.Contains(userAgent, marker.token) {
+			info.OS = marker.name
+			break
+		}
+	}
+
+	lower := strings.ToLower(userAgent)
+	switch {
+	case containsAny(lower, botMarkers):
+		info.Device = DeviceBot
+	case strings.Contains(userAgent, "iPad") || strings.Contains(lower, "tablet") ||
+		(info.OS == "Android" && !strings.Contains(userAgent, "Mobile")):
+		info.Device = DeviceTablet
+	case strings.Contains(userAgent, "Mobi") || info.OS == "iOS" || info.OS == "Android":
+		info.Device = DeviceMobile
+	case info.OS == "Windows" || info.OS == "macOS" || info.OS == "Linux" || info.OS == "ChromeOS":
+		info.Device = DeviceDesktop
+	}
+	return info
+}
+
+// containsAny reports whether s contains any of substrings
+func containsAny(s string, substrings []string) bool {
+	for _, substring := range substrings {
+		if strings.Contains(s, substring) {
+			return true
+		}
+	}
+	return false
+}
+
+// clickAgent returns the parsed user agent of a click, parsing the raw
+// header for clicks recorded before user agents were parsed on redirect
+func clickAgent(click Click) UserAgentInfo {
+	if click.Browser != "" {
+		return UserAgentInfo{Browser: click.Browser, OS: click.OS, Device: click.Device}
+	}
+	return ParseUserAgent(click.UserAgent)
+}
+
+// aggregateAgents counts clicks per browser, operating system and device type
+func aggregateAgents(clicks []Click) (browsers, operatingSystems, devices map[string]int) {
+	browsers, operatingSystems, devices = make(map[string]int), make(map[string]int), make(map[string]int)
+	for _, click := range clicks {
+		agent := clickAgent(click)
+		browsers[agent.Browser]++
+		operatingSystems[agent.OS]++
+		devices[agent.Device]++
+	}
+	return browsers, operatingSystems, devices
+}
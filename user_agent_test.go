@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestParseUserAgent(t *testing.T) {
+	tests := []struct {
+		userAgent string
+		want      UserAgentInfo
+	}{
+		{"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36", UserAgentInfo{"Chrome", "Windows", DeviceDesktop}},
+		{"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36 Edg/120.0.0.0", UserAgentInfo{"Edge", "Windows", DeviceDesktop}},
+		{"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.1 Safari/605.1.15", UserAgentInfo{"Safari", "macOS", DeviceDesktop}},
+		{"Mozilla/5.0 (X11; Linux x86_64; rv:121.0) Gecko/20100101 Firefox/121.0", UserAgentInfo{"Firefox", "Linux", DeviceDesktop}},
+		{"Mozilla/5.0 (iPhone; CPU iPhone OS 17_1 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) CriOS/120.0.6099.119 Mobile/15E148 Safari/604.1", UserAgentInfo{"Chrome", "iOS", DeviceMobile}},
+		{"Mozilla/5.0 (iPad; CPU OS 17_1 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.1 Mobile/15E148 Safari/604.1", UserAgentInfo{"Safari", "iOS", DeviceTablet}},
+		{"Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Mobile Safari/537.36", UserAgentInfo{"Chrome", "Android", DeviceMobile}},
+		{"Mozilla/5.0 (Linux; Android 13; SM-X700) AppleWebKit/537.36 (KHTML, like Gecko) SamsungBrowser/23.0 Chrome/115.0.0.0 Safari/537.36", UserAgentInfo{"Samsung Internet", "Android", DeviceTablet}},
+		{"Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)", UserAgentInfo{"Other", "Other", DeviceBot}},
+		{"curl/8.4.0", UserAgentInfo{"curl", "Other", DeviceBot}},
+		{"", UserAgentInfo{"unknown", "unknown", "unknown"}},
+	}
+	for _, tt := range tests {
+		if got := ParseUserAgent(tt.userAgent); got != tt.want {
+			t.Errorf("ParseUserAgent(%q) = %+v, want %+v", tt.userAgent, got, tt.want)
+		}
+	}
+}
+
+func TestStatsBreakDownUserAgents(t *testing.T) {
+	s := newTestService(t, URLServiceConfig{})
+	ctx := context.Background()
+	if _, err := s.CreateShortURL(ctx, CreateShortURLRequest{URL: "example.com", ShortCode: "agents"}); err != nil {
+		t.Fatalf("CreateShortURL: %v", err)
+	}
+	iPhone := "Mozilla/5.0 (iPhone; CPU iPhone OS 17_1 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.1 Mobile/15E148 Safari/604.1"
+	clicks := []Click{
+		{Source: "direct", UserAgent: iPhone, Browser: "Safari", OS: "iOS", Device: DeviceMobile},
+		// Recorded before user agents were parsed, so stats parse it
+		{Source: "direct", UserAgent: iPhone},
+		{Source: "direct", UserAgent: "curl/8.4.0"},
+	}
+	for _, click := range clicks {
+		if err := s.RecordClick(ctx, "agents", click); err != nil {
+			t.Fatalf("RecordClick: %v", err)
+		}
+	}
+
+	stats, err := s.GetStats(ctx, "agents")
+	if err != nil {
+		t.Fatalf("GetStats: %v", err)
+	}
+	if stats.Browsers["Safari"] != 2 || stats.Browsers["curl"] != 1 {
+		t.Errorf("browsers = %v, want 2 Safari and 1 curl", stats.Browsers)
+	}
+	if stats.OperatingSystems["iOS"] != 2 {
+		t.Errorf("operating systems = %v, want 2 iOS", stats.OperatingSystems)
+	}
+	if stats.Devices[DeviceMobile] != 2 || stats.Devices[DeviceBot] != 1 {
+		t.Errorf("devices = %v, want 2 mobile and 1 bot", stats.Devices)
+	}
+}
@@ -0,0 +1,15 @@
+// Package version holds build information set at link time, e.g.
+//
+//	go build -ldflags "-X logging-middleware/version.Version=1.2.0 \
+//	  -X logging-middleware/version.Commit=$(git rev-parse --short HEAD) \
+//	  -X logging-middleware/version.BuildTime=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+package version
+
+var (
+	// Version is the release version of the build
+	Version = "dev"
+	// Commit is the git commit the build was made from
+	Commit = "unknown"
+	// BuildTime is when the binary was built
+	BuildTime = "unknown"
+)